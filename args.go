@@ -0,0 +1,383 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/configure"
+	"github.com/cubicdaiya/nginx-build/cross"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+	"github.com/cubicdaiya/nginx-build/openresty"
+	"github.com/cubicdaiya/nginx-build/packaging"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// parsedArgs is the arguments of a subcommand parsed into nginxBuildOptions and the configure options of nginx.
+type parsedArgs struct {
+	fs        *flag.FlagSet
+	configure configure.Options
+	// the multiple flags of OpenResty
+	openRestyWith    StringFlag
+	openRestyWithout StringFlag
+}
+
+// parseArgs parses the arguments of the subcommand.
+// The options not available in the subcommand have their default values.
+func parseArgs(cmd *subcommand, args []string, errorHandling flag.ErrorHandling) (*parsedArgs, error) {
+	var (
+		p                parsedArgs
+		multiflagPatch   StringFlag
+		multiflag        StringFlag
+		multiflagDynamic StringFlag
+	)
+
+	fs := flag.NewFlagSet(os.Args[0]+" "+cmd.name, errorHandling)
+	p.fs = fs
+
+	for k, v := range nginxBuildOptions.Bools {
+		if cmd.has(k) {
+			v.Enabled = fs.Bool(k, false, v.Desc)
+		} else {
+			v.Enabled = new(bool)
+		}
+		nginxBuildOptions.Bools[k] = v
+	}
+	for k, v := range nginxBuildOptions.Values {
+		if !cmd.has(k) {
+			v.Value = new(string)
+			*v.Value = v.Default
+			nginxBuildOptions.Values[k] = v
+			continue
+		}
+		if k == "patch" {
+			fs.Var(&multiflagPatch, k, v.Desc)
+		} else if k == "openresty-with" {
+			fs.Var(&p.openRestyWith, k, v.Desc)
+		} else if k == "openresty-without" {
+			fs.Var(&p.openRestyWithout, k, v.Desc)
+		} else {
+			v.Value = fs.String(k, v.Default, v.Desc)
+			nginxBuildOptions.Values[k] = v
+		}
+	}
+	for k, v := range nginxBuildOptions.Numbers {
+		if cmd.has(k) {
+			v.Value = fs.Int(k, v.Default, v.Desc)
+		} else {
+			v.Value = new(int)
+			*v.Value = v.Default
+		}
+		nginxBuildOptions.Numbers[k] = v
+	}
+
+	overrideUnableParseFlags(args)
+
+	argsString := configure.MakeArgsString()
+	for k, v := range argsString {
+		if !cmd.configure {
+			v.Value = new(string)
+			argsString[k] = v
+		} else if k == "add-module" {
+			fs.Var(&multiflag, k, v.Desc)
+		} else if k == "add-dynamic-module" {
+			fs.Var(&multiflagDynamic, k, v.Desc)
+		} else {
+			v.Value = fs.String(k, "", v.Desc)
+			argsString[k] = v
+		}
+	}
+
+	argsBool := configure.MakeArgsBool()
+	for k, v := range argsBool {
+		if cmd.configure {
+			v.Enabled = fs.Bool(k, false, v.Desc)
+		} else {
+			v.Enabled = new(bool)
+		}
+		argsBool[k] = v
+	}
+
+	fs.SetOutput(os.Stdout)
+	fs.Usage = func() { cmd.usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	// Allow multiple flags for `--patch`
+	{
+		tmp := nginxBuildOptions.Values["patch"]
+		tmp2 := multiflagPatch.String()
+		tmp.Value = &tmp2
+		nginxBuildOptions.Values["patch"] = tmp
+	}
+
+	// Allow multiple flags for `--add-module`
+	{
+		tmp := argsString["add-module"]
+		tmp2 := multiflag.String()
+		tmp.Value = &tmp2
+		argsString["add-module"] = tmp
+	}
+
+	// Allow multiple flags for `--add-dynamic-module`
+	{
+		tmp := argsString["add-dynamic-module"]
+		tmp2 := multiflagDynamic.String()
+		tmp.Value = &tmp2
+		argsString["add-dynamic-module"] = tmp
+	}
+
+	p.configure.Values = argsString
+	p.configure.Bools = argsBool
+	return &p, nil
+}
+
+// matrixMode reports whether a build matrix is given.
+func matrixMode() bool {
+	return *nginxBuildOptions.Values["matrix-flavors"].Value != "" ||
+		*nginxBuildOptions.Values["matrix-versions"].Value != "" ||
+		*nginxBuildOptions.Values["matrix-tls"].Value != ""
+}
+
+// makeSpec maps the options parsed by parseArgs into the Spec of a build.
+// The subcommand selects the modes of the build such as configuring only and installing.
+func makeSpec(cmd *subcommand, p *parsedArgs) (nginxbuild.Spec, error) {
+	jobs := nginxBuildOptions.Numbers["j"].Value
+	matrixParallel := nginxBuildOptions.Numbers["matrix-parallel"].Value
+
+	verbose := nginxBuildOptions.Bools["verbose"].Enabled
+	pcreStatic := nginxBuildOptions.Bools["pcre"].Enabled
+	openSSLStatic := nginxBuildOptions.Bools["openssl"].Enabled
+	libreSSLStatic := nginxBuildOptions.Bools["libressl"].Enabled
+	zlibStatic := nginxBuildOptions.Bools["zlib"].Enabled
+	clear := nginxBuildOptions.Bools["clear"].Enabled
+	openResty := nginxBuildOptions.Bools["openresty"].Enabled
+	freenginx := nginxBuildOptions.Bools["freenginx"].Enabled
+	configureOnly := *nginxBuildOptions.Bools["configureonly"].Enabled
+	idempotent := nginxBuildOptions.Bools["idempotent"].Enabled
+	install := *nginxBuildOptions.Bools["install"].Enabled
+	smokeTest := nginxBuildOptions.Bools["smoke-test"].Enabled
+	openRestyPcreJIT := nginxBuildOptions.Bools["openresty-pcre-jit"].Enabled
+	openRestyBundle := nginxBuildOptions.Bools["openresty-bundle"].Enabled
+	fetchShprov := nginxBuildOptions.Bools["fetch-shprov"].Enabled
+	patchDryRun := nginxBuildOptions.Bools["patch-dry-run"].Enabled
+	fetchPatch := nginxBuildOptions.Bools["fetch-patch"].Enabled
+
+	nginxConfigurePath := nginxBuildOptions.Values["c"].Value
+	modulesConfPath := nginxBuildOptions.Values["m"].Value
+	workParentDir := nginxBuildOptions.Values["d"].Value
+	clearScope := nginxBuildOptions.Values["clear-scope"].Value
+	variant := nginxBuildOptions.Values["variant"].Value
+	lockTimeout := nginxBuildOptions.Values["lock-timeout"].Value
+	tracePath := nginxBuildOptions.Values["trace"].Value
+	fixturesDir := nginxBuildOptions.Values["fixtures"].Value
+	nginxTestsDir := nginxBuildOptions.Values["nginx-tests"].Value
+	nginxTestsGlob := nginxBuildOptions.Values["nginx-tests-glob"].Value
+	matrixTLS := nginxBuildOptions.Values["matrix-tls"].Value
+	bisectGood := nginxBuildOptions.Values["good"].Value
+	bisectBad := nginxBuildOptions.Values["bad"].Value
+	bisectRun := nginxBuildOptions.Values["run"].Value
+	bisectReleases := nginxBuildOptions.Values["releases"].Value
+	bundlePath := nginxBuildOptions.Values["bundle"].Value
+	fromBundle := nginxBuildOptions.Values["from-bundle"].Value
+	pcreVersion := nginxBuildOptions.Values["pcreversion"].Value
+	openSSLVersion := nginxBuildOptions.Values["opensslversion"].Value
+	libreSSLVersion := nginxBuildOptions.Values["libresslversion"].Value
+	zlibVersion := nginxBuildOptions.Values["zlibversion"].Value
+	flavorName := nginxBuildOptions.Values["flavor"].Value
+	patchPath := nginxBuildOptions.Values["patch"].Value
+	patchOption := nginxBuildOptions.Values["patch-opt"].Value
+	installDestDir := nginxBuildOptions.Values["destdir"].Value
+	installModulesDir := nginxBuildOptions.Values["install-modules-dir"].Value
+	packageFormat := nginxBuildOptions.Values["package"].Value
+	packageSpecPath := nginxBuildOptions.Values["package-spec"].Value
+	exportFormat := nginxBuildOptions.Values["export"].Value
+	exportPath := nginxBuildOptions.Values["export-path"].Value
+	ociBase := nginxBuildOptions.Values["oci-base"].Value
+	sbomSPDXPath := nginxBuildOptions.Values["sbom-spdx"].Value
+	sbomCycloneDXPath := nginxBuildOptions.Values["sbom-cyclonedx"].Value
+	openRestyLuaJIT := nginxBuildOptions.Values["openresty-luajit"].Value
+	openRestyLuaJITXCFlags := nginxBuildOptions.Values["openresty-luajit-xcflags"].Value
+	targetTriple := nginxBuildOptions.Values["target"].Value
+	targetPrefix := nginxBuildOptions.Values["target-prefix"].Value
+	targetSysroot := nginxBuildOptions.Values["target-sysroot"].Value
+
+	// the subcommands select the modes of a build
+	switch cmd.name {
+	case "configure":
+		configureOnly = true
+	case "install":
+		install = true
+	}
+
+	switch *exportFormat {
+	case "", "dockerfile", "oci":
+	default:
+		return nginxbuild.Spec{}, fmt.Errorf("export=%s is not supported (one of dockerfile, oci)", *exportFormat)
+	}
+	if *exportFormat != "" && *exportPath == "" {
+		return nginxbuild.Spec{}, fmt.Errorf("set output path of export with -export-path")
+	}
+	if *exportFormat != "oci" && *ociBase != "" {
+		return nginxbuild.Spec{}, fmt.Errorf("'-oci-base' is available only with '-export oci'.")
+	}
+
+	// building a package or an OCI image installs nginx into a staging root
+	if *packageFormat != "" || *exportFormat == "oci" {
+		install = true
+	}
+	var lockWait time.Duration
+	if *lockTimeout != "" {
+		d, err := time.ParseDuration(*lockTimeout)
+		if err != nil || d <= 0 {
+			return nginxbuild.Spec{}, fmt.Errorf("lock-timeout=%s is invalid duration", *lockTimeout)
+		}
+		lockWait = d
+	}
+	if install && configureOnly {
+		return nginxbuild.Spec{}, fmt.Errorf("select one between '-install' and '-configureonly'.")
+	}
+	if !install && (*installDestDir != "" || *installModulesDir != "") {
+		return nginxbuild.Spec{}, fmt.Errorf("'-destdir' and '-install-modules-dir' are available only with '-install'.")
+	}
+	if *packageFormat == "" && *packageSpecPath != "" {
+		return nginxbuild.Spec{}, fmt.Errorf("'-package-spec' is available only with '-package'.")
+	}
+	flavor, err := resolveFlavor(*flavorName, *openResty, *freenginx)
+	if err != nil {
+		return nginxbuild.Spec{}, err
+	}
+	flavorComponent, _ := builder.FlavorComponent(flavor)
+
+	var openRestyOptions *openresty.Options
+	if flavorComponent == builder.ComponentOpenResty {
+		openRestyOptions = &openresty.Options{
+			LuaJIT:        *openRestyLuaJIT,
+			LuaJITXCFlags: *openRestyLuaJITXCFlags,
+			PcreJIT:       *openRestyPcreJIT,
+			With:          p.openRestyWith,
+			Without:       p.openRestyWithout,
+		}
+	} else if *openRestyLuaJIT != "" || *openRestyLuaJITXCFlags != "" || *openRestyPcreJIT || *openRestyBundle ||
+		len(p.openRestyWith) > 0 || len(p.openRestyWithout) > 0 {
+		return nginxbuild.Spec{}, fmt.Errorf("'-openresty-*' options are available only with '-flavor openresty'.")
+	}
+
+	var target *cross.Target
+	if *targetTriple != "" {
+		target = &cross.Target{Triple: *targetTriple, Prefix: *targetPrefix, Sysroot: *targetSysroot}
+	} else if *targetPrefix != "" || *targetSysroot != "" {
+		return nginxbuild.Spec{}, fmt.Errorf("'-target-prefix' and '-target-sysroot' are available only with '-target'.")
+	}
+
+	if matrixMode() {
+		if *verbose {
+			return nginxbuild.Spec{}, fmt.Errorf("select one between a build matrix and '-verbose'.")
+		}
+		if install || *exportFormat != "" {
+			return nginxbuild.Spec{}, fmt.Errorf("installing, packaging and exporting are not available in a build matrix.")
+		}
+		if *idempotent || *openRestyBundle || *tracePath != "" {
+			return nginxbuild.Spec{}, fmt.Errorf("'-idempotent', '-openresty-bundle' and '-trace' are not available in a build matrix.")
+		}
+		if *matrixTLS != "" && (*openSSLStatic || *libreSSLStatic) {
+			return nginxbuild.Spec{}, fmt.Errorf("select one between '-matrix-tls' and '-openssl' or '-libressl'.")
+		}
+		if *fromBundle != "" {
+			return nginxbuild.Spec{}, fmt.Errorf("select one between a build matrix and '-from-bundle'.")
+		}
+		if *matrixParallel <= 0 {
+			return nginxbuild.Spec{}, fmt.Errorf("matrix-parallel=%d is invalid", *matrixParallel)
+		}
+	}
+
+	if cmd.name == "bisect" {
+		if *bisectGood == "" || *bisectBad == "" {
+			return nginxbuild.Spec{}, fmt.Errorf("set the good version and the bad version with -good and -bad.")
+		}
+		if matrixMode() {
+			return nginxbuild.Spec{}, fmt.Errorf("a build matrix is not available in bisect.")
+		}
+		if install || *exportFormat != "" {
+			return nginxbuild.Spec{}, fmt.Errorf("installing, packaging and exporting are not available in bisect.")
+		}
+		if *idempotent || *openRestyBundle || *tracePath != "" {
+			return nginxbuild.Spec{}, fmt.Errorf("'-idempotent', '-openresty-bundle' and '-trace' are not available in bisect.")
+		}
+	} else if *bisectGood != "" || *bisectBad != "" || *bisectRun != "" || *bisectReleases != "" {
+		return nginxbuild.Spec{}, fmt.Errorf("'-good', '-bad', '-run' and '-releases' are available only in bisect.")
+	}
+
+	nginxConfigure, err := util.FileGetContents(*nginxConfigurePath)
+	if err != nil {
+		return nginxbuild.Spec{}, err
+	}
+
+	var packageSpec packaging.Spec
+	if *packageFormat != "" {
+		packageSpec, err = loadPackageSpec(*packageSpecPath)
+		if err != nil {
+			return nginxbuild.Spec{}, err
+		}
+	}
+
+	modules3rd, err := module3rd.Load(*modulesConfPath)
+	if err != nil {
+		return nginxbuild.Spec{}, err
+	}
+
+	spec := nginxbuild.Spec{
+		WorkDir:           *workParentDir,
+		Flavor:            flavor,
+		Version:           flavorVersion(flavorComponent),
+		Pcre:              nginxbuild.Library{Static: *pcreStatic, Version: *pcreVersion},
+		OpenSSL:           nginxbuild.Library{Static: *openSSLStatic, Version: *openSSLVersion},
+		LibreSSL:          nginxbuild.Library{Static: *libreSSLStatic, Version: *libreSSLVersion},
+		Zlib:              nginxbuild.Library{Static: *zlibStatic, Version: *zlibVersion},
+		Configure:         nginxConfigure,
+		ConfigureOptions:  p.configure,
+		Modules:           modules3rd,
+		OpenResty:         openRestyOptions,
+		Target:            target,
+		Patch:             *patchPath,
+		PatchOption:       *patchOption,
+		PatchDryRun:       *patchDryRun,
+		Jobs:              *jobs,
+		Verbose:           *verbose,
+		Clear:             *clear,
+		ClearScopes:       splitList(*clearScope),
+		Variant:           *variant,
+		Idempotent:        *idempotent,
+		FetchOnly:         cmd.name == "fetch" || *openRestyBundle,
+		FetchShprov:       *fetchShprov,
+		FetchPatch:        *fetchPatch,
+		Bundle:            *bundlePath,
+		FromBundle:        *fromBundle,
+		LockTimeout:       lockWait,
+		ConfigureOnly:     configureOnly,
+		SmokeTest:         *smokeTest,
+		Fixtures:          *fixturesDir,
+		NginxTests:        *nginxTestsDir,
+		NginxTestsPattern: *nginxTestsGlob,
+		Install:           install,
+		DestDir:           *installDestDir,
+		InstallModulesDir: *installModulesDir,
+		Package:           *packageFormat,
+		PackageSpec:       packageSpec,
+		OCIBase:           *ociBase,
+		SBOMSPDX:          *sbomSPDXPath,
+		SBOMCycloneDX:     *sbomCycloneDXPath,
+	}
+	if *exportFormat == "oci" {
+		spec.OCIPath = *exportPath
+	}
+	return spec, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+)
+
+func TestMakeSpec(t *testing.T) {
+	cmd, _ := findSubcommand("build")
+	p, err := parseArgs(cmd, []string{
+		"-d", "work", "-v", "1.27.0", "-openssl", "-opensslversion", "3.5.0", "-j", "4",
+		"-clear-scope", "modules, patches", "-lock-timeout", "30s", "-export", "oci", "-export-path", "nginx.oci.tar",
+		"-patch", "a.patch", "-patch", "b.patch",
+	}, flag.ContinueOnError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := makeSpec(cmd, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		got  interface{}
+		want interface{}
+	}{
+		{got: spec.WorkDir, want: "work"},
+		{got: spec.Flavor, want: "nginx"},
+		{got: spec.Version, want: "1.27.0"},
+		{got: spec.OpenSSL.Static, want: true},
+		{got: spec.OpenSSL.Version, want: "3.5.0"},
+		{got: spec.Pcre.Static, want: false},
+		{got: spec.Pcre.Version, want: builder.PcreVersion},
+		{got: spec.Jobs, want: 4},
+		{got: len(spec.ClearScopes), want: 2},
+		{got: spec.LockTimeout, want: 30 * time.Second},
+		{got: spec.Install, want: true},
+		{got: spec.OCIPath, want: "nginx.oci.tar"},
+		{got: spec.Patch, want: "a.patch,b.patch"},
+		{got: spec.OpenResty == nil, want: true},
+	}
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Fatalf("got: %v, want: %v", test.got, test.want)
+		}
+	}
+}
+
+func TestMakeSpecModes(t *testing.T) {
+	tests := []struct {
+		cmd           string
+		args          []string
+		configureOnly bool
+		install       bool
+		fetchOnly     bool
+		flavor        string
+		version       string
+	}{
+		{cmd: "build", args: nil, flavor: "nginx", version: builder.NginxVersion},
+		{cmd: "build", args: []string{"-configureonly"}, configureOnly: true, flavor: "nginx", version: builder.NginxVersion},
+		{cmd: "configure", args: nil, configureOnly: true, flavor: "nginx", version: builder.NginxVersion},
+		{cmd: "install", args: nil, install: true, flavor: "nginx", version: builder.NginxVersion},
+		{cmd: "build", args: []string{"-package", "deb"}, install: true, flavor: "nginx", version: builder.NginxVersion},
+		{cmd: "fetch", args: nil, fetchOnly: true, flavor: "nginx", version: builder.NginxVersion},
+		{cmd: "build", args: []string{"-openresty", "-openrestyversion", "1.25.3.2"}, flavor: "openresty", version: "1.25.3.2"},
+		{cmd: "build", args: []string{"-flavor", "angie"}, flavor: "angie", version: builder.AngieVersion},
+	}
+
+	for _, test := range tests {
+		cmd, _ := findSubcommand(test.cmd)
+		p, err := parseArgs(cmd, test.args, flag.ContinueOnError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec, err := makeSpec(cmd, p)
+		if err != nil {
+			t.Fatalf("got: %v, want: nil (%s %v)", err, test.cmd, test.args)
+		}
+		if spec.ConfigureOnly != test.configureOnly || spec.Install != test.install || spec.FetchOnly != test.fetchOnly {
+			t.Fatalf("got: %v %v %v, want: %v %v %v (%s %v)", spec.ConfigureOnly, spec.Install, spec.FetchOnly, test.configureOnly, test.install, test.fetchOnly, test.cmd, test.args)
+		}
+		if spec.Flavor != test.flavor || spec.Version != test.version {
+			t.Fatalf("got: %v %v, want: %v %v", spec.Flavor, spec.Version, test.flavor, test.version)
+		}
+	}
+}
+
+func TestMakeSpecErrors(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		args []string
+	}{
+		{cmd: "build", args: []string{"-export", "tar", "-export-path", "out"}},
+		{cmd: "build", args: []string{"-export", "oci"}},
+		{cmd: "build", args: []string{"-oci-base", "rootfs.tar.gz"}},
+		{cmd: "build", args: []string{"-install", "-configureonly"}},
+		{cmd: "build", args: []string{"-destdir", "staging"}},
+		{cmd: "build", args: []string{"-package-spec", "package.json"}},
+		{cmd: "build", args: []string{"-lock-timeout", "0s"}},
+		{cmd: "build", args: []string{"-flavor", "apache"}},
+		{cmd: "build", args: []string{"-openresty", "-freenginx"}},
+		{cmd: "build", args: []string{"-openresty-pcre-jit"}},
+		{cmd: "build", args: []string{"-target-sysroot", "/opt/sysroot"}},
+		{cmd: "build", args: []string{"-matrix-flavors", "nginx", "-verbose"}},
+		{cmd: "build", args: []string{"-matrix-tls", "openssl", "-openssl"}},
+		{cmd: "build", args: []string{"-matrix-versions", "1.27.0", "-matrix-parallel", "0"}},
+		{cmd: "bisect", args: []string{"-good", "1.27.0"}},
+		{cmd: "build", args: []string{"-c", "configure.notfound"}},
+	}
+
+	for _, test := range tests {
+		cmd, _ := findSubcommand(test.cmd)
+		p, err := parseArgs(cmd, test.args, flag.ContinueOnError)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := makeSpec(cmd, p); err == nil {
+			t.Fatalf("got: %v, want: an error (%s %v)", err, test.cmd, test.args)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/cubicdaiya/nginx-build/command"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// bisectSkipStatus is the exit status of the test command skipping a version as same as `git bisect run`.
+const bisectSkipStatus = 125
+
+// bisectTest returns the test of a bisection running cmd with sh.
+// cmd runs with NGINX_BINARY, NGINX_VERSION and NGINX_SOURCE_DIR of nginx built.
+func bisectTest(cmd, dir string, output io.Writer) func(context.Context, nginxbuild.Result) error {
+	return func(ctx context.Context, result nginxbuild.Result) error {
+		c, err := command.Make(ctx, dir, []string{"sh", "-c", cmd})
+		if err != nil {
+			return err
+		}
+		c.Env = append(os.Environ(),
+			"NGINX_BINARY="+result.BinaryPath,
+			"NGINX_VERSION="+result.Version,
+			"NGINX_SOURCE_DIR="+result.SourceDir,
+		)
+		c.Stdout = output
+		c.Stderr = output
+		err = c.Run()
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == bisectSkipStatus {
+			return nginxbuild.ErrBisectSkip
+		}
+		if err != nil {
+			return fmt.Errorf("test command failed: %w", err)
+		}
+		return nil
+	}
+}
+
+// runBisect searches the first bad version and prints it.
+func runBisect(ctx context.Context, bisection nginxbuild.Bisection, testCmd string, jsonOutput bool) {
+	// the output of the test command does not break the result in JSON
+	output := io.Writer(os.Stdout)
+	if jsonOutput {
+		output = os.Stderr
+	}
+	if testCmd != "" {
+		bisection.Test = bisectTest(testCmd, util.SaveCurrentDir(), output)
+	}
+
+	if len(bisection.Versions) == 0 {
+		flavor := bisection.Spec.Flavor
+		log.Printf("Get the releases of %s.....", flavor)
+		versions, err := nginxbuild.Releases(ctx, flavor)
+		if err != nil {
+			log.Fatalf("Failed to get the releases of %s: %v", flavor, err)
+		}
+		bisection.Versions = versions
+	} else {
+		nginxbuild.SortVersions(bisection.Versions)
+	}
+
+	log.Printf("Bisect between %s (good) and %s (bad).....", bisection.Good, bisection.Bad)
+	result, err := nginxbuild.RunBisect(ctx, bisection, func(step nginxbuild.BisectStep) {
+		if step.Reason != "" {
+			log.Printf("[%s] %s: %s", step.Status, step.Version, step.Reason)
+		} else {
+			log.Printf("[%s] %s", step.Status, step.Version)
+		}
+	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Timed out bisecting: %v", err)
+			os.Exit(exitTimeout)
+		}
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Interrupted bisecting: %v", err)
+			os.Exit(exitInterrupted)
+		}
+		log.Fatal(err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if result.FirstBad != "" {
+		log.Printf("%s is the first bad version (%s is the last good version).", result.FirstBad, result.LastGood)
+		return
+	}
+	log.Printf("The first bad version is one of %v as the versions skipped can not be tested.", result.Candidates)
+}
@@ -1,6 +1,7 @@
 package builder
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
@@ -26,6 +27,8 @@ var (
 	libresslVersionRe  *regexp.Regexp
 	openrestyVersionRe *regexp.Regexp
 	freenginxVersionRe *regexp.Regexp
+	angieVersionRe     *regexp.Regexp
+	tengineVersionRe   *regexp.Regexp
 )
 
 func init() {
@@ -36,6 +39,8 @@ func init() {
 	libresslVersionRe = regexp.MustCompile(`--with-openssl=.+/libressl-(\d+\.\d+\.\d+)`)
 	openrestyVersionRe = regexp.MustCompile(`nginx version: openresty/(\d+\.\d+\.\d+\.\d+)`)
 	freenginxVersionRe = regexp.MustCompile(`freenginx version: freenginx/(\d+\.\d+\.\d+)`)
+	angieVersionRe = regexp.MustCompile(`Angie version: Angie/(\d+\.\d+\.\d+)`)
+	tengineVersionRe = regexp.MustCompile(`Tengine version: Tengine/(\d+\.\d+\.\d+)`)
 }
 
 func (builder *Builder) name() string {
@@ -55,6 +60,10 @@ func (builder *Builder) name() string {
 		name = openresty.Name(builder.Version)
 	case ComponentFreenginx:
 		name = "freenginx"
+	case ComponentAngie:
+		name = "angie"
+	case ComponentTengine:
+		name = "tengine"
 	default:
 		panic("invalid component")
 	}
@@ -93,6 +102,10 @@ func (builder *Builder) DownloadURL() string {
 		return fmt.Sprintf("%s/openresty-%s.tar.gz", OpenRestyDownloadURLPrefix, builder.Version)
 	case ComponentFreenginx:
 		return fmt.Sprintf("%s/freenginx-%s.tar.gz", FreenginxDownloadURLPrefix, builder.Version)
+	case ComponentAngie:
+		return fmt.Sprintf("%s/angie-%s.tar.gz", AngieDownloadURLPrefix, builder.Version)
+	case ComponentTengine:
+		return fmt.Sprintf("%s/tengine-%s.tar.gz", TengineDownloadURLPrefix, builder.Version)
 	default:
 		panic("invalid component")
 	}
@@ -122,13 +135,46 @@ func (builder *Builder) WarnMsgWithLibrary() string {
 		builder.option(), builder.name(), builder.name())
 }
 
+// BinaryName returns the name of the binary the flavor builds.
+// Angie names its binary angie instead of nginx. Tengine keeps the names of nginx.
+func (builder *Builder) BinaryName() string {
+	if builder.Component == ComponentAngie {
+		return "angie"
+	}
+	return "nginx"
+}
+
+// DefaultPrefix returns the installation prefix of the flavor without --prefix.
+func (builder *Builder) DefaultPrefix() string {
+	switch builder.Component {
+	case ComponentOpenResty:
+		return "/usr/local/openresty/nginx"
+	case ComponentAngie:
+		return "/usr/local/angie"
+	default:
+		return "/usr/local/nginx"
+	}
+}
+
+// BinaryPath returns the path of the built binary relative to SourcePath().
+// OpenResty builds nginx under its bundle directory, so it is not supported.
+func (builder *Builder) BinaryPath() string {
+	if builder.Component == ComponentOpenResty {
+		return ""
+	}
+	return "objs/" + builder.BinaryName()
+}
+
 func (builder *Builder) InstalledVersion() (string, error) {
 	nginxBinPath := "/usr/local/sbin/nginx"
+	if builder.Component == ComponentAngie {
+		nginxBinPath = builder.DefaultPrefix() + "/sbin/" + builder.BinaryName()
+	}
 	if os.Getenv("NGINX_BIN") != "" {
 		nginxBinPath = os.Getenv("NGINX_BIN")
 	}
 	args := []string{nginxBinPath, "-V"}
-	cmd, err := command.Make(args)
+	cmd, err := command.Make(context.Background(), "", args)
 	if err != nil {
 		return "", err
 	}
@@ -156,6 +202,10 @@ func (builder *Builder) InstalledVersion() (string, error) {
 		versionRe = libresslVersionRe
 	case "freenginx":
 		versionRe = freenginxVersionRe
+	case "angie":
+		versionRe = angieVersionRe
+	case "tengine":
+		versionRe = tengineVersionRe
 	}
 
 	m := versionRe.FindSubmatch(result)
@@ -184,6 +234,10 @@ func MakeBuilder(component int, version string) Builder {
 		builder.DownloadURLPrefix = OpenRestyDownloadURLPrefix
 	case ComponentFreenginx:
 		builder.DownloadURLPrefix = FreenginxDownloadURLPrefix
+	case ComponentAngie:
+		builder.DownloadURLPrefix = AngieDownloadURLPrefix
+	case ComponentTengine:
+		builder.DownloadURLPrefix = TengineDownloadURLPrefix
 	default:
 		panic("invalid component")
 	}
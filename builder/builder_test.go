@@ -2,6 +2,7 @@ package builder
 
 import (
 	"fmt"
+	"regexp"
 	"testing"
 )
 
@@ -14,6 +15,8 @@ func setupBuilders(t *testing.T) []Builder {
 	builders[ComponentZlib] = MakeLibraryBuilder(ComponentZlib, ZlibVersion, false)
 	builders[ComponentOpenResty] = MakeBuilder(ComponentOpenResty, OpenRestyVersion)
 	builders[ComponentFreenginx] = MakeBuilder(ComponentFreenginx, FreenginxVersion)
+	builders[ComponentAngie] = MakeBuilder(ComponentAngie, AngieVersion)
+	builders[ComponentTengine] = MakeBuilder(ComponentTengine, TengineVersion)
 	return builders
 }
 
@@ -52,6 +55,14 @@ func TestName(t *testing.T) {
 			got:  builders[ComponentFreenginx].name(),
 			want: "freenginx",
 		},
+		{
+			got:  builders[ComponentAngie].name(),
+			want: "angie",
+		},
+		{
+			got:  builders[ComponentTengine].name(),
+			want: "tengine",
+		},
 	}
 
 	for _, test := range tests {
@@ -128,6 +139,14 @@ func TestDownloadURL(t *testing.T) {
 			got:  builders[ComponentFreenginx].DownloadURL(),
 			want: fmt.Sprintf("%s/freenginx-%s.tar.gz", FreenginxDownloadURLPrefix, FreenginxVersion),
 		},
+		{
+			got:  builders[ComponentAngie].DownloadURL(),
+			want: fmt.Sprintf("%s/angie-%s.tar.gz", AngieDownloadURLPrefix, AngieVersion),
+		},
+		{
+			got:  builders[ComponentTengine].DownloadURL(),
+			want: fmt.Sprintf("%s/tengine-%s.tar.gz", TengineDownloadURLPrefix, TengineVersion),
+		},
 	}
 
 	for _, test := range tests {
@@ -172,6 +191,14 @@ func TestSourcePath(t *testing.T) {
 			got:  builders[ComponentFreenginx].SourcePath(),
 			want: fmt.Sprintf("freenginx-%s", FreenginxVersion),
 		},
+		{
+			got:  builders[ComponentAngie].SourcePath(),
+			want: fmt.Sprintf("angie-%s", AngieVersion),
+		},
+		{
+			got:  builders[ComponentTengine].SourcePath(),
+			want: fmt.Sprintf("tengine-%s", TengineVersion),
+		},
 	}
 
 	for _, test := range tests {
@@ -216,6 +243,14 @@ func TestArchivePath(t *testing.T) {
 			got:  builders[ComponentFreenginx].ArchivePath(),
 			want: fmt.Sprintf("freenginx-%s.tar.gz", FreenginxVersion),
 		},
+		{
+			got:  builders[ComponentAngie].ArchivePath(),
+			want: fmt.Sprintf("angie-%s.tar.gz", AngieVersion),
+		},
+		{
+			got:  builders[ComponentTengine].ArchivePath(),
+			want: fmt.Sprintf("tengine-%s.tar.gz", TengineVersion),
+		},
 	}
 
 	for _, test := range tests {
@@ -260,6 +295,14 @@ func TestLogPath(t *testing.T) {
 			got:  builders[ComponentFreenginx].LogPath(),
 			want: fmt.Sprintf("freenginx-%s.log", FreenginxVersion),
 		},
+		{
+			got:  builders[ComponentAngie].LogPath(),
+			want: fmt.Sprintf("angie-%s.log", AngieVersion),
+		},
+		{
+			got:  builders[ComponentTengine].LogPath(),
+			want: fmt.Sprintf("tengine-%s.log", TengineVersion),
+		},
 	}
 
 	for _, test := range tests {
@@ -343,3 +386,109 @@ func TestMakeStaticLibrary(t *testing.T) {
 		}
 	}
 }
+
+func TestBinaryPath(t *testing.T) {
+	builders := setupBuilders(t)
+
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{
+			got:  builders[ComponentNginx].BinaryPath(),
+			want: "objs/nginx",
+		},
+		{
+			got:  builders[ComponentOpenResty].BinaryPath(),
+			want: "",
+		},
+		{
+			got:  builders[ComponentAngie].BinaryPath(),
+			want: "objs/angie",
+		},
+		{
+			got:  builders[ComponentTengine].BinaryPath(),
+			want: "objs/nginx",
+		},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Fatalf("got: %v, want: %v", test.got, test.want)
+		}
+	}
+}
+
+func TestDefaultPrefix(t *testing.T) {
+	builders := setupBuilders(t)
+
+	tests := []struct {
+		builder Builder
+		prefix  string
+		binary  string
+	}{
+		{builder: builders[ComponentNginx], prefix: "/usr/local/nginx", binary: "nginx"},
+		{builder: builders[ComponentOpenResty], prefix: "/usr/local/openresty/nginx", binary: "nginx"},
+		{builder: builders[ComponentFreenginx], prefix: "/usr/local/nginx", binary: "nginx"},
+		{builder: builders[ComponentAngie], prefix: "/usr/local/angie", binary: "angie"},
+		{builder: builders[ComponentTengine], prefix: "/usr/local/nginx", binary: "nginx"},
+	}
+
+	for _, test := range tests {
+		if got := test.builder.DefaultPrefix(); got != test.prefix {
+			t.Fatalf("got: %v, want: %v", got, test.prefix)
+		}
+		if got := test.builder.BinaryName(); got != test.binary {
+			t.Fatalf("got: %v, want: %v", got, test.binary)
+		}
+	}
+}
+
+func TestVersionRe(t *testing.T) {
+	tests := []struct {
+		re     *regexp.Regexp
+		output string
+		want   string
+	}{
+		{re: angieVersionRe, output: "Angie version: Angie/1.9.1\nbuilt by gcc 12.2.0\n", want: "1.9.1"},
+		{re: tengineVersionRe, output: "Tengine version: Tengine/3.1.0\nnginx version: nginx/1.24.0\n", want: "3.1.0"},
+		{re: nginxVersionRe, output: "nginx version: nginx/1.28.0\n", want: "1.28.0"},
+	}
+
+	for _, test := range tests {
+		m := test.re.FindStringSubmatch(test.output)
+		if len(m) < 2 || m[1] != test.want {
+			t.Fatalf("got: %v, want: %v", m, test.want)
+		}
+	}
+}
+
+// TestComponentValues keeps the values of the components added before the flavors added later.
+func TestComponentValues(t *testing.T) {
+	components := []int{ComponentNginx, ComponentOpenResty, ComponentFreenginx, ComponentPcre, ComponentOpenSSL, ComponentLibreSSL, ComponentZlib}
+	for i, c := range components {
+		if c != i {
+			t.Fatalf("got: %v, want: %v", c, i)
+		}
+	}
+}
+
+func TestFlavorComponent(t *testing.T) {
+	for _, flavor := range Flavors {
+		component, err := FlavorComponent(flavor)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b := MakeBuilder(component, FlavorDefaultVersion(component))
+		if b.FlavorName() != flavor {
+			t.Fatalf("got: %v, want: %v", b.FlavorName(), flavor)
+		}
+		if !b.IsFlavor() {
+			t.Fatalf("%v is not a flavor", flavor)
+		}
+	}
+
+	if _, err := FlavorComponent("apache"); err == nil {
+		t.Fatalf("unsupported flavor must be an error")
+	}
+}
@@ -42,6 +42,18 @@ const (
 	FreenginxDownloadURLPrefix = "https://freenginx.org/download"
 )
 
+// angie
+const (
+	AngieVersion           = "1.9.1"
+	AngieDownloadURLPrefix = "https://download.angie.software/files"
+)
+
+// tengine
+const (
+	TengineVersion           = "3.1.0"
+	TengineDownloadURLPrefix = "https://tengine.taobao.org/download"
+)
+
 // component enumerations
 const (
 	ComponentNginx = iota
@@ -51,5 +63,7 @@ const (
 	ComponentOpenSSL
 	ComponentLibreSSL
 	ComponentZlib
+	ComponentAngie
+	ComponentTengine
 	ComponentMax
 )
@@ -0,0 +1,71 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Flavors lists the names of nginx flavors nginx-build can build.
+// The order is used for printing versions.
+var Flavors = []string{
+	"nginx",
+	"openresty",
+	"freenginx",
+	"angie",
+	"tengine",
+}
+
+// FlavorComponent returns the component for a flavor name.
+func FlavorComponent(flavor string) (int, error) {
+	switch flavor {
+	case "nginx":
+		return ComponentNginx, nil
+	case "openresty":
+		return ComponentOpenResty, nil
+	case "freenginx":
+		return ComponentFreenginx, nil
+	case "angie":
+		return ComponentAngie, nil
+	case "tengine":
+		return ComponentTengine, nil
+	}
+	return -1, fmt.Errorf("flavor=%s is not supported (one of %s)", flavor, strings.Join(Flavors, ", "))
+}
+
+// FlavorDefaultVersion returns the default version of a flavor.
+func FlavorDefaultVersion(component int) string {
+	switch component {
+	case ComponentNginx:
+		return NginxVersion
+	case ComponentOpenResty:
+		return OpenRestyVersion
+	case ComponentFreenginx:
+		return FreenginxVersion
+	case ComponentAngie:
+		return AngieVersion
+	case ComponentTengine:
+		return TengineVersion
+	}
+	return ""
+}
+
+// IsFlavor reports whether the builder builds an nginx flavor
+// rather than a static library.
+func (builder *Builder) IsFlavor() bool {
+	switch builder.Component {
+	case ComponentNginx, ComponentOpenResty, ComponentFreenginx, ComponentAngie, ComponentTengine:
+		return true
+	}
+	return false
+}
+
+// FlavorName returns the flavor name of the builder.
+// It is used for the working directory layout.
+func (builder *Builder) FlavorName() string {
+	for _, f := range Flavors {
+		if c, _ := FlavorComponent(f); c == builder.Component {
+			return f
+		}
+	}
+	return ""
+}
@@ -0,0 +1,170 @@
+package builder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/command"
+)
+
+var (
+	autoConfigDefineRe *regexp.Regexp
+)
+
+func init() {
+	autoConfigDefineRe = regexp.MustCompile(`#define\s+(NGX_PREFIX|NGX_SBIN_PATH)\s+"([^"]*)"`)
+}
+
+// InstallNginx runs `make install` in dir with DESTDIR for staging the installation.
+func InstallNginx(ctx context.Context, dir, destDir string, verbose bool) error {
+	args := []string{"make", "install"}
+	if destDir != "" {
+		args = append(args, "DESTDIR="+destDir)
+	}
+	if verbose {
+		return command.Run(ctx, dir, verbose, args)
+	}
+
+	f, err := os.Create(filepath.Join(dir, "nginx-install.log"))
+	if err != nil {
+		log.Printf("[warn] could not create nginx-install.log: %v", err)
+		return command.Run(ctx, dir, verbose, args)
+	}
+	defer f.Close()
+
+	cmd, err := command.Make(ctx, dir, args)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	defer writer.Flush()
+
+	if err := command.Exec(ctx, cmd); err != nil {
+		return fmt.Errorf("make install failed: %w", err)
+	}
+
+	return nil
+}
+
+// autoConfigPath returns the path of ngx_auto_config.h generated by configure in dir.
+// OpenResty generates it under its bundle directory.
+func autoConfigPath(dir string) (string, error) {
+	path := filepath.Join(dir, "objs/ngx_auto_config.h")
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "build/nginx-*/objs/ngx_auto_config.h"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("ngx_auto_config.h is not found")
+	}
+	return matches[0], nil
+}
+
+func parseAutoConfig(content string) (string, string) {
+	var prefix, sbin string
+	for _, m := range autoConfigDefineRe.FindAllStringSubmatch(content, -1) {
+		switch m[1] {
+		case "NGX_PREFIX":
+			if prefix == "" {
+				prefix = m[2]
+			}
+		case "NGX_SBIN_PATH":
+			if sbin == "" {
+				sbin = m[2]
+			}
+		}
+	}
+
+	if prefix == "" {
+		prefix = "/usr/local/nginx/"
+	}
+	if sbin == "" {
+		sbin = "sbin/nginx"
+	}
+	if !strings.HasPrefix(sbin, "/") {
+		sbin = filepath.Join(prefix, sbin)
+	}
+	return prefix, sbin
+}
+
+// InstalledPaths returns the prefix and the path of the binary which `make install` in dir installs.
+func InstalledPaths(dir string) (string, string, error) {
+	path, err := autoConfigPath(dir)
+	if err != nil {
+		return "", "", err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	prefix, sbin := parseAutoConfig(string(content))
+	return prefix, sbin, nil
+}
+
+// ObjsDir returns the objs directory nginx is built in under dir.
+// OpenResty builds nginx under its bundle directory such as build/nginx-1.27.1/objs.
+func ObjsDir(dir string) (string, error) {
+	path, err := autoConfigPath(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(path), nil
+}
+
+// InstallModules copies dynamic modules built in the objs directory under dir into modulesDir under destDir.
+func InstallModules(dir, destDir, modulesDir string) ([]string, error) {
+	objsDir, err := ObjsDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	modules, err := filepath.Glob(filepath.Join(objsDir, "*.so"))
+	if err != nil {
+		return nil, err
+	}
+
+	installDir := filepath.Join(destDir, modulesDir)
+	if err := os.MkdirAll(installDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var installed []string
+	for _, m := range modules {
+		dst := filepath.Join(installDir, filepath.Base(m))
+		if err := copyFile(m, dst, 0755); err != nil {
+			return installed, fmt.Errorf("failed to install %s: %w", m, err)
+		}
+		installed = append(installed, dst)
+	}
+	return installed, nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
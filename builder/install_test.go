@@ -0,0 +1,88 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAutoConfig(t *testing.T) {
+	tests := []struct {
+		content string
+		prefix  string
+		sbin    string
+	}{
+		{
+			content: "",
+			prefix:  "/usr/local/nginx/",
+			sbin:    "/usr/local/nginx/sbin/nginx",
+		},
+		{
+			content: `#ifndef NGX_PREFIX
+#define NGX_PREFIX  "/etc/nginx/"
+#endif
+
+#ifndef NGX_SBIN_PATH
+#define NGX_SBIN_PATH  "/usr/sbin/nginx"
+#endif
+`,
+			prefix: "/etc/nginx/",
+			sbin:   "/usr/sbin/nginx",
+		},
+		{
+			content: `#ifndef NGX_PREFIX
+#define NGX_PREFIX  "/usr/local/openresty/nginx/"
+#endif
+
+#ifndef NGX_SBIN_PATH
+#define NGX_SBIN_PATH  "sbin/nginx"
+#endif
+`,
+			prefix: "/usr/local/openresty/nginx/",
+			sbin:   "/usr/local/openresty/nginx/sbin/nginx",
+		},
+	}
+
+	for _, test := range tests {
+		prefix, sbin := parseAutoConfig(test.content)
+		if prefix != test.prefix {
+			t.Fatalf("got: %v, want: %v", prefix, test.prefix)
+		}
+		if sbin != test.sbin {
+			t.Fatalf("got: %v, want: %v", sbin, test.sbin)
+		}
+	}
+}
+
+func TestInstallModules(t *testing.T) {
+	tests := []struct {
+		objs string
+	}{
+		{objs: "objs"},
+		// OpenResty builds nginx under its bundle directory
+		{objs: "build/nginx-1.27.1/objs"},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		objsDir := filepath.Join(dir, test.objs)
+		if err := os.MkdirAll(objsDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"ngx_auto_config.h", "ngx_http_echo_module.so"} {
+			if err := os.WriteFile(filepath.Join(objsDir, name), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		destDir := t.TempDir()
+		installed, err := InstallModules(dir, destDir, "modules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := filepath.Join(destDir, "modules", "ngx_http_echo_module.so")
+		if len(installed) != 1 || installed[0] != want {
+			t.Fatalf("got: %v, want: %v", installed, want)
+		}
+	}
+}
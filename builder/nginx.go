@@ -2,36 +2,44 @@ package builder
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/cubicdaiya/nginx-build/command"
 )
 
-func BuildNginx(jobs int) error {
+// BuildNginx runs make in dir. env is added to the environment of make.
+func BuildNginx(ctx context.Context, dir string, jobs int, verbose bool, env []string) error {
 	args := []string{"make", "-j", strconv.Itoa(jobs)}
-	if command.VerboseEnabled {
-		return command.Run(args)
+	cmd, err := command.Make(ctx, dir, args)
+	if err != nil {
+		return err
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return command.Exec(ctx, cmd)
 	}
 
-	f, err := os.Create("nginx-build.log")
+	f, err := os.Create(filepath.Join(dir, "nginx-build.log"))
 	if err != nil {
 		log.Printf("[warn] could not create nginx-build.log: %v", err)
-		return command.Run(args)
+		return command.Exec(ctx, cmd)
 	}
 	defer f.Close()
 
-	cmd, err := command.Make(args)
-	if err != nil {
-		return err
-	}
 	writer := bufio.NewWriter(f)
 	cmd.Stderr = writer
 	defer writer.Flush()
 
-	if err := cmd.Run(); err != nil {
+	if err := command.Exec(ctx, cmd); err != nil {
 		return fmt.Errorf("make failed: %w", err)
 	}
 
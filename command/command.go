@@ -1,40 +1,93 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"os"
 	"os/exec"
+	"sync"
+	"syscall"
+	"time"
 )
 
-var VerboseEnabled bool
+// WaitDelay is the time waiting for the output of a command killed by cancellation.
+const WaitDelay = 5 * time.Second
 
-func checkVerboseEnabled(cmd *exec.Cmd) {
-	if VerboseEnabled {
+func setVerbose(cmd *exec.Cmd, verbose bool) {
+	if verbose {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	}
 }
 
-func Make(args []string) (*exec.Cmd, error) {
+// Make makes a command running in dir.
+// The command runs in the current directory when dir is empty.
+// The command runs in its own process group and the whole group is killed when ctx is done.
+func Make(ctx context.Context, dir string, args []string) (*exec.Cmd, error) {
 	var cmd *exec.Cmd
 	switch len(args) {
 	case 0:
 		return nil, errors.New("empty command")
 	case 1:
-		cmd = exec.Command(args[0])
+		cmd = exec.CommandContext(ctx, args[0])
 	default:
-		cmd = exec.Command(args[0], args[1:]...)
+		cmd = exec.CommandContext(ctx, args[0], args[1:]...)
 	}
+	cmd.Dir = dir
+
+	// make, sh and git spawn children, which must be killed together.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = WaitDelay
 
 	return cmd, nil
 }
 
-func Run(args []string) error {
-	cmd, err := Make(args)
+// Run runs a command in dir. The output of the command is shown in verbose mode.
+func Run(ctx context.Context, dir string, verbose bool, args []string) error {
+	cmd, err := Make(ctx, dir, args)
 	if err != nil {
 		return err
 	}
 
-	checkVerboseEnabled(cmd)
-	return cmd.Run()
+	setVerbose(cmd, verbose)
+	return Exec(ctx, cmd)
+}
+
+// Exec runs cmd made by Make and records its CPU time into the Usage of ctx.
+func Exec(ctx context.Context, cmd *exec.Cmd) error {
+	err := cmd.Run()
+	if u, ok := ctx.Value(usageKey{}).(*Usage); ok && cmd.ProcessState != nil {
+		u.add(cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime())
+	}
+	return err
+}
+
+// Usage is the CPU time of the commands run with a context.
+// It includes the CPU time of the descendants waited by the commands.
+type Usage struct {
+	mu  sync.Mutex
+	cpu time.Duration
+}
+
+type usageKey struct{}
+
+// WithUsage returns a context recording the CPU time of the commands run with it into u.
+func WithUsage(ctx context.Context, u *Usage) context.Context {
+	return context.WithValue(ctx, usageKey{}, u)
+}
+
+func (u *Usage) add(d time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.cpu += d
+}
+
+// CPU returns the CPU time recorded.
+func (u *Usage) CPU() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.cpu
 }
@@ -2,36 +2,43 @@ package configure
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/cubicdaiya/nginx-build/command"
 )
 
-func Run() error {
+// Run runs nginx-configure in dir with the environment variables env added.
+func Run(ctx context.Context, dir string, verbose bool, env []string) error {
 	args := []string{"sh", "./nginx-configure"}
-	if command.VerboseEnabled {
-		return command.Run(args)
+	cmd, err := command.Make(ctx, dir, args)
+	if err != nil {
+		return err
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	if verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return command.Exec(ctx, cmd)
 	}
 
-	f, err := os.Create("nginx-configure.log")
+	f, err := os.Create(filepath.Join(dir, "nginx-configure.log"))
 	if err != nil {
 		log.Printf("[warn] could not create nginx-configure.log: %v", err)
-		return command.Run(args)
+		return command.Exec(ctx, cmd)
 	}
 	defer f.Close()
 
-	cmd, err := command.Make(args)
-	if err != nil {
-		return err
-	}
-
 	writer := bufio.NewWriter(f)
 	cmd.Stdout = writer
 	defer writer.Flush()
 
-	if err := cmd.Run(); err != nil {
+	if err := command.Exec(ctx, cmd); err != nil {
 		return fmt.Errorf("configure failed: %w", err)
 	}
 
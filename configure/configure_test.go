@@ -7,6 +7,7 @@ import (
 
 	"github.com/cubicdaiya/nginx-build/builder"
 	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/openresty"
 )
 
 func setupBuilders(t *testing.T) []builder.Builder {
@@ -51,7 +52,7 @@ func TestConfiguregenWithStaticLibraries(t *testing.T) {
 	dependencies = append(dependencies, builder.MakeStaticLibrary(&builders[builder.ComponentOpenSSL]))
 	dependencies = append(dependencies, builder.MakeStaticLibrary(&builders[builder.ComponentZlib]))
 	var configureOptions Options
-	configureScript := Generate("", []module3rd.Module3rd{}, dependencies, configureOptions, "", false, 1)
+	configureScript := Generate("", []module3rd.Module3rd{}, dependencies, configureOptions, "", nil, 1)
 
 	wantedOptions := []string{
 		"--with-http_ssl_module",
@@ -67,3 +68,54 @@ func TestConfiguregenWithStaticLibraries(t *testing.T) {
 
 	}
 }
+
+func TestConfiguregenWithOpenResty(t *testing.T) {
+	openRestyOptions := &openresty.Options{
+		LuaJIT:        "/opt/luajit dir",
+		LuaJITXCFlags: "-DLUAJIT_NUMMODE=2 -DLUAJIT_NAME='lua'",
+		PcreJIT:       true,
+		With:          []string{"http_iconv_module"},
+		Without:       []string{"lua_resty_mysql"},
+	}
+	var configureOptions Options
+	configureScript := Generate("", []module3rd.Module3rd{}, []builder.StaticLibrary{}, configureOptions, "", openRestyOptions, 4)
+
+	wantedOptions := []string{
+		"-j4 \\\n",
+		"--with-luajit='/opt/luajit dir' \\\n",
+		`--with-luajit-xcflags='-DLUAJIT_NUMMODE=2 -DLUAJIT_NAME='\''lua'\''' \` + "\n",
+		"--with-pcre-jit \\\n",
+		"--with-http_iconv_module \\\n",
+		"--without-lua_resty_mysql \\\n",
+	}
+
+	for _, want := range wantedOptions {
+		if !strings.Contains(configureScript, want) {
+			t.Fatalf("configure script does not contain wanted option: %v", want)
+		}
+	}
+}
+
+func TestConfiguregenWithOptions(t *testing.T) {
+	ccOpt := "-O2 -g"
+	ldOpt := `-Wl,-rpath,/opt/lib`
+	configureOptions := Options{
+		Values: map[string]OptionValue{
+			"with-cc-opt": {Name: "--with-cc-opt", Value: &ccOpt},
+			"with-ld-opt": {Name: "--with-ld-opt", Value: &ldOpt},
+		},
+	}
+	configureScript := Generate("", []module3rd.Module3rd{}, []builder.StaticLibrary{}, configureOptions, "", nil, 1)
+
+	// the values are quoted only when they contain spaces
+	wantedOptions := []string{
+		"--with-cc-opt='-O2 -g' \\\n",
+		"--with-ld-opt=-Wl,-rpath,/opt/lib \\\n",
+	}
+
+	for _, want := range wantedOptions {
+		if !strings.Contains(configureScript, want) {
+			t.Fatalf("configure script does not contain wanted option: %v", want)
+		}
+	}
+}
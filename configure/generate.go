@@ -6,9 +6,11 @@ import (
 
 	"github.com/cubicdaiya/nginx-build/builder"
 	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/openresty"
 )
 
-func Generate(configure string, modules3rd []module3rd.Module3rd, dependencies []builder.StaticLibrary, options Options, rootDir string, openResty bool, jobs int) string {
+// Generate generates a configure script. openResty is nil unless OpenResty is built.
+func Generate(configure string, modules3rd []module3rd.Module3rd, dependencies []builder.StaticLibrary, options Options, rootDir string, openResty *openresty.Options, jobs int) string {
 	openSSLStatic := false
 	if len(configure) == 0 {
 		configure = `#!/bin/sh
@@ -17,8 +19,9 @@ func Generate(configure string, modules3rd []module3rd.Module3rd, dependencies [
 `
 	}
 
-	if openResty {
+	if openResty != nil {
 		configure += fmt.Sprintf("-j%d \\\n", jobs)
+		configure += openResty.ConfigureArgs()
 	}
 
 	for _, d := range dependencies {
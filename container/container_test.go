@@ -0,0 +1,234 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDockerfile(t *testing.T) {
+	d := Dockerfile{
+		NginxBuildVersion: "v0.15.0",
+		Packages:          []string{"build-essential", "git", "libssl-dev"},
+		RuntimePackages:   []string{"libssl3"},
+		Files:             []CopyFile{{Src: "context/configure.sh", Dst: "configure.sh"}},
+		Args:              [][]string{{"-v", "1.28.0"}, {"-c", "configure.sh"}, {"-pcre"}, {"-openresty-luajit-xcflags", "-DLUAJIT_NUMMODE=2 -O2"}},
+		BinaryPath:        "/usr/sbin/nginx",
+	}
+	dockerfile := d.Generate()
+
+	wants := []string{
+		"FROM golang:1.22-bookworm AS nginx-build\n",
+		"RUN go install github.com/cubicdaiya/nginx-build@v0.15.0\n",
+		"    build-essential \\\n",
+		"COPY context/configure.sh /nginx-build/configure.sh\n",
+		"    -c configure.sh \\\n",
+		"    -pcre \\\n",
+		"    -openresty-luajit-xcflags '-DLUAJIT_NUMMODE=2 -O2' \\\n",
+		"    -install -destdir /staging\n",
+		"FROM debian:bookworm-slim\nRUN apt-get update \\\n && apt-get install -y --no-install-recommends \\\n    libssl3 \\\n && rm -rf /var/lib/apt/lists/*\n",
+		"COPY --from=build /staging/ /\n",
+		`CMD ["/usr/sbin/nginx", "-g", "daemon off;"]`,
+	}
+	for _, want := range wants {
+		if !strings.Contains(dockerfile, want) {
+			t.Fatalf("Dockerfile does not contain %q:\n%s", want, dockerfile)
+		}
+	}
+}
+
+func TestGenerateDockerfileWithBinary(t *testing.T) {
+	d := Dockerfile{
+		NginxBuildVersion: "(devel)",
+		Binary:            "context/nginx-build",
+		Platform:          "linux/amd64",
+		BinaryPath:        "/usr/local/nginx/sbin/nginx",
+	}
+	dockerfile := d.Generate()
+
+	wants := []string{
+		"FROM --platform=linux/amd64 debian:bookworm AS build\n",
+		"COPY context/nginx-build /usr/local/bin/nginx-build\n",
+		"FROM --platform=linux/amd64 debian:bookworm-slim\n",
+	}
+	for _, want := range wants {
+		if !strings.Contains(dockerfile, want) {
+			t.Fatalf("Dockerfile does not contain %q:\n%s", want, dockerfile)
+		}
+	}
+	// nginx linked statically requires no packages at runtime
+	if strings.Count(dockerfile, "apt-get update") != 1 {
+		t.Fatalf("got: %v, want: %v", strings.Count(dockerfile, "apt-get update"), 1)
+	}
+	for _, unwanted := range []string{"go install", "@latest", "--from=nginx-build"} {
+		if strings.Contains(dockerfile, unwanted) {
+			t.Fatalf("Dockerfile contains %q:\n%s", unwanted, dockerfile)
+		}
+	}
+}
+
+func TestRuntimePackages(t *testing.T) {
+	got := strings.Join(RuntimePackages([]string{"build-essential", "libpcre2-dev", "libssl-dev", "make", "zlib1g-dev"}), " ")
+	if want := "libpcre2-8-0 libssl3 zlib1g"; got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestInstallable(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{version: "v0.15.0", want: true},
+		{version: "v0.15.1-0.20250101000000-0123456789ab", want: true},
+		{version: "v0.15.1-0.20250101000000-0123456789ab+dirty", want: false},
+		{version: "0123456789abcdef0123456789abcdef01234567", want: true},
+		{version: "0123456", want: false},
+		{version: "(devel)", want: false},
+		{version: "", want: false},
+	}
+
+	for _, test := range tests {
+		if got := Installable(test.version); got != test.want {
+			t.Fatalf("got: %v, want: %v (%s)", got, test.want, test.version)
+		}
+	}
+}
+
+// writeELF writes a minimal 64-bit ELF executable which requests a program interpreter when dynamic is true.
+func writeELF(t *testing.T, path string, dynamic bool) {
+	var phnum uint16
+	if dynamic {
+		phnum = 1
+	}
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     64,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     phnum,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		t.Fatal(err)
+	}
+	if dynamic {
+		prog := elf.Prog64{Type: uint32(elf.PT_INTERP)}
+		if err := binary.Write(&buf, binary.LittleEndian, prog); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteLayoutBase(t *testing.T) {
+	root := t.TempDir()
+	writeELF(t, filepath.Join(root, "usr/sbin/nginx"), true)
+	out := filepath.Join(t.TempDir(), "image.tar")
+
+	// a dynamically linked nginx does not run without a base layer
+	img := Image{Ref: "nginx:1.28.0", BinaryPath: "/usr/sbin/nginx", Arch: "amd64"}
+	if err := img.WriteLayout(root, out); err == nil {
+		t.Fatalf("got: %v, want: an error", err)
+	}
+
+	var base bytes.Buffer
+	zw := gzip.NewWriter(&base)
+	tw := tar.NewWriter(zw)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	img.BaseRootfs = filepath.Join(t.TempDir(), "rootfs.tar.gz")
+	if err := os.WriteFile(img.BaseRootfs, base.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := img.WriteLayout(root, out); err != nil {
+		t.Fatalf("got: %v, want: nil", err)
+	}
+}
+
+func TestWriteLayout(t *testing.T) {
+	root := t.TempDir()
+	writeELF(t, filepath.Join(root, "usr/sbin/nginx"), false)
+
+	out := filepath.Join(t.TempDir(), "image.tar")
+	img := Image{Ref: "nginx:1.28.0", BinaryPath: "/usr/sbin/nginx", Arch: "amd64"}
+	if err := img.WriteLayout(root, out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(tr)
+		files[hdr.Name] = body
+	}
+
+	for name, body := range files {
+		if !strings.HasPrefix(name, "blobs/sha256/") {
+			continue
+		}
+		if fmt.Sprintf("%x", sha256.Sum256(body)) != strings.TrimPrefix(name, "blobs/sha256/") {
+			t.Fatalf("digest of %s is mismatched", name)
+		}
+	}
+
+	var index struct {
+		Manifests []descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(files["index.json"], &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Annotations["org.opencontainers.image.ref.name"] != "nginx:1.28.0" {
+		t.Fatalf("unexpected index: %s", files["index.json"])
+	}
+
+	var manifest struct {
+		Layers []descriptor `json:"layers"`
+	}
+	manifestBody := files["blobs/sha256/"+strings.TrimPrefix(index.Manifests[0].Digest, "sha256:")]
+	if err := json.Unmarshal(manifestBody, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 1 {
+		t.Fatalf("unexpected layers: %v", manifest.Layers)
+	}
+}
@@ -0,0 +1,155 @@
+package container
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// commitRe matches a commit hash such as the one given with `make`
+var commitRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// CopyFile is a file copied from the build context into the build stage.
+type CopyFile struct {
+	Src string
+	Dst string
+}
+
+// Dockerfile is the parameters of a multi-stage Dockerfile reproducing a build.
+type Dockerfile struct {
+	// NginxBuildVersion is the version of nginx-build installed with `go install`
+	NginxBuildVersion string
+	// Binary is the path of nginx-build in the build context copied instead of installing it
+	Binary string
+	// Platform is the platform of the build and runtime stages such as linux/amd64 which Binary runs on
+	Platform     string
+	GoImage      string
+	BaseImage    string
+	RuntimeImage string
+	// Packages are installed with apt-get in the build stage
+	Packages []string
+	// RuntimePackages are installed with apt-get in the runtime stage
+	RuntimePackages []string
+	Files           []CopyFile
+	// Args are the options of nginx-build in the build stage. Each option has its value if any.
+	Args [][]string
+	// BinaryPath is the path of nginx in the runtime stage
+	BinaryPath string
+}
+
+const (
+	DefaultGoImage      = "golang:1.22-bookworm"
+	DefaultBaseImage    = "debian:bookworm"
+	DefaultRuntimeImage = "debian:bookworm-slim"
+	// StagingRoot is the staging root of the build stage
+	StagingRoot = "/staging"
+	// WorkDir is the working directory of nginx-build in the build stage
+	WorkDir = "/work"
+	// ContextDir is the directory the build context is copied into
+	ContextDir = "/nginx-build"
+)
+
+// runtimePackages maps the development packages of Debian to the packages of DefaultRuntimeImage providing their shared libraries
+var runtimePackages = map[string]string{
+	"libssl-dev":        "libssl3",
+	"libpcre2-dev":      "libpcre2-8-0",
+	"zlib1g-dev":        "zlib1g",
+	"libxslt1-dev":      "libxslt1.1",
+	"libxml2-dev":       "libxml2",
+	"libgd-dev":         "libgd3",
+	"libgeoip-dev":      "libgeoip1",
+	"libperl-dev":       "libperl5.36",
+	"libatomic-ops-dev": "libatomic-ops1",
+}
+
+// RuntimePackages returns the packages of DefaultRuntimeImage providing the shared libraries of the development packages.
+func RuntimePackages(packages []string) []string {
+	var runtime []string
+	for _, p := range packages {
+		if r, ok := runtimePackages[p]; ok {
+			runtime = append(runtime, r)
+		}
+	}
+	return runtime
+}
+
+// Installable reports whether the version of nginx-build names a release or a commit installable with `go install`.
+// The development builds and the ones with local modifications are not.
+func Installable(version string) bool {
+	if commitRe.MatchString(version) {
+		return true
+	}
+	return strings.HasPrefix(version, "v") && !strings.Contains(version, "+")
+}
+
+// Generate generates the Dockerfile.
+func (d *Dockerfile) Generate() string {
+	goImage := d.GoImage
+	if goImage == "" {
+		goImage = DefaultGoImage
+	}
+	baseImage := d.BaseImage
+	if baseImage == "" {
+		baseImage = DefaultBaseImage
+	}
+	runtimeImage := d.RuntimeImage
+	if runtimeImage == "" {
+		runtimeImage = DefaultRuntimeImage
+	}
+	platform := ""
+	if d.Platform != "" {
+		platform = "--platform=" + d.Platform + " "
+	}
+
+	var b strings.Builder
+	b.WriteString("# generated by nginx-build\n")
+	if d.Binary == "" {
+		fmt.Fprintf(&b, "FROM %s AS nginx-build\n", goImage)
+		fmt.Fprintf(&b, "RUN go install github.com/cubicdaiya/nginx-build@%s\n\n", d.NginxBuildVersion)
+	}
+
+	fmt.Fprintf(&b, "FROM %s%s AS build\n", platform, baseImage)
+	writeAptGet(&b, d.Packages)
+	if d.Binary == "" {
+		b.WriteString("COPY --from=nginx-build /go/bin/nginx-build /usr/local/bin/nginx-build\n")
+	} else {
+		fmt.Fprintf(&b, "COPY %s /usr/local/bin/nginx-build\n", d.Binary)
+	}
+	for _, f := range d.Files {
+		fmt.Fprintf(&b, "COPY %s %s/%s\n", f.Src, ContextDir, f.Dst)
+	}
+	fmt.Fprintf(&b, "WORKDIR %s\n", ContextDir)
+	b.WriteString("RUN nginx-build \\\n")
+	for _, arg := range d.Args {
+		var quoted []string
+		for _, a := range arg {
+			quoted = append(quoted, util.ShellQuote(a))
+		}
+		fmt.Fprintf(&b, "    %s \\\n", strings.Join(quoted, " "))
+	}
+	fmt.Fprintf(&b, "    -d %s \\\n", WorkDir)
+	fmt.Fprintf(&b, "    -install -destdir %s\n\n", StagingRoot)
+
+	fmt.Fprintf(&b, "FROM %s%s\n", platform, runtimeImage)
+	// nginx linked dynamically requires the shared libraries
+	if len(d.RuntimePackages) > 0 {
+		writeAptGet(&b, d.RuntimePackages)
+	}
+	fmt.Fprintf(&b, "COPY --from=build %s/ /\n", StagingRoot)
+	b.WriteString("EXPOSE 80\n")
+	b.WriteString("STOPSIGNAL SIGQUIT\n")
+	fmt.Fprintf(&b, "CMD [\"%s\", \"-g\", \"daemon off;\"]\n", d.BinaryPath)
+	return b.String()
+}
+
+// writeAptGet writes the instruction installing packages with apt-get.
+func writeAptGet(b *strings.Builder, packages []string) {
+	b.WriteString("RUN apt-get update \\\n")
+	b.WriteString(" && apt-get install -y --no-install-recommends \\\n")
+	for _, p := range packages {
+		fmt.Fprintf(b, "    %s \\\n", p)
+	}
+	b.WriteString(" && rm -rf /var/lib/apt/lists/*\n")
+}
@@ -0,0 +1,228 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/packaging"
+)
+
+const (
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// Image is the parameters of an OCI image containing a staged install of nginx.
+type Image struct {
+	// Ref is the reference name of the image such as nginx:1.28.0
+	Ref string
+	// BinaryPath is the path of nginx in the image
+	BinaryPath string
+	// BaseRootfs is an optional gzipped tarball of a minimal root filesystem such as Alpine's minirootfs
+	BaseRootfs string
+	// Arch is GOARCH of the image. runtime.GOARCH is used when empty.
+	Arch string
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type blob struct {
+	digest string
+	body   []byte
+}
+
+func newBlob(body []byte) blob {
+	return blob{digest: digest(body), body: body}
+}
+
+func digest(body []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+}
+
+// layerFromTar returns the gzipped layer and the digest of the uncompressed layer (diff ID).
+func layerFromTar(tarball []byte) (blob, string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(tarball); err != nil {
+		return blob{}, "", err
+	}
+	if err := zw.Close(); err != nil {
+		return blob{}, "", err
+	}
+	return newBlob(buf.Bytes()), digest(tarball), nil
+}
+
+func layerFromGzip(path string) (blob, string, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return blob{}, "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return blob{}, "", fmt.Errorf("%s is not gzipped: %w", path, err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, zr); err != nil {
+		return blob{}, "", err
+	}
+	return newBlob(body), fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// dynamicallyLinked reports whether the ELF binary at path requests a program interpreter such as ld-linux.so.
+func dynamicallyLinked(path string) (bool, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_INTERP {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// WriteLayout writes an OCI image layout tarball of the staging root into out.
+func (img *Image) WriteLayout(stagingRoot, out string) error {
+	var (
+		layers  []blob
+		diffIDs []string
+	)
+
+	// the image has nothing but the staging root without a base layer
+	if img.BaseRootfs == "" {
+		binaryPath := filepath.Join(stagingRoot, img.BinaryPath)
+		dynamic, err := dynamicallyLinked(binaryPath)
+		if err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", binaryPath, err)
+		}
+		if dynamic {
+			return fmt.Errorf("%s is dynamically linked and needs a base root filesystem with its loader and libc", binaryPath)
+		}
+	} else {
+		layer, diffID, err := layerFromGzip(img.BaseRootfs)
+		if err != nil {
+			return err
+		}
+		layers = append(layers, layer)
+		diffIDs = append(diffIDs, diffID)
+	}
+
+	var tarball bytes.Buffer
+	if err := packaging.WriteTree(&tarball, stagingRoot); err != nil {
+		return err
+	}
+	layer, diffID, err := layerFromTar(tarball.Bytes())
+	if err != nil {
+		return err
+	}
+	layers = append(layers, layer)
+	diffIDs = append(diffIDs, diffID)
+
+	arch := img.Arch
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+
+	config := map[string]interface{}{
+		"created":      time.Now().UTC().Format(time.RFC3339),
+		"architecture": arch,
+		"os":           "linux",
+		"config": map[string]interface{}{
+			"Cmd":          []string{img.BinaryPath, "-g", "daemon off;"},
+			"ExposedPorts": map[string]struct{}{"80/tcp": {}},
+			"StopSignal":   "SIGQUIT",
+		},
+		"rootfs": map[string]interface{}{
+			"type":     "layers",
+			"diff_ids": diffIDs,
+		},
+	}
+	configBody, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	configBlob := newBlob(configBody)
+
+	var layerDescs []descriptor
+	for _, l := range layers {
+		layerDescs = append(layerDescs, descriptor{MediaType: mediaTypeLayer, Digest: l.digest, Size: int64(len(l.body))})
+	}
+	manifest := map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     mediaTypeManifest,
+		"config":        descriptor{MediaType: mediaTypeConfig, Digest: configBlob.digest, Size: int64(len(configBody))},
+		"layers":        layerDescs,
+	}
+	manifestBody, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestBlob := newBlob(manifestBody)
+
+	index := map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests": []descriptor{
+			{
+				MediaType:   mediaTypeManifest,
+				Digest:      manifestBlob.digest,
+				Size:        int64(len(manifestBody)),
+				Annotations: map[string]string{"org.opencontainers.image.ref.name": img.Ref},
+			},
+		},
+	}
+	indexBody, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	add := func(name string, body []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(body)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(body)
+		return err
+	}
+
+	if err := add("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+	if err := add("index.json", indexBody); err != nil {
+		return err
+	}
+	blobs := append([]blob{configBlob, manifestBlob}, layers...)
+	for _, b := range blobs {
+		if err := add("blobs/sha256/"+b.digest[len("sha256:"):], b.body); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
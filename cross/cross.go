@@ -0,0 +1,359 @@
+package cross
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// envName is the environment variable given to the configure script of nginx in cross-compiling.
+// The checks rewritten by PrepareSource do not run the tests compiled with it.
+const envName = "NGX_BUILD_CROSS"
+
+var (
+	tripleRe = regexp.MustCompile(`^[A-Za-z0-9_.]+(-[A-Za-z0-9_.]+){1,3}$`)
+	// the paths are written into the configure script and Makefile without quoting
+	pathRe = regexp.MustCompile(`^[A-Za-z0-9_./+,:@=-]+$`)
+)
+
+// Target is the target of cross-compiling nginx and the static libraries.
+type Target struct {
+	// Triple is the target triple such as aarch64-linux-gnu
+	Triple string
+	// Prefix is the prefix of the toolchain such as /opt/cross/bin/aarch64-linux-gnu-. It is Triple + "-" when it is empty.
+	Prefix string
+	// Sysroot is the root directory of the headers and the libraries of the target given to the compiler with --sysroot
+	Sysroot string
+}
+
+// arch is a CPU architecture nginx-build cross-compiles for.
+type arch struct {
+	goarch string
+	elf    elf.Machine
+	macho  macho.Cpu
+	// openssl maps the systems to the targets of Configure of OpenSSL
+	openssl map[string]string
+}
+
+var archs = map[string]arch{
+	"x86_64":      {goarch: "amd64", elf: elf.EM_X86_64, macho: macho.CpuAmd64, openssl: map[string]string{"linux": "linux-x86_64", "darwin": "darwin64-x86_64-cc"}},
+	"aarch64":     {goarch: "arm64", elf: elf.EM_AARCH64, macho: macho.CpuArm64, openssl: map[string]string{"linux": "linux-aarch64", "darwin": "darwin64-arm64-cc"}},
+	"x86":         {goarch: "386", elf: elf.EM_386, openssl: map[string]string{"linux": "linux-x86"}},
+	"arm":         {goarch: "arm", elf: elf.EM_ARM, openssl: map[string]string{"linux": "linux-armv4"}},
+	"riscv64":     {goarch: "riscv64", elf: elf.EM_RISCV, openssl: map[string]string{"linux": "linux64-riscv64"}},
+	"ppc64le":     {goarch: "ppc64le", elf: elf.EM_PPC64, openssl: map[string]string{"linux": "linux-ppc64le"}},
+	"ppc64":       {goarch: "ppc64", elf: elf.EM_PPC64, openssl: map[string]string{"linux": "linux-ppc64"}},
+	"s390x":       {goarch: "s390x", elf: elf.EM_S390, openssl: map[string]string{"linux": "linux64-s390x"}},
+	"mips64":      {goarch: "mips64", elf: elf.EM_MIPS, openssl: map[string]string{"linux": "linux64-mips64"}},
+	"loongarch64": {goarch: "loong64", elf: elf.EM_LOONGARCH, openssl: map[string]string{"linux": "linux64-loongarch64"}},
+}
+
+// systems maps the systems of the triples to the names of the systems of nginx's --crossbuild.
+var systems = map[string]string{
+	"linux":  "Linux",
+	"darwin": "Darwin",
+}
+
+// archName normalizes the architecture of the triple such as arm64 and armv7l.
+func (t *Target) archName() string {
+	name, _, _ := strings.Cut(t.Triple, "-")
+	switch {
+	case name == "amd64":
+		return "x86_64"
+	case name == "arm64":
+		return "aarch64"
+	case regexp.MustCompile(`^i[3-6]86$`).MatchString(name):
+		return "x86"
+	case strings.HasPrefix(name, "arm"):
+		return "arm"
+	case name == "powerpc64le":
+		return "ppc64le"
+	case name == "powerpc64":
+		return "ppc64"
+	case name == "mips64el":
+		return "mips64"
+	}
+	return name
+}
+
+// GOARCH returns the architecture of the target in GOARCH such as arm64, which names the architecture of the packages and the images.
+func (t *Target) GOARCH() string {
+	name, _, _ := strings.Cut(t.Triple, "-")
+	if name == "mips64el" {
+		return "mips64le"
+	}
+	return archs[t.archName()].goarch
+}
+
+// GOOS returns the system of the target in GOOS such as linux.
+func (t *Target) GOOS() string {
+	return t.system()
+}
+
+// bigEndian reports whether the target is big-endian.
+func (t *Target) bigEndian() bool {
+	name, _, _ := strings.Cut(t.Triple, "-")
+	switch name {
+	case "ppc64", "powerpc64", "s390x", "mips64", "armeb":
+		return true
+	}
+	return false
+}
+
+// system returns the system of the triple such as linux of aarch64-unknown-linux-gnu.
+func (t *Target) system() string {
+	for _, field := range strings.Split(t.Triple, "-")[1:] {
+		for name := range systems {
+			if strings.HasPrefix(field, name) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// Validate checks the target is available for cross-compiling.
+func (t *Target) Validate() error {
+	if !tripleRe.MatchString(t.Triple) {
+		return fmt.Errorf("target %s is not a target triple such as aarch64-linux-gnu", t.Triple)
+	}
+	system := t.system()
+	if system == "" {
+		return fmt.Errorf("the system of target %s is not supported (one of linux, darwin)", t.Triple)
+	}
+	a, ok := archs[t.archName()]
+	if !ok || (system == "darwin" && a.macho == 0) {
+		return fmt.Errorf("the architecture of target %s is not supported", t.Triple)
+	}
+	if t.Prefix != "" && !pathRe.MatchString(t.Prefix) {
+		return fmt.Errorf("toolchain prefix %s has the characters not available", t.Prefix)
+	}
+	if t.Sysroot != "" && !pathRe.MatchString(t.Sysroot) {
+		return fmt.Errorf("sysroot %s has the characters not available", t.Sysroot)
+	}
+	return nil
+}
+
+// Tool returns the command of the toolchain such as aarch64-linux-gnu-ar.
+func (t *Target) Tool(name string) string {
+	prefix := t.Prefix
+	if prefix == "" {
+		prefix = t.Triple + "-"
+	}
+	return prefix + name
+}
+
+// CC returns the C compiler with the sysroot. The sysroot is a part of the compiler
+// so that nginx and the static libraries built by the Makefile of nginx are compiled and linked with it.
+func (t *Target) CC() string {
+	if t.Sysroot == "" {
+		return t.Tool("gcc")
+	}
+	return fmt.Sprintf("%s --sysroot=%s", t.Tool("gcc"), t.Sysroot)
+}
+
+// Crossbuild returns the value of --crossbuild of nginx such as Linux::aarch64.
+func (t *Target) Crossbuild() string {
+	name, _, _ := strings.Cut(t.Triple, "-")
+	return fmt.Sprintf("%s::%s", systems[t.system()], name)
+}
+
+// OpenSSLTarget returns the target of Configure of OpenSSL such as linux-aarch64.
+func (t *Target) OpenSSLTarget() string {
+	return archs[t.archName()].openssl[t.system()]
+}
+
+// ConfigureArgs returns the options of the configure script of nginx cross-compiling for the target.
+// The configure script of PCRE2 built statically is given the target with --host.
+func (t *Target) ConfigureArgs(staticPcre bool) string {
+	args := fmt.Sprintf("--crossbuild=%s \\\n--with-cc='%s' \\\n", t.Crossbuild(), t.CC())
+	if staticPcre {
+		args += fmt.Sprintf("--with-pcre-conf-opt=--host=%s \\\n", t.Triple)
+	}
+	return args
+}
+
+// ConfigureEnv returns the environment of the configure script of nginx prepared by PrepareSource.
+// It has the byte order of the target as the test finding it is not run.
+func (t *Target) ConfigureEnv() []string {
+	endian := "little"
+	if t.bigEndian() {
+		endian = "big"
+	}
+	return []string{envName + "=" + endian}
+}
+
+// Env returns the environment of make building nginx and the static libraries.
+// The configure script of zlib finds the toolchain with them.
+func (t *Target) Env() []string {
+	return []string{
+		"CHOST=" + t.Triple,
+		"AR=" + t.Tool("ar"),
+		"RANLIB=" + t.Tool("ranlib"),
+	}
+}
+
+// the checks of the configure script of nginx running the tests compiled
+const (
+	sizeofPath     = "auto/types/sizeof"
+	sizeofRun      = "ngx_size=`$NGX_AUTOTEST`"
+	featurePath    = "auto/feature"
+	featureRun     = "if /bin/sh -c $NGX_AUTOTEST >> $NGX_AUTOCONF_ERR 2>&1; then"
+	endiannessPath = "auto/endianness"
+	endiannessRun  = "if $NGX_AUTOTEST >/dev/null 2>&1; then"
+)
+
+// sizeofCross finds the size of the type by compiling the tests asserting the sizes.
+const sizeofCross = `if [ -n "$` + envName + `" ]; then
+        # the size is found by compiling the tests without running them in cross-compiling
+        ngx_size=
+        mv $NGX_AUTOTEST.c $NGX_AUTOTEST.in.c
+        for ngx_cross_size in 1 2 4 8 16; do
+            sed -e "s/printf(.*/static char ngx_cross_test[sizeof($ngx_type) == $ngx_cross_size ? 1 : -1]; (void) ngx_cross_test;/" \
+                $NGX_AUTOTEST.in.c > $NGX_AUTOTEST.c
+            rm -f $NGX_AUTOTEST
+            eval "$ngx_test >> $NGX_AUTOCONF_ERR 2>&1"
+            if [ -x $NGX_AUTOTEST ]; then
+                ngx_size=$ngx_cross_size
+                break
+            fi
+        done
+    else
+        ` + sizeofRun + `
+    fi`
+
+// featureCross assumes the features compiled work, and the bugs compiled do not exist in cross-compiling.
+const featureCross = `if [ -n "$` + envName + `" ] || /bin/sh -c $NGX_AUTOTEST >> $NGX_AUTOCONF_ERR 2>&1; then`
+
+// endiannessCross takes the byte order from the environment in cross-compiling.
+const endiannessCross = `if [ "$` + envName + `" = little ] || { [ -z "$` + envName + `" ] && $NGX_AUTOTEST >/dev/null 2>&1; }; then`
+
+// caseLabelRe matches the labels of the cases of $ngx_feature_run in auto/feature.
+var caseLabelRe = regexp.MustCompile(`(?m)^\s*(\w+)\)\s*$`)
+
+// rewriteFeature rewrites the checks of the features and the bugs in auto/feature.
+// The checks of the values are left as the values are the output of the tests.
+func rewriteFeature(content string) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(content, featureRun)
+		if i < 0 {
+			b.WriteString(content)
+			return b.String()
+		}
+		labels := caseLabelRe.FindAllStringSubmatch(content[:i], -1)
+		b.WriteString(content[:i])
+		if len(labels) > 0 && labels[len(labels)-1][1] == "value" {
+			b.WriteString(featureRun)
+		} else {
+			b.WriteString(featureCross)
+		}
+		content = content[i+len(featureRun):]
+	}
+}
+
+// PrepareSource rewrites the checks of the configure script of nginx in srcDir running the tests compiled,
+// so that the configure script with ConfigureEnv does not run them. The checks work as before without it.
+func PrepareSource(srcDir string) error {
+	rewrites := []struct {
+		path    string
+		run     string
+		rewrite func(string) string
+	}{
+		{path: sizeofPath, run: sizeofRun, rewrite: func(s string) string { return strings.Replace(s, sizeofRun, sizeofCross, 1) }},
+		{path: featurePath, run: featureRun, rewrite: rewriteFeature},
+		{path: endiannessPath, run: endiannessRun, rewrite: func(s string) string { return strings.Replace(s, endiannessRun, endiannessCross, 1) }},
+	}
+	for _, r := range rewrites {
+		path := filepath.Join(srcDir, r.path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		content := string(data)
+		if strings.Contains(content, envName) {
+			continue
+		}
+		if !strings.Contains(content, r.run) {
+			return fmt.Errorf("%s of %s is not known for cross-compiling", r.path, filepath.Base(srcDir))
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(r.rewrite(content)), info.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prepared reports whether the configure script of nginx in srcDir is rewritten by PrepareSource.
+func Prepared(srcDir string) (bool, error) {
+	for _, path := range []string{sizeofPath, featurePath, endiannessPath} {
+		data, err := os.ReadFile(filepath.Join(srcDir, path))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if strings.Contains(string(data), envName) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// opensslConfig is the command configuring OpenSSL in the Makefile of nginx.
+const opensslConfig = "./config --prefix="
+
+// RewriteMakefile rewrites the Makefile of nginx at path to configure OpenSSL for the target
+// with Configure and the toolchain instead of config guessing the host.
+func (t *Target) RewriteMakefile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content := string(data)
+	if !strings.Contains(content, opensslConfig) {
+		return fmt.Errorf("%s does not configure OpenSSL", path)
+	}
+	configure := fmt.Sprintf("./Configure %s 'CC=%s' AR=%s RANLIB=%s --prefix=", t.OpenSSLTarget(), t.CC(), t.Tool("ar"), t.Tool("ranlib"))
+	return os.WriteFile(path, []byte(strings.Replace(content, opensslConfig, configure, 1)), 0644)
+}
+
+// CheckBinary checks the binary at path is built for the target by its header.
+func (t *Target) CheckBinary(path string) error {
+	a := archs[t.archName()]
+	if t.system() == "darwin" {
+		f, err := macho.Open(path)
+		if err != nil {
+			return fmt.Errorf("%s is not a Mach-O binary: %w", path, err)
+		}
+		defer f.Close()
+		if f.Cpu != a.macho {
+			return fmt.Errorf("%s is built for %s, not %s", path, f.Cpu, t.Triple)
+		}
+		return nil
+	}
+
+	f, err := elf.Open(path)
+	if err != nil {
+		var formatErr *elf.FormatError
+		if errors.As(err, &formatErr) {
+			return fmt.Errorf("%s is not an ELF binary: %w", path, err)
+		}
+		return err
+	}
+	defer f.Close()
+	if f.Machine != a.elf {
+		return fmt.Errorf("%s is built for %s, not %s", path, f.Machine, t.Triple)
+	}
+	return nil
+}
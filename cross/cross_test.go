@@ -0,0 +1,283 @@
+package cross
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		target Target
+		ok     bool
+	}{
+		{target: Target{Triple: "aarch64-linux-gnu"}, ok: true},
+		{target: Target{Triple: "aarch64-unknown-linux-musl", Prefix: "/opt/cross/bin/aarch64-linux-musl-", Sysroot: "/opt/sysroot"}, ok: true},
+		{target: Target{Triple: "armv7l-linux-gnueabihf"}, ok: true},
+		{target: Target{Triple: "i686-pc-linux-gnu"}, ok: true},
+		{target: Target{Triple: "arm64-apple-darwin23"}, ok: true},
+		{target: Target{Triple: "riscv64-apple-darwin"}, ok: false},
+		{target: Target{Triple: "x86_64-w64-mingw32"}, ok: false},
+		{target: Target{Triple: "sparc64-linux-gnu"}, ok: false},
+		{target: Target{Triple: "aarch64"}, ok: false},
+		{target: Target{Triple: "aarch64-linux-gnu; rm -rf /"}, ok: false},
+		{target: Target{Triple: "aarch64-linux-gnu", Sysroot: "/opt/my sysroot"}, ok: false},
+		{target: Target{Triple: "aarch64-linux-gnu", Prefix: "$(id)"}, ok: false},
+	}
+
+	for _, test := range tests {
+		err := test.target.Validate()
+		if (err == nil) != test.ok {
+			t.Fatalf("got: %v, want: %v (%s)", err, test.ok, test.target.Triple)
+		}
+	}
+}
+
+func TestTarget(t *testing.T) {
+	tests := []struct {
+		target     Target
+		crossbuild string
+		openssl    string
+		cc         string
+		env        string
+		goarch     string
+	}{
+		{
+			target:     Target{Triple: "aarch64-linux-gnu"},
+			crossbuild: "Linux::aarch64",
+			openssl:    "linux-aarch64",
+			cc:         "aarch64-linux-gnu-gcc",
+			env:        "NGX_BUILD_CROSS=little",
+			goarch:     "arm64",
+		},
+		{
+			target:     Target{Triple: "armv7l-linux-gnueabihf", Prefix: "/opt/cross/bin/arm-", Sysroot: "/opt/sysroot"},
+			crossbuild: "Linux::armv7l",
+			openssl:    "linux-armv4",
+			cc:         "/opt/cross/bin/arm-gcc --sysroot=/opt/sysroot",
+			env:        "NGX_BUILD_CROSS=little",
+			goarch:     "arm",
+		},
+		{
+			target:     Target{Triple: "s390x-ibm-linux-gnu"},
+			crossbuild: "Linux::s390x",
+			openssl:    "linux64-s390x",
+			cc:         "s390x-ibm-linux-gnu-gcc",
+			env:        "NGX_BUILD_CROSS=big",
+			goarch:     "s390x",
+		},
+		{
+			target:     Target{Triple: "arm64-apple-darwin23"},
+			crossbuild: "Darwin::arm64",
+			openssl:    "darwin64-arm64-cc",
+			cc:         "arm64-apple-darwin23-gcc",
+			env:        "NGX_BUILD_CROSS=little",
+			goarch:     "arm64",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.target.Crossbuild(); got != test.crossbuild {
+			t.Fatalf("got: %v, want: %v", got, test.crossbuild)
+		}
+		if got := test.target.OpenSSLTarget(); got != test.openssl {
+			t.Fatalf("got: %v, want: %v", got, test.openssl)
+		}
+		if got := test.target.CC(); got != test.cc {
+			t.Fatalf("got: %v, want: %v", got, test.cc)
+		}
+		if got := test.target.ConfigureEnv()[0]; got != test.env {
+			t.Fatalf("got: %v, want: %v", got, test.env)
+		}
+		if got := test.target.GOARCH(); got != test.goarch {
+			t.Fatalf("got: %v, want: %v", got, test.goarch)
+		}
+	}
+}
+
+func TestConfigureArgs(t *testing.T) {
+	target := Target{Triple: "aarch64-linux-gnu", Sysroot: "/opt/sysroot"}
+	want := "--crossbuild=Linux::aarch64 \\\n--with-cc='aarch64-linux-gnu-gcc --sysroot=/opt/sysroot' \\\n"
+	if got := target.ConfigureArgs(false); got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+	want += "--with-pcre-conf-opt=--host=aarch64-linux-gnu \\\n"
+	if got := target.ConfigureArgs(true); got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestRewriteMakefile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Makefile")
+	makefile := "../openssl-3.5.0/.openssl/include/openssl/ssl.h:\tobjs/Makefile\n" +
+		"\tcd ../openssl-3.5.0 \\\n" +
+		"\t&& ./config --prefix=/src/openssl-3.5.0/.openssl no-shared no-threads  \\\n" +
+		"\t&& $(MAKE) \\\n"
+	if err := os.WriteFile(path, []byte(makefile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	target := Target{Triple: "aarch64-linux-gnu", Sysroot: "/opt/sysroot"}
+	if err := target.RewriteMakefile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "&& ./Configure linux-aarch64 'CC=aarch64-linux-gnu-gcc --sysroot=/opt/sysroot' AR=aarch64-linux-gnu-ar RANLIB=aarch64-linux-gnu-ranlib --prefix=/src/openssl-3.5.0/.openssl no-shared"
+	if !strings.Contains(string(data), want) {
+		t.Fatalf("got: %v, want: %v", string(data), want)
+	}
+
+	if err := os.WriteFile(path, []byte("all:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.RewriteMakefile(path); err == nil {
+		t.Fatalf("got: %v, want: an error", err)
+	}
+}
+
+// the checks of nginx running the tests compiled
+const (
+	sizeofScript = `ngx_size=
+
+cat << END > $NGX_AUTOTEST.c
+
+#include <stdio.h>
+
+int main(void) {
+    printf("%d", (int) sizeof($ngx_type));
+    return 0;
+}
+
+END
+
+ngx_test="$CC -o $NGX_AUTOTEST $NGX_AUTOTEST.c"
+
+eval "$ngx_test >> $NGX_AUTOCONF_ERR 2>&1"
+
+if [ -x $NGX_AUTOTEST ]; then
+    ngx_size=` + "`$NGX_AUTOTEST`" + `
+    echo " $ngx_size bytes"
+fi
+
+rm -rf $NGX_AUTOTEST*
+`
+	featureScript = `    case "$ngx_feature_run" in
+
+        yes)
+            if /bin/sh -c $NGX_AUTOTEST >> $NGX_AUTOCONF_ERR 2>&1; then
+                echo " found"
+            fi
+        ;;
+
+        value)
+            if /bin/sh -c $NGX_AUTOTEST >> $NGX_AUTOCONF_ERR 2>&1; then
+                echo " found"
+            fi
+        ;;
+
+        bug)
+            if /bin/sh -c $NGX_AUTOTEST >> $NGX_AUTOCONF_ERR 2>&1; then
+                echo " not found"
+            fi
+        ;;
+    esac
+`
+	endiannessScript = `if [ -x $NGX_AUTOTEST ]; then
+    if $NGX_AUTOTEST >/dev/null 2>&1; then
+        echo " little endian"
+    else
+        echo " big endian"
+    fi
+fi
+`
+)
+
+func TestPrepareSource(t *testing.T) {
+	srcDir := t.TempDir()
+	scripts := map[string]string{
+		sizeofPath:     sizeofScript,
+		featurePath:    featureScript,
+		endiannessPath: endiannessScript,
+	}
+	for path, script := range scripts {
+		if err := os.MkdirAll(filepath.Join(srcDir, filepath.Dir(path)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, path), []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// PrepareSource is idempotent
+	for i := 0; i < 2; i++ {
+		if err := PrepareSource(srcDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(srcDir, featurePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), featureCross); got != 2 {
+		t.Fatalf("got: %v, want: %v", got, 2)
+	}
+	if got := strings.Count(string(data), "            "+featureRun); got != 1 {
+		t.Fatalf("got: %v, want: %v", got, 1)
+	}
+
+	// the size is found without running the test
+	for _, env := range []string{"NGX_BUILD_CROSS=little", ""} {
+		cmd := exec.Command("sh", filepath.Join(srcDir, sizeofPath))
+		cmd.Dir = t.TempDir()
+		cmd.Env = append(os.Environ(), "CC=cc", "ngx_type=int", "NGX_AUTOTEST=./autotest", "NGX_AUTOCONF_ERR=autoconf.err", env)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("got: %v, want: nil (%s)", err, out)
+		}
+		if got, want := strings.TrimSpace(string(out)), "4 bytes"; got != want {
+			t.Fatalf("got: %v, want: %v", got, want)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(srcDir, featurePath), []byte("unknown\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrepareSource(srcDir); err == nil {
+		t.Fatalf("got: %v, want: an error", err)
+	}
+}
+
+func TestCheckBinary(t *testing.T) {
+	triples := map[string]string{"amd64": "x86_64-linux-gnu", "arm64": "aarch64-linux-gnu"}
+	triple, ok := triples[runtime.GOARCH]
+	if runtime.GOOS != "linux" || !ok {
+		return
+	}
+	path, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target Target
+		ok     bool
+	}{
+		{target: Target{Triple: triple}, ok: true},
+		{target: Target{Triple: "riscv64-linux-gnu"}, ok: false},
+		{target: Target{Triple: "arm64-apple-darwin"}, ok: false},
+	}
+
+	for _, test := range tests {
+		err := test.target.CheckBinary(path)
+		if (err == nil) != test.ok {
+			t.Fatalf("got: %v, want: %v (%s)", err, test.ok, test.target.Triple)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cubicdaiya/nginx-build/doctor"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+)
+
+// runDoctor checks the commands and the headers required for building nginx by spec are available
+// and reports all the missing ones at once with the hint for installing them.
+func runDoctor(ctx context.Context, spec nginxbuild.Spec, jsonOutput bool) {
+	target, err := nginxbuild.DoctorTarget(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reqs := doctor.Requirements(target)
+	report := doctor.Check(ctx, reqs, target.Configure)
+	distro := doctor.Distro()
+	hint := doctor.Hint(distro, append(report.Missing, report.Unchecked...))
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			Requirements []doctor.Requirement `json:"requirements"`
+			doctor.Report
+			Distro string `json:"distro,omitempty"`
+			Hint   string `json:"hint,omitempty"`
+		}{reqs, report, distro, hint})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if report.OK() {
+		log.Printf("All the %d commands and headers required for building nginx are available.", len(reqs))
+		return
+	}
+	for _, r := range report.Missing {
+		fmt.Fprintf(os.Stderr, "[missing] %s (required by %s)\n", r, r.Reason)
+	}
+	for _, r := range report.Unchecked {
+		fmt.Fprintf(os.Stderr, "[unchecked] %s (required by %s) as no C compiler is found\n", r, r.Reason)
+	}
+	if hint != "" {
+		fmt.Fprintf(os.Stderr, "\nInstall them with:\n  %s\n", hint)
+	}
+	os.Exit(1)
+}
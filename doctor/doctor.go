@@ -0,0 +1,466 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Kind is the kind of a requirement.
+type Kind string
+
+const (
+	// Command is a command found in PATH
+	Command Kind = "command"
+	// Header is a C header found by the C compiler
+	Header Kind = "header"
+)
+
+// the distributions of the install hints
+const (
+	Debian = "debian"
+	RHEL   = "rhel"
+	Alpine = "alpine"
+	Arch   = "arch"
+	SUSE   = "suse"
+	Darwin = "darwin"
+)
+
+// Requirement is a command or a header required for building nginx.
+type Requirement struct {
+	Kind Kind `json:"kind"`
+	// Names is the alternatives of the command or the header
+	Names []string `json:"names"`
+	// Reason is what requires it such as --with-http_xslt_module
+	Reason string `json:"reason"`
+	// Packages is the packages providing it by distribution
+	Packages map[string]string `json:"-"`
+	// IncludeDirs is the directories searched for the header besides the default ones
+	IncludeDirs []string `json:"-"`
+}
+
+// String returns the name of the requirement such as "command: cc or gcc or clang".
+func (r Requirement) String() string {
+	return fmt.Sprintf("%s: %s", r.Kind, strings.Join(r.Names, " or "))
+}
+
+// Target is what a build of nginx consists of.
+type Target struct {
+	// Configure is the configure script of nginx
+	Configure string
+	// Modules is the 3rd party modules
+	Modules []Module
+	// PerlConfigure is true when the configure script is written in perl such as OpenResty's one
+	PerlConfigure bool
+	// StaticPcre, StaticOpenSSL, StaticLibreSSL and StaticZlib are true when the libraries are built statically
+	StaticPcre     bool
+	StaticOpenSSL  bool
+	StaticLibreSSL bool
+	StaticZlib     bool
+	// NginxTests is true when nginx-tests runs against nginx built
+	NginxTests bool
+	// Cross is the target triple nginx is cross-compiled for and CrossTools is the commands of its toolchain besides the C compiler
+	Cross      string
+	CrossTools []string
+}
+
+// Module is a 3rd party module.
+type Module struct {
+	Name string
+	// Form is the form of the module such as git and hg
+	Form string
+	// Shprov is the shell commands provisioning the module
+	Shprov string
+}
+
+// the packages of the requirements by distribution
+var (
+	compilerPackages = map[string]string{Debian: "build-essential", RHEL: "gcc", Alpine: "build-base", Arch: "base-devel", SUSE: "gcc"}
+	perlPackages     = map[string]string{Debian: "perl", RHEL: "perl", Alpine: "perl", Arch: "perl", SUSE: "perl", Darwin: "perl"}
+	// the packages of the commands run by the module provisioning
+	commandPackages = map[string]map[string]string{
+		"make":     {Debian: "make", RHEL: "make", Alpine: "make", Arch: "make", SUSE: "make"},
+		"tar":      {Debian: "tar", RHEL: "tar", Alpine: "tar", Arch: "tar", SUSE: "tar", Darwin: "gnu-tar"},
+		"patch":    {Debian: "patch", RHEL: "patch", Alpine: "patch", Arch: "patch", SUSE: "patch", Darwin: "gpatch"},
+		"git":      {Debian: "git", RHEL: "git", Alpine: "git", Arch: "git", SUSE: "git", Darwin: "git"},
+		"hg":       {Debian: "mercurial", RHEL: "mercurial", Alpine: "mercurial", Arch: "mercurial", SUSE: "mercurial", Darwin: "mercurial"},
+		"perl":     perlPackages,
+		"cmake":    {Debian: "cmake", RHEL: "cmake", Alpine: "cmake", Arch: "cmake", SUSE: "cmake", Darwin: "cmake"},
+		"autoconf": {Debian: "autoconf", RHEL: "autoconf", Alpine: "autoconf", Arch: "autoconf", SUSE: "autoconf", Darwin: "autoconf"},
+		"automake": {Debian: "automake", RHEL: "automake", Alpine: "automake", Arch: "automake", SUSE: "automake", Darwin: "automake"},
+		"libtool":  {Debian: "libtool", RHEL: "libtool", Alpine: "libtool", Arch: "libtool", SUSE: "libtool", Darwin: "libtool"},
+		"ninja":    {Debian: "ninja-build", RHEL: "ninja-build", Alpine: "samurai", Arch: "ninja", SUSE: "ninja", Darwin: "ninja"},
+		"go":       {Debian: "golang-go", RHEL: "golang", Alpine: "go", Arch: "go", SUSE: "go", Darwin: "go"},
+		"cargo":    {Debian: "cargo", RHEL: "cargo", Alpine: "cargo", Arch: "rust", SUSE: "cargo", Darwin: "rust"},
+		"python3":  {Debian: "python3", RHEL: "python3", Alpine: "python3", Arch: "python", SUSE: "python3", Darwin: "python"},
+	}
+)
+
+// the headers required by the configure options of nginx
+var optionHeaders = []struct {
+	options []string
+	req     Requirement
+}{
+	{
+		options: []string{"--with-http_xslt_module"},
+		req: Requirement{
+			Kind:     Header,
+			Names:    []string{"libxslt/xslt.h"},
+			Packages: map[string]string{Debian: "libxslt1-dev", RHEL: "libxslt-devel", Alpine: "libxslt-dev", Arch: "libxslt", SUSE: "libxslt-devel", Darwin: "libxslt"},
+		},
+	},
+	{
+		options: []string{"--with-http_xslt_module"},
+		req: Requirement{
+			Kind:     Header,
+			Names:    []string{"libxml/parser.h"},
+			Packages: map[string]string{Debian: "libxml2-dev", RHEL: "libxml2-devel", Alpine: "libxml2-dev", Arch: "libxml2", SUSE: "libxml2-devel", Darwin: "libxml2"},
+			// the directories nginx searches for libxml2
+			IncludeDirs: []string{"/usr/include/libxml2", "/usr/local/include/libxml2", "/usr/pkg/include/libxml2", "/opt/local/include/libxml2", "/opt/homebrew/include/libxml2"},
+		},
+	},
+	{
+		options: []string{"--with-http_image_filter_module"},
+		req: Requirement{
+			Kind:     Header,
+			Names:    []string{"gd.h"},
+			Packages: map[string]string{Debian: "libgd-dev", RHEL: "gd-devel", Alpine: "gd-dev", Arch: "gd", SUSE: "gd-devel", Darwin: "gd"},
+		},
+	},
+	{
+		options: []string{"--with-http_geoip_module", "--with-stream_geoip_module"},
+		req: Requirement{
+			Kind:     Header,
+			Names:    []string{"GeoIP.h"},
+			Packages: map[string]string{Debian: "libgeoip-dev", RHEL: "GeoIP-devel", Alpine: "geoip-dev", Arch: "geoip", SUSE: "libGeoIP-devel", Darwin: "geoip"},
+		},
+	},
+	{
+		options: []string{"--with-http_perl_module"},
+		req: Requirement{
+			Kind:     Command,
+			Names:    []string{"perl"},
+			Packages: map[string]string{Debian: "libperl-dev", RHEL: "perl-ExtUtils-Embed", Alpine: "perl-dev", Arch: "perl", SUSE: "perl", Darwin: "perl"},
+		},
+	},
+	{
+		options: []string{"--with-libatomic"},
+		req: Requirement{
+			Kind:     Header,
+			Names:    []string{"atomic_ops.h"},
+			Packages: map[string]string{Debian: "libatomic-ops-dev", RHEL: "libatomic_ops-devel", Alpine: "libatomic_ops-dev", Arch: "libatomic_ops", SUSE: "libatomic_ops-devel", Darwin: "libatomic_ops"},
+		},
+	},
+}
+
+// the options requiring OpenSSL
+var sslOptions = []string{"--with-http_ssl_module", "--with-stream_ssl_module", "--with-mail_ssl_module", "--with-http_v3_module"}
+
+// hasOption reports whether configure has one of options without a value such as --with-libatomic=DIR.
+func hasOption(configure string, options ...string) (string, bool) {
+	for _, option := range options {
+		re := regexp.MustCompile(`(?:^|\s)` + regexp.QuoteMeta(option) + `(?:=dynamic)?(?:\s|$)`)
+		if re.MatchString(configure) {
+			return option, true
+		}
+	}
+	return "", false
+}
+
+// Compiler returns the C compiler configure uses.
+func Compiler(configure string) []string {
+	if m := regexp.MustCompile(`--with-cc=(\S+)`).FindStringSubmatch(configure); m != nil {
+		return []string{strings.Trim(m[1], `'"`)}
+	}
+	return []string{"cc", "gcc", "clang"}
+}
+
+// includeFlags returns the -I flags in --with-cc-opt of configure.
+func includeFlags(configure string) []string {
+	var flags []string
+	for _, m := range regexp.MustCompile(`--with-cc-opt=(?:'([^']*)'|"([^"]*)"|(\S+))`).FindAllStringSubmatch(configure, -1) {
+		for _, f := range strings.Fields(m[1] + m[2] + m[3]) {
+			if strings.HasPrefix(f, "-I") {
+				flags = append(flags, f)
+			}
+		}
+	}
+	return flags
+}
+
+// shellBuiltins are not looked up in PATH
+var shellBuiltins = map[string]bool{
+	"cd": true, "export": true, "echo": true, "test": true, "[": true, "true": true, "false": true,
+	"set": true, "exit": true, "source": true, ".": true, "env": true, "sh": true,
+}
+
+// ShprovCommands returns the commands run by shprov.
+// The commands in a path such as ./configure are excluded as they are in the module.
+func ShprovCommands(shprov string) []string {
+	var commands []string
+	seen := make(map[string]bool)
+	for _, c := range regexp.MustCompile(`&&|\|\||[;|()\n]`).Split(shprov, -1) {
+		fields := strings.Fields(c)
+		// skip the environment variables such as CFLAGS=-O2 make
+		for len(fields) > 0 && strings.Contains(fields[0], "=") {
+			fields = fields[1:]
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		name := fields[0]
+		if strings.Contains(name, "/") || shellBuiltins[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		commands = append(commands, name)
+	}
+	return commands
+}
+
+func command(name, reason string) Requirement {
+	return Requirement{Kind: Command, Names: []string{name}, Reason: reason, Packages: commandPackages[name]}
+}
+
+// Requirements returns the commands and the headers required for building target.
+func Requirements(target Target) []Requirement {
+	reqs := []Requirement{
+		command("sh", "build"),
+		command("tar", "build"),
+		command("make", "build"),
+	}
+	if target.Cross == "" {
+		reqs = append(reqs, Requirement{Kind: Command, Names: Compiler(target.Configure), Reason: "build", Packages: compilerPackages})
+	} else {
+		reason := fmt.Sprintf("cross-compiling for %s", target.Cross)
+		reqs = append(reqs, Requirement{Kind: Command, Names: Compiler(target.Configure), Reason: reason, Packages: map[string]string{Debian: "gcc-" + target.Cross}})
+		for _, tool := range target.CrossTools {
+			reqs = append(reqs, Requirement{Kind: Command, Names: []string{tool}, Reason: reason, Packages: map[string]string{Debian: "binutils-" + target.Cross}})
+		}
+	}
+
+	if target.PerlConfigure {
+		reqs = append(reqs, command("perl", "configure"))
+	}
+	if target.StaticOpenSSL {
+		reqs = append(reqs, command("perl", "OpenSSL built statically"))
+	}
+	if target.NginxTests {
+		reqs = append(reqs, command("perl", "nginx-tests"))
+	}
+
+	for _, m := range target.Modules {
+		if m.Form == "git" || m.Form == "hg" {
+			reqs = append(reqs, command(m.Form, fmt.Sprintf("module %s", m.Name)))
+		}
+		for _, c := range ShprovCommands(m.Shprov) {
+			reqs = append(reqs, command(c, fmt.Sprintf("shprov of module %s", m.Name)))
+		}
+	}
+
+	for _, h := range optionHeaders {
+		if option, ok := hasOption(target.Configure, h.options...); ok {
+			req := h.req
+			req.Reason = option
+			reqs = append(reqs, req)
+		}
+	}
+
+	if !target.StaticOpenSSL && !target.StaticLibreSSL {
+		if option, ok := hasOption(target.Configure, sslOptions...); ok {
+			reqs = append(reqs, Requirement{
+				Kind:     Header,
+				Names:    []string{"openssl/ssl.h"},
+				Reason:   option,
+				Packages: map[string]string{Debian: "libssl-dev", RHEL: "openssl-devel", Alpine: "openssl-dev", Arch: "openssl", SUSE: "libopenssl-devel", Darwin: "openssl"},
+			})
+		}
+	}
+	if _, ok := hasOption(target.Configure, "--without-http_rewrite_module"); !target.StaticPcre && !ok {
+		reqs = append(reqs, Requirement{
+			Kind:     Header,
+			Names:    []string{"pcre2.h", "pcre.h"},
+			Reason:   "ngx_http_rewrite_module",
+			Packages: map[string]string{Debian: "libpcre2-dev", RHEL: "pcre2-devel", Alpine: "pcre2-dev", Arch: "pcre2", SUSE: "pcre2-devel", Darwin: "pcre2"},
+		})
+	}
+	if _, ok := hasOption(target.Configure, "--without-http_gzip_module"); !target.StaticZlib && !ok {
+		reqs = append(reqs, Requirement{
+			Kind:     Header,
+			Names:    []string{"zlib.h"},
+			Reason:   "ngx_http_gzip_module",
+			Packages: map[string]string{Debian: "zlib1g-dev", RHEL: "zlib-devel", Alpine: "zlib-dev", Arch: "zlib", SUSE: "zlib-devel"},
+		})
+	}
+
+	return dedup(reqs)
+}
+
+// dedup removes the requirements of the same names keeping the first reason.
+func dedup(reqs []Requirement) []Requirement {
+	var result []Requirement
+	seen := make(map[string]bool)
+	for _, r := range reqs {
+		if seen[r.String()] {
+			continue
+		}
+		seen[r.String()] = true
+		result = append(result, r)
+	}
+	return result
+}
+
+// Report is the result of checking the requirements.
+type Report struct {
+	Missing []Requirement `json:"missing"`
+	// Unchecked is the headers not checked without the C compiler
+	Unchecked []Requirement `json:"unchecked"`
+}
+
+// OK reports whether all the requirements are satisfied.
+func (r *Report) OK() bool {
+	return len(r.Missing) == 0 && len(r.Unchecked) == 0
+}
+
+// Check checks the requirements on the host and reports all the missing ones at once.
+func Check(ctx context.Context, reqs []Requirement, configure string) Report {
+	var report Report
+
+	compiler := ""
+	for _, c := range Compiler(configure) {
+		if path, err := exec.LookPath(c); err == nil {
+			compiler = path
+			break
+		}
+	}
+
+	for _, r := range reqs {
+		switch r.Kind {
+		case Command:
+			if !hasCommand(r.Names) {
+				report.Missing = append(report.Missing, r)
+			}
+		case Header:
+			if compiler == "" {
+				report.Unchecked = append(report.Unchecked, r)
+				continue
+			}
+			if !hasHeader(ctx, compiler, r, includeFlags(configure)) {
+				report.Missing = append(report.Missing, r)
+			}
+		}
+	}
+	return report
+}
+
+func hasCommand(names []string) bool {
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHeader reports whether the C compiler preprocesses one of the headers of r.
+func hasHeader(ctx context.Context, compiler string, r Requirement, flags []string) bool {
+	args := []string{"-E", "-x", "c", "-o", "/dev/null"}
+	args = append(args, flags...)
+	for _, dir := range r.IncludeDirs {
+		args = append(args, "-I"+dir)
+	}
+	args = append(args, "-")
+	for _, name := range r.Names {
+		cmd := exec.CommandContext(ctx, compiler, args...)
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("#include <%s>\n", name))
+		if err := cmd.Run(); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOSRelease returns the distribution of the hints from the contents of /etc/os-release.
+func ParseOSRelease(osRelease []byte) string {
+	var ids []string
+	for _, line := range bytes.Split(osRelease, []byte("\n")) {
+		k, v, ok := strings.Cut(string(line), "=")
+		if !ok || (k != "ID" && k != "ID_LIKE") {
+			continue
+		}
+		ids = append(ids, strings.Fields(strings.Trim(v, `"'`))...)
+	}
+	for _, id := range ids {
+		switch id {
+		case "debian", "ubuntu":
+			return Debian
+		case "rhel", "fedora", "centos", "rocky", "almalinux", "amzn":
+			return RHEL
+		case "alpine":
+			return Alpine
+		case "arch", "archlinux", "manjaro":
+			return Arch
+		case "suse", "opensuse", "sles", "opensuse-leap", "opensuse-tumbleweed":
+			return SUSE
+		}
+	}
+	return ""
+}
+
+// the install commands by distribution
+var installCommands = map[string]string{
+	Debian: "apt-get install -y",
+	RHEL:   "dnf install -y",
+	Alpine: "apk add",
+	Arch:   "pacman -S --needed",
+	SUSE:   "zypper install -y",
+	Darwin: "brew install",
+}
+
+// Packages returns the packages of distro providing reqs in sorted order.
+func Packages(distro string, reqs []Requirement) []string {
+	var packages []string
+	seen := make(map[string]bool)
+	for _, r := range reqs {
+		p := r.Packages[distro]
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		packages = append(packages, p)
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+// Hint returns the command installing the packages of missing on distro.
+// It is empty when distro is unknown or no package provides them.
+func Hint(distro string, missing []Requirement) string {
+	install, ok := installCommands[distro]
+	if !ok {
+		return ""
+	}
+	packages := Packages(distro, missing)
+	if len(packages) == 0 {
+		return ""
+	}
+	return install + " " + strings.Join(packages, " ")
+}
+
+// Distro returns the distribution of the hints on the host.
+func Distro() string {
+	if runtime.GOOS == "darwin" {
+		return Darwin
+	}
+	osRelease, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	return ParseOSRelease(osRelease)
+}
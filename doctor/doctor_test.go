@@ -0,0 +1,149 @@
+package doctor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func names(reqs []Requirement) []string {
+	var result []string
+	for _, r := range reqs {
+		result = append(result, r.String())
+	}
+	return result
+}
+
+func TestRequirements(t *testing.T) {
+	base := []string{"command: sh", "command: tar", "command: make", "command: cc or gcc or clang"}
+	tests := []struct {
+		target Target
+		want   []string
+	}{
+		{
+			target: Target{Configure: "./configure \\\n"},
+			want:   append(base, "header: pcre2.h or pcre.h", "header: zlib.h"),
+		},
+		{
+			target: Target{
+				Configure:  "./configure \\\n--with-cc=/opt/bin/gcc \\\n--without-http_rewrite_module \\\n--without-http_gzip_module \\\n",
+				StaticPcre: true,
+			},
+			want: []string{"command: sh", "command: tar", "command: make", "command: /opt/bin/gcc"},
+		},
+		{
+			target: Target{
+				Configure:     "./configure \\\n--with-http_ssl_module \\\n--with-http_xslt_module=dynamic \\\n--with-libatomic=/opt/libatomic \\\n",
+				StaticOpenSSL: true,
+				StaticPcre:    true,
+				StaticZlib:    true,
+				NginxTests:    true,
+				Modules: []Module{
+					{Name: "njs", Form: "hg", Shprov: "cd .. && ./configure && make"},
+					{Name: "ngx_echo", Form: "git"},
+					{Name: "ngx_local", Form: "local", Shprov: "CC=clang cmake . && ninja"},
+				},
+			},
+			want: append(base,
+				"command: perl", "command: hg", "command: git",
+				"command: cmake", "command: ninja",
+				"header: libxslt/xslt.h", "header: libxml/parser.h",
+			),
+		},
+		{
+			target: Target{
+				Configure:     "./configure \\\n--with-http_v3_module \\\n--with-http_geoip_module \\\n--with-stream_geoip_module \\\n",
+				PerlConfigure: true,
+				StaticPcre:    true,
+				StaticZlib:    true,
+			},
+			want: append(base, "command: perl", "header: GeoIP.h", "header: openssl/ssl.h"),
+		},
+	}
+
+	for _, test := range tests {
+		got := names(Requirements(test.target))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
+
+func TestShprovCommands(t *testing.T) {
+	tests := []struct {
+		shprov string
+		want   []string
+	}{
+		{shprov: "", want: nil},
+		{shprov: "./configure && make", want: []string{"make"}},
+		{shprov: "cd .. && CFLAGS=-O2 cmake -B build; make -C build || (echo failed; exit 1)", want: []string{"cmake", "make"}},
+		{shprov: "autoreconf -i | tee log && /usr/bin/make", want: []string{"autoreconf", "tee"}},
+	}
+
+	for _, test := range tests {
+		got := ShprovCommands(test.shprov)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
+
+func TestIncludeFlags(t *testing.T) {
+	configure := "./configure \\\n--with-cc-opt='-O2 -I/opt/gd/include' \\\n--with-cc-opt=-I/opt/geoip/include \\\n"
+	want := []string{"-I/opt/gd/include", "-I/opt/geoip/include"}
+	if got := includeFlags(configure); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		osRelease string
+		want      string
+	}{
+		{osRelease: "NAME=\"Debian GNU/Linux\"\nID=debian\n", want: Debian},
+		{osRelease: "ID=linuxmint\nID_LIKE=\"ubuntu debian\"\n", want: Debian},
+		{osRelease: "ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n", want: RHEL},
+		{osRelease: "ID=alpine\n", want: Alpine},
+		{osRelease: "ID=opensuse-tumbleweed\nID_LIKE=\"opensuse suse\"\n", want: SUSE},
+		{osRelease: "ID=nixos\n", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := ParseOSRelease([]byte(test.osRelease)); got != test.want {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
+
+func TestHint(t *testing.T) {
+	missing := Requirements(Target{
+		Configure: "./configure \\\n--with-http_image_filter_module \\\n",
+		Modules:   []Module{{Name: "njs", Form: "hg"}},
+	})
+	tests := []struct {
+		distro string
+		want   string
+	}{
+		{distro: Debian, want: "apt-get install -y build-essential libgd-dev libpcre2-dev make mercurial tar zlib1g-dev"},
+		{distro: Darwin, want: "brew install gd gnu-tar mercurial pcre2"},
+		{distro: "", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := Hint(test.distro, missing); got != test.want {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
+
+func TestCrossHint(t *testing.T) {
+	missing := Requirements(Target{
+		Configure:  "./configure \\\n--crossbuild=Linux::aarch64 \\\n--with-cc='aarch64-linux-gnu-gcc --sysroot=/sysroot' \\\n--without-http_rewrite_module \\\n--without-http_gzip_module \\\n",
+		Cross:      "aarch64-linux-gnu",
+		CrossTools: []string{"aarch64-linux-gnu-ar", "aarch64-linux-gnu-ranlib"},
+	})
+	want := "apt-get install -y binutils-aarch64-linux-gnu gcc-aarch64-linux-gnu make tar"
+	if got := Hint(Debian, missing); got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+}
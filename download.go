@@ -1,80 +0,0 @@
-package main
-
-import (
-	"fmt"
-	"io"
-	"log"
-	"net/http"
-	"os"
-	"time"
-
-	"github.com/cubicdaiya/nginx-build/builder"
-	"github.com/cubicdaiya/nginx-build/command"
-	"github.com/cubicdaiya/nginx-build/util"
-)
-
-const DefaultDownloadTimeout = time.Duration(900) * time.Second
-
-func extractArchive(path string) error {
-	return command.Run([]string{"tar", "zxvf", path})
-}
-
-func download(b *builder.Builder) error {
-	c := &http.Client{
-		Timeout: DefaultDownloadTimeout,
-	}
-	res, err := c.Get(b.DownloadURL())
-	if err != nil {
-		return err
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download %s. %s", b.DownloadURL(), res.Status)
-	}
-
-	tmpFileName := b.ArchivePath() + ".download"
-	f, err := os.Create(tmpFileName)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	if _, err := io.Copy(f, res.Body); err != nil && err != io.EOF {
-		return err
-	}
-
-	if err := os.Rename(tmpFileName, b.ArchivePath()); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func downloadAndExtract(b *builder.Builder) error {
-	if !util.FileExists(b.SourcePath()) {
-		if !util.FileExists(b.ArchivePath()) {
-
-			log.Printf("Download %s.....", b.SourcePath())
-
-			if err := download(b); err != nil {
-				return fmt.Errorf("Failed to download %s. %s", b.SourcePath(), err.Error())
-			}
-		}
-
-		log.Printf("Extract %s.....", b.ArchivePath())
-
-		if err := extractArchive(b.ArchivePath()); err != nil {
-			return fmt.Errorf("Failed to extract %s. %s", b.ArchivePath(), err.Error())
-		}
-	} else {
-		log.Printf("%s already exists.", b.SourcePath())
-	}
-	return nil
-}
-
-func downloadAndExtractParallel(b *builder.Builder) {
-	if err := downloadAndExtract(b); err != nil {
-		util.PrintFatalMsg(err, b.LogPath())
-	}
-}
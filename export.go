@@ -0,0 +1,250 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/container"
+	"github.com/cubicdaiya/nginx-build/doctor"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+	"github.com/cubicdaiya/nginx-build/patch"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+var (
+	sbinPathRe *regexp.Regexp
+	prefixRe   *regexp.Regexp
+)
+
+func init() {
+	sbinPathRe = regexp.MustCompile(`--sbin-path=(\S+)`)
+	prefixRe = regexp.MustCompile(`--prefix=(\S+)`)
+}
+
+// exportExcludedOptions are not reproduced in the Dockerfile
+// because the build stage gives its own working directory and staging root,
+// they write outputs on the host or they are of the other modes.
+var exportExcludedOptions = map[string]bool{
+	"d":                true,
+	"clear":            true,
+	"clear-scope":      true,
+	"idempotent":       true,
+	"install":          true,
+	"destdir":          true,
+	"package":          true,
+	"package-spec":     true,
+	"export":           true,
+	"export-path":      true,
+	"oci-base":         true,
+	"output":           true,
+	"trace":            true,
+	"timeout":          true,
+	"lock-timeout":     true,
+	"sbom-spdx":        true,
+	"sbom-cyclonedx":   true,
+	"bundle":           true,
+	"fetch-shprov":     true,
+	"fetch-patch":      true,
+	"configureonly":    true,
+	"patch-dry-run":    true,
+	"help-all":         true,
+	"version":          true,
+	"versions":         true,
+	"openresty-bundle": true,
+	"matrix-flavors":   true,
+	"matrix-versions":  true,
+	"matrix-tls":       true,
+	"matrix-parallel":  true,
+	"good":             true,
+	"bad":              true,
+	"run":              true,
+	"releases":         true,
+	"max-age":          true,
+	"max-size":         true,
+	"keep":             true,
+	"dry-run":          true,
+}
+
+// exportFileOptions are the options giving paths copied into the build context.
+var exportFileOptions = map[string]bool{
+	"c":                true,
+	"m":                true,
+	"patch":            true,
+	"fixtures":         true,
+	"nginx-tests":      true,
+	"from-bundle":      true,
+	"target-sysroot":   true,
+	"openresty-luajit": true,
+}
+
+// exportValueOptions are the options reproduced in the Dockerfile with their values as they are.
+var exportValueOptions = map[string]bool{
+	"verbose":                  true,
+	"j":                        true,
+	"flavor":                   true,
+	"v":                        true,
+	"openresty":                true,
+	"freenginx":                true,
+	"openrestyversion":         true,
+	"freenginxversion":         true,
+	"angieversion":             true,
+	"tengineversion":           true,
+	"pcre":                     true,
+	"openssl":                  true,
+	"libressl":                 true,
+	"zlib":                     true,
+	"pcreversion":              true,
+	"opensslversion":           true,
+	"libresslversion":          true,
+	"zlibversion":              true,
+	"variant":                  true,
+	"patch-opt":                true,
+	"openresty-luajit-xcflags": true,
+	"openresty-with":           true,
+	"openresty-without":        true,
+	"openresty-pcre-jit":       true,
+	"target":                   true,
+	"target-prefix":            true,
+	"smoke-test":               true,
+	"nginx-tests-glob":         true,
+	"install-modules-dir":      true,
+}
+
+// sbinPath guesses the path of nginx installed from the configure script.
+func sbinPath(nginxConfigure string, b *builder.Builder) string {
+	if m := sbinPathRe.FindStringSubmatch(nginxConfigure); m != nil {
+		return strings.Trim(m[1], `'"`)
+	}
+	prefix := b.DefaultPrefix()
+	if m := prefixRe.FindStringSubmatch(nginxConfigure); m != nil {
+		prefix = strings.Trim(m[1], `'"`)
+	}
+	return prefix + "/sbin/" + b.BinaryName()
+}
+
+// buildPackages returns the packages of Debian required in the build stage, which are the ones `nginx-build doctor` requires.
+func buildPackages(spec nginxbuild.Spec) ([]string, error) {
+	target, err := nginxbuild.DoctorTarget(spec)
+	if err != nil {
+		return nil, err
+	}
+	// nginx-build downloads the sources over HTTPS
+	packages := []string{"ca-certificates"}
+	return append(packages, doctor.Packages(doctor.Debian, doctor.Requirements(target))...), nil
+}
+
+// exportDockerfile writes a Dockerfile reproducing the build with the options set in fs and its build context into dir.
+func exportDockerfile(fs *flag.FlagSet, dir string, nginxConfigure string, nginxBuilder *builder.Builder, modules3rd []module3rd.Module3rd, packages []string) error {
+	contextDir := filepath.Join(dir, "context")
+	if err := os.MkdirAll(contextDir, 0755); err != nil {
+		return err
+	}
+
+	d := container.Dockerfile{
+		NginxBuildVersion: nginxBuildVersion(),
+		Packages:          packages,
+		RuntimePackages:   container.RuntimePackages(packages),
+		BinaryPath:        sbinPath(nginxConfigure, nginxBuilder),
+	}
+
+	// a development build is reproduced with the running binary instead of an unpinned `go install`
+	if !container.Installable(d.NginxBuildVersion) {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("nginx-build %s is not installable with `go install` and the running binary does not run on Linux. Use a released nginx-build", d.NginxBuildVersion)
+		}
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		if err := util.CopyTree(self, filepath.Join(contextDir, "nginx-build")); err != nil {
+			return err
+		}
+		d.Binary = "context/nginx-build"
+		d.Platform = "linux/" + runtime.GOARCH
+	}
+
+	for _, m := range modules3rd {
+		if m.Form == "local" {
+			log.Printf("[warn]local module %s is not reproduced in the Dockerfile.", m.Name)
+		}
+	}
+
+	copied := make(map[string]string)
+	copyToContext := func(path string) (string, error) {
+		if name, ok := copied[path]; ok {
+			return name, nil
+		}
+		name := fmt.Sprintf("%d-%s", len(copied), filepath.Base(path))
+		if err := util.CopyTree(path, filepath.Join(contextDir, name)); err != nil {
+			return "", err
+		}
+		d.Files = append(d.Files, container.CopyFile{Src: "context/" + name, Dst: name})
+		copied[path] = name
+		return name, nil
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err != nil || exportExcludedOptions[f.Name] {
+			return
+		}
+		// the configure options of nginx are reproduced as they are
+		if isNginxBuildOption(f.Name) && !exportFileOptions[f.Name] && !exportValueOptions[f.Name] {
+			err = fmt.Errorf("-%s is not reproducible in the Dockerfile", f.Name)
+			return
+		}
+
+		var values []string
+		if multi, ok := f.Value.(*StringFlag); ok {
+			values = *multi
+		} else {
+			values = []string{f.Value.String()}
+		}
+
+		for _, v := range values {
+			if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+				if v == "true" {
+					d.Args = append(d.Args, []string{"-" + f.Name})
+				}
+				continue
+			}
+			if exportFileOptions[f.Name] {
+				var names []string
+				for _, path := range strings.Split(v, ",") {
+					// a series file is copied with the patches listed next to it
+					series := f.Name == "patch" && filepath.Base(path) == patch.SeriesName
+					if series {
+						path = filepath.Dir(path)
+					}
+					name, e := copyToContext(path)
+					if e != nil {
+						err = e
+						return
+					}
+					if series {
+						name += "/" + patch.SeriesName
+					}
+					names = append(names, name)
+				}
+				v = strings.Join(names, ",")
+			}
+			d.Args = append(d.Args, []string{"-" + f.Name, v})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if util.FileExists(filepath.Join(dir, "Dockerfile")) {
+		log.Printf("[warn]overwrite %s/Dockerfile.", dir)
+	}
+	return os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(d.Generate()), 0644)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+)
+
+func TestExportOptions(t *testing.T) {
+	var names []string
+	for k := range nginxBuildOptions.Bools {
+		names = append(names, k)
+	}
+	for k := range nginxBuildOptions.Values {
+		names = append(names, k)
+	}
+	for k := range nginxBuildOptions.Numbers {
+		names = append(names, k)
+	}
+
+	// every option is either excluded, copied into the build context or reproduced as it is
+	for _, name := range names {
+		n := 0
+		for _, options := range []map[string]bool{exportExcludedOptions, exportFileOptions, exportValueOptions} {
+			if options[name] {
+				n++
+			}
+		}
+		if n != 1 {
+			t.Fatalf("got: %v, want: %v (-%s)", n, 1, name)
+		}
+	}
+
+	for _, options := range []map[string]bool{exportExcludedOptions, exportFileOptions, exportValueOptions} {
+		for name := range options {
+			if !isNginxBuildOption(name) {
+				t.Fatalf("-%s is not an option of nginx-build", name)
+			}
+		}
+	}
+}
+
+func TestBuildPackages(t *testing.T) {
+	tests := []struct {
+		spec nginxbuild.Spec
+		want string
+	}{
+		{
+			spec: nginxbuild.Spec{WorkDir: "work"},
+			want: "ca-certificates build-essential libpcre2-dev make tar zlib1g-dev",
+		},
+		{
+			spec: nginxbuild.Spec{WorkDir: "work", Pcre: nginxbuild.Library{Static: true}, OpenSSL: nginxbuild.Library{Static: true}, Zlib: nginxbuild.Library{Static: true}},
+			want: "ca-certificates build-essential make perl tar",
+		},
+		{
+			spec: nginxbuild.Spec{
+				WorkDir:   "work",
+				Configure: "./configure --with-http_ssl_module --with-http_xslt_module --with-http_image_filter_module --with-http_geoip_module --with-http_perl_module --with-libatomic",
+				Modules:   []module3rd.Module3rd{{Name: "njs", Form: "hg"}},
+			},
+			want: "ca-certificates build-essential libatomic-ops-dev libgd-dev libgeoip-dev libpcre2-dev libperl-dev libssl-dev libxml2-dev libxslt1-dev make mercurial tar zlib1g-dev",
+		},
+	}
+
+	for _, test := range tests {
+		packages, err := buildPackages(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(packages, " "); got != test.want {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
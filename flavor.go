@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+)
+
+// resolveFlavor merges -flavor with the legacy flavor flags such as -openresty and -freenginx.
+func resolveFlavor(flavor string, openResty, freenginx bool) (string, error) {
+	if openResty && freenginx {
+		return "", fmt.Errorf("select one between '-openresty' and '-freenginx'.")
+	}
+
+	aliases := []struct {
+		name    string
+		enabled bool
+	}{
+		{name: "openresty", enabled: openResty},
+		{name: "freenginx", enabled: freenginx},
+	}
+	for _, alias := range aliases {
+		if !alias.enabled {
+			continue
+		}
+		if flavor != "" && flavor != "nginx" && flavor != alias.name {
+			return "", fmt.Errorf("'-%s' conflicts with '-flavor %s'.", alias.name, flavor)
+		}
+		flavor = alias.name
+	}
+
+	if flavor == "" {
+		flavor = "nginx"
+	}
+
+	if _, err := builder.FlavorComponent(flavor); err != nil {
+		return "", err
+	}
+	return flavor, nil
+}
+
+// flavorVersion returns the version option value given for the flavor.
+func flavorVersion(component int) string {
+	var k string
+	switch component {
+	case builder.ComponentNginx:
+		k = "v"
+	case builder.ComponentOpenResty:
+		k = "openrestyversion"
+	case builder.ComponentFreenginx:
+		k = "freenginxversion"
+	case builder.ComponentAngie:
+		k = "angieversion"
+	case builder.ComponentTengine:
+		k = "tengineversion"
+	default:
+		return ""
+	}
+	return *nginxBuildOptions.Values[k].Value
+}
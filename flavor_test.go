@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResolveFlavor(t *testing.T) {
+	tests := []struct {
+		flavor    string
+		openResty bool
+		freenginx bool
+		want      string
+		ok        bool
+	}{
+		{flavor: "", want: "nginx", ok: true},
+		{flavor: "nginx", want: "nginx", ok: true},
+		{flavor: "angie", want: "angie", ok: true},
+		{flavor: "tengine", want: "tengine", ok: true},
+		{flavor: "", openResty: true, want: "openresty", ok: true},
+		{flavor: "nginx", openResty: true, want: "openresty", ok: true},
+		{flavor: "openresty", openResty: true, want: "openresty", ok: true},
+		{flavor: "", freenginx: true, want: "freenginx", ok: true},
+		{flavor: "angie", openResty: true, ok: false},
+		{flavor: "openresty", freenginx: true, ok: false},
+		{flavor: "", openResty: true, freenginx: true, ok: false},
+		{flavor: "apache", ok: false},
+	}
+
+	for _, test := range tests {
+		got, err := resolveFlavor(test.flavor, test.openResty, test.freenginx)
+		if (err == nil) != test.ok {
+			t.Fatalf("got: %v, want: %v (%+v)", err, test.ok, test)
+		}
+		if got != test.want {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/lock"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+)
+
+// parseAge parses the age such as 720h and 30d.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("max-age=%s is invalid", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("max-age=%s is invalid", s)
+	}
+	return d, nil
+}
+
+// runGC lists the working directories and the download cache in root and removes the entries selected by policy.
+// Nothing is removed without policy or with dryRun.
+func runGC(ctx context.Context, root string, policy nginxbuild.GCPolicy, dryRun, jsonOutput bool) {
+	entries, err := nginxbuild.ListWorkEntries(root)
+	if err != nil {
+		log.Fatal(err)
+	}
+	removed := nginxbuild.SelectGC(entries, policy, time.Now())
+
+	var (
+		freed  int64
+		failed bool
+	)
+	if !dryRun {
+		var done []nginxbuild.WorkEntry
+		for _, e := range removed {
+			err := nginxbuild.RemoveWorkEntry(ctx, e)
+			var heldErr *lock.HeldError
+			if errors.As(err, &heldErr) {
+				log.Printf("[notice] Skip %s locked by %s", e.Path, heldErr.Holder)
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to remove %s: %v", e.Path, err)
+				failed = true
+				continue
+			}
+			freed += e.Size
+			done = append(done, e)
+		}
+		removed = done
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			Entries []nginxbuild.WorkEntry `json:"entries"`
+			Removed []nginxbuild.WorkEntry `json:"removed"`
+			DryRun  bool                   `json:"dry_run"`
+		}{entries, removed, dryRun})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if err := nginxbuild.WriteWorkTable(os.Stdout, entries, removed); err != nil {
+			log.Fatal(err)
+		}
+		switch {
+		case dryRun:
+			log.Printf("%d entries would be removed.", len(removed))
+		case len(removed) > 0:
+			log.Printf("Complete removing %d entries (%s freed)!", len(removed), nginxbuild.FormatSize(freed))
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
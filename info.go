@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+)
+
+// printInfo prints what spec resolves to.
+func printInfo(spec nginxbuild.Spec, jsonOutput bool) {
+	plan, err := nginxbuild.Resolve(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(plan); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	fmt.Printf("flavor:      %s\n", plan.Flavor)
+	fmt.Printf("version:     %s\n", plan.Version)
+	fmt.Printf("download:    %s\n", plan.Nginx.DownloadURL)
+	fmt.Printf("workdir:     %s\n", plan.WorkDir)
+	fmt.Printf("source:      %s (fetched: %v)\n", plan.SourceDir, plan.Fetched)
+	if plan.Target != "" {
+		fmt.Printf("target:      %s\n", plan.Target)
+	}
+	if len(plan.Variants) > 0 {
+		fmt.Printf("variants:    %s\n", strings.Join(plan.Variants, ", "))
+	}
+	if plan.BinaryPath != "" {
+		fmt.Printf("binary:      %s\n", plan.BinaryPath)
+	}
+	for _, l := range plan.Libraries {
+		fmt.Printf("library:     %s %s (%s)\n", l.Name, l.Version, l.DownloadURL)
+	}
+	for _, m := range plan.Modules {
+		fmt.Printf("module:      %s\n", m)
+	}
+	fmt.Printf("fingerprint: %s\n", plan.Fingerprint)
+	if plan.Manifest != nil {
+		fmt.Printf("last build:  %s (fingerprint: %s)\n", plan.Manifest.Created.Format("2006-01-02 15:04:05"), plan.Manifest.Fingerprint)
+	}
+	for _, state := range plan.Patched {
+		fmt.Printf("patched:     %s (%s)\n", filepath.Base(state.Dir), patchStateLabel(state))
+	}
+	fmt.Printf("configure:\n%s\n", strings.TrimRight(plan.ConfigureScript, "\n"))
+}
+
+// patchStateLabel describes the state of the patches of a source directory.
+func patchStateLabel(state *nginxbuild.PatchState) string {
+	switch {
+	case state.Status != nginxbuild.PatchStatusApplied:
+		return fmt.Sprintf("interrupted in %s the patches", state.Status)
+	case len(state.Modified) > 0:
+		return fmt.Sprintf("applied: %d, modified outside nginx-build: %s", len(state.Patches), strings.Join(state.Modified, ", "))
+	default:
+		return fmt.Sprintf("applied: %d", len(state.Patches))
+	}
+}
+
+// runClean removes the working directory of the version spec resolves to.
+// It waits for the build using the working directory.
+func runClean(ctx context.Context, spec nginxbuild.Spec) {
+	workDir, err := nginxbuild.Clean(ctx, spec)
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("%s does not exist.", workDir)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to remove %s: %v", workDir, err)
+	}
+	if len(spec.ClearScopes) > 0 {
+		log.Printf("Complete removing %s of %s!", strings.Join(spec.ClearScopes, ", "), workDir)
+		return
+	}
+	log.Printf("Complete removing %s!", workDir)
+}
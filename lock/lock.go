@@ -0,0 +1,289 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultStaleAfter is the time after which a lock without the heartbeat of its holder is stale.
+const DefaultStaleAfter = 2 * time.Minute
+
+// pollInterval is the interval of trying to acquire a lock held by another process.
+const pollInterval = 500 * time.Millisecond
+
+// Holder is the process holding a lock. It is written into the lock file.
+type Holder struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Created time.Time `json:"created"`
+	// Token distinguishes the locks of the same process
+	Token string `json:"token"`
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("pid %d on %s since %s", h.PID, h.Host, h.Created.Local().Format("2006-01-02 15:04:05"))
+}
+
+// HeldError is the error of a lock held by another process until the timeout.
+type HeldError struct {
+	Path   string
+	Holder Holder
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("%s is locked by %s", e.Path, e.Holder)
+}
+
+// LostError is the error of a lock lost while it is held.
+type LostError struct {
+	Path   string
+	Holder Holder
+	Err    error
+}
+
+func (e *LostError) Error() string {
+	return fmt.Sprintf("the lock %s of %s was lost: %v", e.Path, e.Holder, e.Err)
+}
+
+func (e *LostError) Unwrap() error {
+	return e.Err
+}
+
+// Options is the options of acquiring a lock.
+type Options struct {
+	// Timeout is the time waiting for the lock held by another process.
+	// It waits until ctx is done when it is zero.
+	Timeout time.Duration
+	// StaleAfter is the time after which a lock without the heartbeat is stale (default: DefaultStaleAfter)
+	StaleAfter time.Duration
+	// Wait is called once when the lock is held by another process
+	Wait func(Holder)
+	// Stale is called when a stale lock is removed
+	Stale func(Holder)
+}
+
+// Lock is an advisory lock of a lock file shared among the processes and the hosts.
+type Lock struct {
+	path   string
+	holder Holder
+	stop   chan struct{}
+	done   chan struct{}
+	// err is the first failure of the heartbeat
+	err error
+}
+
+// Acquire acquires the lock of path waiting for the other process holding it.
+// The lock is stale when its holder on the same host has exited or its heartbeat stopped for StaleAfter.
+func Acquire(ctx context.Context, path string, opts Options) (*Lock, error) {
+	if opts.StaleAfter <= 0 {
+		opts.StaleAfter = DefaultStaleAfter
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	holder, err := newHolder()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	waiting := false
+	for {
+		err := create(path, holder)
+		if err == nil {
+			l := &Lock{path: path, holder: holder, stop: make(chan struct{}), done: make(chan struct{})}
+			go l.heartbeat(opts.StaleAfter / 4)
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		current, stale, err := inspect(path, opts.StaleAfter)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil && stale {
+			removed, err := removeStale(path, current)
+			if err != nil {
+				return nil, err
+			}
+			if removed && opts.Stale != nil {
+				opts.Stale(current)
+			}
+			continue
+		}
+		if err == nil && !waiting {
+			waiting = true
+			if opts.Wait != nil {
+				opts.Wait(current)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) && opts.Timeout > 0 {
+				return nil, fmt.Errorf("timed out waiting for the lock: %w", &HeldError{Path: path, Holder: current})
+			}
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Release releases the lock. The lock file is removed unless another process took it over as stale.
+// It returns the failure of the heartbeat while the lock was held, *LostError when the lock file was removed.
+func (l *Lock) Release() error {
+	close(l.stop)
+	<-l.done
+	current, err := read(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return l.err
+		}
+		return errors.Join(l.err, err)
+	}
+	if current != l.holder {
+		return l.err
+	}
+	return errors.Join(l.err, os.Remove(l.path))
+}
+
+func (l *Lock) heartbeat(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			err := os.Chtimes(l.path, now, now)
+			if err == nil {
+				continue
+			}
+			if errors.Is(err, os.ErrNotExist) {
+				// the lock file was removed and the lock is not held anymore
+				l.err = &LostError{Path: l.path, Holder: l.holder, Err: err}
+				return
+			}
+			if l.err == nil {
+				l.err = fmt.Errorf("heartbeat of the lock %s failed: %w", l.path, err)
+			}
+		}
+	}
+}
+
+func newHolder() (Holder, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return Holder{}, err
+	}
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return Holder{}, err
+	}
+	return Holder{PID: os.Getpid(), Host: host, Created: time.Now().UTC(), Token: hex.EncodeToString(token)}, nil
+}
+
+// create creates the lock file exclusively. It fails with os.ErrExist when the lock is held.
+func create(path string, holder Holder) error {
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	// writes the holder into a temporary file and links it as the lock file
+	// so that the lock file without the holder is never seen
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpFileName := f.Name()
+	defer os.Remove(tmpFileName)
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Link(tmpFileName, path)
+}
+
+func read(path string) (Holder, error) {
+	var holder Holder
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return holder, err
+	}
+	if err := json.Unmarshal(data, &holder); err != nil {
+		return holder, fmt.Errorf("lock file %s is broken: %w", path, err)
+	}
+	return holder, nil
+}
+
+// inspect returns the holder of the lock of path and reports whether the lock is stale.
+func inspect(path string, staleAfter time.Duration) (Holder, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return Holder{}, false, err
+	}
+	holder, err := read(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return holder, false, err
+		}
+		// a broken lock file is stale after its holder stopped writing it
+		return holder, time.Since(info.ModTime()) > staleAfter, nil
+	}
+	if time.Since(info.ModTime()) > staleAfter {
+		return holder, true, nil
+	}
+	host, _ := os.Hostname()
+	return holder, holder.Host == host && !alive(holder.PID), nil
+}
+
+// alive reports whether the process of pid exists on the host.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// removeStale removes the stale lock of holder and reports whether it was removed.
+// The lock is moved aside before removing so that a new lock taken by another process is not removed.
+// It returns *LostError when the lock taken over by another process can not be restored.
+func removeStale(path string, holder Holder) (bool, error) {
+	aside := fmt.Sprintf("%s.stale.%d", path, os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		return false, nil
+	}
+	current, err := read(aside)
+	if err == nil && current != holder {
+		// another process took over the lock in the meantime
+		if err := os.Link(aside, path); err != nil {
+			os.Remove(aside)
+			return false, &LostError{Path: path, Holder: current, Err: err}
+		}
+		os.Remove(aside)
+		return false, nil
+	}
+	os.Remove(aside)
+	return true, nil
+}
@@ -0,0 +1,159 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeHolder(t *testing.T, path string, holder Holder, mtime time.Time) {
+	t.Helper()
+	data, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "work", "1.28.0.lock")
+
+	l, err := Acquire(context.Background(), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var waited Holder
+	_, err = Acquire(context.Background(), path, Options{
+		Timeout: 100 * time.Millisecond,
+		Wait:    func(h Holder) { waited = h },
+	})
+	var heldErr *HeldError
+	if !errors.As(err, &heldErr) {
+		t.Fatalf("got: %v, want: HeldError", err)
+	}
+	if heldErr.Holder != l.holder || waited != l.holder {
+		t.Fatalf("got: %v, %v, want: %v", heldErr.Holder, waited, l.holder)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Acquire(ctx, path, Options{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got: %v, want: %v", err, context.Canceled)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got: %v, want: the lock file removed", err)
+	}
+
+	l, err = Acquire(context.Background(), path, Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireStale(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "1.28.0.lock")
+	stale := Holder{PID: 1 << 22, Host: host, Token: "stale"}
+	writeHolder(t, path, stale, time.Now())
+
+	var removed Holder
+	l, err := Acquire(context.Background(), path, Options{Timeout: time.Second, Stale: func(h Holder) { removed = h }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+	if removed != stale {
+		t.Fatalf("got: %v, want: %v", removed, stale)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	tests := []struct {
+		holder Holder
+		mtime  time.Time
+		stale  bool
+	}{
+		{holder: Holder{PID: os.Getpid(), Host: host}, mtime: now, stale: false},
+		{holder: Holder{PID: 1 << 22, Host: host}, mtime: now, stale: true},
+		{holder: Holder{PID: 1 << 22, Host: "other"}, mtime: now, stale: false},
+		{holder: Holder{PID: os.Getpid(), Host: "other"}, mtime: now.Add(-time.Hour), stale: true},
+	}
+
+	path := filepath.Join(t.TempDir(), "1.28.0.lock")
+	for _, test := range tests {
+		writeHolder(t, path, test.holder, test.mtime)
+		holder, stale, err := inspect(path, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if holder != test.holder {
+			t.Fatalf("got: %v, want: %v", holder, test.holder)
+		}
+		if stale != test.stale {
+			t.Fatalf("%v got: %v, want: %v", test.holder, stale, test.stale)
+		}
+	}
+}
+
+func TestReleaseTakenOver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.28.0.lock")
+	l, err := Acquire(context.Background(), path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := Holder{PID: 1, Host: "other", Token: "other"}
+	writeHolder(t, path, other, time.Now())
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	holder, err := read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder != other {
+		t.Fatalf("got: %v, want: %v", holder, other)
+	}
+}
+
+func TestReleaseLost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1.28.0.lock")
+	l, err := Acquire(context.Background(), path, Options{StaleAfter: 40 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	<-l.done
+
+	var lostErr *LostError
+	if err := l.Release(); !errors.As(err, &lostErr) {
+		t.Fatalf("got: %v, want: %T", err, lostErr)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+)
+
+// splitList splits a comma-separated list of an option.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// matrixCombinations returns the combinations of a build matrix from the options.
+// A version of -matrix-versions is of a flavor such as openresty:1.27.1.2.
+// A version without the flavor is of the flavor of a single build or the only flavor of -matrix-flavors.
+// The flavor, its version and the TLS library of a single build are used for the lists not given.
+func matrixCombinations(flavorsOpt, versionsOpt, tlsOpt, flavor string, openSSL, libreSSL nginxbuild.Library) ([]nginxbuild.Combination, error) {
+	flavors := splitList(flavorsOpt)
+
+	versions := make(map[string][]string)
+	var (
+		bare     []string
+		prefixed []string
+	)
+	for _, v := range splitList(versionsOpt) {
+		f, version, ok := strings.Cut(v, ":")
+		if !ok {
+			bare = append(bare, v)
+			continue
+		}
+		if _, err := builder.FlavorComponent(f); err != nil {
+			return nil, err
+		}
+		if version == "" {
+			return nil, fmt.Errorf("the version of %s is empty in -matrix-versions", f)
+		}
+		if len(versions[f]) == 0 {
+			prefixed = append(prefixed, f)
+		}
+		versions[f] = append(versions[f], version)
+	}
+
+	if len(flavors) == 0 {
+		// the flavors of the versions given
+		if len(bare) > 0 || len(prefixed) == 0 {
+			flavors = []string{flavor}
+		}
+		for _, f := range prefixed {
+			if !slices.Contains(flavors, f) {
+				flavors = append(flavors, f)
+			}
+		}
+		versions[flavor] = append(bare, versions[flavor]...)
+	} else {
+		for _, f := range prefixed {
+			if !slices.Contains(flavors, f) {
+				return nil, fmt.Errorf("%s in -matrix-versions is not in -matrix-flavors", f)
+			}
+		}
+		if len(bare) > 0 && len(flavors) > 1 {
+			return nil, fmt.Errorf("the versions of -matrix-versions need their flavors such as %s:%s for the flavors %s", flavors[0], bare[0], strings.Join(flavors, ","))
+		}
+		versions[flavors[0]] = append(bare, versions[flavors[0]]...)
+	}
+
+	for _, f := range flavors {
+		component, err := builder.FlavorComponent(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions[f]) == 0 {
+			versions[f] = []string{flavorVersion(component)}
+		}
+	}
+
+	tls := splitList(tlsOpt)
+	if len(tls) == 0 {
+		switch {
+		case openSSL.Static:
+			tls = []string{"openssl-" + openSSL.Version}
+		case libreSSL.Static:
+			tls = []string{"libressl-" + libreSSL.Version}
+		default:
+			tls = []string{nginxbuild.TLSSystem}
+		}
+	}
+	for _, t := range tls {
+		if _, _, err := nginxbuild.ParseTLS(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return nginxbuild.Combinations(flavors, versions, tls), nil
+}
+
+// runMatrix runs a build matrix and prints its results as a table or JSON.
+func runMatrix(ctx context.Context, matrix nginxbuild.Matrix, jsonOutput bool) {
+	if !jsonOutput {
+		log.Printf("Build %d combinations (%d at once).....", len(matrix.Combinations), matrix.Parallel)
+	}
+
+	results, err := nginxbuild.RunMatrix(ctx, matrix)
+	if results == nil && err != nil {
+		log.Fatal(err)
+	}
+
+	failed := 0
+	for _, r := range results {
+		if !r.Passed() {
+			failed++
+		}
+	}
+
+	if jsonOutput {
+		v := struct {
+			Results []nginxbuild.MatrixResult `json:"results"`
+			Passed  int                       `json:"passed"`
+			Failed  int                       `json:"failed"`
+		}{results, len(results) - failed, failed}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		fmt.Println()
+		if err := nginxbuild.WriteMatrixTable(os.Stdout, results); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println()
+		for _, r := range results {
+			if r.Passed() {
+				continue
+			}
+			if r.LogPath != "" {
+				log.Printf("[fail] %s: %s (see %s)", r.Combination, r.Error, r.LogPath)
+			} else {
+				log.Printf("[fail] %s: %s", r.Combination, r.Error)
+			}
+		}
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Timed out building the build matrix: %v", err)
+		os.Exit(exitTimeout)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("Interrupted building the build matrix: %v", err)
+		os.Exit(exitInterrupted)
+	}
+	if failed > 0 {
+		log.Fatalf("%d of %d combinations failed.", failed, len(results))
+	}
+	if !jsonOutput {
+		log.Printf("Complete building %d combinations!", len(results))
+	}
+}
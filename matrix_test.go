@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+)
+
+func TestMatrixCombinations(t *testing.T) {
+	// the default versions of the flavors are of the options
+	cmd, _ := findSubcommand("build")
+	if _, err := parseArgs(cmd, nil, flag.ContinueOnError); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		flavors  string
+		versions string
+		tls      string
+		flavor   string
+		want     []nginxbuild.Combination
+	}{
+		{
+			flavor: "nginx",
+			want:   []nginxbuild.Combination{{Flavor: "nginx", Version: builder.NginxVersion, TLS: "system"}},
+		},
+		{
+			versions: "1.26.3,1.28.0",
+			tls:      "system,openssl-3.5.0",
+			flavor:   "nginx",
+			want: []nginxbuild.Combination{
+				{Flavor: "nginx", Version: "1.26.3", TLS: "system"},
+				{Flavor: "nginx", Version: "1.26.3", TLS: "openssl-3.5.0"},
+				{Flavor: "nginx", Version: "1.28.0", TLS: "system"},
+				{Flavor: "nginx", Version: "1.28.0", TLS: "openssl-3.5.0"},
+			},
+		},
+		{
+			flavors:  "nginx,openresty",
+			versions: "nginx:1.28.0,openresty:1.25.3.2,openresty:1.27.1.2",
+			flavor:   "nginx",
+			want: []nginxbuild.Combination{
+				{Flavor: "nginx", Version: "1.28.0", TLS: "system"},
+				{Flavor: "openresty", Version: "1.25.3.2", TLS: "system"},
+				{Flavor: "openresty", Version: "1.27.1.2", TLS: "system"},
+			},
+		},
+		{
+			// the flavors without their versions are built with the default versions
+			flavors:  "nginx,angie",
+			versions: "nginx:1.28.0",
+			flavor:   "nginx",
+			want: []nginxbuild.Combination{
+				{Flavor: "nginx", Version: "1.28.0", TLS: "system"},
+				{Flavor: "angie", Version: builder.AngieVersion, TLS: "system"},
+			},
+		},
+		{
+			// the flavors are of the versions without -matrix-flavors
+			versions: "1.28.0,openresty:1.27.1.2",
+			flavor:   "nginx",
+			want: []nginxbuild.Combination{
+				{Flavor: "nginx", Version: "1.28.0", TLS: "system"},
+				{Flavor: "openresty", Version: "1.27.1.2", TLS: "system"},
+			},
+		},
+		{
+			versions: "1.27.1.2",
+			flavor:   "openresty",
+			want:     []nginxbuild.Combination{{Flavor: "openresty", Version: "1.27.1.2", TLS: "system"}},
+		},
+		{
+			flavors:  "freenginx",
+			versions: "1.29.0",
+			flavor:   "nginx",
+			want:     []nginxbuild.Combination{{Flavor: "freenginx", Version: "1.29.0", TLS: "system"}},
+		},
+	}
+
+	for _, test := range tests {
+		got, err := matrixCombinations(test.flavors, test.versions, test.tls, test.flavor, nginxbuild.Library{}, nginxbuild.Library{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+
+	errorTests := []struct {
+		flavors  string
+		versions string
+		tls      string
+	}{
+		{flavors: "nginx,openresty", versions: "1.28.0"},
+		{flavors: "nginx", versions: "openresty:1.27.1.2"},
+		{versions: "apache:2.4.0"},
+		{versions: "openresty:"},
+		{flavors: "apache"},
+		{tls: "boringssl"},
+	}
+
+	for _, test := range errorTests {
+		if _, err := matrixCombinations(test.flavors, test.versions, test.tls, "nginx", nginxbuild.Library{}, nginxbuild.Library{}); err == nil {
+			t.Fatalf("got: %v, want: an error (%+v)", err, test)
+		}
+	}
+}
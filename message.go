@@ -1,13 +1,16 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"runtime"
-	"runtime/debug"
+
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+	"github.com/cubicdaiya/nginx-build/nginxtests"
+	"github.com/cubicdaiya/nginx-build/openresty"
+	"github.com/cubicdaiya/nginx-build/smoke"
 )
 
 var (
@@ -18,11 +21,7 @@ func nginxBuildVersion() string {
 	if NginxBuildVersion != "" {
 		return NginxBuildVersion
 	}
-	info, ok := debug.ReadBuildInfo()
-	if !ok {
-		return "(devel)"
-	}
-	return info.Main.Version
+	return nginxbuild.Version()
 }
 
 func printNginxBuildVersion() {
@@ -36,8 +35,8 @@ Copyright (C) 2014- Tatsuhiko Kubo <cubicdaiya@gmail.com>
 
 }
 
-func printConfigureOptions() error {
-	cmd := exec.Command("objs/nginx", "-V")
+func printConfigureOptions(binPath string) error {
+	cmd := exec.Command(binPath, "-V")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -52,13 +51,13 @@ Compiler: %s %s
 		runtime.Version())
 }
 
-func printLastMsg(workDir, srcDir string, openResty, configureOnly bool) {
+func printLastMsg(srcDir, binPath string, configureOnly bool) {
 	log.Println("Complete building nginx!")
 
-	if !openResty {
+	if binPath != "" {
 		if !configureOnly {
 			fmt.Println()
-			err := printConfigureOptions()
+			err := printConfigureOptions(binPath)
 			if err != nil {
 				fmt.Println(err.Error())
 			}
@@ -68,30 +67,72 @@ func printLastMsg(workDir, srcDir string, openResty, configureOnly bool) {
 
 	lastMsgFormat := `Enter the following command for install nginx.
 
-   $ cd %s/%s%s
+   $ cd %s%s
    $ sudo make install
 `
 	if configureOnly {
-		log.Printf(lastMsgFormat, workDir, srcDir, "\n   $ make")
+		log.Printf(lastMsgFormat, srcDir, "\n   $ make")
 	} else {
-		log.Printf(lastMsgFormat, workDir, srcDir, "")
+		log.Printf(lastMsgFormat, srcDir, "")
 	}
 }
 
-func usage() {
-	fmt.Fprintf(os.Stdout, "Usage of %s:\n", os.Args[0])
-	flag.VisitAll(func(f *flag.Flag) {
-		if !isNginxBuildOption(f.Name) {
-			return
+func printInstallMsg(installedBinPath, versionInfo string) {
+	fmt.Println()
+	fmt.Print(versionInfo)
+	fmt.Println()
+	log.Printf("Complete installing nginx into %s!", installedBinPath)
+}
+
+func printTimings(timings []nginxbuild.Timing) {
+	if len(timings) == 0 {
+		return
+	}
+	fmt.Println()
+	if err := nginxbuild.WriteTimingTable(os.Stdout, timings); err != nil {
+		log.Printf("[warn] failed to print timings: %v", err)
+	}
+	fmt.Println()
+}
+
+func printTestReport(name string, report *smoke.Report) {
+	for _, c := range report.Checks {
+		if c.Err != nil {
+			log.Printf("[fail] %s: %v", c.Name, c.Err)
+		} else {
+			log.Printf("[pass] %s", c.Name)
 		}
-		s := fmt.Sprintf("  -%s", f.Name)
-		s += "\n\t"
-		s += f.Usage
-		defValue := defaultStringValue(f.Name)
-		if defValue != "" {
-			s += fmt.Sprintf(" ( default: %s )", defValue)
+	}
+	if report.Passed() {
+		log.Printf("%s passed!", name)
+	}
+}
+
+func printNginxTestsSummary(summary *nginxtests.Summary) {
+	for _, f := range summary.Files {
+		switch {
+		case !f.OK():
+			log.Printf("[fail] %s: %s", f.Name, f.Problem())
+		case f.SkipAll != "":
+			log.Printf("[skip] %s: %s", f.Name, f.SkipAll)
+		default:
+			log.Printf("[pass] %s", f.Name)
 		}
+	}
+	t := summary.Totals()
+	log.Printf("nginx-tests: %d files, %d tests, %d passed, %d failed, %d skipped, %d todo",
+		t.Files, t.Tests, t.Passed, t.Failed, t.Skipped, t.Todo)
+	if summary.Passed() {
+		log.Printf("nginx-tests passed!")
+	}
+}
 
-		fmt.Fprintf(os.Stdout, "%s\n", s)
-	})
+func printOpenRestyBundle(srcDir string) {
+	components, err := openresty.Bundled(srcDir)
+	if err != nil {
+		log.Fatalf("Failed to list components bundled in %s: %v", srcDir, err)
+	}
+	for _, c := range components {
+		fmt.Printf("%s %s\n", c.Name, c.Version)
+	}
 }
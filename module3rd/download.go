@@ -2,92 +2,78 @@ package module3rd
 
 import (
 	"bufio"
+	"context"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/cubicdaiya/nginx-build/command"
 	"github.com/cubicdaiya/nginx-build/util"
 )
 
-func DownloadAndExtractParallel(m Module3rd) {
-	if util.FileExists(m.Name) {
-		log.Printf("%s already exists.", m.Name)
-		return
-	}
-
-	if m.Form != "local" {
-		if len(m.Rev) > 0 {
-			log.Printf("Download %s-%s.....", m.Name, m.Rev)
-		} else {
-			log.Printf("Download %s.....", m.Name)
-		}
-
-		logName := fmt.Sprintf("%s.log", m.Name)
+// LogPath returns the path of the log file for downloading the module.
+func LogPath(m Module3rd) string {
+	return fmt.Sprintf("%s.log", m.Name)
+}
 
-		err := download(m, logName)
-		if err != nil {
-			util.PrintFatalMsg(err, logName)
-		}
-	} else if !util.FileExists(m.Url) {
-		log.Fatalf("no such directory:%s", m.Url)
-	}
+// Exists reports whether the module is already downloaded into workDir.
+func Exists(m Module3rd, workDir string) bool {
+	return util.FileExists(filepath.Join(workDir, m.Name))
 }
 
-func download(m Module3rd, logName string) error {
+// Download downloads the module into workDir.
+// Unless verbose, the output is written into LogPath(m) under workDir.
+// The module half-cloned is removed when downloading failed or was cancelled.
+func Download(ctx context.Context, m Module3rd, workDir string, verbose bool) error {
 	form := m.Form
 	url := m.Url
 
+	var args []string
 	switch form {
 	case "git":
-		args := []string{form, "clone", "--recursive", url}
-		if command.VerboseEnabled {
-			return command.Run(args)
-		}
-
-		f, err := os.Create(logName)
-		if err != nil {
-			return command.Run(args)
-		}
-		defer f.Close()
-
-		cmd, err := command.Make(args)
-		if err != nil {
-			return err
-		}
-
-		writer := bufio.NewWriter(f)
-		defer writer.Flush()
-
-		cmd.Stderr = writer
-
-		return cmd.Run()
+		args = []string{form, "clone", "--recursive", url}
 	case "hg":
-		args := []string{form, "clone", url}
-		if command.VerboseEnabled {
-			return command.Run(args)
+		args = []string{form, "clone", url}
+	case "local":
+		path := url
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workDir, path)
 		}
-
-		f, err := os.Create(logName)
-		if err != nil {
-			return command.Run(args)
+		if !util.FileExists(path) {
+			return fmt.Errorf("no such directory:%s", url)
 		}
-		defer f.Close()
+		return nil
+	default:
+		return fmt.Errorf("form=%s is not supported", form)
+	}
 
-		cmd, err := command.Make(args)
-		if err != nil {
-			return err
-		}
+	if err := clone(ctx, m, workDir, verbose, args); err != nil {
+		os.RemoveAll(filepath.Join(workDir, m.Name))
+		return err
+	}
+	return nil
+}
 
-		writer := bufio.NewWriter(f)
-		defer writer.Flush()
+func clone(ctx context.Context, m Module3rd, workDir string, verbose bool, args []string) error {
+	if verbose {
+		return command.Run(ctx, workDir, verbose, args)
+	}
 
-		cmd.Stderr = writer
+	f, err := os.Create(filepath.Join(workDir, LogPath(m)))
+	if err != nil {
+		return command.Run(ctx, workDir, verbose, args)
+	}
+	defer f.Close()
 
-		return cmd.Run()
-	case "local": // not implemented yet
-		return nil
+	cmd, err := command.Make(ctx, workDir, args)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Errorf("form=%s is not supported", form)
+	writer := bufio.NewWriter(f)
+	defer writer.Flush()
+
+	cmd.Stderr = writer
+
+	return command.Exec(ctx, cmd)
 }
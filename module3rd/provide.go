@@ -1,74 +1,78 @@
 package module3rd
 
 import (
+	"context"
 	"fmt"
-	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/cubicdaiya/nginx-build/command"
-	"github.com/cubicdaiya/nginx-build/util"
 )
 
-func Provide(m *Module3rd) error {
+// Provide checks out the revision of the module and runs its provision under workDir.
+func Provide(ctx context.Context, m *Module3rd, workDir string, verbose bool) error {
 	if len(m.Rev) > 0 {
-		dir := util.SaveCurrentDir()
-		if err := os.Chdir(m.Name); err != nil {
-			return fmt.Errorf("chdir to %s failed: %w", m.Name, err)
-		}
-		if err := switchRev(m.Form, m.Rev); err != nil {
+		dir := filepath.Join(workDir, m.Name)
+		if err := switchRev(ctx, m.Form, m.Rev, dir, verbose); err != nil {
 			return fmt.Errorf("%s (%s checkout %s): %s", m.Name, m.Form, m.Rev, err.Error())
 		}
-		if err := os.Chdir(dir); err != nil {
-			return fmt.Errorf("return to dir %s failed: %w", dir, err)
-		}
 	}
 
 	if len(m.Shprov) > 0 {
-		dir := util.SaveCurrentDir()
+		dir := filepath.Join(workDir, m.Name)
 		if len(m.ShprovDir) > 0 {
-			if err := os.Chdir(m.Name + "/" + m.ShprovDir); err != nil {
-				return fmt.Errorf("chdir to %s/%s failed: %w", m.Name, m.ShprovDir, err)
-			}
-		} else {
-			if err := os.Chdir(m.Name); err != nil {
-				return fmt.Errorf("chdir to %s failed: %w", m.Name, err)
-			}
+			dir = filepath.Join(dir, m.ShprovDir)
 		}
-		if err := provideShell(m.Shprov); err != nil {
+		if err := provideShell(ctx, m.Shprov, dir, verbose); err != nil {
 			return fmt.Errorf("%s's shprov(%s): %s", m.Name, m.Shprov, err.Error())
 		}
-		if err := os.Chdir(dir); err != nil {
-			return fmt.Errorf("return to dir %s failed: %w", dir, err)
-		}
 	}
 
 	return nil
 }
 
-func provideShell(sh string) error {
+func provideShell(ctx context.Context, sh, dir string, verbose bool) error {
 	if strings.TrimSpace(sh) == "" {
 		return nil
 	}
-	if command.VerboseEnabled {
-		return command.Run([]string{"sh", "-c", sh})
-	}
-
-	cmd := exec.Command("sh", "-c", sh)
-	return cmd.Run()
+	return command.Run(ctx, dir, verbose, []string{"sh", "-c", sh})
 }
 
-func switchRev(form, rev string) error {
+func switchRev(ctx context.Context, form, rev, dir string, verbose bool) error {
 	var err error
 
 	switch form {
 	case "git":
-		err = command.Run([]string{"git", "checkout", rev})
+		err = command.Run(ctx, dir, verbose, []string{"git", "checkout", rev})
 	case "hg":
-		err = command.Run([]string{"hg", "checkout", rev})
+		err = command.Run(ctx, dir, verbose, []string{"hg", "checkout", rev})
 	default:
 		err = fmt.Errorf("form=%s is not supported", form)
 	}
 
 	return err
 }
+
+// ResolvedRev returns the commit checked out in the module's repository under workDir.
+func ResolvedRev(m Module3rd, workDir string) (string, error) {
+	var args []string
+	switch m.Form {
+	case "git":
+		args = []string{"git", "rev-parse", "HEAD"}
+	case "hg":
+		args = []string{"hg", "id", "-i", "--debug"}
+	default:
+		return "", fmt.Errorf("form=%s is not supported", m.Form)
+	}
+
+	cmd, err := command.Make(context.Background(), filepath.Join(workDir, m.Name), args)
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s (%s): %w", m.Name, strings.Join(args, " "), err)
+	}
+	// hg appends '+' to the id of a modified working directory
+	return strings.TrimSuffix(strings.TrimSpace(string(out)), "+"), nil
+}
@@ -1,19 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"runtime"
 	"strings"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/cubicdaiya/nginx-build/builder"
-	"github.com/cubicdaiya/nginx-build/command"
 	"github.com/cubicdaiya/nginx-build/configure"
-	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
 	"github.com/cubicdaiya/nginx-build/util"
 )
 
@@ -21,150 +22,91 @@ var (
 	nginxBuildOptions Options
 )
 
+const (
+	// exitInterrupted is the exit status when the build was interrupted by a signal.
+	exitInterrupted = 130
+	// exitTimeout is the exit status when the build exceeded -timeout as same as timeout(1).
+	exitTimeout = 124
+)
+
 func init() {
 	nginxBuildOptions = makeNginxBuildOptions()
 }
 
 // fake flag for --with-xxx=dynamic
-func overrideUnableParseFlags() {
-	for i, arg := range os.Args {
+func overrideUnableParseFlags(args []string) {
+	for i, arg := range args {
 		if strings.Contains(arg, "with-http_xslt_module=dynamic") {
-			os.Args[i] = "--with-http_xslt_module_dynamic"
+			args[i] = "--with-http_xslt_module_dynamic"
 		}
 		if strings.Contains(arg, "with-http_image_filter_module=dynamic") {
-			os.Args[i] = "--with-http_image_filter_module_dynamic"
+			args[i] = "--with-http_image_filter_module_dynamic"
 		}
 		if strings.Contains(arg, "with-http_geoip_module=dynamic") {
-			os.Args[i] = "--with-http_geoip_module_dynamic"
+			args[i] = "--with-http_geoip_module_dynamic"
 		}
 		if strings.Contains(arg, "with-http_perl_module=dynamic") {
-			os.Args[i] = "--with-http_perl_module_dynamic"
+			args[i] = "--with-http_perl_module_dynamic"
 		}
 		if strings.Contains(arg, "with-mail=dynamic") {
-			os.Args[i] = "--with-mail_dynamic"
+			args[i] = "--with-mail_dynamic"
 		}
 		if strings.Contains(arg, "with-stream=dynamic") {
-			os.Args[i] = "--with-stream_dynamic"
+			args[i] = "--with-stream_dynamic"
 		}
 		if strings.Contains(arg, "with-stream_geoip_module=dynamic") {
-			os.Args[i] = "--with-stream_geoip_module_dynamic"
+			args[i] = "--with-stream_geoip_module_dynamic"
 		}
 	}
 }
 
 func main() {
-	var (
-		multiflagPatch StringFlag
-	)
-
-	// Parse flags
-	for k, v := range nginxBuildOptions.Bools {
-		v.Enabled = flag.Bool(k, false, v.Desc)
-		nginxBuildOptions.Bools[k] = v
-	}
-	for k, v := range nginxBuildOptions.Values {
-		if k == "patch" {
-			flag.Var(&multiflagPatch, k, v.Desc)
-		} else {
-			v.Value = flag.String(k, v.Default, v.Desc)
-			nginxBuildOptions.Values[k] = v
-		}
-	}
-	for k, v := range nginxBuildOptions.Numbers {
-		v.Value = flag.Int(k, v.Default, v.Desc)
-		nginxBuildOptions.Numbers[k] = v
-	}
-
-	overrideUnableParseFlags()
-
-	var (
-		configureOptions configure.Options
-		multiflag        StringFlag
-		multiflagDynamic StringFlag
-	)
-
-	argsString := configure.MakeArgsString()
-	for k, v := range argsString {
-		if k == "add-module" {
-			flag.Var(&multiflag, k, v.Desc)
-		} else if k == "add-dynamic-module" {
-			flag.Var(&multiflagDynamic, k, v.Desc)
-		} else {
-			v.Value = flag.String(k, "", v.Desc)
-			argsString[k] = v
-		}
+	cmd, args := parseSubcommand(os.Args[1:])
+	if cmd.name == "versions" {
+		printNginxVersions()
+		return
 	}
+	run(cmd, args)
+}
 
-	argsBool := configure.MakeArgsBool()
-	for k, v := range argsBool {
-		v.Enabled = flag.Bool(k, false, v.Desc)
-		argsBool[k] = v
+// run runs the subcommand with its arguments.
+func run(cmd *subcommand, args []string) {
+	p, err := parseArgs(cmd, args, flag.ExitOnError)
+	if err != nil {
+		log.Fatal(err)
 	}
+	fs := p.fs
 
-	flag.CommandLine.SetOutput(os.Stdout)
-	// The output of original flag.Usage() is too long
-	defaultUsage := flag.Usage
-	flag.Usage = usage
-	flag.Parse()
-
-	jobs := nginxBuildOptions.Numbers["j"].Value
+	gcKeep := nginxBuildOptions.Numbers["keep"].Value
 
 	verbose := nginxBuildOptions.Bools["verbose"].Enabled
-	pcreStatic := nginxBuildOptions.Bools["pcre"].Enabled
-	openSSLStatic := nginxBuildOptions.Bools["openssl"].Enabled
-	libreSSLStatic := nginxBuildOptions.Bools["libressl"].Enabled
-	zlibStatic := nginxBuildOptions.Bools["zlib"].Enabled
-	clear := nginxBuildOptions.Bools["clear"].Enabled
 	versionPrint := nginxBuildOptions.Bools["version"].Enabled
 	versionsPrint := nginxBuildOptions.Bools["versions"].Enabled
-	openResty := nginxBuildOptions.Bools["openresty"].Enabled
-	freenginx := nginxBuildOptions.Bools["freenginx"].Enabled
-	configureOnly := nginxBuildOptions.Bools["configureonly"].Enabled
-	idempotent := nginxBuildOptions.Bools["idempotent"].Enabled
 	helpAll := nginxBuildOptions.Bools["help-all"].Enabled
+	openRestyBundle := nginxBuildOptions.Bools["openresty-bundle"].Enabled
+	dryRun := nginxBuildOptions.Bools["dry-run"].Enabled
 
-	version := nginxBuildOptions.Values["v"].Value
-	nginxConfigurePath := nginxBuildOptions.Values["c"].Value
-	modulesConfPath := nginxBuildOptions.Values["m"].Value
 	workParentDir := nginxBuildOptions.Values["d"].Value
-	pcreVersion := nginxBuildOptions.Values["pcreversion"].Value
-	openSSLVersion := nginxBuildOptions.Values["opensslversion"].Value
-	libreSSLVersion := nginxBuildOptions.Values["libresslversion"].Value
-	zlibVersion := nginxBuildOptions.Values["zlibversion"].Value
-	openRestyVersion := nginxBuildOptions.Values["openrestyversion"].Value
-	freenginxVersion := nginxBuildOptions.Values["freenginxversion"].Value
-	patchOption := nginxBuildOptions.Values["patch-opt"].Value
-
-	// Allow multiple flags for `--patch`
-	{
-		tmp := nginxBuildOptions.Values["patch"]
-		tmp2 := multiflagPatch.String()
-		tmp.Value = &tmp2
-		nginxBuildOptions.Values["patch"] = tmp
-	}
-
-	// Allow multiple flags for `--add-module`
-	{
-		tmp := argsString["add-module"]
-		tmp2 := multiflag.String()
-		tmp.Value = &tmp2
-		argsString["add-module"] = tmp
-	}
-
-	// Allow multiple flags for `--add-dynamic-module`
-	{
-		tmp := argsString["add-dynamic-module"]
-		tmp2 := multiflagDynamic.String()
-		tmp.Value = &tmp2
-		argsString["add-dynamic-module"] = tmp
-	}
-
-	patchPath := nginxBuildOptions.Values["patch"].Value
-	configureOptions.Values = argsString
-	configureOptions.Bools = argsBool
+	timeout := nginxBuildOptions.Values["timeout"].Value
+	gcMaxAge := nginxBuildOptions.Values["max-age"].Value
+	gcMaxSize := nginxBuildOptions.Values["max-size"].Value
+	outputFormat := nginxBuildOptions.Values["output"].Value
+	tracePath := nginxBuildOptions.Values["trace"].Value
+	matrixFlavors := nginxBuildOptions.Values["matrix-flavors"].Value
+	matrixVersions := nginxBuildOptions.Values["matrix-versions"].Value
+	matrixTLS := nginxBuildOptions.Values["matrix-tls"].Value
+	matrixParallel := nginxBuildOptions.Numbers["matrix-parallel"].Value
+	bisectGood := nginxBuildOptions.Values["good"].Value
+	bisectBad := nginxBuildOptions.Values["bad"].Value
+	bisectRun := nginxBuildOptions.Values["run"].Value
+	bisectReleases := nginxBuildOptions.Values["releases"].Value
+	exportFormat := nginxBuildOptions.Values["export"].Value
+	exportPath := nginxBuildOptions.Values["export-path"].Value
 
 	if *helpAll {
-		defaultUsage()
+		// The output of original flag.Usage() is too long
+		fmt.Fprintf(os.Stdout, "Usage of %s:\n", fs.Name())
+		fs.PrintDefaults()
 		return
 	}
 
@@ -178,267 +120,223 @@ func main() {
 		return
 	}
 
-	printFirstMsg()
+	// info, doctor and clean do not build nginx
+	inspecting := cmd.name == "info" || cmd.name == "doctor" || cmd.name == "clean"
 
-	// set verbose mode
-	command.VerboseEnabled = *verbose
-
-	var nginxBuilder builder.Builder
-	if *openResty && *freenginx {
-		log.Fatal("select one between '-openresty' and '-freenginx'.")
+	var jsonOutput bool
+	switch *outputFormat {
+	case "text":
+	case "json":
+		jsonOutput = true
+	default:
+		log.Fatalf("output=%s is not supported (one of text, json)", *outputFormat)
 	}
-	if *openSSLStatic && *libreSSLStatic {
-		log.Fatal("select one between '-openssl' and '-libressl'.")
+	if jsonOutput && *verbose {
+		log.Fatal("select one between '-output json' and '-verbose'.")
 	}
-	if *openResty {
-		nginxBuilder = builder.MakeBuilder(builder.ComponentOpenResty, *openRestyVersion)
-	} else if *freenginx {
-		nginxBuilder = builder.MakeBuilder(builder.ComponentFreenginx, *freenginxVersion)
-	} else {
-		nginxBuilder = builder.MakeBuilder(builder.ComponentNginx, *version)
+	if jsonOutput && *openRestyBundle {
+		log.Fatal("select one between '-output json' and '-openresty-bundle'.")
 	}
-	pcreBuilder := builder.MakeLibraryBuilder(builder.ComponentPcre, *pcreVersion, *pcreStatic)
-	openSSLBuilder := builder.MakeLibraryBuilder(builder.ComponentOpenSSL, *openSSLVersion, *openSSLStatic)
-	libreSSLBuilder := builder.MakeLibraryBuilder(builder.ComponentLibreSSL, *libreSSLVersion, *libreSSLStatic)
-	zlibBuilder := builder.MakeLibraryBuilder(builder.ComponentZlib, *zlibVersion, *zlibStatic)
 
-	if *idempotent {
-		builders := []builder.Builder{
-			nginxBuilder,
-			pcreBuilder,
-			openSSLBuilder,
-			libreSSLBuilder,
-			zlibBuilder,
+	if cmd.name == "gc" {
+		if len(*workParentDir) == 0 {
+			log.Fatal("set working directory with -d")
 		}
-
-		isSame, err := builder.IsSameVersion(builders)
-		if err != nil {
-			log.Println("[notice]", err)
+		var (
+			policy nginxbuild.GCPolicy
+			err    error
+		)
+		if *gcMaxAge != "" {
+			if policy.MaxAge, err = parseAge(*gcMaxAge); err != nil {
+				log.Fatal(err)
+			}
 		}
-		if isSame {
-			log.Println("Installed nginx is same.")
-			return
+		if *gcMaxSize != "" {
+			if policy.MaxSize, err = nginxbuild.ParseSize(*gcMaxSize); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *gcKeep < 0 {
+			log.Fatalf("keep=%d is invalid", *gcKeep)
 		}
+		policy.Keep = *gcKeep
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		runGC(ctx, *workParentDir, policy, *dryRun, jsonOutput)
+		return
 	}
 
-	// change default umask
-	_ = syscall.Umask(0)
-
-	versionCheck(*version)
+	if !jsonOutput && !inspecting {
+		printFirstMsg()
+	}
 
-	nginxConfigure, err := util.FileGetContents(*nginxConfigurePath)
-	if err != nil {
-		log.Fatal(err)
+	var buildTimeout time.Duration
+	if *timeout != "" {
+		d, err := time.ParseDuration(*timeout)
+		if err != nil || d <= 0 {
+			log.Fatalf("timeout=%s is invalid duration", *timeout)
+		}
+		buildTimeout = d
 	}
-	nginxConfigure = configure.Normalize(nginxConfigure)
 
-	modules3rd, err := module3rd.Load(*modulesConfPath)
+	spec, err := makeSpec(cmd, p)
 	if err != nil {
 		log.Fatal(err)
 	}
+	flavorComponent, _ := builder.FlavorComponent(spec.Flavor)
+	bisectMode := cmd.name == "bisect"
+	fetchOnly := cmd.name == "fetch"
 
-	if len(*workParentDir) == 0 {
-		log.Fatal("set working directory with -d")
-	}
-
-	if !util.FileExists(*workParentDir) {
-		err := os.Mkdir(*workParentDir, 0755)
-		if err != nil {
-			log.Fatalf("Failed to create working directory %s.", *workParentDir)
-		}
-	}
+	// change default umask
+	_ = syscall.Umask(0)
 
-	var workDir string
-	if *openResty {
-		workDir = *workParentDir + "/openresty/" + *openRestyVersion
-	} else if *freenginx {
-		workDir = *workParentDir + "/freenginx/" + *freenginxVersion
-	} else {
-		workDir = *workParentDir + "/nginx/" + *version
+	// the version of a bundle is checked in fetching
+	if flavorComponent == builder.ComponentNginx && !matrixMode() && !bisectMode && spec.FromBundle == "" {
+		versionCheck(spec.Version)
 	}
 
-	if *clear {
-		err := util.ClearWorkDir(workDir)
+	if *exportFormat == "dockerfile" {
+		nginxBuilder := builder.MakeBuilder(flavorComponent, spec.Version)
+		packages, err := buildPackages(spec)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("Failed to export Dockerfile: %v", err)
 		}
-	}
-
-	if !util.FileExists(workDir) {
-		err := os.MkdirAll(workDir, 0755)
-		if err != nil {
-			log.Fatalf("Failed to create working directory %s.", workDir)
+		if err := exportDockerfile(fs, *exportPath, configure.Normalize(spec.Configure), &nginxBuilder, spec.Modules, packages); err != nil {
+			log.Fatalf("Failed to export Dockerfile: %v", err)
 		}
+		log.Printf("Complete exporting Dockerfile into %s!", *exportPath)
+		return
 	}
 
-	rootDir := util.SaveCurrentDir()
-	err = os.Chdir(workDir)
-	if err != nil {
-		log.Fatal(err)
+	if len(*workParentDir) == 0 && !spec.Idempotent {
+		log.Fatal("set working directory with -d")
 	}
 
-	// remove nginx source code applied patch
-	if *patchPath != "" && util.FileExists(nginxBuilder.SourcePath()) {
-		err := os.RemoveAll(nginxBuilder.SourcePath())
+	var combinations []nginxbuild.Combination
+	if matrixMode() {
+		combinations, err = matrixCombinations(*matrixFlavors, *matrixVersions, *matrixTLS, spec.Flavor, spec.OpenSSL, spec.LibreSSL)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 
-	var wg sync.WaitGroup
-	if *pcreStatic {
-		wg.Add(1)
-		go func() {
-			downloadAndExtractParallel(&pcreBuilder)
-			wg.Done()
-		}()
-	}
-
-	if *openSSLStatic {
-		wg.Add(1)
-		go func() {
-			downloadAndExtractParallel(&openSSLBuilder)
-			wg.Done()
-		}()
-	}
-
-	if *libreSSLStatic {
-		wg.Add(1)
-		go func() {
-			downloadAndExtractParallel(&libreSSLBuilder)
-			wg.Done()
-		}()
-	}
-
-	if *zlibStatic {
-		wg.Add(1)
-		go func() {
-			downloadAndExtractParallel(&zlibBuilder)
-			wg.Done()
-		}()
-	}
-
-	wg.Add(1)
-	go func() {
-		downloadAndExtractParallel(&nginxBuilder)
-		wg.Done()
-	}()
-
-	if len(modules3rd) > 0 {
-		wg.Add(len(modules3rd))
-		for _, m := range modules3rd {
-			go func(m module3rd.Module3rd) {
-				module3rd.DownloadAndExtractParallel(m)
-				wg.Done()
-			}(m)
-		}
-
-	}
-
-	// wait until all downloading processes by goroutine finish
-	wg.Wait()
-
-	if len(modules3rd) > 0 {
-		for _, m := range modules3rd {
-			if err := module3rd.Provide(&m); err != nil {
-				log.Fatal(err)
-			}
-		}
+	switch cmd.name {
+	case "info":
+		printInfo(spec, jsonOutput)
+		return
+	case "doctor":
+		runDoctor(context.Background(), spec, jsonOutput)
+		return
 	}
 
-	// cd workDir/nginx-${version}
-	if err := os.Chdir(nginxBuilder.SourcePath()); err != nil {
-		log.Fatalf("failed to change directory: %v", err)
+	// the progress goes to stderr and stdout has only the events
+	if jsonOutput {
+		spec.Events = nginxbuild.JSONEvents(os.Stdout)
 	}
 
-	var dependencies []builder.StaticLibrary
-	if *pcreStatic {
-		dependencies = append(dependencies, builder.MakeStaticLibrary(&pcreBuilder))
+	// cancels the build (and reverts patches) when the build was interrupted or timed out.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if buildTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, buildTimeout)
+		defer cancel()
 	}
 
-	if *openSSLStatic {
-		dependencies = append(dependencies, builder.MakeStaticLibrary(&openSSLBuilder))
+	if cmd.name == "clean" {
+		runClean(ctx, spec)
+		return
 	}
 
-	if *libreSSLStatic {
-		dependencies = append(dependencies, builder.MakeStaticLibrary(&libreSSLBuilder))
+	nginxbuild.NginxBuildVersion = nginxBuildVersion()
+	if matrixMode() {
+		spec.Events = nil
+		runMatrix(ctx, nginxbuild.Matrix{Spec: spec, Combinations: combinations, Parallel: *matrixParallel}, jsonOutput)
+		return
 	}
-
-	if *zlibStatic {
-		dependencies = append(dependencies, builder.MakeStaticLibrary(&zlibBuilder))
+	if bisectMode {
+		spec.Events = nil
+		bisection := nginxbuild.Bisection{
+			Spec:     spec,
+			Versions: splitList(*bisectReleases),
+			Good:     *bisectGood,
+			Bad:      *bisectBad,
+		}
+		runBisect(ctx, bisection, *bisectRun, jsonOutput)
+		return
 	}
-
-	log.Printf("Generate configure script for %s.....", nginxBuilder.SourcePath())
-
-	if *pcreStatic && pcreBuilder.IsIncludeWithOption(nginxConfigure) {
-		log.Println(pcreBuilder.WarnMsgWithLibrary())
+	result, err := nginxbuild.Build(ctx, spec)
+	if *tracePath != "" && len(result.Timings) > 0 {
+		if err := writeTrace(*tracePath, result.Timings); err != nil {
+			log.Printf("[warn] failed to write trace: %v", err)
+		}
 	}
-
-	if *openSSLStatic && openSSLBuilder.IsIncludeWithOption(nginxConfigure) {
-		log.Println(openSSLBuilder.WarnMsgWithLibrary())
+	if result.SmokeTest != nil && !jsonOutput {
+		printTestReport("Smoke test", result.SmokeTest)
 	}
-
-	if *libreSSLStatic && libreSSLBuilder.IsIncludeWithOption(nginxConfigure) {
-		log.Println(libreSSLBuilder.WarnMsgWithLibrary())
+	if result.Fixtures != nil && !jsonOutput {
+		printTestReport("Fixtures", result.Fixtures)
 	}
-
-	if *zlibStatic && zlibBuilder.IsIncludeWithOption(nginxConfigure) {
-		log.Println(zlibBuilder.WarnMsgWithLibrary())
+	if result.NginxTests != nil && !jsonOutput {
+		printNginxTestsSummary(result.NginxTests)
 	}
-
-	configureScript := configure.Generate(nginxConfigure, modules3rd, dependencies, configureOptions, rootDir, *openResty, *jobs)
-
-	err = os.WriteFile("./nginx-configure", []byte(configureScript), 0655)
 	if err != nil {
-		log.Fatalf("Failed to generate configure script for %s", nginxBuilder.SourcePath())
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Timed out building nginx after %s: %v", buildTimeout, err)
+			os.Exit(exitTimeout)
+		}
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Interrupted building nginx: %v", err)
+			os.Exit(exitInterrupted)
+		}
+		var stageErr *nginxbuild.StageError
+		if !jsonOutput && errors.As(err, &stageErr) && stageErr.LogPath != "" {
+			util.PrintFatalMsg(err, stageErr.LogPath, *verbose)
+		}
+		log.Fatal(err)
 	}
 
-	util.Patch(*patchPath, *patchOption, rootDir, false)
+	if result.Skipped || jsonOutput {
+		return
+	}
 
-	// reverts source code with patch -R when the build was interrupted.
-	if *patchPath != "" {
-		sigChannel := make(chan os.Signal, 1)
-		signal.Notify(sigChannel, os.Interrupt)
-		go func() {
-			<-sigChannel
-			util.Patch(*patchPath, *patchOption, rootDir, true)
-		}()
+	if *openRestyBundle {
+		printOpenRestyBundle(result.SourceDir)
+		return
 	}
 
-	log.Printf("Configure %s.....", nginxBuilder.SourcePath())
+	printTimings(result.Timings)
 
-	err = configure.Run()
-	if err != nil {
-		log.Printf("Failed to configure %s\n", nginxBuilder.SourcePath())
-		util.Patch(*patchPath, *patchOption, rootDir, true)
-		util.PrintFatalMsg(err, "nginx-configure.log")
+	if spec.PatchDryRun {
+		log.Printf("Complete checking patches for %s!", result.SourceDir)
+		return
 	}
 
-	if *configureOnly {
-		util.Patch(*patchPath, *patchOption, rootDir, true)
-		printLastMsg(workDir, nginxBuilder.SourcePath(), *openResty, *configureOnly)
+	if fetchOnly {
+		if result.BundlePath != "" {
+			log.Printf("Complete writing bundle into %s!", result.BundlePath)
+		} else {
+			log.Printf("Complete fetching into %s!", result.WorkDir)
+		}
 		return
 	}
 
-	log.Printf("Build %s.....", nginxBuilder.SourcePath())
-
-	if *openSSLStatic {
-		// Sometimes machine hardware name('uname -m') is different
-		// from machine processor architecture name('uname -p') on Mac.
-		// Specifically, `uname -p` is 'i386' and `uname -m` is 'x86_64'.
-		// In this case, a build of OpenSSL fails.
-		// So it needs to convince OpenSSL with KERNEL_BITS.
-		if runtime.GOOS == "darwin" && runtime.GOARCH == "amd64" {
-			os.Setenv("KERNEL_BITS", "64")
+	if spec.Install {
+		printInstallMsg(result.InstalledBinaryPath, result.InstalledVersionInfo)
+		if result.PackagePath != "" {
+			log.Printf("Complete packaging nginx into %s!", result.PackagePath)
+		}
+		if result.OCIPath != "" {
+			log.Printf("Complete exporting OCI image into %s!", result.OCIPath)
 		}
+		return
 	}
 
-	err = builder.BuildNginx(*jobs)
-	if err != nil {
-		log.Printf("Failed to build %s\n", nginxBuilder.SourcePath())
-		util.Patch(*patchPath, *patchOption, rootDir, true)
-		util.PrintFatalMsg(err, "nginx-build.log")
+	binaryPath := result.BinaryPath
+	if spec.Target != nil && binaryPath != "" {
+		// nginx cross-compiled does not run on the host to show its configure options
+		log.Printf("%s is built for %s.", binaryPath, spec.Target.Triple)
+		binaryPath = ""
 	}
-
-	printLastMsg(workDir, nginxBuilder.SourcePath(), *openResty, *configureOnly)
+	printLastMsg(result.SourceDir, binaryPath, spec.ConfigureOnly)
 }
@@ -0,0 +1,242 @@
+package nginxbuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/openresty"
+)
+
+// The statuses of a version in a bisection.
+const (
+	BisectGood = "good"
+	BisectBad  = "bad"
+	BisectSkip = "skip"
+)
+
+// ErrBisectSkip is returned by the test of a bisection when the version can not be tested.
+var ErrBisectSkip = errors.New("the version can not be tested")
+
+// Releases returns the released versions of the flavor in the download directory in ascending order.
+func Releases(ctx context.Context, flavor string) ([]string, error) {
+	component, err := builder.FlavorComponent(flavor)
+	if err != nil {
+		return nil, err
+	}
+	b := builder.MakeBuilder(component, "0")
+	indexURL := path.Dir(b.DownloadURL()) + "/"
+
+	c := &http.Client{Timeout: DefaultDownloadTimeout}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, indexURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get the releases of %s from %s. %s", flavor, indexURL, res.Status)
+	}
+	index, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := ParseReleases(b.FlavorName(), string(index))
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no releases of %s in %s", flavor, indexURL)
+	}
+	return versions, nil
+}
+
+// ParseReleases returns the versions of the archives of name in a download index in ascending order.
+// The pre-releases such as rc are excluded.
+func ParseReleases(name, index string) []string {
+	re := regexp.MustCompile(`(?:^|[^\w.-])` + regexp.QuoteMeta(name) + `-(\d+(?:\.\d+)+)\.tar\.gz\b`)
+	seen := make(map[string]bool)
+	var versions []string
+	for _, m := range re.FindAllStringSubmatch(index, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			versions = append(versions, m[1])
+		}
+	}
+	SortVersions(versions)
+	return versions
+}
+
+// SortVersions sorts the versions numerically in ascending order.
+func SortVersions(versions []string) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return openresty.CompareVersion(versions[i], versions[j]) < 0
+	})
+}
+
+// Bisection is a binary search for the first version failing a build or a test.
+type Bisection struct {
+	// Spec is the build of each version. The archives downloaded are shared in Spec.DownloadCache (<Spec.WorkDir>/cache by default).
+	Spec Spec
+	// Versions is the versions in ascending order
+	Versions []string
+	// Good is the version known to pass and Bad is the version known to fail
+	Good string
+	Bad  string
+	// Test tests nginx built. The version is bad when it returns an error and skipped when the error is ErrBisectSkip.
+	// The version is good when the build passes and Test is nil.
+	Test func(ctx context.Context, result Result) error
+}
+
+// BisectStep is a version tested in a bisection.
+type BisectStep struct {
+	Version string `json:"version"`
+	Status  string `json:"status"`
+	// Reason is why the version is bad or skipped
+	Reason string `json:"reason,omitempty"`
+	// Duration is the wall-clock seconds of the build and the test
+	Duration float64 `json:"duration"`
+	LogPath  string  `json:"log_path,omitempty"`
+}
+
+// BisectResult is the result of a bisection.
+type BisectResult struct {
+	// FirstBad is the first bad version. It is empty when the versions skipped hide it.
+	FirstBad string `json:"first_bad,omitempty"`
+	LastGood string `json:"last_good"`
+	// Candidates is the versions which may be the first bad one when some of them are skipped
+	Candidates []string     `json:"candidates,omitempty"`
+	Steps      []BisectStep `json:"steps"`
+}
+
+// candidates returns the versions from Good to Bad.
+func (bs *Bisection) candidates() ([]string, error) {
+	good, bad := -1, -1
+	for i, v := range bs.Versions {
+		if v == bs.Good {
+			good = i
+		}
+		if v == bs.Bad {
+			bad = i
+		}
+	}
+	if good < 0 {
+		return nil, fmt.Errorf("the good version %s is not released", bs.Good)
+	}
+	if bad < 0 {
+		return nil, fmt.Errorf("the bad version %s is not released", bs.Bad)
+	}
+	if good >= bad {
+		return nil, fmt.Errorf("the good version %s must be older than the bad version %s", bs.Good, bs.Bad)
+	}
+	return bs.Versions[good : bad+1], nil
+}
+
+// step builds and tests a version.
+func (bs *Bisection) step(ctx context.Context, version string) (step BisectStep, err error) {
+	step = BisectStep{Version: version, Status: BisectGood}
+	start := time.Now()
+	defer func() {
+		step.Duration = time.Since(start).Seconds()
+	}()
+
+	spec := bs.Spec
+	spec.Version = version
+	if spec.DownloadCache == "" {
+		spec.DownloadCache = filepath.Join(bs.Spec.WorkDir, "cache")
+	}
+	result, err := Build(ctx, spec)
+	if err == nil && bs.Test != nil {
+		err = bs.Test(ctx, result)
+	}
+	if err == nil {
+		return step, nil
+	}
+	if ctx.Err() != nil {
+		return step, ctx.Err()
+	}
+
+	var stageErr *StageError
+	if errors.As(err, &stageErr) {
+		switch stageErr.Stage {
+		case StageDownload, StageExtract:
+			// the failures not caused by nginx
+			return step, err
+		}
+		step.LogPath = stageErr.LogPath
+	}
+	step.Status = BisectBad
+	if errors.Is(err, ErrBisectSkip) {
+		step.Status = BisectSkip
+	}
+	step.Reason = err.Error()
+	return step, nil
+}
+
+// RunBisect searches the first bad version between Good and Bad by building the versions one by one.
+// Good and Bad are assumed as they are and not built. step is called after each version is tested.
+func RunBisect(ctx context.Context, bs Bisection, step func(BisectStep)) (BisectResult, error) {
+	var result BisectResult
+
+	versions, err := bs.candidates()
+	if err != nil {
+		return result, err
+	}
+
+	// versions[lo] is good, versions[hi] is bad and the versions skipped are between them
+	lo, hi := 0, len(versions)-1
+	skipped := make(map[int]bool)
+	for {
+		mid := bisectMiddle(lo, hi, skipped)
+		if mid < 0 {
+			break
+		}
+		s, err := bs.step(ctx, versions[mid])
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", versions[mid], err)
+		}
+		result.Steps = append(result.Steps, s)
+		if step != nil {
+			step(s)
+		}
+		switch s.Status {
+		case BisectGood:
+			lo = mid
+		case BisectBad:
+			hi = mid
+		default:
+			skipped[mid] = true
+		}
+	}
+
+	result.LastGood = versions[lo]
+	if hi-lo == 1 {
+		result.FirstBad = versions[hi]
+	} else {
+		result.Candidates = append(result.Candidates, versions[lo+1:hi+1]...)
+	}
+	return result, nil
+}
+
+// bisectMiddle returns the version not skipped nearest to the middle between lo and hi (exclusive).
+// It returns -1 when there is no such version.
+func bisectMiddle(lo, hi int, skipped map[int]bool) int {
+	mid := (lo + hi) / 2
+	for d := 0; mid-d > lo || mid+d < hi; d++ {
+		for _, i := range []int{mid - d, mid + d} {
+			if i > lo && i < hi && !skipped[i] {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,950 @@
+package nginxbuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/configure"
+	"github.com/cubicdaiya/nginx-build/container"
+	"github.com/cubicdaiya/nginx-build/cross"
+	"github.com/cubicdaiya/nginx-build/lock"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/nginxtests"
+	"github.com/cubicdaiya/nginx-build/openresty"
+	"github.com/cubicdaiya/nginx-build/packaging"
+	"github.com/cubicdaiya/nginx-build/smoke"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// build is the state of a build shared among its stages.
+type build struct {
+	spec    Spec
+	baseDir string
+	workDir string
+	srcDir  string
+	// pristineDir is the source code extracted. It is srcDir unless a variant is built.
+	pristineDir string
+
+	nginx     builder.Builder
+	libraries []*builder.Builder
+
+	// patches is the patches applied to the source code and patchStates is the states of the source directories patched
+	patches     []util.PatchFile
+	patchStates []*PatchState
+	revertOnce  sync.Once
+	revertErr   error
+
+	fixtures []smoke.Fixture
+
+	// bundle is the manifest of the bundle the build is made from
+	bundle *BundleManifest
+
+	// workDirLock is the lock of the versioned working directory held until the build finishes
+	workDirLock *lock.Lock
+
+	eventMu sync.Mutex
+
+	recordMu  sync.Mutex
+	timings   []Timing
+	artifacts map[string]string
+}
+
+func absPath(baseDir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// logPath returns the path of the log file of a stage. It is empty in verbose mode.
+func (b *build) logPath(dir, name string) string {
+	if b.spec.Verbose {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+func (b *build) installing() bool {
+	return b.spec.Install || b.spec.Package != "" || b.spec.OCIPath != ""
+}
+
+// testing reports whether nginx built is tested before installing.
+func (b *build) testing() bool {
+	return b.spec.SmokeTest || b.spec.Fixtures != ""
+}
+
+func (b *build) validate() error {
+	spec := &b.spec
+	if spec.OpenSSL.Static && spec.LibreSSL.Static {
+		return errors.New("select one between OpenSSL and LibreSSL")
+	}
+	if spec.ConfigureOnly && b.installing() {
+		return errors.New("select one between configuring only and installing")
+	}
+	if err := validateClearScopes(spec.ClearScopes); err != nil {
+		return err
+	}
+	if err := validateVariant(spec.Variant); err != nil {
+		return err
+	}
+	if spec.FetchOnly && spec.Variant != "" {
+		return errors.New("a variant is not available in fetching only")
+	}
+	if spec.PatchDryRun && (spec.Patch == "" || spec.FetchOnly) {
+		return errors.New("checking the patches requires patches and is not available in fetching only")
+	}
+	if !spec.FetchOnly && (spec.Bundle != "" || spec.FetchShprov || spec.FetchPatch) {
+		return errors.New("a bundle, shprov and patches in fetching are available only in fetching only")
+	}
+	if b.testing() {
+		if spec.ConfigureOnly {
+			return errors.New("select one between configuring only and testing nginx")
+		}
+		if b.nginx.BinaryPath() == "" && !b.installing() {
+			return fmt.Errorf("testing %s requires installing", b.nginx.FlavorName())
+		}
+	}
+	if b.nginx.Component == builder.ComponentOpenResty {
+		if spec.OpenResty != nil {
+			if err := spec.OpenResty.Validate(b.nginx.Version); err != nil {
+				return err
+			}
+		}
+	} else if spec.OpenResty != nil {
+		return errors.New("OpenResty's options are available only with OpenResty")
+	}
+	if spec.Target != nil {
+		if err := validateTarget(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTarget checks the build is available in cross-compiling.
+func validateTarget(b *build) error {
+	spec := &b.spec
+	if err := spec.Target.Validate(); err != nil {
+		return err
+	}
+	switch {
+	case b.nginx.Component == builder.ComponentOpenResty:
+		return errors.New("cross-compiling OpenResty is not supported")
+	case spec.LibreSSL.Static:
+		return errors.New("cross-compiling LibreSSL is not supported. Use OpenSSL built statically")
+	case b.testing() || spec.NginxTests != "":
+		return errors.New("nginx cross-compiled can not be tested on the host")
+	case spec.Idempotent:
+		return errors.New("nginx cross-compiled can not be compared with the installed one")
+	case (spec.Package != "" || spec.OCIPath != "") && spec.Target.GOOS() != "linux":
+		return fmt.Errorf("nginx cross-compiled for %s can not be packaged", spec.Target.Triple)
+	}
+	options := []string{"--crossbuild", "--with-cc"}
+	if spec.Pcre.Static {
+		options = append(options, "--with-pcre-conf-opt")
+	}
+	for _, option := range options {
+		if strings.Contains(spec.Configure, option+"=") {
+			return fmt.Errorf("%s in the configure script is given by the target in cross-compiling", option)
+		}
+	}
+	return nil
+}
+
+func libraryBuilder(component int, lib Library, defaultVersion string) builder.Builder {
+	version := lib.Version
+	if version == "" {
+		version = defaultVersion
+	}
+	return builder.MakeLibraryBuilder(component, version, lib.Static)
+}
+
+func newBuild(spec Spec) (*build, error) {
+	b := &build{artifacts: make(map[string]string)}
+
+	var err error
+	b.baseDir = spec.BaseDir
+	if b.baseDir == "" {
+		b.baseDir = util.SaveCurrentDir()
+	}
+	b.baseDir, err = filepath.Abs(b.baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if spec.FromBundle != "" {
+		manifest, err := ReadBundleManifest(absPath(b.baseDir, spec.FromBundle))
+		if err != nil {
+			return nil, err
+		}
+		spec, err = applyBundle(spec, manifest)
+		if err != nil {
+			return nil, err
+		}
+		b.bundle = &manifest
+	}
+	b.spec = spec
+
+	flavor := spec.Flavor
+	if flavor == "" {
+		flavor = "nginx"
+	}
+	component, err := builder.FlavorComponent(flavor)
+	if err != nil {
+		return nil, err
+	}
+	version := spec.Version
+	if version == "" {
+		version = builder.FlavorDefaultVersion(component)
+	}
+	b.nginx = builder.MakeBuilder(component, version)
+
+	libraries := []builder.Builder{
+		libraryBuilder(builder.ComponentPcre, spec.Pcre, builder.PcreVersion),
+		libraryBuilder(builder.ComponentOpenSSL, spec.OpenSSL, builder.OpenSSLVersion),
+		libraryBuilder(builder.ComponentLibreSSL, spec.LibreSSL, builder.LibreSSLVersion),
+		libraryBuilder(builder.ComponentZlib, spec.Zlib, builder.ZlibVersion),
+	}
+	for i := range libraries {
+		if libraries[i].Static {
+			b.libraries = append(b.libraries, &libraries[i])
+		}
+	}
+
+	if b.spec.Jobs <= 0 {
+		b.spec.Jobs = runtime.NumCPU()
+	}
+
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	b.workDir = filepath.Join(absPath(b.baseDir, spec.WorkDir), b.nginx.FlavorName(), b.nginx.Version)
+	b.pristineDir = filepath.Join(b.workDir, b.nginx.SourcePath())
+	b.srcDir = variantDir(b.workDir, b.nginx.SourcePath(), spec.Variant)
+	if b.bundle != nil {
+		b.spec.Patch = b.bundlePatches()
+	}
+	return b, nil
+}
+
+// stageError makes the error of a stage.
+// The cause is the cancellation of ctx when the stage was interrupted.
+func stageError(ctx context.Context, stage, component, logPath string, err error) error {
+	if ctx.Err() != nil {
+		return &StageError{Stage: stage, Component: component, Err: ctx.Err()}
+	}
+	return &StageError{Stage: stage, Component: component, LogPath: logPath, Err: err}
+}
+
+// Build builds nginx by spec.
+// When ctx is done, the running command is killed, the patches applied are reverted
+// and Build returns an error wrapping ctx.Err().
+func Build(ctx context.Context, spec Spec) (Result, error) {
+	var result Result
+
+	b, err := newBuild(spec)
+	if err != nil {
+		return result, err
+	}
+
+	b.emit(Event{Type: EventBuildStart, Flavor: b.nginx.FlavorName(), Version: b.nginx.Version, Variant: b.spec.Variant, WorkDir: b.workDir})
+	start := time.Now()
+	err = b.run(ctx, &result)
+	if err == nil && !result.Skipped && !b.spec.PatchDryRun {
+		result.ManifestPath, err = b.writeManifest(&result)
+	}
+	if b.workDirLock != nil {
+		if lockErr := b.workDirLock.Release(); lockErr != nil && err == nil {
+			err = lockErr
+		}
+	}
+	result.Timings = sortTimings(b.timings)
+	finish := Event{Type: EventBuildFinish, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		finish.Error = err.Error()
+		var stageErr *StageError
+		if errors.As(err, &stageErr) {
+			finish.Stage = stageErr.Stage
+			finish.Component = stageErr.Component
+			finish.LogPath = stageErr.LogPath
+		}
+	}
+	b.emit(finish)
+
+	return result, err
+}
+
+func (b *build) run(ctx context.Context, result *Result) (err error) {
+	spec := &b.spec
+	logger := spec.logger()
+
+	if spec.Idempotent {
+		builders := []builder.Builder{b.nginx}
+		for _, l := range b.libraries {
+			builders = append(builders, *l)
+		}
+
+		isSame, err := builder.IsSameVersion(builders)
+		if err != nil {
+			logger.Println("[notice]", err)
+		}
+		if isSame {
+			logger.Println("Installed nginx is same.")
+			result.Skipped = true
+			return nil
+		}
+	}
+
+	if spec.WorkDir == "" {
+		return errors.New("working directory is not set")
+	}
+	if err := b.checkDestDir(); err != nil {
+		return err
+	}
+
+	result.Version = b.nginx.Version
+	result.WorkDir = b.workDir
+	result.SourceDir = b.srcDir
+
+	if err := b.lockWorkDir(ctx); err != nil {
+		return err
+	}
+
+	if err := b.prepareWorkDir(); err != nil {
+		return err
+	}
+
+	if b.bundle != nil {
+		if err := b.extractBundle(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := b.fetch(ctx); err != nil {
+		return err
+	}
+
+	for i := range spec.Modules {
+		m := spec.Modules[i]
+		// shprov runs in fetching only when it is required
+		if spec.FetchOnly && !spec.FetchShprov {
+			m.Shprov = ""
+		}
+		err := b.runStage(ctx, StageProvide, m.Name, func(ctx context.Context, ev *Event) error {
+			if err := module3rd.Provide(ctx, &m, b.workDir, spec.Verbose); err != nil {
+				return stageError(ctx, StageProvide, m.Name, "", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if spec.FetchOnly {
+		if spec.FetchPatch {
+			if err := b.applyPatch(ctx); err != nil {
+				return err
+			}
+		}
+		if spec.Bundle != "" {
+			result.BundlePath, err = b.writeBundle(ctx)
+		}
+		return err
+	}
+
+	if spec.PatchDryRun {
+		return b.checkPatch(ctx)
+	}
+
+	if err := b.copyVariant(ctx); err != nil {
+		return err
+	}
+
+	if spec.Target != nil {
+		if err := cross.PrepareSource(b.srcDir); err != nil {
+			return err
+		}
+	}
+
+	if spec.Fixtures != "" {
+		b.fixtures, err = smoke.LoadFixtures(absPath(b.baseDir, spec.Fixtures))
+		if err != nil {
+			return err
+		}
+	}
+	// finds the test files before building as nginx-tests runs after it
+	if spec.NginxTests != "" && !spec.ConfigureOnly && b.nginx.BinaryPath() != "" {
+		if _, err := nginxtests.Files(absPath(b.baseDir, spec.NginxTests), spec.NginxTestsPattern); err != nil {
+			return err
+		}
+	}
+
+	configureScript, err := b.generateConfigure()
+	if err != nil {
+		return err
+	}
+	result.ConfigureScript = configureScript
+	result.Fingerprint = fingerprint(configureScript, spec.Modules, spec.Patch, spec.PatchOption)
+	b.emitArtifact("configure", filepath.Join(b.srcDir, "nginx-configure"))
+
+	if err := b.applyPatch(ctx); err != nil {
+		return err
+	}
+
+	// reverts source code with patch -R when the build failed or was interrupted.
+	defer func() {
+		if err != nil {
+			b.revertPatch()
+		}
+	}()
+
+	logger.Printf("Configure %s.....", b.nginx.SourcePath())
+
+	var configureEnv []string
+	if spec.Target != nil {
+		configureEnv = spec.Target.ConfigureEnv()
+	}
+
+	err = b.runStage(ctx, StageConfigure, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+		ev.LogPath = b.logPath(b.srcDir, "nginx-configure.log")
+		if err := configure.Run(ctx, b.srcDir, spec.Verbose, configureEnv); err != nil {
+			logger.Printf("Failed to configure %s\n", b.nginx.SourcePath())
+			return stageError(ctx, StageConfigure, b.nginx.SourcePath(), ev.LogPath, err)
+		}
+		// OpenSSL is configured for the target by the Makefile
+		if spec.Target != nil && spec.OpenSSL.Static {
+			if err := spec.Target.RewriteMakefile(filepath.Join(b.srcDir, "objs", "Makefile")); err != nil {
+				return stageError(ctx, StageConfigure, b.nginx.SourcePath(), ev.LogPath, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if spec.SBOMSPDX != "" || spec.SBOMCycloneDX != "" {
+		err := b.runStage(ctx, StageSBOM, "", func(ctx context.Context, ev *Event) error {
+			doc, err := makeSBOM(b.workDir, b.archiveDir(), b.baseDir, &b.nginx, b.libraries, spec.Modules, spec.Patch)
+			if err != nil {
+				return fmt.Errorf("Failed to make SBOM: %w", err)
+			}
+			return writeSBOM(&doc, absPath(b.baseDir, spec.SBOMSPDX), absPath(b.baseDir, spec.SBOMCycloneDX))
+		})
+		if err != nil {
+			return err
+		}
+		b.emitArtifact("sbom-spdx", absPath(b.baseDir, spec.SBOMSPDX))
+		b.emitArtifact("sbom-cyclonedx", absPath(b.baseDir, spec.SBOMCycloneDX))
+	}
+
+	if spec.ConfigureOnly {
+		if spec.NginxTests != "" {
+			b.skipNginxTests("configuring only")
+		}
+		return b.revertPatch()
+	}
+
+	logger.Printf("Build %s.....", b.nginx.SourcePath())
+
+	var env []string
+	if spec.Target != nil {
+		env = append(env, spec.Target.Env()...)
+	} else if spec.OpenSSL.Static {
+		// Sometimes machine hardware name('uname -m') is different
+		// from machine processor architecture name('uname -p') on Mac.
+		// Specifically, `uname -p` is 'i386' and `uname -m` is 'x86_64'.
+		// In this case, a build of OpenSSL fails.
+		// So it needs to convince OpenSSL with KERNEL_BITS.
+		if runtime.GOOS == "darwin" && runtime.GOARCH == "amd64" {
+			env = append(env, "KERNEL_BITS=64")
+		}
+	}
+
+	err = b.runStage(ctx, StageBuild, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+		ev.LogPath = b.logPath(b.srcDir, "nginx-build.log")
+		if err := builder.BuildNginx(ctx, b.srcDir, spec.Jobs, spec.Verbose, env); err != nil {
+			logger.Printf("Failed to build %s\n", b.nginx.SourcePath())
+			return stageError(ctx, StageBuild, b.nginx.SourcePath(), ev.LogPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if b.nginx.BinaryPath() != "" {
+		result.BinaryPath = filepath.Join(b.srcDir, b.nginx.BinaryPath())
+		if spec.Target != nil {
+			if err := spec.Target.CheckBinary(result.BinaryPath); err != nil {
+				return &StageError{Stage: StageBuild, Component: b.nginx.SourcePath(), Err: err}
+			}
+		}
+		b.emitArtifact("binary", result.BinaryPath)
+
+		if b.testing() {
+			objsDir := filepath.Dir(result.BinaryPath)
+			modules, err := smoke.Modules(objsDir)
+			if err != nil {
+				return err
+			}
+			test := smoke.Test{BinaryPath: result.BinaryPath, Modules: modules, ModulesDir: objsDir}
+			if err := b.test(ctx, result, test); err != nil {
+				return err
+			}
+		}
+		if spec.NginxTests != "" {
+			if err := b.runNginxTests(ctx, result); err != nil {
+				return err
+			}
+		}
+	} else if spec.NginxTests != "" {
+		b.skipNginxTests(fmt.Sprintf("%s is not built by nginx-build", b.nginx.FlavorName()))
+	}
+
+	if b.installing() {
+		if err := b.install(ctx, result); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revertPatch reverts the patches once. It runs even after ctx of the build is done.
+func (b *build) revertPatch() error {
+	b.revertOnce.Do(func() {
+		for _, state := range b.patchStates {
+			if err := state.revert(context.Background(), &b.spec); err != nil && b.revertErr == nil {
+				b.revertErr = err
+			}
+		}
+	})
+	return b.revertErr
+}
+
+// applyPatch applies the patches to the source code of nginx, the modules and the static libraries.
+// The patches are recorded in the source directories before applying them, so that the next build reverts them.
+func (b *build) applyPatch(ctx context.Context) error {
+	spec := &b.spec
+	if spec.Patch == "" {
+		return nil
+	}
+	return b.runStage(ctx, StagePatch, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+		files, err := b.patchFiles()
+		if err != nil {
+			return stageError(ctx, StagePatch, b.nginx.SourcePath(), "", err)
+		}
+		dirs, groups, err := b.groupPatches(files)
+		if err != nil {
+			return stageError(ctx, StagePatch, b.nginx.SourcePath(), "", err)
+		}
+		// the states are removed as nothing is changed when they are not recorded or a patch does not apply
+		clearStates := func(dirs []string) {
+			for _, dir := range dirs {
+				os.RemoveAll(filepath.Join(dir, PatchStateDir))
+			}
+		}
+		var states []*PatchState
+		for i, dir := range dirs {
+			state, err := beginPatchState(dir, groups[dir])
+			if err != nil {
+				clearStates(dirs[:i+1])
+				return stageError(ctx, StagePatch, b.nginx.SourcePath(), "", err)
+			}
+			states = append(states, state)
+		}
+		changed, err := util.Patch(ctx, files, b.workDir, b.patchTargets(b.srcDir), false, false, spec.logger())
+		if err != nil {
+			clearStates(dirs)
+			return stageError(ctx, StagePatch, b.nginx.SourcePath(), "", err)
+		}
+		for _, state := range states {
+			if err := state.finish(changed); err != nil {
+				return err
+			}
+		}
+		b.patches = files
+		b.patchStates = states
+		return nil
+	})
+}
+
+// checkPatch checks the patches apply to the source code extracted without changing it.
+func (b *build) checkPatch(ctx context.Context) error {
+	spec := &b.spec
+	return b.runStage(ctx, StagePatch, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+		files, err := b.patchFiles()
+		if err == nil {
+			_, err = util.Patch(ctx, files, b.workDir, b.patchTargets(b.pristineDir), false, true, spec.logger())
+		}
+		if err != nil {
+			return stageError(ctx, StagePatch, b.nginx.SourcePath(), "", err)
+		}
+		return nil
+	})
+}
+
+func (b *build) prepareWorkDir() error {
+	if b.spec.Clear {
+		if err := util.ClearWorkDir(b.workDir); err != nil {
+			return err
+		}
+	} else if err := b.clearScopes(b.spec.ClearScopes); err != nil {
+		return err
+	}
+
+	if !util.FileExists(b.workDir) {
+		if err := os.MkdirAll(b.workDir, 0755); err != nil {
+			return fmt.Errorf("Failed to create working directory %s.", b.workDir)
+		}
+	}
+
+	// revert the patches applied by the previous build
+	return b.restorePatched()
+}
+
+// fetch downloads and extracts nginx, the static libraries and 3rd party modules in parallel.
+// The first failure cancels the others.
+func (b *build) fetch(parent context.Context) error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	logger := b.spec.logger()
+	builders := append([]*builder.Builder{&b.nginx}, b.libraries...)
+	for _, bb := range builders {
+		wg.Add(1)
+		go func(bb *builder.Builder) {
+			defer wg.Done()
+			if err := b.downloadAndExtract(ctx, parent, bb); err != nil {
+				setErr(err)
+			}
+		}(bb)
+	}
+
+	for _, m := range b.spec.Modules {
+		wg.Add(1)
+		go func(m module3rd.Module3rd) {
+			defer wg.Done()
+			if module3rd.Exists(m, b.workDir) {
+				logger.Printf("%s already exists.", m.Name)
+				return
+			}
+			if b.spec.Offline && m.Form != "local" {
+				setErr(&StageError{Stage: StageDownload, Component: m.Name, Err: errors.New("not downloaded offline")})
+				return
+			}
+			if m.Form != "local" {
+				if len(m.Rev) > 0 {
+					logger.Printf("Download %s-%s.....", m.Name, m.Rev)
+				} else {
+					logger.Printf("Download %s.....", m.Name)
+				}
+			}
+			err := b.runStage(ctx, StageDownload, m.Name, func(ctx context.Context, ev *Event) error {
+				ev.LogPath = b.logPath(b.workDir, module3rd.LogPath(m))
+				if err := module3rd.Download(ctx, m, b.workDir, b.spec.Verbose); err != nil {
+					return stageError(parent, StageDownload, m.Name, ev.LogPath, err)
+				}
+				return nil
+			})
+			if err != nil {
+				setErr(err)
+			}
+		}(m)
+	}
+
+	// wait until all downloading processes by goroutine finish
+	wg.Wait()
+
+	return firstErr
+}
+
+func (b *build) generateConfigure() (string, error) {
+	logger := b.spec.logger()
+	logger.Printf("Generate configure script for %s.....", b.nginx.SourcePath())
+
+	for _, l := range b.libraries {
+		if l.IsIncludeWithOption(configure.Normalize(b.spec.Configure)) {
+			logger.Println(l.WarnMsgWithLibrary())
+		}
+	}
+
+	configureScript := b.configureScript()
+	if err := os.WriteFile(filepath.Join(b.srcDir, "nginx-configure"), []byte(configureScript), 0655); err != nil {
+		return "", fmt.Errorf("Failed to generate configure script for %s", b.nginx.SourcePath())
+	}
+	return configureScript, nil
+}
+
+// configureScript returns the configure script of the build.
+func (b *build) configureScript() string {
+	var dependencies []builder.StaticLibrary
+	for _, l := range b.libraries {
+		dependencies = append(dependencies, builder.MakeStaticLibrary(l))
+	}
+
+	openRestyOptions := b.spec.OpenResty
+	if b.nginx.Component == builder.ComponentOpenResty && openRestyOptions == nil {
+		openRestyOptions = &openresty.Options{}
+	}
+
+	script := configure.Generate(configure.Normalize(b.spec.Configure), b.spec.Modules, dependencies, b.spec.ConfigureOptions, b.baseDir, openRestyOptions, b.spec.Jobs)
+	if b.spec.Target != nil {
+		script += b.spec.Target.ConfigureArgs(b.spec.Pcre.Static)
+	}
+	return script
+}
+
+// arch returns GOARCH of nginx built, which is of the target in cross-compiling.
+// It is empty for the host.
+func (b *build) arch() string {
+	if b.spec.Target == nil {
+		return ""
+	}
+	return b.spec.Target.GOARCH()
+}
+
+// checkDestDir refuses the staging root given for a package or an OCI image when it is not empty,
+// as the files of the previous installations would be packaged.
+func (b *build) checkDestDir() error {
+	spec := &b.spec
+	if spec.DestDir == "" || (spec.Package == "" && spec.OCIPath == "") {
+		return nil
+	}
+	destDir := absPath(b.baseDir, spec.DestDir)
+	entries, err := os.ReadDir(destDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("staging root %s for packaging is not empty. Remove it or give an empty directory", destDir)
+	}
+	return nil
+}
+
+func (b *build) install(ctx context.Context, result *Result) error {
+	spec := &b.spec
+	logger := spec.logger()
+
+	destDir := absPath(b.baseDir, spec.DestDir)
+	if destDir == "" && (spec.Package != "" || spec.OCIPath != "") {
+		destDir = filepath.Join(b.outputDir(), "package-root")
+		if err := util.ClearWorkDir(destDir); err != nil {
+			return err
+		}
+	}
+
+	logger.Printf("Install %s.....", b.nginx.SourcePath())
+
+	var sbinPath string
+	err := b.runStage(ctx, StageInstall, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+		ev.LogPath = b.logPath(b.srcDir, "nginx-install.log")
+		if err := builder.InstallNginx(ctx, b.srcDir, destDir, spec.Verbose); err != nil {
+			logger.Printf("Failed to install %s\n", b.nginx.SourcePath())
+			return stageError(ctx, StageInstall, b.nginx.SourcePath(), ev.LogPath, err)
+		}
+
+		if spec.InstallModulesDir != "" {
+			modules, err := builder.InstallModules(b.srcDir, destDir, spec.InstallModulesDir)
+			if err != nil {
+				return err
+			}
+			for _, m := range modules {
+				logger.Printf("Installed dynamic module: %s", m)
+			}
+			result.InstalledModules = modules
+		}
+
+		var err error
+		_, sbinPath, err = builder.InstalledPaths(b.srcDir)
+		if err != nil {
+			return err
+		}
+		result.InstalledBinaryPath = filepath.Join(destDir, sbinPath)
+
+		// nginx cross-compiled does not run on the host
+		if spec.Target != nil {
+			return nil
+		}
+		out, err := exec.CommandContext(ctx, result.InstalledBinaryPath, "-V").CombinedOutput()
+		if err != nil {
+			return &StageError{Stage: StageInstall, Component: result.InstalledBinaryPath, Err: fmt.Errorf("failed to verify installed nginx: %w", err)}
+		}
+		result.InstalledVersionInfo = string(out)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	b.emitArtifact("installed", result.InstalledBinaryPath)
+
+	// nginx-build does not know where OpenResty builds nginx
+	if b.testing() && result.BinaryPath == "" {
+		test := smoke.Test{
+			BinaryPath: result.InstalledBinaryPath,
+			Modules:    result.InstalledModules,
+			ModulesDir: filepath.Join(destDir, spec.InstallModulesDir),
+		}
+		if err := b.test(ctx, result, test); err != nil {
+			return err
+		}
+	}
+
+	if spec.Package != "" {
+		logger.Printf("Package %s as %s.....", b.nginx.SourcePath(), spec.Package)
+
+		err := b.runStage(ctx, StagePackage, spec.Package, func(ctx context.Context, ev *Event) error {
+			meta := packaging.Metadata{
+				Version:     b.nginx.Version,
+				Fingerprint: result.Fingerprint,
+				Arch:        b.arch(),
+			}
+			var err error
+			result.PackagePath, err = packaging.Build(spec.Package, spec.PackageSpec, meta, destDir, b.workDir)
+			if err != nil {
+				return fmt.Errorf("Failed to build package: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		b.emitArtifact("package", result.PackagePath)
+	}
+
+	if spec.OCIPath != "" {
+		logger.Printf("Export OCI image of %s.....", b.nginx.SourcePath())
+
+		err := b.runStage(ctx, StageOCI, "", func(ctx context.Context, ev *Event) error {
+			image := container.Image{
+				Ref:        b.nginx.FlavorName() + ":" + b.nginx.Version,
+				BinaryPath: sbinPath,
+				BaseRootfs: absPath(b.baseDir, spec.OCIBase),
+				Arch:       b.arch(),
+			}
+			result.OCIPath = absPath(b.baseDir, spec.OCIPath)
+			if err := image.WriteLayout(destDir, result.OCIPath); err != nil {
+				return fmt.Errorf("Failed to export OCI image: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		b.emitArtifact("oci", result.OCIPath)
+	}
+
+	return nil
+}
+
+// test runs the smoke test and the fixtures against nginx.
+func (b *build) test(ctx context.Context, result *Result, test smoke.Test) error {
+	logger := b.spec.logger()
+
+	if b.spec.SmokeTest {
+		logger.Printf("Smoke test %s.....", test.BinaryPath)
+
+		err := b.runStage(ctx, StageSmoke, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+			report, err := test.Run(ctx)
+			result.SmokeTest = &report
+			if err != nil {
+				return stageError(ctx, StageSmoke, b.nginx.SourcePath(), "", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(b.fixtures) > 0 {
+		logger.Printf("Run %d fixtures against %s.....", len(b.fixtures), test.BinaryPath)
+
+		err := b.runStage(ctx, StageFixtures, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+			report, err := test.RunFixtures(ctx, b.fixtures)
+			result.Fixtures = &report
+			if err != nil {
+				return stageError(ctx, StageFixtures, b.nginx.SourcePath(), "", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runNginxTests runs nginx-tests against nginx built.
+func (b *build) runNginxTests(ctx context.Context, result *Result) error {
+	spec := &b.spec
+	logger := spec.logger()
+
+	logger.Printf("Run nginx-tests against %s.....", result.BinaryPath)
+
+	return b.runStage(ctx, StageNginxTests, b.nginx.SourcePath(), func(ctx context.Context, ev *Event) error {
+		suite := nginxtests.Suite{
+			Dir:        absPath(b.baseDir, spec.NginxTests),
+			BinaryPath: result.BinaryPath,
+			ModulesDir: filepath.Dir(result.BinaryPath),
+			Pattern:    spec.NginxTestsPattern,
+			Jobs:       spec.Jobs,
+			Log:        os.Stdout,
+		}
+		ev.LogPath = b.logPath(b.srcDir, "nginx-tests.log")
+		if ev.LogPath != "" {
+			f, err := os.Create(ev.LogPath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			suite.Log = f
+		}
+
+		summary, err := suite.Run(ctx)
+		if summary.Files != nil {
+			result.NginxTests = &summary
+			totals := summary.Totals()
+			ev.Tests = &totals
+		}
+		if err != nil {
+			return stageError(ctx, StageNginxTests, b.nginx.SourcePath(), ev.LogPath, err)
+		}
+		return nil
+	})
+}
+
+// skipNginxTests reports nginx-tests is skipped as nginx is not built.
+func (b *build) skipNginxTests(reason string) {
+	b.spec.logger().Printf("Skip nginx-tests: %s", reason)
+	b.emit(Event{Type: EventStageFinish, Stage: StageNginxTests, Component: b.nginx.SourcePath(), Skipped: reason})
+}
@@ -0,0 +1,546 @@
+package nginxbuild
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// BundleManifestName is the name of the manifest at the root of a bundle.
+const BundleManifestName = "nginx-build-bundle.json"
+
+// bundlePatchDir is the directory of the patches in the versioned working directory of a bundle.
+const bundlePatchDir = "nginx-build-patches"
+
+// BundleFile is a file in a bundle with its checksum.
+type BundleFile struct {
+	// Path is the slash-separated path relative to the root of the bundle
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	// Option and Target are the options and the component of a patch listed in a series file
+	Option string `json:"option,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// BundleManifest describes the sources fetched into a bundle.
+// The bundle is a tar.gz of the working directory such as nginx/1.28.0/... with the manifest at its root.
+type BundleManifest struct {
+	NginxBuildVersion string    `json:"nginx_build_version"`
+	Created           time.Time `json:"created"`
+	Flavor            string    `json:"flavor"`
+	Version           string    `json:"version"`
+	Pcre              Library   `json:"pcre"`
+	OpenSSL           Library   `json:"openssl"`
+	LibreSSL          Library   `json:"libressl"`
+	Zlib              Library   `json:"zlib"`
+	// Configure is the contents of the configure script given in fetching
+	Configure string `json:"configure,omitempty"`
+	// Modules is the 3rd party modules with their revisions resolved into the commits
+	Modules []module3rd.Module3rd `json:"modules,omitempty"`
+	// Patches is the patches in order of applying
+	Patches     []BundleFile `json:"patches,omitempty"`
+	PatchOption string       `json:"patch_option,omitempty"`
+	// Patched is true when the patches were applied in fetching
+	Patched bool `json:"patched"`
+	// Provisioned is true when shprov of the modules ran in fetching
+	Provisioned bool `json:"provisioned"`
+	// Archives is the archives of nginx and the static libraries
+	Archives []BundleFile `json:"archives"`
+	// Tree is the SHA-256 digest of the entries of the working directory such as the source trees and the modules
+	Tree string `json:"tree"`
+}
+
+// ReadBundleManifest reads the manifest of the bundle at path.
+func ReadBundleManifest(path string) (BundleManifest, error) {
+	var manifest BundleManifest
+
+	f, err := os.Open(path)
+	if err != nil {
+		return manifest, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return manifest, fmt.Errorf("%s is not a bundle: %w", path, err)
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return manifest, fmt.Errorf("%s is not a bundle: %s is not found", path, BundleManifestName)
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("%s is not a bundle: %w", path, err)
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != BundleManifestName {
+			continue
+		}
+		if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+			return manifest, fmt.Errorf("%s of %s is invalid: %w", BundleManifestName, path, err)
+		}
+		return manifest, nil
+	}
+}
+
+// applyBundle returns spec building the sources of the bundle without network access.
+// The configure script in the bundle is used when spec has none.
+func applyBundle(spec Spec, manifest BundleManifest) (Spec, error) {
+	if spec.FetchOnly {
+		return spec, errors.New("select one between fetching and building from a bundle")
+	}
+	if len(spec.Modules) > 0 || spec.Patch != "" {
+		return spec, errors.New("the modules and the patches of a bundle can not be changed")
+	}
+
+	spec.Flavor = manifest.Flavor
+	spec.Version = manifest.Version
+	spec.Pcre = manifest.Pcre
+	spec.OpenSSL = manifest.OpenSSL
+	spec.LibreSSL = manifest.LibreSSL
+	spec.Zlib = manifest.Zlib
+	if spec.Configure == "" {
+		spec.Configure = manifest.Configure
+	}
+	spec.Modules = append([]module3rd.Module3rd(nil), manifest.Modules...)
+	if manifest.Provisioned {
+		for i := range spec.Modules {
+			spec.Modules[i].Shprov = ""
+		}
+	}
+	spec.PatchOption = manifest.PatchOption
+	spec.DownloadCache = ""
+	spec.Offline = true
+	return spec, nil
+}
+
+// bundlePrefix returns the slash-separated path of the versioned working directory in a bundle.
+func (b *build) bundlePrefix() string {
+	return path.Join(b.nginx.FlavorName(), b.nginx.Version)
+}
+
+// bundleRoot returns the directory a bundle is extracted into.
+func (b *build) bundleRoot() string {
+	return absPath(b.baseDir, b.spec.WorkDir)
+}
+
+// bundlePatches returns the paths of the patches of the bundle extracted.
+func (b *build) bundlePatches() string {
+	if b.bundle.Patched {
+		return ""
+	}
+	var paths []string
+	for _, p := range b.bundle.Patches {
+		paths = append(paths, filepath.Join(b.bundleRoot(), filepath.FromSlash(p.Path)))
+	}
+	return strings.Join(paths, ",")
+}
+
+// bundlePatchFiles returns the patches of the bundle extracted with their options.
+// The patches without the options are applied with the option given in fetching.
+func (b *build) bundlePatchFiles() []util.PatchFile {
+	if b.bundle.Patched {
+		return nil
+	}
+	var files []util.PatchFile
+	for _, p := range b.bundle.Patches {
+		option := p.Option
+		if option == "" {
+			option = b.spec.PatchOption
+		}
+		files = append(files, util.PatchFile{
+			Path:   filepath.Join(b.bundleRoot(), filepath.FromSlash(p.Path)),
+			Option: option,
+			Target: p.Target,
+		})
+	}
+	return files
+}
+
+// extractBundle extracts the bundle into the working directory and verifies the checksums of the files in it.
+func (b *build) extractBundle(ctx context.Context) error {
+	spec := &b.spec
+	bundlePath := absPath(b.baseDir, spec.FromBundle)
+	name := filepath.Base(bundlePath)
+
+	spec.logger().Printf("Extract %s.....", name)
+
+	return b.runStage(ctx, StageExtract, name, func(ctx context.Context, ev *Event) error {
+		// the source trees and the modules are verified before extracting them
+		if err := verifyBundleTree(bundlePath, b.bundle); err != nil {
+			return stageError(ctx, StageExtract, name, "", err)
+		}
+		if err := extractArchive(ctx, b.bundleRoot(), bundlePath, spec.Verbose); err != nil {
+			return stageError(ctx, StageExtract, name, "", fmt.Errorf("Failed to extract %s. %w", name, err))
+		}
+		files := append(append([]BundleFile(nil), b.bundle.Archives...), b.bundle.Patches...)
+		for _, f := range files {
+			sum, err := util.FileSHA256(filepath.Join(b.bundleRoot(), filepath.FromSlash(f.Path)))
+			if err != nil {
+				return stageError(ctx, StageExtract, name, "", err)
+			}
+			if sum != f.SHA256 {
+				return stageError(ctx, StageExtract, name, "", fmt.Errorf("checksum of %s mismatched: %s (want: %s)", f.Path, sum, f.SHA256))
+			}
+		}
+		return nil
+	})
+}
+
+// treeDigest digests the entries of the working directory in a bundle, which are the ones other than the manifest, the archives and the patches.
+type treeDigest struct {
+	h hash.Hash
+}
+
+func newTreeDigest() *treeDigest {
+	return &treeDigest{h: sha256.New()}
+}
+
+// add adds the entry of hdr with its contents r.
+func (d *treeDigest) add(hdr *tar.Header, r io.Reader) error {
+	sum := sha256.New()
+	if hdr.Typeflag == tar.TypeReg {
+		if _, err := io.Copy(sum, r); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(d.h, "%s\x00%c\x00%o\x00%s\x00%x\n", strings.TrimSuffix(hdr.Name, "/"), hdr.Typeflag, hdr.Mode&0777, hdr.Linkname, sum.Sum(nil))
+	return nil
+}
+
+func (d *treeDigest) sum() string {
+	return hex.EncodeToString(d.h.Sum(nil))
+}
+
+// verifyBundleTree verifies the digest of the entries of the working directory in the bundle at path is the one in manifest.
+func verifyBundleTree(path string, manifest *BundleManifest) error {
+	skipped := map[string]bool{BundleManifestName: true}
+	for _, files := range [][]BundleFile{manifest.Archives, manifest.Patches} {
+		for _, f := range files {
+			skipped[f.Path] = true
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	d := newTreeDigest()
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if skipped[strings.TrimPrefix(hdr.Name, "./")] {
+			continue
+		}
+		if err := d.add(hdr, tr); err != nil {
+			return err
+		}
+	}
+	if sum := d.sum(); sum != manifest.Tree {
+		return fmt.Errorf("checksum of the source trees in %s mismatched: %s (want: %s)", filepath.Base(path), sum, manifest.Tree)
+	}
+	return nil
+}
+
+// bundleManifest makes the manifest of the bundle of the sources fetched.
+// The files maps the paths in the bundle to the files added besides the working directory.
+func (b *build) bundleManifest() (BundleManifest, map[string]string, error) {
+	spec := &b.spec
+	files := make(map[string]string)
+	manifest := BundleManifest{
+		NginxBuildVersion: Version(),
+		Created:           time.Now().UTC(),
+		Flavor:            b.nginx.FlavorName(),
+		Version:           b.nginx.Version,
+		Pcre:              spec.Pcre,
+		OpenSSL:           spec.OpenSSL,
+		LibreSSL:          spec.LibreSSL,
+		Zlib:              spec.Zlib,
+		Configure:         spec.Configure,
+		PatchOption:       spec.PatchOption,
+		Patched:           spec.FetchPatch && spec.Patch != "",
+		Provisioned:       spec.FetchShprov,
+	}
+
+	for _, m := range spec.Modules {
+		switch m.Form {
+		case "git", "hg":
+			rev, err := module3rd.ResolvedRev(m, b.workDir)
+			if err != nil {
+				return manifest, nil, fmt.Errorf("Failed to resolve the revision of %s: %w", m.Name, err)
+			}
+			m.Rev = rev
+		case "local":
+			dir := m.Url
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(b.workDir, dir)
+			}
+			if rel, err := filepath.Rel(b.workDir, dir); err != nil || strings.HasPrefix(rel, "..") {
+				return manifest, nil, fmt.Errorf("%s out of the working directory can not be bundled", m.Name)
+			}
+		}
+		manifest.Modules = append(manifest.Modules, m)
+	}
+
+	for _, bb := range append([]*builder.Builder{&b.nginx}, b.libraries...) {
+		src := filepath.Join(b.archiveDir(), bb.ArchivePath())
+		f, err := bundleFile(path.Join(b.bundlePrefix(), bb.ArchivePath()), src)
+		if err != nil {
+			return manifest, nil, err
+		}
+		manifest.Archives = append(manifest.Archives, f)
+		files[f.Path] = src
+	}
+
+	patches, err := util.PatchFiles(spec.Patch, spec.PatchOption, b.baseDir)
+	if err != nil {
+		return manifest, nil, err
+	}
+	for i, p := range patches {
+		name := path.Join(b.bundlePrefix(), bundlePatchDir, fmt.Sprintf("%02d-%s", i, filepath.Base(p.Path)))
+		f, err := bundleFile(name, p.Path)
+		if err != nil {
+			return manifest, nil, err
+		}
+		if p.Series != "" {
+			// the patches of a series are applied with -p1 without -p
+			if f.Option, err = p.ExplicitOption(); err != nil {
+				return manifest, nil, err
+			}
+			f.Target = p.Target
+		}
+		manifest.Patches = append(manifest.Patches, f)
+		files[f.Path] = p.Path
+	}
+
+	return manifest, files, nil
+}
+
+func bundleFile(name, src string) (BundleFile, error) {
+	sum, err := util.FileSHA256(src)
+	if err != nil {
+		return BundleFile{}, err
+	}
+	return BundleFile{Path: name, SHA256: sum}, nil
+}
+
+// writeBundle writes the working directory, the archives and the patches into a bundle.
+func (b *build) writeBundle(ctx context.Context) (string, error) {
+	spec := &b.spec
+	out := absPath(b.baseDir, spec.Bundle)
+
+	spec.logger().Printf("Write bundle %s.....", out)
+
+	err := b.runStage(ctx, StageBundle, "", func(ctx context.Context, ev *Event) error {
+		manifest, files, err := b.bundleManifest()
+		if err != nil {
+			return err
+		}
+		if err := writeBundleArchive(ctx, out, b.workDir, b.bundlePrefix(), &manifest, files); err != nil {
+			return stageError(ctx, StageBundle, "", "", fmt.Errorf("Failed to write bundle %s: %w", out, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	b.emitArtifact("bundle", out)
+	return out, nil
+}
+
+// bundleEntry is a file of the working directory in a bundle.
+type bundleEntry struct {
+	name string
+	src  string
+	info os.FileInfo
+}
+
+// writeBundleArchive writes the manifest, the files under workDir as prefix and files into a tar.gz at out.
+// The digest of the files under workDir is recorded in Tree of manifest.
+func writeBundleArchive(ctx context.Context, out, workDir, prefix string, manifest *BundleManifest, files map[string]string) error {
+	f, err := os.CreateTemp(filepath.Dir(out), filepath.Base(out)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpFileName := f.Name()
+	defer os.Remove(tmpFileName)
+	defer f.Close()
+
+	var entries []bundleEntry
+	err = filepath.Walk(workDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(workDir, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		// the bundle itself and the files added below are skipped
+		if p == out || strings.HasPrefix(p, tmpFileName) || files[name] != "" {
+			return nil
+		}
+		if info.IsDir() && (rel == bundlePatchDir || strings.Contains(rel, variantSeparator) || info.Name() == PatchStateDir) {
+			return filepath.SkipDir
+		}
+		entries = append(entries, bundleEntry{name: name, src: p, info: info})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	d := newTreeDigest()
+	for _, e := range entries {
+		if err := digestBundleEntry(d, e); err != nil {
+			return err
+		}
+	}
+	manifest.Tree = d.sum()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+
+	hdr := &tar.Header{Name: BundleManifestName, Mode: 0644, Size: int64(len(data) + 1), ModTime: manifest.Created, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := addBundleEntry(tw, e.name, e.src, e.info); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		src := files[name]
+		info, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if err := addBundleEntry(tw, name, src, info); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFileName, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, out)
+}
+
+// bundleHeader returns the header of the entry of src named name in a bundle.
+func bundleHeader(name, src string, info os.FileInfo) (*tar.Header, error) {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		link, err = os.Readlink(src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	return hdr, nil
+}
+
+func digestBundleEntry(d *treeDigest, e bundleEntry) error {
+	hdr, err := bundleHeader(e.name, e.src, e.info)
+	if err != nil {
+		return err
+	}
+	if !e.info.Mode().IsRegular() {
+		return d.add(hdr, nil)
+	}
+	f, err := os.Open(e.src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.add(hdr, f)
+}
+
+func addBundleEntry(tw *tar.Writer, name, src string, info os.FileInfo) error {
+	hdr, err := bundleHeader(name, src, info)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,34 @@
+package nginxbuild
+
+import (
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/doctor"
+)
+
+// DoctorTarget returns what the build of spec consists of for checking the host.
+func DoctorTarget(spec Spec) (doctor.Target, error) {
+	var target doctor.Target
+
+	b, err := newBuild(spec)
+	if err != nil {
+		return target, err
+	}
+
+	target.Configure = b.configureScript()
+	for _, m := range spec.Modules {
+		target.Modules = append(target.Modules, doctor.Module{Name: m.Name, Form: m.Form, Shprov: m.Shprov})
+	}
+	// the configure script of OpenResty is written in perl
+	target.PerlConfigure = b.nginx.Component == builder.ComponentOpenResty
+	target.StaticPcre = spec.Pcre.Static
+	target.StaticOpenSSL = spec.OpenSSL.Static
+	target.StaticLibreSSL = spec.LibreSSL.Static
+	target.StaticZlib = spec.Zlib.Static
+	target.NginxTests = spec.NginxTests != ""
+	if spec.Target != nil {
+		target.Cross = spec.Target.Triple
+		target.CrossTools = []string{spec.Target.Tool("ar"), spec.Target.Tool("ranlib")}
+	}
+
+	return target, nil
+}
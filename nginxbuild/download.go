@@ -0,0 +1,147 @@
+package nginxbuild
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/command"
+	"github.com/cubicdaiya/nginx-build/lock"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+const DefaultDownloadTimeout = time.Duration(900) * time.Second
+
+func extractArchive(ctx context.Context, workDir, path string, verbose bool) error {
+	return command.Run(ctx, workDir, verbose, []string{"tar", "zxvf", path})
+}
+
+// download downloads the archive of b into dir and returns its size.
+func download(ctx context.Context, b *builder.Builder, dir string) (int64, error) {
+	c := &http.Client{
+		Timeout: DefaultDownloadTimeout,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.DownloadURL(), nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to download %s. %s", b.DownloadURL(), res.Status)
+	}
+
+	archivePath := filepath.Join(dir, b.ArchivePath())
+	// the temporary file is unique as the download cache may be shared with the other processes
+	f, err := os.CreateTemp(dir, b.ArchivePath()+".*.download")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	tmpFileName := f.Name()
+
+	n, err := io.Copy(f, res.Body)
+	if err != nil && err != io.EOF {
+		os.Remove(tmpFileName)
+		return n, err
+	}
+	if err := os.Chmod(tmpFileName, 0644); err != nil {
+		os.Remove(tmpFileName)
+		return n, err
+	}
+
+	if err := os.Rename(tmpFileName, archivePath); err != nil {
+		os.Remove(tmpFileName)
+		return n, err
+	}
+
+	return n, nil
+}
+
+// archiveDir returns the directory of the archives downloaded.
+func (b *build) archiveDir() string {
+	if b.spec.DownloadCache != "" {
+		return absPath(b.baseDir, b.spec.DownloadCache)
+	}
+	return b.workDir
+}
+
+// downloadAndExtract downloads and extracts the archive of bb unless its source exists.
+// The errors are made with parent as ctx may be cancelled by the failure of another download.
+func (b *build) downloadAndExtract(ctx, parent context.Context, bb *builder.Builder) error {
+	logger := b.spec.logger()
+	if util.FileExists(filepath.Join(b.workDir, bb.SourcePath())) {
+		logger.Printf("%s already exists.", bb.SourcePath())
+		return nil
+	}
+
+	archiveDir := b.archiveDir()
+	if err := os.MkdirAll(archiveDir, 0755); err != nil {
+		return err
+	}
+	archivePath := filepath.Join(archiveDir, bb.ArchivePath())
+
+	if err := b.downloadArchive(ctx, parent, bb, archiveDir, archivePath); err != nil {
+		return err
+	}
+
+	logger.Printf("Extract %s.....", bb.ArchivePath())
+
+	return b.runStage(ctx, StageExtract, bb.SourcePath(), func(ctx context.Context, ev *Event) error {
+		if err := extractArchive(ctx, b.workDir, archivePath, b.spec.Verbose); err != nil {
+			// a half-extracted tree is not reused by the next build
+			os.RemoveAll(filepath.Join(b.workDir, bb.SourcePath()))
+			return stageError(parent, StageExtract, bb.SourcePath(), "", fmt.Errorf("Failed to extract %s. %w", bb.ArchivePath(), err))
+		}
+		return nil
+	})
+}
+
+// archiveLocks serializes downloading the same archive into a download cache shared among the builds in a process.
+var archiveLocks sync.Map
+
+// downloadArchive downloads the archive of bb into archiveDir unless it exists.
+func (b *build) downloadArchive(ctx, parent context.Context, bb *builder.Builder, archiveDir, archivePath string) error {
+	v, _ := archiveLocks.LoadOrStore(archivePath, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if util.FileExists(archivePath) {
+		return nil
+	}
+	if b.spec.Offline {
+		return &StageError{Stage: StageDownload, Component: bb.SourcePath(), Err: fmt.Errorf("%s is not downloaded offline", bb.ArchivePath())}
+	}
+
+	// the download cache may be shared with the other processes
+	l, err := lock.Acquire(ctx, archivePath+".lock", b.lockOptions(archivePath))
+	if err != nil {
+		return stageError(parent, StageDownload, bb.SourcePath(), "", err)
+	}
+	defer l.Release()
+	if util.FileExists(archivePath) {
+		return nil
+	}
+
+	b.spec.logger().Printf("Download %s.....", bb.SourcePath())
+
+	return b.runStage(ctx, StageDownload, bb.SourcePath(), func(ctx context.Context, ev *Event) error {
+		n, err := download(ctx, bb, archiveDir)
+		ev.Bytes = n
+		if err != nil {
+			return stageError(parent, StageDownload, bb.SourcePath(), "", fmt.Errorf("Failed to download %s. %w", bb.SourcePath(), err))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,146 @@
+package nginxbuild
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/command"
+	"github.com/cubicdaiya/nginx-build/nginxtests"
+)
+
+// The types of Event.
+const (
+	EventBuildStart  = "build_start"
+	EventBuildFinish = "build_finish"
+	EventStageStart  = "stage_start"
+	EventStageFinish = "stage_finish"
+	EventArtifact    = "artifact"
+)
+
+// The stages of a build.
+const (
+	StageLock       = "lock"
+	StageDownload   = "download"
+	StageExtract    = "extract"
+	StageVariant    = "variant"
+	StageProvide    = "provide"
+	StagePatch      = "patch"
+	StageConfigure  = "configure"
+	StageBuild      = "build"
+	StageInstall    = "install"
+	StageSBOM       = "sbom"
+	StagePackage    = "package"
+	StageOCI        = "oci"
+	StageSmoke      = "smoke"
+	StageFixtures   = "fixtures"
+	StageNginxTests = "nginx-tests"
+	StageBundle     = "bundle"
+)
+
+// Event is an event of a build such as the start and the finish of a stage.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Stage     string    `json:"stage,omitempty"`
+	Component string    `json:"component,omitempty"`
+	// Duration is the wall-clock seconds of a stage or a build
+	Duration float64 `json:"duration,omitempty"`
+	// CPU is the CPU seconds of the commands run in a stage
+	CPU float64 `json:"cpu,omitempty"`
+	// Bytes is the size of the archive downloaded
+	Bytes int64 `json:"bytes,omitempty"`
+	// ExitCode is the exit status of the failed command of a stage
+	ExitCode *int   `json:"exit_code,omitempty"`
+	LogPath  string `json:"log_path,omitempty"`
+	// Artifact is the kind of an artifact (binary, configure, installed, package, oci, sbom-spdx, sbom-cyclonedx)
+	Artifact string `json:"artifact,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Skipped is the reason of skipping a stage
+	Skipped string `json:"skipped,omitempty"`
+	// Tests is the summary of the tests run in a stage
+	Tests *nginxtests.Totals `json:"tests,omitempty"`
+
+	// set in build_start
+	Flavor  string `json:"flavor,omitempty"`
+	Version string `json:"version,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	WorkDir string `json:"work_dir,omitempty"`
+}
+
+// JSONEvents returns an event handler writing events into w as newline-delimited JSON.
+func JSONEvents(w io.Writer) func(Event) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(ev)
+	}
+}
+
+// emit sends ev to the event handler of the spec. Events are never sent concurrently.
+func (b *build) emit(ev Event) {
+	if b.spec.Events == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	b.eventMu.Lock()
+	defer b.eventMu.Unlock()
+	b.spec.Events(ev)
+}
+
+func (b *build) emitArtifact(kind, path string) {
+	if path == "" {
+		return
+	}
+	b.recordMu.Lock()
+	b.artifacts[kind] = path
+	b.recordMu.Unlock()
+	b.emit(Event{Type: EventArtifact, Artifact: kind, Path: path})
+}
+
+// runStage runs f as a stage of the build, records its timing and emits its start and finish.
+// f runs with ctx recording the CPU time of the commands and may fill the finish event such as Bytes and LogPath.
+func (b *build) runStage(ctx context.Context, stage, component string, f func(ctx context.Context, ev *Event) error) error {
+	b.emit(Event{Type: EventStageStart, Stage: stage, Component: component})
+
+	var usage command.Usage
+	ev := Event{Type: EventStageFinish, Stage: stage, Component: component}
+	start := time.Now()
+	err := f(command.WithUsage(ctx, &usage), &ev)
+	timing := Timing{
+		Stage:     stage,
+		Component: component,
+		Start:     start,
+		Wall:      time.Since(start),
+		CPU:       usage.CPU(),
+	}
+	b.recordMu.Lock()
+	b.timings = append(b.timings, timing)
+	b.recordMu.Unlock()
+
+	ev.Duration = timing.Wall.Seconds()
+	ev.CPU = timing.CPU.Seconds()
+	if err != nil {
+		ev.Error = err.Error()
+		var stageErr *StageError
+		if errors.As(err, &stageErr) && stageErr.LogPath != "" {
+			ev.LogPath = stageErr.LogPath
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code := exitErr.ExitCode()
+			ev.ExitCode = &code
+		}
+	}
+	b.emit(ev)
+	return err
+}
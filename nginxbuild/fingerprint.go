@@ -0,0 +1,15 @@
+package nginxbuild
+
+import (
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/packaging"
+)
+
+// fingerprint identifies a build with the configure script, 3rd party modules and patches.
+func fingerprint(configureScript string, modules3rd []module3rd.Module3rd, patchPath, patchOption string) string {
+	inputs := []string{configureScript, patchPath, patchOption}
+	for _, m := range modules3rd {
+		inputs = append(inputs, m.Name, m.Url, m.Rev)
+	}
+	return packaging.Fingerprint(inputs...)
+}
@@ -0,0 +1,284 @@
+package nginxbuild
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/lock"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// The scopes of clearing a working directory.
+const (
+	ClearNginx     = "nginx"
+	ClearModules   = "modules"
+	ClearLibraries = "libraries"
+)
+
+// ClearScopes lists the scopes of clearing a working directory.
+var ClearScopes = []string{ClearNginx, ClearModules, ClearLibraries}
+
+// CacheFlavor is the flavor of the archives in the download cache of a working root.
+const CacheFlavor = "cache"
+
+// WorkEntry is a versioned working directory in a working root or an archive in its download cache.
+type WorkEntry struct {
+	Flavor  string `json:"flavor"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	// LastUsed is the latest of the modification times of the directory, its build manifest and its lock
+	LastUsed time.Time `json:"last_used"`
+	// Built is true when the binary of nginx was built
+	Built bool `json:"built"`
+}
+
+// GCPolicy is the policy of removing the entries of a working root.
+// The zero values are unlimited.
+type GCPolicy struct {
+	// MaxAge removes the entries not used for it
+	MaxAge time.Duration
+	// Keep removes the entries except the Keep most recently used ones of each flavor
+	Keep int
+	// MaxSize removes the least recently used entries until the total size is within it
+	MaxSize int64
+}
+
+// ListWorkEntries lists the versioned working directories and the archives in the download cache of root
+// in order of the flavors and the versions.
+func ListWorkEntries(root string) ([]WorkEntry, error) {
+	var entries []WorkEntry
+	for _, flavor := range append(append([]string(nil), builder.Flavors...), CacheFlavor) {
+		dir := filepath.Join(root, flavor)
+		names, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, d := range names {
+			if flavor == CacheFlavor {
+				if !d.Type().IsRegular() || !strings.HasSuffix(d.Name(), ".tar.gz") {
+					continue
+				}
+			} else if !d.IsDir() {
+				continue
+			}
+			e, err := workEntry(flavor, d.Name(), filepath.Join(dir, d.Name()))
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func workEntry(flavor, version, path string) (WorkEntry, error) {
+	e := WorkEntry{Flavor: flavor, Version: version, Path: path}
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e.Size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return e, err
+	}
+
+	for _, p := range []string{path, filepath.Join(path, ManifestName), path + ".lock"} {
+		if info, err := os.Stat(p); err == nil && info.ModTime().After(e.LastUsed) {
+			e.LastUsed = info.ModTime()
+		}
+	}
+
+	if flavor != CacheFlavor {
+		component, err := builder.FlavorComponent(flavor)
+		if err != nil {
+			return e, err
+		}
+		b := builder.MakeBuilder(component, version)
+		if b.BinaryPath() != "" {
+			e.Built = util.FileExists(filepath.Join(path, b.SourcePath(), b.BinaryPath()))
+		} else if manifest, err := LoadManifest(path); err == nil {
+			e.Built = manifest.Artifacts["installed"] != ""
+		}
+	}
+	return e, nil
+}
+
+// SelectGC returns the entries removed by policy at now in order of the least recently used.
+func SelectGC(entries []WorkEntry, policy GCPolicy, now time.Time) []WorkEntry {
+	sorted := append([]WorkEntry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastUsed.After(sorted[j].LastUsed)
+	})
+
+	var kept, removed []WorkEntry
+	counts := make(map[string]int)
+	var total int64
+	for _, e := range sorted {
+		if policy.MaxAge > 0 && now.Sub(e.LastUsed) > policy.MaxAge {
+			removed = append(removed, e)
+			continue
+		}
+		if policy.Keep > 0 && counts[e.Flavor] >= policy.Keep {
+			removed = append(removed, e)
+			continue
+		}
+		counts[e.Flavor]++
+		total += e.Size
+		kept = append(kept, e)
+	}
+	for policy.MaxSize > 0 && total > policy.MaxSize && len(kept) > 0 {
+		e := kept[len(kept)-1]
+		kept = kept[:len(kept)-1]
+		total -= e.Size
+		removed = append(removed, e)
+	}
+
+	sort.SliceStable(removed, func(i, j int) bool {
+		return removed[i].LastUsed.Before(removed[j].LastUsed)
+	})
+	return removed
+}
+
+// RemoveWorkEntry removes the entry holding its lock.
+// It does not wait for the build using the entry and returns *lock.HeldError.
+func RemoveWorkEntry(ctx context.Context, e WorkEntry) error {
+	l, err := lock.Acquire(ctx, e.Path+".lock", lock.Options{Timeout: time.Millisecond})
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return util.ClearWorkDir(e.Path)
+}
+
+// validateClearScopes checks the scopes of clearing a working directory.
+func validateClearScopes(scopes []string) error {
+	for _, scope := range scopes {
+		found := false
+		for _, s := range ClearScopes {
+			if scope == s {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("clear scope %s is not supported (one of %s)", scope, strings.Join(ClearScopes, ", "))
+		}
+	}
+	return nil
+}
+
+// clearScopes removes the parts of the versioned working directory selected by the scopes.
+func (b *build) clearScopes(scopes []string) error {
+	var paths []string
+	for _, scope := range scopes {
+		switch scope {
+		case ClearNginx:
+			paths = append(paths, b.pristineDir, b.srcDir)
+		case ClearModules:
+			for _, m := range b.spec.Modules {
+				if m.Form == "local" {
+					continue
+				}
+				// the module such as njs/nginx is in the repository cloned
+				paths = append(paths, filepath.Join(b.workDir, strings.SplitN(m.Name, "/", 2)[0]))
+			}
+		case ClearLibraries:
+			for _, l := range b.libraries {
+				paths = append(paths, filepath.Join(b.workDir, l.SourcePath()))
+			}
+		}
+	}
+	for _, p := range paths {
+		if err := util.ClearWorkDir(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizeUnits is the units of the sizes in order of ParseSize and FormatSize.
+var sizeUnits = []string{"K", "M", "G", "T"}
+
+// ParseSize parses a size such as 512M and 10G in bytes (1K = 1024).
+func ParseSize(s string) (int64, error) {
+	n := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	var scale int64 = 1
+	for i, u := range sizeUnits {
+		if strings.HasSuffix(n, u) {
+			n = strings.TrimSuffix(n, u)
+			scale = 1 << (10 * (i + 1))
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(n, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("size %s is invalid", s)
+	}
+	return int64(size * float64(scale)), nil
+}
+
+// FormatSize formats the size in bytes such as 1.5G.
+func FormatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+	value := float64(size)
+	unit := ""
+	for _, u := range sizeUnits {
+		if value < 1024 {
+			break
+		}
+		value /= 1024
+		unit = u
+	}
+	return fmt.Sprintf("%.1f%s", value, unit)
+}
+
+// WriteWorkTable writes the entries of a working root with the entries removed in a table.
+func WriteWorkTable(w io.Writer, entries, removed []WorkEntry) error {
+	removing := make(map[string]bool, len(removed))
+	for _, e := range removed {
+		removing[e.Path] = true
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "flavor\tversion\tsize\tlast used\tbuilt\t")
+	var total int64
+	for _, e := range entries {
+		built := "no"
+		if e.Built {
+			built = "yes"
+		}
+		if e.Flavor == CacheFlavor {
+			built = "-"
+		}
+		action := ""
+		if removing[e.Path] {
+			action = "remove"
+		}
+		total += e.Size
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Flavor, e.Version, FormatSize(e.Size), e.LastUsed.Local().Format("2006-01-02 15:04"), built, action)
+	}
+	fmt.Fprintf(tw, "(total)\t\t%s\t\t\t\n", FormatSize(total))
+	return tw.Flush()
+}
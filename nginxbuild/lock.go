@@ -0,0 +1,74 @@
+package nginxbuild
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/cubicdaiya/nginx-build/lock"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// lockOptions returns the options of the lock of name reporting the waiting and the stale lock into the logger.
+func (b *build) lockOptions(name string) lock.Options {
+	logger := b.spec.logger()
+	return lock.Options{
+		Timeout: b.spec.LockTimeout,
+		Wait: func(h lock.Holder) {
+			logger.Printf("Wait for %s locked by %s.....", name, h)
+		},
+		Stale: func(h lock.Holder) {
+			logger.Printf("[notice] Remove the stale lock of %s held by %s", name, h)
+		},
+	}
+}
+
+// workDirLockPath returns the path of the lock file of the versioned working directory.
+// It is out of the working directory as clearing it keeps the lock.
+func (b *build) workDirLockPath() string {
+	return b.workDir + ".lock"
+}
+
+// lockWorkDir locks the versioned working directory against the other builds sharing it.
+func (b *build) lockWorkDir(ctx context.Context) error {
+	return b.runStage(ctx, StageLock, "", func(ctx context.Context, ev *Event) error {
+		l, err := lock.Acquire(ctx, b.workDirLockPath(), b.lockOptions(b.workDir))
+		if err != nil {
+			return stageError(ctx, StageLock, "", "", err)
+		}
+		b.workDirLock = l
+		return nil
+	})
+}
+
+// Clean removes the versioned working directory of spec holding its lock and returns its path.
+// Only the parts of the directory are removed with ClearScopes and the source directory of Variant with it.
+// It returns os.ErrNotExist when the directory does not exist.
+func Clean(ctx context.Context, spec Spec) (string, error) {
+	b, err := newBuild(spec)
+	if err != nil {
+		return "", err
+	}
+	if !util.FileExists(b.workDir) {
+		return b.workDir, os.ErrNotExist
+	}
+	if spec.Variant != "" && len(spec.ClearScopes) == 0 && !util.FileExists(b.srcDir) {
+		return b.srcDir, os.ErrNotExist
+	}
+	l, err := lock.Acquire(ctx, b.workDirLockPath(), b.lockOptions(b.workDir))
+	if err != nil {
+		return b.workDir, err
+	}
+	dir := b.workDir
+	switch {
+	case len(spec.ClearScopes) > 0:
+		err = b.clearScopes(spec.ClearScopes)
+	case spec.Variant != "":
+		// removes the variant only
+		dir = b.srcDir
+		err = util.ClearWorkDir(dir)
+	default:
+		err = util.ClearWorkDir(dir)
+	}
+	return dir, errors.Join(err, l.Release())
+}
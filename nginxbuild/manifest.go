@@ -0,0 +1,71 @@
+package nginxbuild
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// ManifestName is the name of the build manifest written into the working directory.
+const ManifestName = "nginx-build-manifest.json"
+
+// Manifest is the record of a build: what was built, the artifacts and the time spent in each stage.
+type Manifest struct {
+	NginxBuildVersion string    `json:"nginx_build_version"`
+	Flavor            string    `json:"flavor"`
+	Version           string    `json:"version"`
+	Variant           string    `json:"variant,omitempty"`
+	Target            string    `json:"target,omitempty"`
+	Fingerprint       string    `json:"fingerprint,omitempty"`
+	Created           time.Time `json:"created"`
+	// Artifacts maps the kinds of the artifacts to their paths
+	Artifacts map[string]string `json:"artifacts"`
+	// Patches is the patches applied in order with their options and targets
+	Patches []util.PatchFile `json:"patches,omitempty"`
+	Timings []Timing         `json:"timings"`
+}
+
+// LoadManifest loads the build manifest in the versioned working directory
+// or the source directory of a variant.
+func LoadManifest(workDir string) (Manifest, error) {
+	var manifest Manifest
+	data, err := os.ReadFile(filepath.Join(workDir, ManifestName))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+func (b *build) writeManifest(result *Result) (string, error) {
+	b.recordMu.Lock()
+	manifest := Manifest{
+		NginxBuildVersion: Version(),
+		Flavor:            b.nginx.FlavorName(),
+		Version:           b.nginx.Version,
+		Variant:           b.spec.Variant,
+		Target:            b.spec.targetTriple(),
+		Fingerprint:       result.Fingerprint,
+		Created:           time.Now().UTC(),
+		Artifacts:         make(map[string]string, len(b.artifacts)),
+		Patches:           b.patches,
+		Timings:           sortTimings(b.timings),
+	}
+	for k, v := range b.artifacts {
+		manifest.Artifacts[k] = v
+	}
+	b.recordMu.Unlock()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(b.outputDir(), ManifestName)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,249 @@
+package nginxbuild
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+)
+
+// The statuses of the stages in a build matrix.
+const (
+	StatusPassed = "passed"
+	StatusFailed = "failed"
+	StatusNotRun = "not-run"
+)
+
+// TLSSystem is the TLS library of the system, which is not built statically.
+const TLSSystem = "system"
+
+// Combination is a combination of the flavor, the version and the TLS library in a build matrix.
+type Combination struct {
+	Flavor  string `json:"flavor"`
+	Version string `json:"version"`
+	// TLS is the TLS library such as system, openssl, openssl-3.5.0 and libressl-4.1.0.
+	// openssl and libressl without the version are their default versions.
+	TLS string `json:"tls"`
+}
+
+func (c Combination) String() string {
+	return fmt.Sprintf("%s-%s %s", c.Flavor, c.Version, c.TLS)
+}
+
+// Combinations returns all the combinations of the flavors, the versions and the TLS libraries.
+// versions maps a flavor to its versions.
+func Combinations(flavors []string, versions map[string][]string, tls []string) []Combination {
+	if len(tls) == 0 {
+		tls = []string{TLSSystem}
+	}
+	var combinations []Combination
+	for _, f := range flavors {
+		for _, v := range versions[f] {
+			for _, t := range tls {
+				combinations = append(combinations, Combination{Flavor: f, Version: v, TLS: t})
+			}
+		}
+	}
+	return combinations
+}
+
+// ParseTLS parses the TLS library of a combination into the libraries built statically.
+func ParseTLS(tls string) (openSSL, libreSSL Library, err error) {
+	name, version, _ := strings.Cut(tls, "-")
+	switch name {
+	case TLSSystem:
+		if version != "" {
+			return openSSL, libreSSL, fmt.Errorf("the version of the TLS library of the system is not selectable: %s", tls)
+		}
+	case "openssl":
+		openSSL = Library{Static: true, Version: version}
+	case "libressl":
+		libreSSL = Library{Static: true, Version: version}
+	default:
+		return openSSL, libreSSL, fmt.Errorf("unknown TLS library %s (one of system, openssl[-version], libressl[-version])", tls)
+	}
+	return openSSL, libreSSL, nil
+}
+
+// Matrix is a build matrix running the builds of the combinations in parallel.
+type Matrix struct {
+	// Spec is the base of the builds.
+	// Each combination is built in the working directory <Spec.WorkDir>/<flavor>/<version> as a single build
+	// and its TLS library other than the system's is built as a variant such as openssl-3.5.0.
+	// The archives are shared in Spec.DownloadCache (<Spec.WorkDir>/cache by default).
+	Spec         Spec
+	Combinations []Combination
+	// Parallel is the number of the builds running at once
+	Parallel int
+}
+
+// MatrixResult is the result of a combination in a build matrix.
+type MatrixResult struct {
+	Combination
+	WorkDir   string `json:"work_dir"`
+	Configure string `json:"configure"`
+	Build     string `json:"build"`
+	Smoke     string `json:"smoke"`
+	// Duration is the wall-clock seconds of the build
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+	LogPath  string  `json:"log_path,omitempty"`
+	// Result is the result of the build
+	Result Result `json:"-"`
+}
+
+// Passed reports whether the build of the combination passed.
+func (r *MatrixResult) Passed() bool {
+	return r.Error == ""
+}
+
+// stageStatus returns the status of stage from the stages run and the stage failed.
+func stageStatus(timings []Timing, failed, stage string) string {
+	if failed == stage {
+		return StatusFailed
+	}
+	for _, t := range timings {
+		if t.Stage == stage {
+			return StatusPassed
+		}
+	}
+	return StatusNotRun
+}
+
+// spec returns the spec of the build of c.
+func (m *Matrix) spec(c Combination) (Spec, error) {
+	spec := m.Spec
+	spec.Flavor = c.Flavor
+	spec.Version = c.Version
+
+	var err error
+	spec.OpenSSL, spec.LibreSSL, err = ParseTLS(c.TLS)
+	if err != nil {
+		return spec, err
+	}
+	if spec.OpenSSL.Version == "" {
+		spec.OpenSSL.Version = builder.OpenSSLVersion
+	}
+	if spec.LibreSSL.Version == "" {
+		spec.LibreSSL.Version = builder.LibreSSLVersion
+	}
+
+	component, err := builder.FlavorComponent(c.Flavor)
+	if err != nil {
+		return spec, err
+	}
+	if component != builder.ComponentOpenResty {
+		spec.OpenResty = nil
+	}
+
+	// the TLS libraries of a version are built as its variants in <Spec.WorkDir>/<flavor>/<version>
+	if c.TLS != TLSSystem {
+		tls := c.TLS
+		if !strings.Contains(tls, "-") {
+			if spec.OpenSSL.Static {
+				tls += "-" + spec.OpenSSL.Version
+			} else {
+				tls += "-" + spec.LibreSSL.Version
+			}
+		}
+		if spec.Variant != "" {
+			spec.Variant += "-" + tls
+		} else {
+			spec.Variant = tls
+		}
+	}
+	if spec.DownloadCache == "" {
+		spec.DownloadCache = filepath.Join(m.Spec.WorkDir, "cache")
+	}
+
+	logger := m.Spec.logger()
+	spec.Logger = log.New(logger.Writer(), logger.Prefix()+"["+c.String()+"] ", logger.Flags())
+	return spec, nil
+}
+
+// RunMatrix builds the combinations of the matrix in parallel.
+// The results are in the order of the combinations and all the combinations are built even if some of them fail.
+// The returned error is not nil when the combinations are invalid or ctx is done.
+func RunMatrix(ctx context.Context, m Matrix) ([]MatrixResult, error) {
+	if len(m.Combinations) == 0 {
+		return nil, errors.New("no combinations in the build matrix")
+	}
+	specs := make([]Spec, len(m.Combinations))
+	for i, c := range m.Combinations {
+		var err error
+		specs[i], err = m.spec(c)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c, err)
+		}
+	}
+
+	parallel := m.Parallel
+	if parallel <= 0 {
+		parallel = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, parallel)
+		results = make([]MatrixResult, len(m.Combinations))
+	)
+	for i, c := range m.Combinations {
+		results[i] = MatrixResult{
+			Combination: c,
+			Configure:   StatusNotRun,
+			Build:       StatusNotRun,
+			Smoke:       StatusNotRun,
+		}
+		wg.Add(1)
+		go func(r *MatrixResult, spec Spec) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				r.Error = ctx.Err().Error()
+				return
+			}
+			defer func() { <-sem }()
+
+			start := time.Now()
+			result, err := Build(ctx, spec)
+			r.Duration = time.Since(start).Seconds()
+			r.Result = result
+			r.WorkDir = result.WorkDir
+
+			var failed string
+			if err != nil {
+				r.Error = err.Error()
+				var stageErr *StageError
+				if errors.As(err, &stageErr) {
+					failed = stageErr.Stage
+					r.LogPath = stageErr.LogPath
+				}
+			}
+			r.Configure = stageStatus(result.Timings, failed, StageConfigure)
+			r.Build = stageStatus(result.Timings, failed, StageBuild)
+			r.Smoke = stageStatus(result.Timings, failed, StageSmoke)
+		}(&results[i], specs[i])
+	}
+	wg.Wait()
+
+	return results, ctx.Err()
+}
+
+// WriteMatrixTable writes the results of a build matrix into w as a table.
+func WriteMatrixTable(w io.Writer, results []MatrixResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "flavor\tversion\ttls\tconfigure\tbuild\tsmoke\twall")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%.2fs\n", r.Flavor, r.Version, r.TLS, r.Configure, r.Build, r.Smoke, r.Duration)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,1064 @@
+package nginxbuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/cross"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/openresty"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+func TestNewBuild(t *testing.T) {
+	tests := []struct {
+		spec       Spec
+		workDir    string
+		srcDir     string
+		libraries  int
+		arch       string
+		shouldFail bool
+	}{
+		{
+			spec:    Spec{WorkDir: "work", BaseDir: "/tmp"},
+			workDir: "/tmp/work/nginx/" + builder.NginxVersion,
+			srcDir:  "/tmp/work/nginx/" + builder.NginxVersion + "/nginx-" + builder.NginxVersion,
+		},
+		{
+			spec:      Spec{WorkDir: "/work", BaseDir: "/tmp", Flavor: "openresty", Version: "1.27.1.2", Pcre: Library{Static: true}, Zlib: Library{Static: true}},
+			workDir:   "/work/openresty/1.27.1.2",
+			srcDir:    "/work/openresty/1.27.1.2/openresty-1.27.1.2",
+			libraries: 2,
+		},
+		{
+			spec:    Spec{WorkDir: "/work", Version: "1.28.0", Variant: "debug"},
+			workDir: "/work/nginx/1.28.0",
+			srcDir:  "/work/nginx/1.28.0/nginx-1.28.0+debug",
+		},
+		{
+			spec:       Spec{WorkDir: "work", Variant: "../debug"},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", Variant: "debug", FetchOnly: true},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", Flavor: "apache"},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", OpenSSL: Library{Static: true}, LibreSSL: Library{Static: true}},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", ConfigureOnly: true, Install: true},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", OpenResty: &openresty.Options{LuaJIT: "off"}},
+			shouldFail: true,
+		},
+		{
+			spec:      Spec{WorkDir: "/work", Version: "1.28.0", OpenSSL: Library{Static: true}, Target: &cross.Target{Triple: "aarch64-linux-gnu"}},
+			workDir:   "/work/nginx/1.28.0",
+			srcDir:    "/work/nginx/1.28.0/nginx-1.28.0",
+			libraries: 1,
+			arch:      "arm64",
+		},
+		{
+			spec:    Spec{WorkDir: "/work", Version: "1.28.0", Install: true, Package: "deb", Target: &cross.Target{Triple: "armv7l-linux-gnueabihf"}},
+			workDir: "/work/nginx/1.28.0",
+			srcDir:  "/work/nginx/1.28.0/nginx-1.28.0",
+			arch:    "arm",
+		},
+		{
+			spec:       Spec{WorkDir: "work", Target: &cross.Target{Triple: "x86_64-w64-mingw32"}},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", Install: true, OCIPath: "nginx.oci.tar", Target: &cross.Target{Triple: "arm64-apple-darwin23"}},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", LibreSSL: Library{Static: true}, Target: &cross.Target{Triple: "aarch64-linux-gnu"}},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", SmokeTest: true, Install: true, Target: &cross.Target{Triple: "aarch64-linux-gnu"}},
+			shouldFail: true,
+		},
+		{
+			spec:       Spec{WorkDir: "work", Configure: "./configure --with-cc=clang", Target: &cross.Target{Triple: "aarch64-linux-gnu"}},
+			shouldFail: true,
+		},
+	}
+
+	for _, test := range tests {
+		b, err := newBuild(test.spec)
+		if test.shouldFail {
+			if err == nil {
+				t.Fatalf("newBuild(%+v) should fail", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("newBuild(%+v): %v", test.spec, err)
+		}
+		if b.workDir != filepath.FromSlash(test.workDir) {
+			t.Fatalf("got: %v, want: %v", b.workDir, test.workDir)
+		}
+		if b.srcDir != filepath.FromSlash(test.srcDir) {
+			t.Fatalf("got: %v, want: %v", b.srcDir, test.srcDir)
+		}
+		if len(b.libraries) != test.libraries {
+			t.Fatalf("got: %v, want: %v", len(b.libraries), test.libraries)
+		}
+		if b.arch() != test.arch {
+			t.Fatalf("got: %v, want: %v", b.arch(), test.arch)
+		}
+		if b.spec.Jobs <= 0 {
+			t.Fatalf("got: %v, want: > 0", b.spec.Jobs)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		spec      Spec
+		version   string
+		srcDir    string
+		libraries []string
+		modules   []string
+	}{
+		{
+			spec:    Spec{WorkDir: "/work"},
+			version: builder.NginxVersion,
+			srcDir:  "/work/nginx/" + builder.NginxVersion + "/nginx-" + builder.NginxVersion,
+		},
+		{
+			spec: Spec{
+				WorkDir: "/work",
+				Flavor:  "freenginx",
+				Version: "1.27.4",
+				Pcre:    Library{Static: true},
+				Zlib:    Library{Static: true},
+				Modules: []module3rd.Module3rd{{Name: "ngx_echo"}, {Name: "ngx_headers_more", Rev: "v0.37"}},
+			},
+			version:   "1.27.4",
+			srcDir:    "/work/freenginx/1.27.4/freenginx-1.27.4",
+			libraries: []string{"pcre2", "zlib"},
+			modules:   []string{"ngx_echo", "ngx_headers_more@v0.37"},
+		},
+	}
+
+	for _, test := range tests {
+		plan, err := Resolve(test.spec)
+		if err != nil {
+			t.Fatalf("Resolve(%+v): %v", test.spec, err)
+		}
+		if plan.Version != test.version {
+			t.Fatalf("got: %v, want: %v", plan.Version, test.version)
+		}
+		if plan.SourceDir != filepath.FromSlash(test.srcDir) {
+			t.Fatalf("got: %v, want: %v", plan.SourceDir, test.srcDir)
+		}
+		var libraries []string
+		for _, l := range plan.Libraries {
+			libraries = append(libraries, l.Name)
+		}
+		if !reflect.DeepEqual(libraries, test.libraries) {
+			t.Fatalf("got: %v, want: %v", libraries, test.libraries)
+		}
+		if !reflect.DeepEqual(plan.Modules, test.modules) {
+			t.Fatalf("got: %v, want: %v", plan.Modules, test.modules)
+		}
+		if plan.Fetched || plan.BinaryPath != "" || plan.Manifest != nil {
+			t.Fatalf("got: %+v, want: nothing fetched", plan)
+		}
+		if !strings.HasPrefix(plan.ConfigureScript, "#!/bin/sh") || plan.Fingerprint == "" {
+			t.Fatalf("got: %+v, want: a configure script and a fingerprint", plan)
+		}
+	}
+}
+
+func TestStageError(t *testing.T) {
+	cause := errors.New("exit status 2")
+	var err error = &StageError{Stage: "build", Component: "nginx-1.28.0", LogPath: "/work/nginx-build.log", Err: cause}
+
+	if got, want := err.Error(), "build nginx-1.28.0: exit status 2"; got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+	if !errors.Is(err, cause) {
+		t.Fatalf("StageError should unwrap %v", cause)
+	}
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.LogPath != "/work/nginx-build.log" {
+		t.Fatalf("got: %v, want: %v", stageErr, "/work/nginx-build.log")
+	}
+}
+
+func TestStageErrorCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	err := stageError(ctx, "build", "nginx-1.28.0", "/work/nginx-build.log", errors.New("signal: killed"))
+	if errors.Is(err, context.Canceled) {
+		t.Fatalf("%v should not be cancellation", err)
+	}
+
+	cancel()
+	err = stageError(ctx, "build", "nginx-1.28.0", "/work/nginx-build.log", errors.New("signal: killed"))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got: %v, want: %v", err, context.Canceled)
+	}
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.LogPath != "" {
+		t.Fatalf("LogPath of interrupted stage should be empty: %v", stageErr)
+	}
+}
+
+func TestRunStage(t *testing.T) {
+	var events []Event
+	b := &build{spec: Spec{Events: func(ev Event) { events = append(events, ev) }}, artifacts: make(map[string]string)}
+
+	cause := exec.Command("sh", "-c", "exit 3").Run()
+	err := b.runStage(context.Background(), StageBuild, "nginx-1.28.0", func(ctx context.Context, ev *Event) error {
+		ev.Bytes = 42
+		return &StageError{Stage: StageBuild, Component: "nginx-1.28.0", LogPath: "/work/nginx-build.log", Err: cause}
+	})
+	if err == nil {
+		t.Fatalf("runStage should return the error of the stage")
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("got: %v, want: %v", len(events), 2)
+	}
+	if events[0].Type != EventStageStart || events[1].Type != EventStageFinish {
+		t.Fatalf("got: %v, want: %v", []string{events[0].Type, events[1].Type}, []string{EventStageStart, EventStageFinish})
+	}
+	finish := events[1]
+	if finish.Stage != StageBuild || finish.Component != "nginx-1.28.0" || finish.Bytes != 42 {
+		t.Fatalf("got: %+v", finish)
+	}
+	if finish.ExitCode == nil || *finish.ExitCode != 3 {
+		t.Fatalf("got: %v, want: %v", finish.ExitCode, 3)
+	}
+	if finish.LogPath != "/work/nginx-build.log" {
+		t.Fatalf("got: %v, want: %v", finish.LogPath, "/work/nginx-build.log")
+	}
+}
+
+func TestJSONEvents(t *testing.T) {
+	var buf bytes.Buffer
+	emit := JSONEvents(&buf)
+	emit(Event{Type: EventStageStart, Stage: StageDownload, Component: "pcre2-10.45"})
+	emit(Event{Type: EventArtifact, Artifact: "binary", Path: "/work/nginx-1.28.0/objs/nginx"})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got: %v, want: %v", len(lines), 2)
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(lines[1]), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != EventArtifact || ev.Path != "/work/nginx-1.28.0/objs/nginx" {
+		t.Fatalf("got: %+v", ev)
+	}
+	if strings.Contains(lines[0], "exit_code") || strings.Contains(lines[0], "bytes") {
+		t.Fatalf("empty fields should be omitted: %s", lines[0])
+	}
+}
+
+func TestTimings(t *testing.T) {
+	start := time.Date(2025, 4, 23, 0, 0, 0, 0, time.UTC)
+	timings := []Timing{
+		{Stage: StageBuild, Component: "nginx-1.28.0", Start: start.Add(3 * time.Second), Wall: 40 * time.Second, CPU: 150 * time.Second},
+		{Stage: StageDownload, Component: "nginx-1.28.0", Start: start, Wall: 2 * time.Second},
+		{Stage: StageDownload, Component: "openssl-3.5.0", Start: start.Add(time.Millisecond), Wall: 3 * time.Second},
+	}
+
+	var table bytes.Buffer
+	if err := WriteTimingTable(&table, timings); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(table.String(), "\n")
+	if !strings.HasPrefix(lines[1], "download") || !strings.HasPrefix(lines[3], "build") {
+		t.Fatalf("timings should be sorted by the start:\n%s", table.String())
+	}
+	if got, want := strings.Fields(lines[4]), []string{"download", "(total)", "5.00s", "0.00s"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+
+	var trace bytes.Buffer
+	if err := WriteTrace(&trace, timings); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(trace.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	var tids []int
+	for _, ev := range doc.TraceEvents {
+		if ev.Phase == "X" {
+			tids = append(tids, ev.Tid)
+			if ev.Name == StageBuild && (ev.Timestamp != 3000000 || ev.Duration != 40000000) {
+				t.Fatalf("got: %+v", ev)
+			}
+		}
+	}
+	// the downloads in parallel are on the different tracks
+	if len(tids) != 3 || tids[0] == tids[1] || tids[0] != tids[2] {
+		t.Fatalf("got: %v", tids)
+	}
+
+	data, err := json.Marshal(timings[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Timing
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != timings[0] {
+		t.Fatalf("got: %+v, want: %+v", decoded, timings[0])
+	}
+}
+
+func TestParseTLS(t *testing.T) {
+	tests := []struct {
+		tls        string
+		openSSL    Library
+		libreSSL   Library
+		shouldFail bool
+	}{
+		{tls: "system"},
+		{tls: "openssl", openSSL: Library{Static: true}},
+		{tls: "openssl-3.0.16", openSSL: Library{Static: true, Version: "3.0.16"}},
+		{tls: "libressl-4.1.0", libreSSL: Library{Static: true, Version: "4.1.0"}},
+		{tls: "system-1.0", shouldFail: true},
+		{tls: "boringssl", shouldFail: true},
+	}
+
+	for _, test := range tests {
+		openSSL, libreSSL, err := ParseTLS(test.tls)
+		if test.shouldFail {
+			if err == nil {
+				t.Fatalf("%s got: nil, want: error", test.tls)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if openSSL != test.openSSL || libreSSL != test.libreSSL {
+			t.Fatalf("%s got: %+v %+v, want: %+v %+v", test.tls, openSSL, libreSSL, test.openSSL, test.libreSSL)
+		}
+	}
+}
+
+func TestMatrixSpec(t *testing.T) {
+	m := Matrix{Spec: Spec{WorkDir: "work", OpenResty: &openresty.Options{}}}
+	combinations := Combinations([]string{"nginx", "openresty"}, map[string][]string{
+		"nginx":     {"1.26.3", "1.28.0"},
+		"openresty": {"1.27.1.2"},
+	}, []string{"system", "openssl"})
+	if len(combinations) != 6 {
+		t.Fatalf("got: %d, want: %d", len(combinations), 6)
+	}
+
+	tests := []struct {
+		combination   Combination
+		variant       string
+		openSSL       Library
+		withOpenResty bool
+	}{
+		{combinations[0], "", Library{Version: builder.OpenSSLVersion}, false},
+		{combinations[3], "openssl-" + builder.OpenSSLVersion, Library{Static: true, Version: builder.OpenSSLVersion}, false},
+		{combinations[5], "openssl-" + builder.OpenSSLVersion, Library{Static: true, Version: builder.OpenSSLVersion}, true},
+		{Combination{"nginx", "1.28.0", "openssl-3.0.16"}, "openssl-3.0.16", Library{Static: true, Version: "3.0.16"}, false},
+	}
+
+	for _, test := range tests {
+		spec, err := m.spec(test.combination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.WorkDir != "work" || spec.DownloadCache != "work/cache" {
+			t.Fatalf("%s got: %s %s, want: %s %s", test.combination, spec.WorkDir, spec.DownloadCache, "work", "work/cache")
+		}
+		if spec.Variant != test.variant {
+			t.Fatalf("%s got: %s, want: %s", test.combination, spec.Variant, test.variant)
+		}
+		if spec.OpenSSL != test.openSSL {
+			t.Fatalf("%s got: %+v, want: %+v", test.combination, spec.OpenSSL, test.openSSL)
+		}
+		if (spec.OpenResty != nil) != test.withOpenResty {
+			t.Fatalf("%s got: %v, want: %v", test.combination, spec.OpenResty != nil, test.withOpenResty)
+		}
+	}
+
+	// a variant given is combined with the TLS library
+	m.Spec.Variant = "debug"
+	spec, err := m.spec(Combination{"nginx", "1.28.0", "libressl-4.1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.Variant != "debug-libressl-4.1.0" {
+		t.Fatalf("got: %s, want: %s", spec.Variant, "debug-libressl-4.1.0")
+	}
+}
+
+func TestStageStatus(t *testing.T) {
+	timings := []Timing{{Stage: StageDownload}, {Stage: StageConfigure}, {Stage: StageBuild}}
+	tests := []struct {
+		failed string
+		stage  string
+		want   string
+	}{
+		{"", StageConfigure, StatusPassed},
+		{StageBuild, StageBuild, StatusFailed},
+		{StageBuild, StageConfigure, StatusPassed},
+		{"", StageSmoke, StatusNotRun},
+	}
+
+	for _, test := range tests {
+		if got := stageStatus(timings, test.failed, test.stage); got != test.want {
+			t.Fatalf("%s got: %s, want: %s", test.stage, got, test.want)
+		}
+	}
+}
+
+func TestParseReleases(t *testing.T) {
+	index := `<a href="nginx-1.9.9.tar.gz">nginx-1.9.9.tar.gz</a>
+<a href="nginx-1.10.0.tar.gz">nginx-1.10.0.tar.gz</a>
+<a href="nginx-1.10.0.tar.gz.asc">nginx-1.10.0.tar.gz.asc</a>
+<a href="nginx-1.9.10.zip">nginx-1.9.10.zip</a>
+<a href="nginx-1.9.10.tar.gz">nginx-1.9.10.tar.gz</a>
+<a href="nginx-1.11.0rc1.tar.gz">nginx-1.11.0rc1.tar.gz</a>
+<a href="freenginx-1.27.0.tar.gz">freenginx-1.27.0.tar.gz</a>`
+
+	want := []string{"1.9.9", "1.9.10", "1.10.0"}
+	if got := ParseReleases("nginx", index); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestBisectMiddle(t *testing.T) {
+	tests := []struct {
+		lo, hi  int
+		skipped map[int]bool
+		want    int
+	}{
+		{0, 8, nil, 4},
+		{0, 1, nil, -1},
+		{0, 2, nil, 1},
+		{0, 8, map[int]bool{4: true}, 3},
+		{0, 8, map[int]bool{3: true, 4: true}, 5},
+		{2, 5, map[int]bool{3: true, 4: true}, -1},
+	}
+
+	for _, test := range tests {
+		if got := bisectMiddle(test.lo, test.hi, test.skipped); got != test.want {
+			t.Fatalf("%d %d %v got: %d, want: %d", test.lo, test.hi, test.skipped, got, test.want)
+		}
+	}
+}
+
+func TestBisectionCandidates(t *testing.T) {
+	versions := []string{"1.25.0", "1.25.1", "1.26.0", "1.27.0"}
+	tests := []struct {
+		good, bad  string
+		want       []string
+		shouldFail bool
+	}{
+		{good: "1.25.1", bad: "1.27.0", want: []string{"1.25.1", "1.26.0", "1.27.0"}},
+		{good: "1.27.0", bad: "1.25.1", shouldFail: true},
+		{good: "1.24.0", bad: "1.25.1", shouldFail: true},
+	}
+
+	for _, test := range tests {
+		bs := Bisection{Versions: versions, Good: test.good, Bad: test.bad}
+		got, err := bs.candidates()
+		if test.shouldFail {
+			if err == nil {
+				t.Fatalf("%s..%s got: %v, want: error", test.good, test.bad, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
+
+func TestApplyBundle(t *testing.T) {
+	manifest := BundleManifest{
+		Flavor:      "freenginx",
+		Version:     "1.27.4",
+		Zlib:        Library{Static: true, Version: "1.3.1"},
+		Configure:   "./configure --with-debug",
+		Modules:     []module3rd.Module3rd{{Name: "ngx_echo", Form: "git", Rev: "0123abc", Shprov: "make"}},
+		Provisioned: true,
+	}
+	tests := []struct {
+		spec       Spec
+		configure  string
+		shouldFail bool
+	}{
+		{spec: Spec{WorkDir: "work", DownloadCache: "cache"}, configure: "./configure --with-debug"},
+		{spec: Spec{WorkDir: "work", Configure: "./configure"}, configure: "./configure"},
+		{spec: Spec{WorkDir: "work", Patch: "a.patch"}, shouldFail: true},
+		{spec: Spec{WorkDir: "work", Modules: []module3rd.Module3rd{{Name: "ngx_echo"}}}, shouldFail: true},
+		{spec: Spec{WorkDir: "work", FetchOnly: true}, shouldFail: true},
+	}
+
+	for _, test := range tests {
+		spec, err := applyBundle(test.spec, manifest)
+		if test.shouldFail {
+			if err == nil {
+				t.Fatalf("applyBundle(%+v) should fail", test.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if spec.Flavor != "freenginx" || spec.Version != "1.27.4" || spec.Zlib != manifest.Zlib {
+			t.Fatalf("got: %+v, want: the flavor and the versions of the bundle", spec)
+		}
+		if spec.Configure != test.configure {
+			t.Fatalf("got: %v, want: %v", spec.Configure, test.configure)
+		}
+		if spec.Modules[0].Rev != "0123abc" || spec.Modules[0].Shprov != "" {
+			t.Fatalf("got: %+v, want: the module resolved and provisioned", spec.Modules[0])
+		}
+		if !spec.Offline || spec.DownloadCache != "" {
+			t.Fatalf("got: %+v, want: offline without a download cache", spec)
+		}
+	}
+	if manifest.Modules[0].Shprov != "make" {
+		t.Fatalf("got: %v, want: the manifest unchanged", manifest.Modules[0].Shprov)
+	}
+}
+
+func TestBundleArchive(t *testing.T) {
+	dir := t.TempDir()
+	workDir := filepath.Join(dir, "nginx", "1.28.0")
+	if err := os.MkdirAll(filepath.Join(workDir, "nginx-1.28.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "nginx-1.28.0", "configure"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	patch := filepath.Join(dir, "a.patch")
+	if err := os.WriteFile(patch, []byte("--- a\n+++ b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "bundle.tar.gz")
+	manifest := BundleManifest{Flavor: "nginx", Version: "1.28.0", PatchOption: "-p1", Patches: []BundleFile{{Path: "nginx/1.28.0/" + bundlePatchDir + "/00-a.patch"}}}
+	files := map[string]string{"nginx/1.28.0/" + bundlePatchDir + "/00-a.patch": patch}
+	if err := writeBundleArchive(context.Background(), out, workDir, "nginx/1.28.0", &manifest, files); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadBundleManifest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Tree == "" || !reflect.DeepEqual(got, manifest) {
+		t.Fatalf("got: %+v, want: %+v", got, manifest)
+	}
+	if err := verifyBundleTree(out, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	// the source tree changed is not verified with the manifest
+	if err := os.WriteFile(filepath.Join(workDir, "nginx-1.28.0", "configure"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	changed := filepath.Join(dir, "changed.tar.gz")
+	changedManifest := manifest
+	if err := writeBundleArchive(context.Background(), changed, workDir, "nginx/1.28.0", &changedManifest, files); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyBundleTree(changed, &got); err == nil {
+		t.Fatal("verifyBundleTree with the source tree changed should fail")
+	}
+
+	output, err := exec.Command("tar", "tzf", out).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		BundleManifestName,
+		"nginx/1.28.0/",
+		"nginx/1.28.0/nginx-1.28.0/",
+		"nginx/1.28.0/nginx-1.28.0/configure",
+		"nginx/1.28.0/" + bundlePatchDir + "/00-a.patch",
+	}
+	if names := strings.Fields(string(output)); !reflect.DeepEqual(names, want) {
+		t.Fatalf("got: %v, want: %v", names, want)
+	}
+
+	if _, err := ReadBundleManifest(patch); err == nil {
+		t.Fatalf("ReadBundleManifest(%s) should fail", patch)
+	}
+}
+
+func TestSelectGC(t *testing.T) {
+	now := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	entries := []WorkEntry{
+		{Flavor: "nginx", Version: "1.26.0", Size: 100, LastUsed: now.Add(-40 * day)},
+		{Flavor: "nginx", Version: "1.27.0", Size: 200, LastUsed: now.Add(-10 * day)},
+		{Flavor: "nginx", Version: "1.28.0", Size: 300, LastUsed: now.Add(-1 * day)},
+		{Flavor: "openresty", Version: "1.27.1.2", Size: 400, LastUsed: now.Add(-5 * day)},
+	}
+	versions := func(entries []WorkEntry) []string {
+		var vs []string
+		for _, e := range entries {
+			vs = append(vs, e.Version)
+		}
+		return vs
+	}
+
+	tests := []struct {
+		policy GCPolicy
+		want   []string
+	}{
+		{policy: GCPolicy{}, want: nil},
+		{policy: GCPolicy{MaxAge: 30 * day}, want: []string{"1.26.0"}},
+		{policy: GCPolicy{Keep: 1}, want: []string{"1.26.0", "1.27.0"}},
+		{policy: GCPolicy{MaxSize: 700}, want: []string{"1.26.0", "1.27.0"}},
+		{policy: GCPolicy{MaxAge: 7 * day, MaxSize: 300}, want: []string{"1.26.0", "1.27.0", "1.27.1.2"}},
+	}
+	for _, test := range tests {
+		if got := versions(SelectGC(entries, test.policy, now)); !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%+v got: %v, want: %v", test.policy, got, test.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+	}{
+		{size: "512", want: 512},
+		{size: "4K", want: 4096},
+		{size: "1.5M", want: 3 << 19},
+		{size: "10g", want: 10 << 30},
+		{size: "2TB", want: 2 << 40},
+	}
+	for _, test := range tests {
+		got, err := ParseSize(test.size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+		if test.want >= 1024 && FormatSize(got) == "" {
+			t.Fatalf("FormatSize(%d) is empty", got)
+		}
+	}
+	for _, size := range []string{"", "G", "-1M", "10X"} {
+		if _, err := ParseSize(size); err == nil {
+			t.Fatalf("ParseSize(%q) should fail", size)
+		}
+	}
+	if got := FormatSize(3 << 19); got != "1.5M" {
+		t.Fatalf("got: %v, want: %v", got, "1.5M")
+	}
+}
+
+func TestListWorkEntries(t *testing.T) {
+	root := t.TempDir()
+	for _, p := range []string{"nginx/1.28.0", "openresty/1.27.1.2", "cache"} {
+		if err := os.MkdirAll(filepath.Join(root, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"nginx/1.28.0.lock", "cache/nginx-1.28.0.tar.gz", "cache/nginx-1.28.0.tar.gz.lock"} {
+		if err := os.WriteFile(filepath.Join(root, p), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := ListWorkEntries(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Flavor+"/"+e.Version)
+	}
+	want := []string{"nginx/1.28.0", "openresty/1.27.1.2", "cache/nginx-1.28.0.tar.gz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+	if entries[2].Size != 1 || entries[2].Built {
+		t.Fatalf("got: %+v, want: the archive of 1 byte", entries[2])
+	}
+}
+
+func TestVariant(t *testing.T) {
+	workDir := filepath.Join(t.TempDir(), "nginx", "1.28.0")
+	b, err := newBuild(Spec{WorkDir: filepath.Dir(filepath.Dir(workDir)), Version: "1.28.0", Variant: "debug", Logger: DiscardLogger})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(b.pristineDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the files made by configuring and building without a variant are not copied
+	for name, content := range map[string]string{
+		"configure":           "#!/bin/sh\n",
+		"conf/nginx.conf":     "events {}\n",
+		"auto/feature":        "if /bin/sh -c $NGX_AUTOTEST >> $NGX_AUTOCONF_ERR 2>&1; then\n",
+		"Makefile":            "default: build\n",
+		"objs/Makefile":       "build: binary\n",
+		"nginx-configure":     "#!/bin/sh\n./configure\n",
+		"nginx-configure.log": "checking for OS\n",
+	} {
+		path := filepath.Join(b.pristineDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("nginx.conf", filepath.Join(b.pristineDir, "conf", "default.conf")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.copyVariant(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"configure", "conf/nginx.conf", "auto/feature"} {
+		if !util.FileExists(filepath.Join(b.srcDir, filepath.FromSlash(name))) {
+			t.Fatalf("got: no %s, want: %s copied into %s", name, name, b.srcDir)
+		}
+	}
+	for _, name := range []string{"Makefile", "objs", "nginx-configure", "nginx-configure.log"} {
+		if util.FileExists(filepath.Join(b.srcDir, name)) {
+			t.Fatalf("got: %s, want: %s not copied into %s", name, name, b.srcDir)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(b.srcDir, "conf", "default.conf")); err != nil || link != "nginx.conf" {
+		t.Fatalf("got: %v, %v, want: the symbolic link to nginx.conf", link, err)
+	}
+	variants, err := ListVariants(b.workDir, b.nginx.SourcePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(variants, []string{"debug"}) {
+		t.Fatalf("got: %v, want: %v", variants, []string{"debug"})
+	}
+
+	// the variant interrupted in patching is removed and the source code extracted is kept
+	if _, err := beginPatchState(b.srcDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.restorePatched(); err != nil {
+		t.Fatal(err)
+	}
+	if util.FileExists(b.srcDir) || !util.FileExists(b.pristineDir) {
+		t.Fatalf("got: %v, %v, want: only %s removed", util.FileExists(b.srcDir), util.FileExists(b.pristineDir), b.srcDir)
+	}
+
+	// the source code patched or rewritten for cross-compiling by a build without a variant is not copied
+	if _, err := beginPatchState(b.pristineDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.copyVariant(context.Background()); err == nil || util.FileExists(b.srcDir) {
+		t.Fatalf("got: %v, want: an error without %s", err, b.srcDir)
+	}
+	if err := os.RemoveAll(filepath.Join(b.pristineDir, PatchStateDir)); err != nil {
+		t.Fatal(err)
+	}
+	feature := "if [ -n \"$NGX_BUILD_CROSS\" ] || /bin/sh -c $NGX_AUTOTEST >> $NGX_AUTOCONF_ERR 2>&1; then\n"
+	if err := os.WriteFile(filepath.Join(b.pristineDir, "auto", "feature"), []byte(feature), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if prepared, err := cross.Prepared(b.pristineDir); err != nil || !prepared {
+		t.Fatalf("got: %v, %v, want: auto/feature rewritten", prepared, err)
+	}
+	if err := b.copyVariant(context.Background()); err == nil || util.FileExists(b.srcDir) {
+		t.Fatalf("got: %v, want: an error without %s", err, b.srcDir)
+	}
+
+	// the source code extracted and interrupted in patching by a build without a variant is removed
+	if _, err := beginPatchState(b.pristineDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.restorePatched(); err != nil {
+		t.Fatal(err)
+	}
+	if util.FileExists(b.pristineDir) {
+		t.Fatalf("got: %s exists, want: removed", b.pristineDir)
+	}
+}
+
+func TestPatchSeries(t *testing.T) {
+	dir := t.TempDir()
+	workDir := filepath.Join(dir, "work", "nginx", "1.28.0")
+	patchDir := filepath.Join(dir, "patches")
+	files := map[string]string{
+		filepath.Join(workDir, "nginx-1.28.0", "configure"): "#!/bin/sh\n",
+		filepath.Join(workDir, "ngx_echo", "config"):        "ngx_addon_name=ngx_echo\n",
+		filepath.Join(patchDir, "configure.patch"):          "--- a/configure\n+++ b/configure\n@@ -1 +1,2 @@\n #!/bin/sh\n+echo nginx\n",
+		filepath.Join(patchDir, "echo.patch"):               "--- config\n+++ config\n@@ -1 +1 @@\n-ngx_addon_name=ngx_echo\n+ngx_addon_name=ngx_http_echo\n",
+		filepath.Join(patchDir, "series"):                   "# the patches of nginx and the modules\nconfigure.patch\necho.patch -p0 -d ngx_echo\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	spec := Spec{
+		WorkDir: filepath.Join(dir, "work"),
+		Version: "1.28.0",
+		Modules: []module3rd.Module3rd{{Name: "ngx_echo", Form: "git"}},
+		Patch:   patchDir,
+		Logger:  DiscardLogger,
+	}
+	b, err := newBuild(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.applyPatch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []util.PatchFile{
+		{Path: filepath.Join(patchDir, "configure.patch"), Series: filepath.Join(patchDir, "series")},
+		{Path: filepath.Join(patchDir, "echo.patch"), Option: "-p0", Target: "ngx_echo", Series: filepath.Join(patchDir, "series")},
+	}
+	if !reflect.DeepEqual(b.patches, want) {
+		t.Fatalf("got: %+v, want: %+v", b.patches, want)
+	}
+	for path, content := range map[string]string{
+		filepath.Join(workDir, "nginx-1.28.0", "configure"): "#!/bin/sh\necho nginx\n",
+		filepath.Join(workDir, "ngx_echo", "config"):        "ngx_addon_name=ngx_http_echo\n",
+	} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Fatalf("got: %q, want: %q", data, content)
+		}
+		state, err := LoadPatchState(filepath.Dir(path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.Status != PatchStatusApplied || len(state.Files) != 1 || len(state.Modified) != 0 {
+			t.Fatalf("got: %+v, want: the state of %s applied", state, filepath.Dir(path))
+		}
+	}
+
+	if err := b.revertPatch(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(workDir, "ngx_echo", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "ngx_addon_name=ngx_echo\n" || util.FileExists(filepath.Join(workDir, "ngx_echo", PatchStateDir)) {
+		t.Fatalf("got: %q, want: ngx_echo reverted", data)
+	}
+
+	// the states recorded are removed when recording the state of a directory fails
+	if err := os.RemoveAll(filepath.Join(workDir, "ngx_echo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "ngx_echo"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err = newBuild(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.applyPatch(context.Background()); err == nil {
+		t.Fatal("applyPatch should fail")
+	}
+	if util.FileExists(filepath.Join(workDir, "nginx-1.28.0", PatchStateDir)) {
+		t.Fatalf("got: %s exists, want: removed", filepath.Join(workDir, "nginx-1.28.0", PatchStateDir))
+	}
+
+	// a patch of a component out of the build is not applied
+	if err := os.WriteFile(filepath.Join(patchDir, "series"), []byte("echo.patch -d ngx_lua\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err = newBuild(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.checkPatch(context.Background()); err == nil {
+		t.Fatal("checkPatch with an unknown target should fail")
+	}
+}
+
+func TestRestorePatched(t *testing.T) {
+	dir := t.TempDir()
+	srcDir := filepath.Join(dir, "work", "nginx", "1.28.0", "nginx-1.28.0")
+	patchPath := filepath.Join(dir, "configure.patch")
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(patchPath, []byte("--- a/configure\n+++ b/configure\n@@ -1 +1,2 @@\n #!/bin/sh\n+echo nginx\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	spec := Spec{WorkDir: filepath.Join(dir, "work"), Version: "1.28.0", Patch: patchPath, PatchOption: "-p1", Logger: DiscardLogger}
+
+	tests := []struct {
+		name     string
+		modify   bool
+		corrupt  bool
+		reverted bool
+	}{
+		{name: "reverted", reverted: true},
+		{name: "modified outside nginx-build", modify: true},
+		{name: "failing to revert", corrupt: true},
+	}
+	for _, test := range tests {
+		if err := os.RemoveAll(srcDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(srcDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, "configure"), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		b, err := newBuild(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.applyPatch(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		patched, err := os.ReadFile(filepath.Join(srcDir, "configure"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.modify {
+			patched = []byte("#!/bin/sh\necho modified\n")
+			if err := os.WriteFile(filepath.Join(srcDir, "configure"), patched, 0755); err != nil {
+				t.Fatal(err)
+			}
+			state, err := LoadPatchState(srcDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(state.Modified, []string{"configure"}) {
+				t.Fatalf("%s: got: %v, want: %v", test.name, state.Modified, []string{"configure"})
+			}
+		}
+		if test.corrupt {
+			state, err := LoadPatchState(srcDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			corrupted := "--- a/configure\n+++ b/configure\n@@ -1 +1,2 @@\n #!/bin/sh\n+echo nginx-build\n"
+			if err := os.WriteFile(filepath.Join(srcDir, PatchStateDir, state.Patches[0].Copy), []byte(corrupted), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// the next build restores the source code patched by the build killed
+		b, err = newBuild(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.restorePatched()
+		data, readErr := os.ReadFile(filepath.Join(srcDir, "configure"))
+		if test.reverted {
+			if err != nil || readErr != nil || string(data) != "#!/bin/sh\n" || util.FileExists(filepath.Join(srcDir, PatchStateDir)) {
+				t.Fatalf("%s: got: %q, %v, want: configure reverted", test.name, data, err)
+			}
+			continue
+		}
+
+		// the source code is kept with the changes and the build fails
+		if err == nil {
+			t.Fatalf("%s: got: %v, want: an error", test.name, err)
+		}
+		if readErr != nil || string(data) != string(patched) {
+			t.Fatalf("%s: got: %q, %v, want: %q", test.name, data, readErr, patched)
+		}
+		state, err := LoadPatchState(srcDir)
+		if err != nil || state.Status != PatchStatusApplied {
+			t.Fatalf("%s: got: %v, %v, want: the patches applied", test.name, state, err)
+		}
+
+		// -clear-scope nginx removes the source code
+		clearSpec := spec
+		clearSpec.ClearScopes = []string{ClearNginx}
+		b, err = newBuild(clearSpec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.prepareWorkDir(); err != nil {
+			t.Fatalf("%s: got: %v, want: nil", test.name, err)
+		}
+		if util.FileExists(srcDir) {
+			t.Fatalf("%s: got: %s exists, want: removed", test.name, srcDir)
+		}
+	}
+}
+
+func TestCheckDestDir(t *testing.T) {
+	destDir := t.TempDir()
+	tests := []struct {
+		spec       Spec
+		stale      bool
+		shouldFail bool
+	}{
+		{spec: Spec{WorkDir: "work", DestDir: destDir, Package: "tar.gz"}},
+		{spec: Spec{WorkDir: "work", DestDir: destDir, Package: "tar.gz"}, stale: true, shouldFail: true},
+		{spec: Spec{WorkDir: "work", DestDir: destDir, OCIPath: "image"}, stale: true, shouldFail: true},
+		// installing without packaging keeps installing into the existing root
+		{spec: Spec{WorkDir: "work", DestDir: destDir, Install: true}, stale: true},
+		{spec: Spec{WorkDir: "work", DestDir: filepath.Join(destDir, "missing"), Package: "tar.gz"}},
+	}
+
+	for _, test := range tests {
+		if test.stale {
+			if err := os.WriteFile(filepath.Join(destDir, "stale"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.RemoveAll(filepath.Join(destDir, "stale")); err != nil {
+			t.Fatal(err)
+		}
+		b, err := newBuild(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.checkDestDir()
+		if (err != nil) != test.shouldFail {
+			t.Fatalf("got: %v, want: %v", err, test.shouldFail)
+		}
+	}
+}
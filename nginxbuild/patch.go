@@ -0,0 +1,283 @@
+package nginxbuild
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// PatchStateDir is the directory in a source directory keeping the state of the patches applied to it
+// with the copies of the patches, so that they are reverted even after the build applying them was killed.
+const PatchStateDir = ".nginx-build-patches"
+
+// patchStateName is the name of the state in PatchStateDir.
+const patchStateName = "state.json"
+
+// The statuses of the patches of a source directory.
+const (
+	PatchStatusApplying  = "applying"
+	PatchStatusApplied   = "applied"
+	PatchStatusReverting = "reverting"
+)
+
+// AppliedPatch is a patch applied to a source directory.
+type AppliedPatch struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	// Option is the options applying the patch such as -p1
+	Option string `json:"option,omitempty"`
+	// Copy is the name of the copy of the patch in PatchStateDir reverting it
+	Copy string `json:"copy"`
+}
+
+// PatchState is the state of the patches applied to a source directory.
+type PatchState struct {
+	// Dir is the source directory
+	Dir     string         `json:"dir"`
+	Status  string         `json:"status"`
+	Patches []AppliedPatch `json:"patches"`
+	// Files maps the files changed by the patches relative to Dir to their SHA-256 after applying.
+	// The checksum of a file deleted is empty.
+	Files map[string]string `json:"files,omitempty"`
+	// Modified is the files changed after the patches were applied
+	Modified []string `json:"modified,omitempty"`
+}
+
+// LoadPatchState loads the state of the patches applied to the source directory dir
+// and finds the files modified after applying them. It returns an error wrapping os.ErrNotExist when dir is not patched.
+func LoadPatchState(dir string) (*PatchState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, PatchStateDir, patchStateName))
+	if err != nil {
+		return nil, err
+	}
+	var state PatchState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("the state of the patches of %s is broken: %w", dir, err)
+	}
+	state.Dir = dir
+	state.Modified = nil
+	if state.Status != PatchStatusApplied {
+		return &state, nil
+	}
+	for name, sum := range state.Files {
+		got, err := util.FileSHA256(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if got != sum {
+			state.Modified = append(state.Modified, name)
+		}
+	}
+	sort.Strings(state.Modified)
+	return &state, nil
+}
+
+func (state *PatchState) write() error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(state.Dir, PatchStateDir, patchStateName)
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// beginPatchState records the patches applied to the source directory dir before applying them.
+func beginPatchState(dir string, files []util.PatchFile) (*PatchState, error) {
+	stateDir := filepath.Join(dir, PatchStateDir)
+	if err := os.RemoveAll(stateDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, err
+	}
+	state := &PatchState{Dir: dir, Status: PatchStatusApplying}
+	for i, f := range files {
+		option, err := f.ExplicitOption()
+		if err != nil {
+			return nil, err
+		}
+		sum, err := util.FileSHA256(f.Path)
+		if err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%02d-%s", i, filepath.Base(f.Path))
+		if err := util.CopyTree(f.Path, filepath.Join(stateDir, name)); err != nil {
+			return nil, err
+		}
+		state.Patches = append(state.Patches, AppliedPatch{Path: f.Path, SHA256: sum, Option: option, Copy: name})
+	}
+	return state, state.write()
+}
+
+// finish records the checksums of the files changed by the patches.
+// changed is the paths of the files relative to the directory having Dir.
+func (state *PatchState) finish(changed []string) error {
+	state.Status = PatchStatusApplied
+	state.Files = make(map[string]string)
+	base := filepath.Dir(state.Dir)
+	for _, name := range changed {
+		rel, err := filepath.Rel(state.Dir, filepath.Join(base, name))
+		if err != nil || !filepath.IsLocal(rel) {
+			continue
+		}
+		sum, err := util.FileSHA256(filepath.Join(state.Dir, rel))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		state.Files[rel] = sum
+	}
+	return state.write()
+}
+
+// revert reverts the patches with their copies and removes the state.
+func (state *PatchState) revert(ctx context.Context, spec *Spec) error {
+	state.Status = PatchStatusReverting
+	if err := state.write(); err != nil {
+		return err
+	}
+	var files []util.PatchFile
+	for _, p := range state.Patches {
+		files = append(files, util.PatchFile{Path: filepath.Join(state.Dir, PatchStateDir, p.Copy), Option: p.Option})
+	}
+	if _, err := util.Patch(ctx, files, state.Dir, map[string]string{"": "."}, true, false, spec.logger()); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(state.Dir, PatchStateDir))
+}
+
+// patchFiles returns the patches of the build in order of applying.
+func (b *build) patchFiles() ([]util.PatchFile, error) {
+	if b.bundle != nil {
+		return b.bundlePatchFiles(), nil
+	}
+	return util.PatchFiles(b.spec.Patch, b.spec.PatchOption, b.baseDir)
+}
+
+// patchTargets maps the targets of the patches to their source directories relative to the working directory.
+// The patches are applied to nginx in srcDir without a target, and the 3rd party modules in the working directory
+// and the static libraries are named by their names such as ngx_echo and openssl.
+func (b *build) patchTargets(srcDir string) map[string]string {
+	targets := map[string]string{
+		"":                   filepath.Base(srcDir),
+		b.nginx.FlavorName(): filepath.Base(srcDir),
+	}
+	for _, m := range b.spec.Modules {
+		// the local modules are not changed by nginx-build
+		if m.Form != "local" {
+			targets[m.Name] = m.Name
+		}
+	}
+	for _, l := range b.libraries {
+		targets[builder.MakeStaticLibrary(l).Name] = l.SourcePath()
+	}
+	return targets
+}
+
+// groupPatches groups the patches by the source directories they are applied to in order of the directories patched first.
+func (b *build) groupPatches(files []util.PatchFile) ([]string, map[string][]util.PatchFile, error) {
+	targets := b.patchTargets(b.srcDir)
+	var dirs []string
+	groups := make(map[string][]util.PatchFile)
+	for _, f := range files {
+		target, ok := targets[f.Target]
+		if !ok {
+			return nil, nil, fmt.Errorf("Patch target: %s of %s is not a component of the build", f.Target, f.Path)
+		}
+		dir := filepath.Join(b.workDir, target)
+		if _, ok := groups[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		groups[dir] = append(groups[dir], f)
+	}
+	return dirs, groups, nil
+}
+
+// sourceDirs returns the source directories of the build which may be patched.
+func (b *build) sourceDirs() []string {
+	dirs := []string{b.pristineDir}
+	if b.srcDir != b.pristineDir {
+		dirs = append(dirs, b.srcDir)
+	}
+	var others []string
+	for _, target := range b.patchTargets(b.srcDir) {
+		if dir := filepath.Join(b.workDir, target); dir != b.srcDir {
+			others = append(others, dir)
+		}
+	}
+	sort.Strings(others)
+	return append(dirs, others...)
+}
+
+// loadPatchStates returns the states of the source directories of the build patched.
+func (b *build) loadPatchStates() ([]*PatchState, error) {
+	var states []*PatchState
+	for _, dir := range b.sourceDirs() {
+		state, err := LoadPatchState(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// clearScopeOf returns the scope of clearing the source directory dir.
+func (b *build) clearScopeOf(dir string) string {
+	if dir == b.pristineDir || dir == b.srcDir {
+		return ClearNginx
+	}
+	for _, l := range b.libraries {
+		if dir == filepath.Join(b.workDir, l.SourcePath()) {
+			return ClearLibraries
+		}
+	}
+	return ClearModules
+}
+
+// restorePatched reverts the patches applied to the source directories by the previous builds.
+// A source directory is removed to be made again only when the build applying or reverting its patches was interrupted.
+// It returns an error without removing a source directory when the files patched were modified outside nginx-build
+// or the patches can not be reverted, as the changes are lost by removing it. -clear or -clear-scope removes it.
+func (b *build) restorePatched() error {
+	logger := b.spec.logger()
+	for _, dir := range b.sourceDirs() {
+		name := filepath.Base(dir)
+		state, err := LoadPatchState(dir)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			continue
+		case err != nil:
+			return fmt.Errorf("%w. Remove %s with -clear or -clear-scope %s", err, name, b.clearScopeOf(dir))
+		case state.Status != PatchStatusApplied:
+			logger.Printf("[warn]%s was interrupted in %s the patches. It is made again.", name, state.Status)
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		case len(state.Modified) > 0:
+			return fmt.Errorf("%s was modified outside nginx-build after the patches were applied: %s. Remove it with -clear or -clear-scope %s",
+				name, strings.Join(state.Modified, ", "), b.clearScopeOf(dir))
+		default:
+			logger.Printf("Revert the patches of %s.....", name)
+			if err := state.revert(context.Background(), &b.spec); err != nil {
+				// nothing is changed by the patches failing to revert
+				state.Status = PatchStatusApplied
+				return errors.Join(fmt.Errorf("Failed to revert the patches of %s: %w. Remove it with -clear or -clear-scope %s", name, err, b.clearScopeOf(dir)), state.write())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package nginxbuild
+
+import (
+	"path/filepath"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// Component is a component downloaded in a build.
+type Component struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	DownloadURL string `json:"download_url"`
+}
+
+// Plan is what a spec resolves to without building nginx.
+type Plan struct {
+	Flavor  string `json:"flavor"`
+	Version string `json:"version"`
+	// WorkDir is the versioned working directory such as <WorkDir>/nginx/1.28.0
+	WorkDir   string `json:"work_dir"`
+	SourceDir string `json:"source_dir"`
+	Variant   string `json:"variant,omitempty"`
+	// Target is the target triple nginx is cross-compiled for
+	Target string `json:"target,omitempty"`
+	// Variants is the variants built in the working directory
+	Variants  []string    `json:"variants,omitempty"`
+	Nginx     Component   `json:"nginx"`
+	Libraries []Component `json:"libraries,omitempty"`
+	Modules   []string    `json:"modules,omitempty"`
+	// ConfigureScript is the configure script generated
+	ConfigureScript string `json:"configure_script"`
+	Fingerprint     string `json:"fingerprint"`
+	// Fetched is true when the source of nginx exists in the working directory
+	Fetched bool `json:"fetched"`
+	// BinaryPath is the path of nginx built when it exists
+	BinaryPath string `json:"binary_path,omitempty"`
+	// Manifest is the build manifest of the last build when it exists
+	Manifest *Manifest `json:"manifest,omitempty"`
+	// Patched is the states of the source directories patched and reverted by the next build
+	Patched []*PatchState `json:"patched,omitempty"`
+}
+
+// Resolve resolves spec into the plan of the build without downloading or building anything.
+func Resolve(spec Spec) (Plan, error) {
+	var plan Plan
+
+	b, err := newBuild(spec)
+	if err != nil {
+		return plan, err
+	}
+
+	plan.Flavor = b.nginx.FlavorName()
+	plan.Version = b.nginx.Version
+	plan.WorkDir = b.workDir
+	plan.SourceDir = b.srcDir
+	plan.Variant = spec.Variant
+	plan.Target = spec.targetTriple()
+	plan.Variants, err = ListVariants(b.workDir, b.nginx.SourcePath())
+	if err != nil {
+		return plan, err
+	}
+	plan.Nginx = Component{Name: b.nginx.FlavorName(), Version: b.nginx.Version, DownloadURL: b.nginx.DownloadURL()}
+	for _, l := range b.libraries {
+		plan.Libraries = append(plan.Libraries, Component{Name: builder.MakeStaticLibrary(l).Name, Version: l.Version, DownloadURL: l.DownloadURL()})
+	}
+	for _, m := range spec.Modules {
+		plan.Modules = append(plan.Modules, moduleLabel(m))
+	}
+
+	plan.ConfigureScript = b.configureScript()
+	plan.Fingerprint = fingerprint(plan.ConfigureScript, spec.Modules, spec.Patch, spec.PatchOption)
+
+	plan.Fetched = util.FileExists(b.pristineDir)
+	if b.nginx.BinaryPath() != "" {
+		if binaryPath := filepath.Join(b.srcDir, b.nginx.BinaryPath()); util.FileExists(binaryPath) {
+			plan.BinaryPath = binaryPath
+		}
+	}
+	if manifest, err := LoadManifest(b.outputDir()); err == nil {
+		plan.Manifest = &manifest
+	}
+	plan.Patched, err = b.loadPatchStates()
+	if err != nil {
+		return plan, err
+	}
+
+	return plan, nil
+}
+
+func moduleLabel(m module3rd.Module3rd) string {
+	if m.Rev != "" {
+		return m.Name + "@" + m.Rev
+	}
+	return m.Name
+}
@@ -0,0 +1,116 @@
+package nginxbuild
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/sbom"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+func sbomComponent(kind int, b *builder.Builder, archiveDir string) (sbom.Component, error) {
+	c := sbom.Component{
+		Kind:        kind,
+		Name:        b.FlavorName(),
+		Version:     b.Version,
+		DownloadURL: b.DownloadURL(),
+	}
+	if kind == sbom.KindLibrary {
+		c.Name = builder.MakeStaticLibrary(b).Name
+	}
+	c.License = sbom.License(c.Name, c.Version)
+
+	archivePath := filepath.Join(archiveDir, b.ArchivePath())
+	if util.FileExists(archivePath) {
+		var err error
+		c.SHA256, c.SHA1, err = sbom.Checksums(archivePath)
+		if err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// makeSBOM collects the components of the build into a software bill of materials.
+func makeSBOM(workDir, archiveDir, rootDir string, nginxBuilder *builder.Builder, libraries []*builder.Builder, modules3rd []module3rd.Module3rd, patchPath string) (sbom.Document, error) {
+	doc := sbom.Document{
+		Name:    nginxBuilder.SourcePath(),
+		Tool:    "nginx-build-" + Version(),
+		Created: time.Now(),
+	}
+
+	c, err := sbomComponent(sbom.KindFlavor, nginxBuilder, archiveDir)
+	if err != nil {
+		return doc, err
+	}
+	doc.Components = append(doc.Components, c)
+
+	for _, l := range libraries {
+		c, err := sbomComponent(sbom.KindLibrary, l, archiveDir)
+		if err != nil {
+			return doc, err
+		}
+		doc.Components = append(doc.Components, c)
+	}
+
+	for _, m := range modules3rd {
+		c := sbom.Component{
+			Kind:    sbom.KindModule,
+			Name:    m.Name,
+			License: sbom.License(m.Name, ""),
+		}
+		if m.Form != "local" {
+			c.DownloadURL = m.Url
+			c.VCS = m.Form
+			c.Commit, err = module3rd.ResolvedRev(m, workDir)
+			if err != nil {
+				return doc, err
+			}
+		}
+		doc.Components = append(doc.Components, c)
+	}
+
+	patches, err := util.PatchPaths(patchPath, rootDir)
+	if err != nil {
+		return doc, err
+	}
+	for _, p := range patches {
+		c := sbom.Component{
+			Kind: sbom.KindPatch,
+			Name: p,
+		}
+		c.SHA256, c.SHA1, err = sbom.Checksums(p)
+		if err != nil {
+			return doc, err
+		}
+		doc.Components = append(doc.Components, c)
+	}
+
+	return doc, nil
+}
+
+func writeSBOM(doc *sbom.Document, spdxPath, cycloneDXPath string) error {
+	if spdxPath != "" {
+		b, err := doc.SPDX()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(spdxPath, b, 0644); err != nil {
+			return fmt.Errorf("failed to write SPDX: %w", err)
+		}
+	}
+	if cycloneDXPath != "" {
+		b, err := doc.CycloneDX()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(cycloneDXPath, b, 0644); err != nil {
+			return fmt.Errorf("failed to write CycloneDX: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package nginxbuild
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/configure"
+	"github.com/cubicdaiya/nginx-build/cross"
+	"github.com/cubicdaiya/nginx-build/module3rd"
+	"github.com/cubicdaiya/nginx-build/nginxtests"
+	"github.com/cubicdaiya/nginx-build/openresty"
+	"github.com/cubicdaiya/nginx-build/packaging"
+	"github.com/cubicdaiya/nginx-build/smoke"
+)
+
+// Library is a library built statically into nginx.
+type Library struct {
+	Static  bool   `json:"static"`
+	Version string `json:"version,omitempty"`
+}
+
+// Spec is the specification of a build of nginx.
+type Spec struct {
+	// WorkDir is the parent working directory. The build runs in WorkDir/<flavor>/<version>.
+	WorkDir string
+	// BaseDir is the directory relative paths such as patches and --add-module are resolved from.
+	// The current directory is used when it is empty.
+	BaseDir string
+
+	// Flavor is one of builder.Flavors. nginx is used when it is empty.
+	Flavor string
+	// Version is the version of the flavor. The default version of the flavor is used when it is empty.
+	Version string
+
+	Pcre     Library
+	OpenSSL  Library
+	LibreSSL Library
+	Zlib     Library
+
+	// Configure is the contents of a configure script for building nginx
+	Configure        string
+	ConfigureOptions configure.Options
+	Modules          []module3rd.Module3rd
+	// OpenResty is the OpenResty's unique configure options
+	OpenResty *openresty.Options
+	// Target is the target nginx and the static libraries are cross-compiled for. nginx is built for the host when it is nil.
+	Target *cross.Target
+
+	// Variant is the name of a build variant. A variant is built in its own copy of the source code
+	// such as <WorkDir>/nginx/1.28.0/nginx-1.28.0+debug and the source code extracted is never patched.
+	Variant string
+
+	// Patch is the comma-separated paths of patches and PatchOption is the option for patch
+	Patch       string
+	PatchOption string
+	// PatchDryRun checks the patches apply to the source code and stops the build without changing it
+	PatchDryRun bool
+
+	// DownloadCache is the directory the archives are downloaded into and extracted from.
+	// The archives are downloaded into the working directory when it is empty.
+	DownloadCache string
+	Jobs          int
+	Verbose       bool
+	Clear         bool
+	// ClearScopes removes the parts of the working directory such as ClearModules instead of the whole by Clear
+	ClearScopes []string
+	Idempotent  bool
+	// FetchOnly stops the build after downloading and extracting the sources and checking out the revisions of the modules
+	FetchOnly bool
+	// FetchShprov runs shprov of the modules and FetchPatch applies the patches in fetching only
+	FetchShprov bool
+	FetchPatch  bool
+	// Bundle is the output path of a bundle (tar.gz) of the sources fetched in fetching only
+	Bundle string
+	// FromBundle is the path of a bundle the build is made from.
+	// The flavor, the versions, the modules and the patches come from the bundle and nothing is downloaded.
+	FromBundle string
+	// Offline fails the build instead of downloading the sources which do not exist
+	Offline bool
+	// LockTimeout is the time waiting for the locks of the working directory and the download cache
+	// held by the other builds. It waits until the context is done when it is zero.
+	LockTimeout   time.Duration
+	ConfigureOnly bool
+
+	// SmokeTest starts nginx built on localhost and checks it responds
+	SmokeTest bool
+	// Fixtures is the directory of the fixtures run against nginx built (see smoke.LoadFixtures)
+	Fixtures string
+	// NginxTests is a local checkout of nginx-tests run against nginx built.
+	// It is skipped when nginx is not built by nginx-build such as configuring only and OpenResty.
+	NginxTests string
+	// NginxTestsPattern is the comma-separated glob patterns of the test files in NginxTests (default: *.t)
+	NginxTestsPattern string
+
+	Install bool
+	// DestDir is the staging root for installing nginx
+	DestDir           string
+	InstallModulesDir string
+
+	// Package is the format of a package built from the installed nginx (deb, rpm, tar.gz)
+	Package     string
+	PackageSpec packaging.Spec
+	// OCIPath is the output path of an OCI image layout tarball of the installed nginx
+	OCIPath string
+	OCIBase string
+
+	SBOMSPDX      string
+	SBOMCycloneDX string
+
+	// Logger receives the progress of the build. log.Default() is used when it is nil.
+	Logger *log.Logger
+	// Events receives the events of the build such as the start and the finish of the stages.
+	// It is never called concurrently.
+	Events func(Event)
+}
+
+// Result is the result of a build of nginx.
+type Result struct {
+	// Version is the version of the flavor built
+	Version string
+	// WorkDir is the versioned working directory such as <WorkDir>/nginx/1.28.0
+	WorkDir   string
+	SourceDir string
+	// BinaryPath is the path of nginx built. It is empty when the binary is not built by nginx-build.
+	BinaryPath          string
+	ConfigureScript     string
+	Fingerprint         string
+	InstalledBinaryPath string
+	InstalledModules    []string
+	PackagePath         string
+	OCIPath             string
+	// InstalledVersionInfo is the output of `nginx -V` for verifying the installed nginx
+	InstalledVersionInfo string
+	// Skipped is true when the installed nginx is same as Spec with Idempotent
+	Skipped bool
+	// SmokeTest is the report of the smoke test
+	SmokeTest *smoke.Report
+	// Fixtures is the report of the fixtures
+	Fixtures *smoke.Report
+	// NginxTests is the summary of nginx-tests. It is nil when nginx-tests is skipped.
+	NginxTests *nginxtests.Summary
+	// ManifestPath is the path of the build manifest
+	ManifestPath string
+	// BundlePath is the path of the bundle written in fetching only
+	BundlePath string
+	// Timings is the time spent in each stage in order of the start
+	Timings []Timing
+}
+
+// StageError is an error occurred in a stage of a build.
+type StageError struct {
+	Stage     string
+	Component string
+	// LogPath is the log file of the stage. It is empty when the output is not logged.
+	LogPath string
+	Err     error
+}
+
+func (e *StageError) Error() string {
+	if e.Component == "" {
+		return fmt.Sprintf("%s: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %v", e.Stage, e.Component, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+func (spec *Spec) logger() *log.Logger {
+	if spec.Logger == nil {
+		return log.Default()
+	}
+	return spec.Logger
+}
+
+// targetTriple returns the target triple of cross-compiling, which is empty for the host.
+func (spec *Spec) targetTriple() string {
+	if spec.Target == nil {
+		return ""
+	}
+	return spec.Target.Triple
+}
+
+// DiscardLogger is a logger discarding the progress of the build.
+var DiscardLogger = log.New(io.Discard, "", 0)
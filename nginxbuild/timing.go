@@ -0,0 +1,152 @@
+package nginxbuild
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Timing is the time spent in a stage of a build.
+type Timing struct {
+	Stage     string
+	Component string
+	Start     time.Time
+	// Wall is the wall-clock time of the stage
+	Wall time.Duration
+	// CPU is the CPU time (user and system) of the commands run in the stage
+	CPU time.Duration
+}
+
+type timingJSON struct {
+	Stage     string    `json:"stage"`
+	Component string    `json:"component,omitempty"`
+	Start     time.Time `json:"start"`
+	Wall      float64   `json:"wall"`
+	CPU       float64   `json:"cpu"`
+}
+
+// MarshalJSON encodes the timing with the durations in seconds.
+func (t Timing) MarshalJSON() ([]byte, error) {
+	return json.Marshal(timingJSON{
+		Stage:     t.Stage,
+		Component: t.Component,
+		Start:     t.Start,
+		Wall:      t.Wall.Seconds(),
+		CPU:       t.CPU.Seconds(),
+	})
+}
+
+// UnmarshalJSON decodes the timing encoded by MarshalJSON.
+func (t *Timing) UnmarshalJSON(data []byte) error {
+	var v timingJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	t.Stage = v.Stage
+	t.Component = v.Component
+	t.Start = v.Start
+	t.Wall = time.Duration(v.Wall * float64(time.Second))
+	t.CPU = time.Duration(v.CPU * float64(time.Second))
+	return nil
+}
+
+// sortTimings sorts the timings by the start time.
+func sortTimings(timings []Timing) []Timing {
+	sorted := append([]Timing(nil), timings...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+	return sorted
+}
+
+// WriteTimingTable writes the summary table of the timings into w.
+// The total of each stage follows the timings. The stages running in parallel overlap in the total.
+func WriteTimingTable(w io.Writer, timings []Timing) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "stage\tcomponent\twall\tcpu")
+
+	var (
+		stages []string
+		wall   = make(map[string]time.Duration)
+		cpu    = make(map[string]time.Duration)
+	)
+	for _, t := range sortTimings(timings) {
+		fmt.Fprintf(tw, "%s\t%s\t%.2fs\t%.2fs\n", t.Stage, t.Component, t.Wall.Seconds(), t.CPU.Seconds())
+		if _, ok := wall[t.Stage]; !ok {
+			stages = append(stages, t.Stage)
+		}
+		wall[t.Stage] += t.Wall
+		cpu[t.Stage] += t.CPU
+	}
+
+	for _, stage := range stages {
+		fmt.Fprintf(tw, "%s\t%s\t%.2fs\t%.2fs\n", stage, "(total)", wall[stage].Seconds(), cpu[stage].Seconds())
+	}
+	return tw.Flush()
+}
+
+type traceEvent struct {
+	Name      string            `json:"name"`
+	Category  string            `json:"cat"`
+	Phase     string            `json:"ph"`
+	Timestamp int64             `json:"ts"`
+	Duration  int64             `json:"dur,omitempty"`
+	Pid       int               `json:"pid"`
+	Tid       int               `json:"tid"`
+	Args      map[string]string `json:"args,omitempty"`
+}
+
+// WriteTrace writes the timings into w in the Chrome trace event format.
+// Each component has its own track so that the stages running in parallel are shown side by side.
+// It is viewable with chrome://tracing or Perfetto.
+func WriteTrace(w io.Writer, timings []Timing) error {
+	sorted := sortTimings(timings)
+
+	var events []traceEvent
+	tids := make(map[string]int)
+	var origin time.Time
+	if len(sorted) > 0 {
+		origin = sorted[0].Start
+	}
+	for _, t := range sorted {
+		track := t.Component
+		if track == "" {
+			track = t.Stage
+		}
+		tid, ok := tids[track]
+		if !ok {
+			tid = len(tids) + 1
+			tids[track] = tid
+			events = append(events, traceEvent{
+				Name:  "thread_name",
+				Phase: "M",
+				Pid:   1,
+				Tid:   tid,
+				Args:  map[string]string{"name": track},
+			})
+		}
+		events = append(events, traceEvent{
+			Name:      t.Stage,
+			Category:  t.Stage,
+			Phase:     "X",
+			Timestamp: t.Start.Sub(origin).Microseconds(),
+			Duration:  t.Wall.Microseconds(),
+			Pid:       1,
+			Tid:       tid,
+			Args: map[string]string{
+				"component": t.Component,
+				"cpu":       fmt.Sprintf("%.3fs", t.CPU.Seconds()),
+			},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+}
@@ -0,0 +1,124 @@
+package nginxbuild
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/cross"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// variantSeparator separates the source directory and the variant such as nginx-1.28.0+debug.
+const variantSeparator = "+"
+
+var variantRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func validateVariant(variant string) error {
+	if variant != "" && !variantRe.MatchString(variant) {
+		return fmt.Errorf("variant %s is invalid (letters, digits, '.', '_' and '-' are available)", variant)
+	}
+	return nil
+}
+
+// variantDir returns the source directory of the variant in workDir.
+// It is next to the source directory extracted so that the static libraries and the modules are found in the same relative paths.
+func variantDir(workDir, sourcePath, variant string) string {
+	if variant == "" {
+		return filepath.Join(workDir, sourcePath)
+	}
+	return filepath.Join(workDir, sourcePath+variantSeparator+variant)
+}
+
+// ListVariants lists the variants built in the versioned working directory in order of the names.
+func ListVariants(workDir, sourcePath string) ([]string, error) {
+	names, err := os.ReadDir(workDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var variants []string
+	for _, d := range names {
+		variant, ok := strings.CutPrefix(d.Name(), sourcePath+variantSeparator)
+		if ok && d.IsDir() && variantRe.MatchString(variant) {
+			variants = append(variants, variant)
+		}
+	}
+	sort.Strings(variants)
+	return variants, nil
+}
+
+// outputDir returns the directory the build manifest and the package root of the build are written into.
+func (b *build) outputDir() string {
+	if b.spec.Variant == "" {
+		return b.workDir
+	}
+	return b.srcDir
+}
+
+// variantExcluded is the files made in the source directory extracted by the builds without a variant, which are not copied into the variants.
+var variantExcluded = map[string]bool{
+	"objs":                true,
+	"Makefile":            true,
+	"build":               true, // the modules of OpenResty configured
+	"nginx-configure":     true,
+	"nginx-configure.log": true,
+	"nginx-build.log":     true,
+	"nginx-install.log":   true,
+	"nginx-tests.log":     true,
+	PatchStateDir:         true,
+}
+
+// copyVariant copies the source code extracted into the source directory of the variant unless it exists.
+// The source code patched or rewritten for cross-compiling by a build without a variant is not copied.
+func (b *build) copyVariant(ctx context.Context) error {
+	if b.spec.Variant == "" || util.FileExists(b.srcDir) {
+		return nil
+	}
+	b.spec.logger().Printf("Copy %s into %s.....", b.nginx.SourcePath(), filepath.Base(b.srcDir))
+
+	return b.runStage(ctx, StageVariant, b.spec.Variant, func(ctx context.Context, ev *Event) error {
+		if err := b.checkPristine(); err != nil {
+			return stageError(ctx, StageVariant, b.spec.Variant, "", err)
+		}
+		tmpDir := b.srcDir + ".tmp"
+		if err := os.RemoveAll(tmpDir); err != nil {
+			return err
+		}
+		err := util.CopyTreeExcept(b.pristineDir, tmpDir, func(rel string) bool {
+			return variantExcluded[rel]
+		})
+		if err != nil {
+			os.RemoveAll(tmpDir)
+			return stageError(ctx, StageVariant, b.spec.Variant, "", err)
+		}
+		return os.Rename(tmpDir, b.srcDir)
+	})
+}
+
+// checkPristine checks the source code extracted is not changed by a build without a variant.
+func (b *build) checkPristine() error {
+	name := b.nginx.SourcePath()
+	if util.FileExists(filepath.Join(b.pristineDir, PatchStateDir)) {
+		return fmt.Errorf("%s is patched by a build without a variant. Remove it with -clear or -clear-scope %s", name, ClearNginx)
+	}
+	// the configure script of OpenResty is not rewritten
+	if b.nginx.Component == builder.ComponentOpenResty {
+		return nil
+	}
+	prepared, err := cross.Prepared(b.pristineDir)
+	if err != nil {
+		return err
+	}
+	if prepared {
+		return fmt.Errorf("%s is rewritten for cross-compiling by a build without a variant. Remove it with -clear or -clear-scope %s", name, ClearNginx)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package nginxbuild
+
+import (
+	"runtime/debug"
+)
+
+const modulePath = "github.com/cubicdaiya/nginx-build"
+
+// NginxBuildVersion is the version of nginx-build recorded in the artifacts such as SBOM.
+// It is resolved from the build information when it is empty.
+var NginxBuildVersion string
+
+// Version returns the version of nginx-build.
+func Version() string {
+	if NginxBuildVersion != "" {
+		return NginxBuildVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "(devel)"
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, d := range info.Deps {
+		if d.Path == modulePath {
+			return d.Version
+		}
+	}
+	return "(devel)"
+}
@@ -0,0 +1,213 @@
+package nginxtests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cubicdaiya/nginx-build/command"
+)
+
+// DefaultPattern is the pattern of the test files run by default.
+const DefaultPattern = "*.t"
+
+// Suite is a local checkout of nginx-tests (https://github.com/nginx/nginx-tests) run against nginx.
+type Suite struct {
+	// Dir is the directory of the checkout
+	Dir string
+	// BinaryPath is the path of nginx tested
+	BinaryPath string
+	// ModulesDir is the directory of the dynamic modules
+	ModulesDir string
+	// Pattern is the comma-separated glob patterns of the test files such as "ssl*.t,http2*.t"
+	Pattern string
+	// Jobs is the number of the test files run in parallel
+	Jobs int
+	// Log receives the output of the test files. It may be nil.
+	Log io.Writer
+}
+
+// Summary is the result of nginx-tests.
+type Summary struct {
+	Files []File
+}
+
+// Totals is the numbers of the test files and the tests in a summary.
+type Totals struct {
+	Files       int      `json:"files"`
+	Tests       int      `json:"tests"`
+	Passed      int      `json:"passed"`
+	Failed      int      `json:"failed"`
+	Skipped     int      `json:"skipped"`
+	Todo        int      `json:"todo"`
+	FailedFiles []string `json:"failed_files,omitempty"`
+}
+
+// Totals returns the numbers of the test files and the tests.
+func (s *Summary) Totals() Totals {
+	t := Totals{Files: len(s.Files)}
+	for _, f := range s.Files {
+		t.Tests += f.Run
+		t.Passed += f.Passed
+		t.Failed += f.Failed
+		t.Skipped += f.Skipped
+		t.Todo += f.Todo
+		if !f.OK() {
+			t.FailedFiles = append(t.FailedFiles, f.Name)
+		}
+	}
+	return t
+}
+
+// Passed reports whether all the test files passed.
+func (s *Summary) Passed() bool {
+	for _, f := range s.Files {
+		if !f.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Files returns the test files in dir matched with the comma-separated glob patterns.
+func Files(dir, pattern string) ([]string, error) {
+	if pattern == "" {
+		pattern = DefaultPattern
+	}
+
+	seen := make(map[string]bool)
+	var files []string
+	for _, p := range strings.Split(pattern, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(dir, p))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", p, err)
+		}
+		for _, m := range matches {
+			name := filepath.Base(m)
+			if !strings.HasSuffix(name, ".t") || seen[name] {
+				continue
+			}
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no test files matched with %s in %s", pattern, dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Run runs the test files with perl and parses their TAP output.
+// The returned error is not nil when a test file failed or nginx-tests could not run.
+func (s *Suite) Run(ctx context.Context) (Summary, error) {
+	var summary Summary
+
+	if _, err := exec.LookPath("perl"); err != nil {
+		return summary, errors.New("nginx-tests requires perl")
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, "lib", "Test", "Nginx.pm")); err != nil {
+		return summary, fmt.Errorf("%s is not a checkout of nginx-tests", s.Dir)
+	}
+	names, err := Files(s.Dir, s.Pattern)
+	if err != nil {
+		return summary, err
+	}
+
+	jobs := s.Jobs
+	if jobs <= 0 {
+		jobs = 1
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		sem   = make(chan struct{}, jobs)
+		files = make([]File, len(names))
+		errs  = make([]error, len(names))
+	)
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			}
+			defer func() { <-sem }()
+
+			var output bytes.Buffer
+			files[i], errs[i] = s.runFile(ctx, name, &output)
+			if s.Log != nil {
+				mu.Lock()
+				fmt.Fprintf(s.Log, "=== %s\n", name)
+				s.Log.Write(output.Bytes())
+				mu.Unlock()
+			}
+		}(i, name)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return summary, err
+	}
+	for i := range names {
+		if errs[i] != nil {
+			return summary, errs[i]
+		}
+	}
+	summary.Files = files
+
+	if !summary.Passed() {
+		totals := summary.Totals()
+		return summary, fmt.Errorf("%d of %d test files failed: %s", len(totals.FailedFiles), totals.Files, strings.Join(totals.FailedFiles, ", "))
+	}
+	return summary, nil
+}
+
+func (s *Suite) runFile(ctx context.Context, name string, output io.Writer) (File, error) {
+	cmd, err := command.Make(ctx, s.Dir, []string{"perl", name})
+	if err != nil {
+		return File{}, err
+	}
+	cmd.Env = append(os.Environ(), "TEST_NGINX_BINARY="+s.BinaryPath)
+	if s.ModulesDir != "" {
+		cmd.Env = append(cmd.Env, "TEST_NGINX_MODULES="+s.ModulesDir)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&stdout, output)
+	cmd.Stderr = output
+
+	runErr := command.Exec(ctx, cmd)
+	if ctx.Err() != nil {
+		return File{}, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return File{}, fmt.Errorf("%s: %w", name, runErr)
+	}
+
+	f, err := Parse(name, &stdout)
+	if err != nil {
+		return f, fmt.Errorf("%s: %w", name, err)
+	}
+	if exitErr != nil {
+		f.ExitCode = exitErr.ExitCode()
+	}
+	return f, nil
+}
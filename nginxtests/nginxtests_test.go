@@ -0,0 +1,128 @@
+package nginxtests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   File
+		ok     bool
+	}{
+		{
+			name: "passed",
+			output: `1..3
+ok 1 - http
+ok 2 - https # skip no ssl
+ok 3 - chunked # TODO not yet
+`,
+			want: File{Name: "passed", Planned: 3, Run: 3, Passed: 1, Skipped: 1, Todo: 1},
+			ok:   true,
+		},
+		{
+			name: "failed",
+			output: `ok 1 - http
+not ok 2 - proxy
+#   Failed test 'proxy'
+not ok 3
+1..3
+`,
+			want: File{Name: "failed", Planned: 3, Run: 3, Passed: 1, Failed: 2, Failures: []string{"proxy", "test 3"}},
+			ok:   false,
+		},
+		{
+			name:   "skip all",
+			output: "1..0 # SKIP no grpc available\n",
+			want:   File{Name: "skip all", Planned: 0, SkipAll: "no grpc available"},
+			ok:     true,
+		},
+		{
+			name:   "bail out",
+			output: "1..2\nok 1\nBail out! cannot start nginx\n",
+			want:   File{Name: "bail out", Planned: 2, Run: 1, Passed: 1, BailOut: "cannot start nginx"},
+			ok:     false,
+		},
+		{
+			name:   "missing tests",
+			output: "1..2\nok 1\n",
+			want:   File{Name: "missing tests", Planned: 2, Run: 1, Passed: 1},
+			ok:     false,
+		},
+		{
+			name:   "no plan",
+			output: "ok 1\n",
+			want:   File{Name: "no plan", Planned: -1, Run: 1, Passed: 1},
+			ok:     false,
+		},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.name, strings.NewReader(test.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s got: %+v, want: %+v", test.name, got, test.want)
+		}
+		if got.OK() != test.ok {
+			t.Fatalf("%s OK got: %v, want: %v (%s)", test.name, got.OK(), test.ok, got.Problem())
+		}
+	}
+}
+
+func TestTotals(t *testing.T) {
+	summary := Summary{Files: []File{
+		{Name: "a.t", Planned: 2, Run: 2, Passed: 2},
+		{Name: "b.t", Planned: 3, Run: 3, Passed: 1, Failed: 1, Skipped: 1},
+		{Name: "c.t", Planned: 0, SkipAll: "no ssl"},
+	}}
+
+	want := Totals{Files: 3, Tests: 5, Passed: 3, Failed: 1, Skipped: 1, FailedFiles: []string{"b.t"}}
+	if got := summary.Totals(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %+v, want: %+v", got, want)
+	}
+	if summary.Passed() {
+		t.Fatalf("got: %v, want: %v", true, false)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"ssl.t", "ssl_verify.t", "proxy.t", "http2.t", "README"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"", []string{"http2.t", "proxy.t", "ssl.t", "ssl_verify.t"}},
+		{"ssl*.t", []string{"ssl.t", "ssl_verify.t"}},
+		{"proxy.t, ssl*, proxy*", []string{"proxy.t", "ssl.t", "ssl_verify.t"}},
+		{"grpc*.t", nil},
+	}
+
+	for _, test := range tests {
+		got, err := Files(dir, test.pattern)
+		if test.want == nil {
+			if err == nil {
+				t.Fatalf("%s got: %v, want: error", test.pattern, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("%s got: %v, want: %v", test.pattern, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package nginxtests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	planRe   = regexp.MustCompile(`^1\.\.(\d+)(?:\s*#\s*(?i:skip)\S*\s*(.*))?`)
+	resultRe = regexp.MustCompile(`^(not )?ok\b\s*(\d*)\s*(?:-\s*)?([^#]*?)\s*(?:#\s*(\w+)\S*\s*(.*))?$`)
+)
+
+// File is the result of a test file.
+type File struct {
+	Name string
+	// Planned is the number of the tests planned. It is -1 without a plan.
+	Planned int
+	Run     int
+	Passed  int
+	Failed  int
+	Skipped int
+	// Todo is the number of the tests marked TODO. They do not fail the file.
+	Todo int
+	// SkipAll is the reason of skipping the whole file such as a module not built
+	SkipAll string
+	// BailOut is the reason of bailing out
+	BailOut string
+	// Failures is the descriptions of the tests failed
+	Failures []string
+	ExitCode int
+}
+
+// OK reports whether the test file passed.
+func (f *File) OK() bool {
+	if f.BailOut != "" || f.Failed > 0 {
+		return false
+	}
+	if f.SkipAll != "" {
+		return true
+	}
+	return f.ExitCode == 0 && f.Planned >= 0 && f.Run == f.Planned
+}
+
+// Problem returns why the test file did not pass.
+func (f *File) Problem() string {
+	switch {
+	case f.OK():
+		return ""
+	case f.BailOut != "":
+		return "bail out: " + f.BailOut
+	case f.Failed > 0:
+		return fmt.Sprintf("failed %d/%d: %s", f.Failed, f.Run, strings.Join(f.Failures, ", "))
+	case f.Planned < 0:
+		return "no plan"
+	case f.Run != f.Planned:
+		return fmt.Sprintf("planned %d tests but ran %d", f.Planned, f.Run)
+	default:
+		return fmt.Sprintf("exit status %d", f.ExitCode)
+	}
+}
+
+// Parse parses TAP output of a test file.
+func Parse(name string, r io.Reader) (File, error) {
+	f := File{Name: name, Planned: -1}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, "Bail out!") {
+			f.BailOut = strings.TrimSpace(strings.TrimPrefix(line, "Bail out!"))
+			if f.BailOut == "" {
+				f.BailOut = "(no reason)"
+			}
+			continue
+		}
+		if m := planRe.FindStringSubmatch(line); m != nil {
+			f.Planned, _ = strconv.Atoi(m[1])
+			if f.Planned == 0 {
+				f.SkipAll = strings.TrimSpace(m[2])
+				if f.SkipAll == "" {
+					f.SkipAll = "(no reason)"
+				}
+			}
+			continue
+		}
+		m := resultRe.FindStringSubmatch(line)
+		if m == nil {
+			// diagnostics and the others
+			continue
+		}
+		f.Run++
+		notOK := m[1] != ""
+		directive := strings.ToUpper(m[4])
+		switch {
+		case strings.HasPrefix(directive, "TODO"):
+			f.Todo++
+		case strings.HasPrefix(directive, "SKIP"):
+			f.Skipped++
+		case notOK:
+			f.Failed++
+			desc := m[3]
+			if desc == "" {
+				desc = "test " + m[2]
+			}
+			f.Failures = append(f.Failures, desc)
+		default:
+			f.Passed++
+		}
+	}
+
+	return f, scanner.Err()
+}
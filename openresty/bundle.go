@@ -0,0 +1,44 @@
+package openresty
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Component is a component bundled in an OpenResty source distribution.
+type Component struct {
+	Name    string
+	Version string
+}
+
+// parseBundleEntry splits an entry of bundle/ such as lua-resty-core-0.1.28 into its name and version.
+// The version starts after the first hyphen followed by a digit.
+func parseBundleEntry(entry string) Component {
+	for i := 0; i < len(entry)-1; i++ {
+		if entry[i] == '-' && entry[i+1] >= '0' && entry[i+1] <= '9' {
+			return Component{Name: entry[:i], Version: entry[i+1:]}
+		}
+	}
+	return Component{Name: entry}
+}
+
+// Bundled lists the components in bundle/ of an extracted OpenResty source distribution.
+func Bundled(sourcePath string) ([]Component, error) {
+	entries, err := os.ReadDir(filepath.Join(sourcePath, "bundle"))
+	if err != nil {
+		return nil, err
+	}
+
+	var components []Component
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		components = append(components, parseBundleEntry(e.Name()))
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+	return components, nil
+}
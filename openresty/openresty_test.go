@@ -35,3 +35,93 @@ func TestOpenrestyName(t *testing.T) {
 		}
 	}
 }
+
+func TestCompareVersion(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{a: "1.15.8.1", b: "1.15.8.1", want: 0},
+		{a: "1.13.6.2", b: "1.15.8.1", want: -1},
+		{a: "1.27.1.2", b: "1.9.7.3", want: 1},
+		{a: "1.15.8.1rc1", b: "1.15.8.1", want: 0},
+	}
+
+	for _, test := range tests {
+		got := CompareVersion(test.a, test.b)
+		if got != test.want {
+			t.Fatalf("CompareVersion(%v, %v) got: %v, want: %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		version string
+		options Options
+		valid   bool
+	}{
+		{
+			version: "1.27.1.2",
+			options: Options{Without: []string{"lua_resty_mysql", "lua_resty_signal"}},
+			valid:   true,
+		},
+		{
+			version: "1.27.1.2",
+			options: Options{With: []string{"http_postgres_module"}, PcreJIT: true},
+			valid:   true,
+		},
+		{
+			// lua_resty_signal is bundled since 1.15.8.1
+			version: "1.13.6.2",
+			options: Options{Without: []string{"lua_resty_signal"}},
+			valid:   false,
+		},
+		{
+			// lua_resty_core is mandatory since 1.15.8.1
+			version: "1.27.1.2",
+			options: Options{Without: []string{"lua_resty_core"}},
+			valid:   false,
+		},
+		{
+			version: "1.27.1.2",
+			options: Options{With: []string{"lua_cjson"}},
+			valid:   false,
+		},
+		{
+			version: "1.27.1.2",
+			options: Options{Without: []string{"lua_unknown"}},
+			valid:   false,
+		},
+	}
+
+	for _, test := range tests {
+		err := test.options.Validate(test.version)
+		if test.valid && err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !test.valid && err == nil {
+			t.Fatalf("%+v must be invalid for %v", test.options, test.version)
+		}
+	}
+}
+
+func TestParseBundleEntry(t *testing.T) {
+	tests := []struct {
+		entry string
+		want  Component
+	}{
+		{entry: "ngx_lua-0.10.27", want: Component{Name: "ngx_lua", Version: "0.10.27"}},
+		{entry: "lua-resty-core-0.1.30", want: Component{Name: "lua-resty-core", Version: "0.1.30"}},
+		{entry: "LuaJIT-2.1-20250117", want: Component{Name: "LuaJIT", Version: "2.1-20250117"}},
+		{entry: "install", want: Component{Name: "install"}},
+	}
+
+	for _, test := range tests {
+		got := parseBundleEntry(test.entry)
+		if got != test.want {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package openresty
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// Options is the set of configure options unique to OpenResty.
+type Options struct {
+	// LuaJIT is the directory of an external LuaJIT installation for --with-luajit
+	LuaJIT string
+	// LuaJITXCFlags is the value of --with-luajit-xcflags
+	LuaJITXCFlags string
+	PcreJIT       bool
+	// With and Without are the bundled components toggled with --with-xxx and --without-xxx
+	With    []string
+	Without []string
+}
+
+// bundleToggle describes a bundled component which is toggled with --with-xxx or --without-xxx.
+type bundleToggle struct {
+	// DefaultOff is true when the component is disabled by default and is enabled with --with-xxx
+	DefaultOff bool
+	// Since and Until are the range of OpenResty versions having the toggle (empty means unbounded)
+	Since string
+	Until string
+}
+
+var bundleToggles = map[string]bundleToggle{
+	"lua51":                          {DefaultOff: true, Until: "1.13.6.2"},
+	"lua_cjson":                      {},
+	"lua_tablepool":                  {Since: "1.15.8.1"},
+	"lua_redis_parser":               {},
+	"lua_rds_parser":                 {},
+	"lua_resty_core":                 {Until: "1.13.6.2"},
+	"lua_resty_dns":                  {},
+	"lua_resty_lock":                 {},
+	"lua_resty_lrucache":             {},
+	"lua_resty_limit_traffic":        {},
+	"lua_resty_memcached":            {},
+	"lua_resty_mysql":                {},
+	"lua_resty_redis":                {},
+	"lua_resty_shell":                {Since: "1.15.8.1"},
+	"lua_resty_signal":               {Since: "1.15.8.1"},
+	"lua_resty_string":               {},
+	"lua_resty_upload":               {},
+	"lua_resty_upstream_healthcheck": {},
+	"lua_resty_websocket":            {},
+	"http_drizzle_module":            {DefaultOff: true},
+	"http_iconv_module":              {DefaultOff: true},
+	"http_postgres_module":           {DefaultOff: true},
+	"http_lua_module":                {},
+	"http_lua_upstream_module":       {},
+	"stream_lua_module":              {Since: "1.13.6.1"},
+	"ngx_devel_kit_module":           {},
+	"http_echo_module":               {},
+	"http_xss_module":                {},
+	"http_coolkit_module":            {},
+	"http_set_misc_module":           {},
+	"http_form_input_module":         {},
+	"http_encrypted_session_module":  {},
+	"http_srcache_module":            {},
+	"http_headers_more_module":       {},
+	"http_array_var_module":          {},
+	"http_memc_module":               {},
+	"http_redis2_module":             {},
+	"http_redis_module":              {},
+	"http_rds_json_module":           {},
+	"http_rds_csv_module":            {},
+	"stream_lua_upstream_module":     {Since: "1.13.6.1"},
+}
+
+// BundleToggles returns the names of bundled components which can be toggled.
+func BundleToggles() []string {
+	var names []string
+	for name := range bundleToggles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func versionInRange(version string, toggle bundleToggle) bool {
+	if toggle.Since != "" && CompareVersion(version, toggle.Since) < 0 {
+		return false
+	}
+	if toggle.Until != "" && CompareVersion(version, toggle.Until) > 0 {
+		return false
+	}
+	return true
+}
+
+// Validate checks the options against the OpenResty version being built.
+func (o *Options) Validate(version string) error {
+	var errs []string
+
+	check := func(name string, with bool) {
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "--with-"), "--without-")
+		toggle, ok := bundleToggles[name]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("%s is not a bundled component of OpenResty", name))
+			return
+		}
+		if !versionInRange(version, toggle) {
+			errs = append(errs, fmt.Sprintf("%s is not available in OpenResty %s", name, version))
+			return
+		}
+		if with && !toggle.DefaultOff {
+			errs = append(errs, fmt.Sprintf("%s is enabled by default. use --without-%s to disable it", name, name))
+		}
+		if !with && toggle.DefaultOff {
+			errs = append(errs, fmt.Sprintf("%s is disabled by default. use --with-%s to enable it", name, name))
+		}
+	}
+
+	for _, name := range o.With {
+		check(name, true)
+	}
+	for _, name := range o.Without {
+		check(name, false)
+	}
+
+	if o.LuaJITXCFlags != "" && o.LuaJIT != "" {
+		errs = append(errs, "--with-luajit-xcflags is ignored with an external LuaJIT (--with-luajit)")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid OpenResty options: %s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// ConfigureArgs returns the options as lines of a configure script.
+func (o *Options) ConfigureArgs() string {
+	result := ""
+	if o.LuaJIT != "" {
+		result += fmt.Sprintf("--with-luajit=%s \\\n", util.ShellQuote(o.LuaJIT))
+	}
+	if o.LuaJITXCFlags != "" {
+		result += fmt.Sprintf("--with-luajit-xcflags=%s \\\n", util.ShellQuote(o.LuaJITXCFlags))
+	}
+	if o.PcreJIT {
+		result += "--with-pcre-jit \\\n"
+	}
+	for _, name := range o.With {
+		result += fmt.Sprintf("--with-%s \\\n", strings.TrimPrefix(name, "--with-"))
+	}
+	for _, name := range o.Without {
+		result += fmt.Sprintf("--without-%s \\\n", strings.TrimPrefix(name, "--without-"))
+	}
+	return result
+}
@@ -0,0 +1,42 @@
+package openresty
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersion compares OpenResty versions such as 1.27.1.2 numerically.
+// It returns -1, 0 or 1. Suffixes such as rc1 are ignored.
+func CompareVersion(a, b string) int {
+	an := versionNumbers(a)
+	bn := versionNumbers(b)
+	for i := 0; i < len(an) || i < len(bn); i++ {
+		var x, y int
+		if i < len(an) {
+			x = an[i]
+		}
+		if i < len(bn) {
+			y = bn[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionNumbers(version string) []int {
+	version = versionPattern.FindString(version)
+	var numbers []int
+	for _, s := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			break
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"github.com/cubicdaiya/nginx-build/builder"
+	"github.com/cubicdaiya/nginx-build/nginxtests"
 )
 
 type Options struct {
@@ -42,6 +43,14 @@ func makeNginxBuildOptions() Options {
 		Desc:    "jobs to build nginx",
 		Default: runtime.NumCPU(),
 	}
+	argsNumber["keep"] = OptionNumber{
+		Desc:    "number of the most recently used versions of each flavor kept by gc (unlimited when it is 0)",
+		Default: 0,
+	}
+	argsNumber["matrix-parallel"] = OptionNumber{
+		Desc:    "number of the builds running at once in a build matrix",
+		Default: 2,
+	}
 
 	argsBool["verbose"] = OptionBool{
 		Desc: "verbose mode",
@@ -68,17 +77,41 @@ func makeNginxBuildOptions() Options {
 		Desc: "print nginx versions",
 	}
 	argsBool["openresty"] = OptionBool{
-		Desc: "download openresty instead of nginx",
+		Desc: "download openresty instead of nginx (alias of '-flavor openresty')",
 	}
 	argsBool["freenginx"] = OptionBool{
-		Desc: "download freenginx instead of nginx",
+		Desc: "download freenginx instead of nginx (alias of '-flavor freenginx')",
 	}
 	argsBool["configureonly"] = OptionBool{
 		Desc: "configure nginx only not building",
 	}
+	argsBool["smoke-test"] = OptionBool{
+		Desc: "start nginx built on localhost and check it responds",
+	}
+	argsBool["install"] = OptionBool{
+		Desc: "install nginx with 'make install' after building",
+	}
 	argsBool["idempotent"] = OptionBool{
 		Desc: "build nginx if already installed nginx version is different",
 	}
+	argsBool["openresty-pcre-jit"] = OptionBool{
+		Desc: "enable PCRE JIT for OpenResty (--with-pcre-jit)",
+	}
+	argsBool["openresty-bundle"] = OptionBool{
+		Desc: "print components bundled in OpenResty and exit",
+	}
+	argsBool["fetch-shprov"] = OptionBool{
+		Desc: "run shprov of 3rd party modules in fetching",
+	}
+	argsBool["patch-dry-run"] = OptionBool{
+		Desc: "check the patches apply to the source code without building",
+	}
+	argsBool["fetch-patch"] = OptionBool{
+		Desc: "apply patches in fetching",
+	}
+	argsBool["dry-run"] = OptionBool{
+		Desc: "print what gc removes without removing",
+	}
 	argsBool["help-all"] = OptionBool{
 		Desc: "print all flags",
 	}
@@ -99,6 +132,62 @@ func makeNginxBuildOptions() Options {
 		Desc:    "working directory",
 		Default: "",
 	}
+	argsString["output"] = OptionValue{
+		Desc:    "output format of the progress (text, json)",
+		Default: "text",
+	}
+	argsString["trace"] = OptionValue{
+		Desc:    "output path of the timings of the stages in Chrome trace event JSON",
+		Default: "",
+	}
+	argsString["fixtures"] = OptionValue{
+		Desc:    "directory of the fixtures (nginx.conf and fixture.json) run against nginx built",
+		Default: "",
+	}
+	argsString["nginx-tests"] = OptionValue{
+		Desc:    "local checkout of nginx-tests run against nginx built",
+		Default: "",
+	}
+	argsString["nginx-tests-glob"] = OptionValue{
+		Desc:    "comma-separated glob patterns of the test files in nginx-tests",
+		Default: nginxtests.DefaultPattern,
+	}
+	argsString["matrix-flavors"] = OptionValue{
+		Desc:    "comma-separated flavors built in a build matrix",
+		Default: "",
+	}
+	argsString["matrix-versions"] = OptionValue{
+		Desc:    "comma-separated versions built in a build matrix",
+		Default: "",
+	}
+	argsString["matrix-tls"] = OptionValue{
+		Desc:    "comma-separated TLS libraries built in a build matrix (system, openssl[-version], libressl[-version])",
+		Default: "",
+	}
+	argsString["good"] = OptionValue{
+		Desc:    "version known to pass in bisect",
+		Default: "",
+	}
+	argsString["bad"] = OptionValue{
+		Desc:    "version known to fail in bisect",
+		Default: "",
+	}
+	argsString["run"] = OptionValue{
+		Desc:    "command testing nginx built in bisect (exit status 125 skips the version)",
+		Default: "",
+	}
+	argsString["releases"] = OptionValue{
+		Desc:    "comma-separated versions searched in bisect instead of the releases in the download directory",
+		Default: "",
+	}
+	argsString["timeout"] = OptionValue{
+		Desc:    "timeout of the whole build such as 30m (no timeout when it is empty)",
+		Default: "",
+	}
+	argsString["lock-timeout"] = OptionValue{
+		Desc:    "time waiting for the working directory locked by another nginx-build such as 5m (wait until the timeout of the build when it is empty)",
+		Default: "",
+	}
 	argsString["pcreversion"] = OptionValue{
 		Desc:    "PCRE version",
 		Default: builder.PcreVersion,
@@ -123,12 +212,112 @@ func makeNginxBuildOptions() Options {
 		Desc:    "freenginx version",
 		Default: builder.FreenginxVersion,
 	}
+	argsString["angieversion"] = OptionValue{
+		Desc:    "angie version",
+		Default: builder.AngieVersion,
+	}
+	argsString["tengineversion"] = OptionValue{
+		Desc:    "tengine version",
+		Default: builder.TengineVersion,
+	}
+	argsString["flavor"] = OptionValue{
+		Desc:    "nginx flavor to build (nginx, openresty, freenginx, angie, tengine)",
+		Default: "nginx",
+	}
+	argsString["openresty-luajit"] = OptionValue{
+		Desc:    "external LuaJIT directory for OpenResty (--with-luajit)",
+		Default: "",
+	}
+	argsString["openresty-luajit-xcflags"] = OptionValue{
+		Desc:    "extra C compiler flags for LuaJIT bundled in OpenResty (--with-luajit-xcflags)",
+		Default: "",
+	}
+	argsString["openresty-with"] = OptionValue{
+		Desc:    "enable a component bundled in OpenResty (e.g. http_iconv_module)",
+		Default: "",
+	}
+	argsString["openresty-without"] = OptionValue{
+		Desc:    "disable a component bundled in OpenResty (e.g. lua_resty_mysql)",
+		Default: "",
+	}
+	argsString["destdir"] = OptionValue{
+		Desc:    "staging root directory (DESTDIR) for '-install'",
+		Default: "",
+	}
+	argsString["install-modules-dir"] = OptionValue{
+		Desc:    "directory for installing dynamic modules with '-install'",
+		Default: "",
+	}
+	argsString["package"] = OptionValue{
+		Desc:    "build a package (deb, rpm, tar.gz) from nginx installed into a staging root",
+		Default: "",
+	}
+	argsString["package-spec"] = OptionValue{
+		Desc:    "configuration file for package metadata",
+		Default: "",
+	}
+	argsString["export"] = OptionValue{
+		Desc:    "export a Dockerfile reproducing the build (dockerfile) or an OCI image layout tarball (oci)",
+		Default: "",
+	}
+	argsString["export-path"] = OptionValue{
+		Desc:    "output directory of the Dockerfile or output file of the OCI image layout",
+		Default: "",
+	}
+	argsString["oci-base"] = OptionValue{
+		Desc:    "gzipped tarball of a base root filesystem for the OCI image",
+		Default: "",
+	}
+	argsString["sbom-spdx"] = OptionValue{
+		Desc:    "output path of software bill of materials in SPDX JSON",
+		Default: "",
+	}
+	argsString["sbom-cyclonedx"] = OptionValue{
+		Desc:    "output path of software bill of materials in CycloneDX JSON",
+		Default: "",
+	}
+	argsString["variant"] = OptionValue{
+		Desc:    "name of a build variant built in its own copy of the source code",
+		Default: "",
+	}
+	argsString["target"] = OptionValue{
+		Desc:    "target triple nginx and the static libraries are cross-compiled for such as aarch64-linux-gnu",
+		Default: "",
+	}
+	argsString["target-prefix"] = OptionValue{
+		Desc:    "prefix of the cross toolchain such as /opt/cross/bin/aarch64-linux-gnu- (default: <target>-)",
+		Default: "",
+	}
+	argsString["target-sysroot"] = OptionValue{
+		Desc:    "sysroot of the target given to the cross compiler",
+		Default: "",
+	}
+	argsString["clear-scope"] = OptionValue{
+		Desc:    "comma-separated parts of the working directory removed before building (nginx, modules, libraries)",
+		Default: "",
+	}
+	argsString["max-age"] = OptionValue{
+		Desc:    "age of the versions and the archives removed by gc such as 720h and 30d",
+		Default: "",
+	}
+	argsString["max-size"] = OptionValue{
+		Desc:    "total size of the working directory kept by gc such as 10G",
+		Default: "",
+	}
+	argsString["bundle"] = OptionValue{
+		Desc:    "output path of a bundle (tar.gz) of the sources fetched for building offline",
+		Default: "",
+	}
+	argsString["from-bundle"] = OptionValue{
+		Desc:    "bundle written by fetch to build nginx from without network access",
+		Default: "",
+	}
 	argsString["patch"] = OptionValue{
-		Desc:    "patch path for applying to nginx",
+		Desc:    "patch path for applying to nginx (a series file or a directory having it applies the patches listed)",
 		Default: "",
 	}
 	argsString["patch-opt"] = OptionValue{
-		Desc:    "option for patch",
+		Desc:    "option for patch such as -p1 (-p, -R and -l are supported)",
 		Default: "",
 	}
 
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/cubicdaiya/nginx-build/packaging"
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// loadPackageSpec loads the package spec and resolves its paths from the current directory.
+func loadPackageSpec(path string) (packaging.Spec, error) {
+	spec, err := packaging.LoadSpec(path)
+	if err != nil {
+		return spec, err
+	}
+	rootDir := util.SaveCurrentDir()
+	if spec.SystemdUnit != "" && !filepath.IsAbs(spec.SystemdUnit) {
+		spec.SystemdUnit = filepath.Join(rootDir, spec.SystemdUnit)
+	}
+	if spec.Logrotate != "" && !filepath.IsAbs(spec.Logrotate) {
+		spec.Logrotate = filepath.Join(rootDir, spec.Logrotate)
+	}
+	return spec, nil
+}
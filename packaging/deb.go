@@ -0,0 +1,182 @@
+package packaging
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// arWriter writes a common ar archive which is the container format of deb.
+type arWriter struct {
+	w io.Writer
+}
+
+func newArWriter(w io.Writer) (*arWriter, error) {
+	if _, err := io.WriteString(w, "!<arch>\n"); err != nil {
+		return nil, err
+	}
+	return &arWriter{w: w}, nil
+}
+
+func (aw *arWriter) add(name string, body []byte, mtime time.Time) error {
+	hdr := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, mtime.Unix(), 0, 0, 0100644, len(body))
+	if _, err := io.WriteString(aw.w, hdr); err != nil {
+		return err
+	}
+	if _, err := aw.w.Write(body); err != nil {
+		return err
+	}
+	// members are aligned on even byte boundaries
+	if len(body)%2 == 1 {
+		if _, err := aw.w.Write([]byte("\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func debControl(spec Spec, meta Metadata, installedSize int64) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Package: %s\n", spec.Name)
+	fmt.Fprintf(&b, "Version: %s\n", debVersion(spec, meta))
+	fmt.Fprintf(&b, "Architecture: %s\n", debArch(meta.arch()))
+	fmt.Fprintf(&b, "Maintainer: %s\n", spec.Maintainer)
+	fmt.Fprintf(&b, "Installed-Size: %d\n", (installedSize+1023)/1024)
+	if len(spec.Depends) > 0 {
+		fmt.Fprintf(&b, "Depends: %s\n", strings.Join(spec.Depends, ", "))
+	}
+	if len(spec.Conflicts) > 0 {
+		fmt.Fprintf(&b, "Conflicts: %s\n", strings.Join(spec.Conflicts, ", "))
+	}
+	b.WriteString("Section: httpd\n")
+	b.WriteString("Priority: optional\n")
+	if spec.Homepage != "" {
+		fmt.Fprintf(&b, "Homepage: %s\n", spec.Homepage)
+	}
+	fmt.Fprintf(&b, "Description: %s\n", spec.Description)
+	fmt.Fprintf(&b, " Build fingerprint: %s\n", meta.Fingerprint)
+	return b.String()
+}
+
+func checkConffiles(spec Spec, entries []entry) error {
+	names := make(map[string]bool)
+	for _, e := range entries {
+		if e.Info.Mode().IsRegular() {
+			names[e.Name] = true
+		}
+	}
+	for _, c := range spec.Conffiles {
+		if !names[c] {
+			return fmt.Errorf("conffile %s is not installed in the staging root", c)
+		}
+	}
+	return nil
+}
+
+func buildDebControlTar(spec Spec, meta Metadata, entries []entry, mtime time.Time) ([]byte, error) {
+	var (
+		installedSize int64
+		md5sums       strings.Builder
+	)
+	for _, e := range entries {
+		if !e.Info.Mode().IsRegular() {
+			continue
+		}
+		installedSize += e.Info.Size()
+		body, err := os.ReadFile(e.Path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&md5sums, "%x  %s\n", md5.Sum(body), strings.TrimPrefix(e.Name, "/"))
+	}
+
+	files := []struct {
+		name string
+		body string
+	}{
+		{name: "control", body: debControl(spec, meta, installedSize)},
+		{name: "md5sums", body: md5sums.String()},
+	}
+	if len(spec.Conffiles) > 0 {
+		files = append(files, struct {
+			name string
+			body string
+		}{name: "conffiles", body: strings.Join(spec.Conffiles, "\n") + "\n"})
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    "./" + f.name,
+			Mode:    0644,
+			Size:    int64(len(f.body)),
+			ModTime: mtime,
+			Uname:   "root",
+			Gname:   "root",
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(tw, f.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func buildDeb(spec Spec, meta Metadata, entries []entry, out string) error {
+	if err := checkConffiles(spec, entries); err != nil {
+		return err
+	}
+
+	mtime := time.Now()
+
+	control, err := buildDebControlTar(spec, meta, entries, mtime)
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	zw := gzip.NewWriter(&data)
+	if err := writeTar(zw, entries); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	aw, err := newArWriter(f)
+	if err != nil {
+		return err
+	}
+	if err := aw.add("debian-binary", []byte("2.0\n"), mtime); err != nil {
+		return err
+	}
+	if err := aw.add("control.tar.gz", control, mtime); err != nil {
+		return err
+	}
+	if err := aw.add("data.tar.gz", data.Bytes(), mtime); err != nil {
+		return err
+	}
+	return f.Close()
+}
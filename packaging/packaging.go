@@ -0,0 +1,174 @@
+package packaging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Formats lists the formats of packages.
+var Formats = []string{"deb", "rpm", "tar.gz"}
+
+// Metadata is the information of a build given to packages.
+type Metadata struct {
+	// Version is the version of nginx
+	Version     string
+	Fingerprint string
+	// Arch is GOARCH of the binary. runtime.GOARCH is used when empty.
+	Arch string
+}
+
+func (meta Metadata) arch() string {
+	if meta.Arch != "" {
+		return meta.Arch
+	}
+	return runtime.GOARCH
+}
+
+// entry is a file in the staging root.
+type entry struct {
+	// Name is the absolute path in the package such as /usr/sbin/nginx
+	Name     string
+	Path     string
+	Info     os.FileInfo
+	LinkName string
+}
+
+// Build builds a package of format from the staging root and returns the path of the package.
+func Build(format string, spec Spec, meta Metadata, stagingRoot, outDir string) (string, error) {
+	if err := stageExtraFiles(spec, stagingRoot, format); err != nil {
+		return "", err
+	}
+
+	entries, err := collect(stagingRoot)
+	if err != nil {
+		return "", err
+	}
+
+	switch format {
+	case "deb":
+		out := filepath.Join(outDir, fmt.Sprintf("%s_%s_%s.deb", spec.Name, debVersion(spec, meta), debArch(meta.arch())))
+		return out, buildDeb(spec, meta, entries, out)
+	case "rpm":
+		out := filepath.Join(outDir, fmt.Sprintf("%s-%s-%s.%s.rpm", spec.Name, meta.Version, rpmRelease(spec, meta), rpmArch(meta.arch())))
+		return out, buildRPM(spec, meta, entries, out)
+	case "tar.gz":
+		out := filepath.Join(outDir, fmt.Sprintf("%s-%s-%s.tar.gz", spec.Name, meta.Version, meta.Fingerprint))
+		return out, buildTarGz(entries, out)
+	}
+	return "", fmt.Errorf("package format=%s is not supported (one of %s)", format, strings.Join(Formats, ", "))
+}
+
+// stageExtraFiles copies a systemd unit and a logrotate configuration into the staging root.
+func stageExtraFiles(spec Spec, stagingRoot, format string) error {
+	if spec.SystemdUnit != "" {
+		unitDir := "lib/systemd/system"
+		if format == "rpm" {
+			unitDir = "usr/lib/systemd/system"
+		}
+		dst := filepath.Join(stagingRoot, unitDir, spec.Name+".service")
+		if err := copyFile(spec.SystemdUnit, dst); err != nil {
+			return fmt.Errorf("failed to stage systemd unit %s: %w", spec.SystemdUnit, err)
+		}
+	}
+	if spec.Logrotate != "" {
+		dst := filepath.Join(stagingRoot, "etc/logrotate.d", spec.Name)
+		if err := copyFile(spec.Logrotate, dst); err != nil {
+			return fmt.Errorf("failed to stage logrotate configuration %s: %w", spec.Logrotate, err)
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, 0644)
+}
+
+func collect(stagingRoot string) ([]entry, error) {
+	var entries []entry
+	err := filepath.Walk(stagingRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(stagingRoot, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		e := entry{
+			Name: "/" + filepath.ToSlash(rel),
+			Path: path,
+			Info: info,
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			e.LinkName, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("staging root %s is empty", stagingRoot)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+func debVersion(spec Spec, meta Metadata) string {
+	return fmt.Sprintf("%s-%s+%s", meta.Version, spec.Release, meta.Fingerprint)
+}
+
+func rpmRelease(spec Spec, meta Metadata) string {
+	return fmt.Sprintf("%s.%s", spec.Release, meta.Fingerprint)
+}
+
+func debArch(goarch string) string {
+	switch goarch {
+	case "386":
+		return "i386"
+	case "arm":
+		return "armhf"
+	case "ppc64le":
+		return "ppc64el"
+	case "mips64le":
+		return "mips64el"
+	}
+	return goarch
+}
+
+func rpmArch(goarch string) string {
+	switch goarch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "i386"
+	case "arm":
+		return "armv7hl"
+	case "mips64le":
+		return "mips64el"
+	case "loong64":
+		return "loongarch64"
+	}
+	return goarch
+}
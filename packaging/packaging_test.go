@@ -0,0 +1,183 @@
+package packaging
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupStagingRoot(t *testing.T) string {
+	root := t.TempDir()
+	files := map[string]string{
+		"usr/sbin/nginx":       "#!/bin/sh\n",
+		"etc/nginx/nginx.conf": "events {}\n",
+		"etc/nginx/mime.types": "types {}\n",
+		"var/log/nginx/.keep":  "",
+	}
+	for name, body := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestBuildDeb(t *testing.T) {
+	root := setupStagingRoot(t)
+	spec := defaultSpec()
+	spec.Conflicts = []string{"nginx-common"}
+	spec.Conffiles = []string{"/etc/nginx/nginx.conf"}
+	meta := Metadata{Version: "1.28.0", Fingerprint: "0123456789ab", Arch: "amd64"}
+
+	out, err := Build("deb", spec, meta, root, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Base(out) != "nginx_1.28.0-1+0123456789ab_amd64.deb" {
+		t.Fatalf("unexpected package name: %v", out)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("!<arch>\ndebian-binary   ")) {
+		t.Fatalf("deb is not an ar archive")
+	}
+
+	// the control archive follows debian-binary
+	offset := 8 + 60 + 4
+	size := 0
+	for _, c := range strings.TrimSpace(string(b[offset+48 : offset+58])) {
+		size = size*10 + int(c-'0')
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b[offset+60 : offset+60+size]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	members := make(map[string]string)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(tr)
+		members[hdr.Name] = string(body)
+	}
+
+	wants := []string{
+		"Package: nginx\n",
+		"Version: 1.28.0-1+0123456789ab\n",
+		"Conflicts: nginx-common\n",
+	}
+	for _, want := range wants {
+		if !strings.Contains(members["./control"], want) {
+			t.Fatalf("control does not contain %q: %v", want, members["./control"])
+		}
+	}
+	if members["./conffiles"] != "/etc/nginx/nginx.conf\n" {
+		t.Fatalf("unexpected conffiles: %v", members["./conffiles"])
+	}
+	if !strings.Contains(members["./md5sums"], "  usr/sbin/nginx\n") {
+		t.Fatalf("unexpected md5sums: %v", members["./md5sums"])
+	}
+}
+
+func TestBuildRPM(t *testing.T) {
+	root := setupStagingRoot(t)
+	spec := defaultSpec()
+	meta := Metadata{Version: "1.28.0", Fingerprint: "0123456789ab", Arch: "arm64"}
+
+	out, err := Build("rpm", spec, meta, root, t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filepath.Base(out) != "nginx-1.28.0-1.0123456789ab.aarch64.rpm" {
+		t.Fatalf("unexpected package name: %v", out)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte{0xed, 0xab, 0xee, 0xdb}) {
+		t.Fatalf("rpm does not start with the lead magic")
+	}
+}
+
+func TestRPMFiles(t *testing.T) {
+	root := setupStagingRoot(t)
+	// the empty directories made by make install such as <prefix>/logs
+	for _, dir := range []string{"usr/local/nginx/logs", "usr/local/nginx/client_body_temp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := collect(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := rpmFiles(defaultSpec(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		if f.Mode&040000 != 0 {
+			dirs[f.Name] = true
+		}
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "/usr/local/nginx", want: true},
+		{name: "/usr/local/nginx/logs", want: true},
+		{name: "/usr/local/nginx/client_body_temp", want: true},
+		{name: "/etc/nginx", want: true},
+		{name: "/var/log/nginx", want: true},
+		{name: "/usr", want: false},
+		{name: "/usr/local", want: false},
+		{name: "/etc", want: false},
+		{name: "/var/log", want: false},
+	}
+	for _, test := range tests {
+		if got := dirs[test.name]; got != test.want {
+			t.Fatalf("got: %v, want: %v (%s)", got, test.want, test.name)
+		}
+	}
+}
+
+func TestBuildMissingConffile(t *testing.T) {
+	root := setupStagingRoot(t)
+	spec := defaultSpec()
+	spec.Conffiles = []string{"/etc/nginx/missing.conf"}
+	meta := Metadata{Version: "1.28.0", Fingerprint: "0123456789ab"}
+
+	if _, err := Build("deb", spec, meta, root, t.TempDir()); err == nil {
+		t.Fatalf("missing conffile must be an error")
+	}
+}
+
+func TestBuildUnsupportedFormat(t *testing.T) {
+	root := setupStagingRoot(t)
+	meta := Metadata{Version: "1.28.0", Fingerprint: "0123456789ab"}
+
+	if _, err := Build("apk", defaultSpec(), meta, root, t.TempDir()); err == nil {
+		t.Fatalf("unsupported format must be an error")
+	}
+}
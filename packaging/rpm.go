@@ -0,0 +1,118 @@
+package packaging
+
+import (
+	"os"
+	"time"
+
+	"github.com/google/rpmpack"
+)
+
+// filesystemDirs are the directories owned by the filesystem package and the packages nginx depends on.
+// The other directories such as <prefix>/logs are owned by the package, so the empty ones are installed.
+var filesystemDirs = map[string]bool{
+	"/bin": true, "/boot": true, "/etc": true, "/etc/logrotate.d": true, "/lib": true, "/lib64": true,
+	"/opt": true, "/run": true, "/sbin": true, "/srv": true, "/usr": true, "/usr/bin": true,
+	"/usr/include": true, "/usr/lib": true, "/usr/lib/systemd": true, "/usr/lib/systemd/system": true,
+	"/usr/lib64": true, "/usr/libexec": true, "/usr/local": true, "/usr/local/bin": true,
+	"/usr/local/etc": true, "/usr/local/include": true, "/usr/local/lib": true, "/usr/local/lib64": true,
+	"/usr/local/libexec": true, "/usr/local/sbin": true, "/usr/local/share": true, "/usr/sbin": true,
+	"/usr/share": true, "/usr/share/doc": true, "/usr/share/man": true, "/var": true, "/var/cache": true,
+	"/var/lib": true, "/var/log": true, "/var/run": true, "/var/spool": true, "/var/tmp": true,
+}
+
+// rpmFiles returns the files and the directories of the rpm.
+func rpmFiles(spec Spec, entries []entry) ([]rpmpack.RPMFile, error) {
+	conffiles := make(map[string]bool)
+	for _, c := range spec.Conffiles {
+		conffiles[c] = true
+	}
+
+	var files []rpmpack.RPMFile
+	for _, e := range entries {
+		f := rpmpack.RPMFile{
+			Name:  e.Name,
+			Mode:  uint(e.Info.Mode().Perm()),
+			Owner: "root",
+			Group: "root",
+			MTime: uint32(e.Info.ModTime().Unix()),
+		}
+		switch {
+		case e.Info.IsDir():
+			if filesystemDirs[e.Name] {
+				continue
+			}
+			f.Mode |= 040000
+		case e.Info.Mode()&os.ModeSymlink != 0:
+			f.Mode |= 0120000
+			f.Body = []byte(e.LinkName)
+		default:
+			var err error
+			f.Body, err = os.ReadFile(e.Path)
+			if err != nil {
+				return nil, err
+			}
+			if conffiles[e.Name] {
+				f.Type = rpmpack.ConfigFile | rpmpack.NoReplaceFile
+			}
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func buildRPM(spec Spec, meta Metadata, entries []entry, out string) error {
+	if err := checkConffiles(spec, entries); err != nil {
+		return err
+	}
+
+	var conflicts rpmpack.Relations
+	for _, c := range spec.Conflicts {
+		if err := conflicts.Set(c); err != nil {
+			return err
+		}
+	}
+	var requires rpmpack.Relations
+	for _, d := range spec.Depends {
+		if err := requires.Set(d); err != nil {
+			return err
+		}
+	}
+
+	r, err := rpmpack.NewRPM(rpmpack.RPMMetaData{
+		Name:        spec.Name,
+		Summary:     spec.Description,
+		Description: spec.Description + "\nBuild fingerprint: " + meta.Fingerprint,
+		Version:     meta.Version,
+		Release:     rpmRelease(spec, meta),
+		Arch:        rpmArch(meta.arch()),
+		Packager:    spec.Maintainer,
+		URL:         spec.Homepage,
+		Licence:     spec.License,
+		Group:       "System Environment/Daemons",
+		BuildTime:   time.Now(),
+		Requires:    requires,
+		Conflicts:   conflicts,
+	})
+	if err != nil {
+		return err
+	}
+
+	files, err := rpmFiles(spec, entries)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		r.AddFile(f)
+	}
+
+	fo, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer fo.Close()
+
+	if err := r.Write(fo); err != nil {
+		return err
+	}
+	return fo.Close()
+}
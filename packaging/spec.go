@@ -0,0 +1,68 @@
+package packaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Spec is the metadata of the package built from nginx installed by nginx-build.
+type Spec struct {
+	Name        string   `json:"name"`
+	Release     string   `json:"release"`
+	Maintainer  string   `json:"maintainer"`
+	Description string   `json:"description"`
+	Homepage    string   `json:"homepage"`
+	License     string   `json:"license"`
+	Depends     []string `json:"depends"`
+	Conflicts   []string `json:"conflicts"`
+	Conffiles   []string `json:"conffiles"`
+	SystemdUnit string   `json:"systemd_unit"`
+	Logrotate   string   `json:"logrotate"`
+}
+
+func defaultSpec() Spec {
+	return Spec{
+		Name:        "nginx",
+		Release:     "1",
+		Maintainer:  "nginx-build",
+		Description: "nginx built by nginx-build",
+		Homepage:    "https://nginx.org/",
+		License:     "BSD-2-Clause",
+	}
+}
+
+// LoadSpec loads the package spec from path. The default spec is returned when path is empty.
+func LoadSpec(path string) (Spec, error) {
+	spec := defaultSpec()
+	if len(path) > 0 {
+		f, err := os.Open(path)
+		if err != nil {
+			return spec, err
+		}
+		defer f.Close()
+		if err := json.NewDecoder(f).Decode(&spec); err != nil {
+			return spec, fmt.Errorf("packageSpecPath(%s) is invalid JSON.", path)
+		}
+	}
+	if spec.Name == "" {
+		return spec, fmt.Errorf("name of package is empty")
+	}
+	if spec.Release == "" {
+		spec.Release = "1"
+	}
+	return spec, nil
+}
+
+// Fingerprint returns a short digest identifying a build from its inputs
+// such as the configure script and the revisions of modules.
+func Fingerprint(inputs ...string) string {
+	h := sha256.New()
+	for _, in := range inputs {
+		h.Write([]byte(in))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
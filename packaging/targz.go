@@ -0,0 +1,68 @@
+package packaging
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+// writeTar writes entries into w with names relative to the root such as ./usr/sbin/nginx.
+func writeTar(w io.Writer, entries []entry) error {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr, err := tar.FileInfoHeader(e.Info, e.LinkName)
+		if err != nil {
+			return err
+		}
+		hdr.Name = "." + e.Name
+		if e.Info.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "root", "root"
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !e.Info.Mode().IsRegular() {
+			continue
+		}
+		f, err := os.Open(e.Path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func buildTarGz(entries []entry, out string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	if err := writeTar(zw, entries); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// WriteTree writes the files under root into w as a tar archive.
+func WriteTree(w io.Writer, root string) error {
+	entries, err := collect(root)
+	if err != nil {
+		return err
+	}
+	return writeTar(w, entries)
+}
@@ -0,0 +1,397 @@
+package patch
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The operations of the lines in a hunk.
+const (
+	OpContext = ' '
+	OpDelete  = '-'
+	OpAdd     = '+'
+)
+
+// devNull is the name of the missing side of a new or deleted file.
+const devNull = "/dev/null"
+
+// Line is a line of a hunk. Text has the newline unless it is the last line of a file without the newline.
+type Line struct {
+	Op   byte
+	Text string
+}
+
+// Hunk is a hunk of the changes of a file.
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+	// Pos is the line number of the hunk header in the diff
+	Pos int
+}
+
+// File is the changes of a file in a diff.
+type File struct {
+	// OldName and NewName are the names in the diff. OldName is empty for a new file and NewName is empty for a deleted file.
+	OldName string
+	NewName string
+	// Mode is the mode of a new file or a file changing its mode in a git-style diff
+	Mode os.FileMode
+	// Git is true when the file is of a git-style diff
+	Git   bool
+	Hunks []Hunk
+	// Pos is the line number of the header of the file in the diff
+	Pos int
+}
+
+// IsNew reports whether the file is created.
+func (f *File) IsNew() bool {
+	return f.OldName == ""
+}
+
+// IsDelete reports whether the file is deleted.
+func (f *File) IsDelete() bool {
+	return f.NewName == ""
+}
+
+// Patch is a diff of unified format or git-style with the options applying it.
+type Patch struct {
+	// Name is the name of the patch in the reports such as its path
+	Name string
+	// Dir is the directory the patch is applied to relative to the directory given to Apply
+	Dir   string
+	Files []*File
+	Options
+}
+
+// DefaultStrip returns the number of the components stripped without -p: 1 for git-style diffs and 0 for the others.
+func (p *Patch) DefaultStrip() int {
+	for _, f := range p.Files {
+		if !f.Git {
+			return 0
+		}
+	}
+	return 1
+}
+
+// parser parses a diff line by line.
+type parser struct {
+	name  string
+	lines []string
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%s:%d: %s", p.name, p.pos, fmt.Sprintf(format, args...))
+}
+
+// next returns the next line without the newline.
+func (p *parser) next() (string, bool) {
+	if p.pos >= len(p.lines) {
+		return "", false
+	}
+	line := p.lines[p.pos]
+	p.pos++
+	return line, true
+}
+
+func (p *parser) peek() (string, bool) {
+	if p.pos >= len(p.lines) {
+		return "", false
+	}
+	return p.lines[p.pos], true
+}
+
+// Parse parses the diff data. The lines out of the files such as the commit message are ignored.
+func Parse(name string, data []byte) (*Patch, error) {
+	p := &parser{name: name}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		p.lines = append(p.lines, strings.TrimSuffix(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	patch := &Patch{Name: name, Options: Options{Strip: -1, Fuzz: -1}}
+	for {
+		line, ok := p.peek()
+		if !ok {
+			break
+		}
+		var (
+			f   *File
+			err error
+		)
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			f, err = p.parseGitFile()
+		case strings.HasPrefix(line, "--- "):
+			if next := p.pos + 1; next < len(p.lines) && strings.HasPrefix(p.lines[next], "+++ ") {
+				f = &File{Pos: p.pos + 1}
+				err = p.parseNames(f)
+			} else {
+				p.pos++
+				continue
+			}
+		default:
+			p.pos++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := p.parseHunks(f); err != nil {
+			return nil, err
+		}
+		patch.Files = append(patch.Files, f)
+	}
+	if len(patch.Files) == 0 {
+		return nil, fmt.Errorf("%s: no changes are found", name)
+	}
+	return patch, nil
+}
+
+// parseGitFile parses the extended header of a git-style diff.
+func (p *parser) parseGitFile() (*File, error) {
+	header, _ := p.next()
+	f := &File{Git: true, Pos: p.pos}
+	oldName, newName, ok := splitGitNames(strings.TrimPrefix(header, "diff --git "))
+	if !ok {
+		return nil, p.errorf("malformed diff header: %s", header)
+	}
+	f.OldName, f.NewName = oldName, newName
+
+	for {
+		line, ok := p.peek()
+		if !ok || strings.HasPrefix(line, "diff --git ") || strings.HasPrefix(line, "@@ ") {
+			return f, nil
+		}
+		var err error
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			return f, p.parseNames(f)
+		case strings.HasPrefix(line, "new file mode "):
+			f.OldName = ""
+			f.Mode, err = parseMode(strings.TrimPrefix(line, "new file mode "))
+		case strings.HasPrefix(line, "new mode "):
+			f.Mode, err = parseMode(strings.TrimPrefix(line, "new mode "))
+		case strings.HasPrefix(line, "deleted file mode "):
+			f.NewName = ""
+		case strings.HasPrefix(line, "rename from "):
+			// the names of a rename have no prefix such as a/ and b/
+			f.OldName, err = unquote("a/" + strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			f.NewName, err = unquote("b/" + strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy from "), strings.HasPrefix(line, "copy to "):
+			return nil, p.errorf("copying files is not supported")
+		case strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			return nil, p.errorf("binary patch of %s is not supported", f.NewName)
+		case strings.HasPrefix(line, "old mode "), strings.HasPrefix(line, "index "),
+			strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "):
+		default:
+			return f, nil
+		}
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		p.pos++
+	}
+}
+
+// splitGitNames splits the names of `diff --git a/name b/name`.
+func splitGitNames(s string) (string, string, bool) {
+	if strings.HasPrefix(s, `"`) {
+		quoted, rest, ok := cutQuoted(s)
+		if !ok {
+			return "", "", false
+		}
+		newName, err := unquote(strings.TrimSpace(rest))
+		return quoted, newName, err == nil
+	}
+	// both names are same unless the file is renamed
+	if n := len(s); n%2 == 1 && s[n/2] == ' ' && strings.TrimPrefix(s[:n/2], "a/") == strings.TrimPrefix(s[n/2+1:], "b/") {
+		return s[:n/2], s[n/2+1:], true
+	}
+	i := strings.LastIndex(s, " b/")
+	if i < 0 {
+		return "", "", false
+	}
+	newName, err := unquote(s[i+1:])
+	return s[:i], newName, err == nil
+}
+
+func cutQuoted(s string) (string, string, bool) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			name, err := strconv.Unquote(s[:i+1])
+			return name, s[i+1:], err == nil
+		}
+	}
+	return "", "", false
+}
+
+func unquote(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	return strconv.Unquote(s)
+}
+
+func parseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("malformed mode: %s", s)
+	}
+	return os.FileMode(mode).Perm(), nil
+}
+
+// parseNames parses the lines of `--- old` and `+++ new`.
+func (p *parser) parseNames(f *File) error {
+	oldLine, _ := p.next()
+	newLine, ok := p.next()
+	if !ok || !strings.HasPrefix(newLine, "+++ ") {
+		return p.errorf("+++ is missing after %s", oldLine)
+	}
+	oldName, err := parseName(strings.TrimPrefix(oldLine, "--- "))
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	newName, err := parseName(strings.TrimPrefix(newLine, "+++ "))
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+	if oldName == "" && newName == "" {
+		return p.errorf("both files are %s", devNull)
+	}
+	// the names in the extended header of a git-style diff are kept for the renames
+	if !f.Git || oldName == "" || f.OldName == "" {
+		f.OldName = oldName
+	}
+	if !f.Git || newName == "" || f.NewName == "" {
+		f.NewName = newName
+	}
+	return nil
+}
+
+// parseName parses the name of `--- name<TAB>timestamp`. It is empty for /dev/null.
+func parseName(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		name, _, ok := cutQuoted(s)
+		if !ok {
+			return "", fmt.Errorf("malformed name: %s", s)
+		}
+		s = name
+	} else if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	} else {
+		s = strings.TrimRight(s, " ")
+	}
+	if s == devNull {
+		return "", nil
+	}
+	if s == "" {
+		return "", fmt.Errorf("name is empty")
+	}
+	return s, nil
+}
+
+// parseHunks parses the hunks of the file.
+func (p *parser) parseHunks(f *File) error {
+	for {
+		line, ok := p.peek()
+		if !ok || !strings.HasPrefix(line, "@@ ") {
+			return nil
+		}
+		p.pos++
+		h := Hunk{Pos: p.pos}
+		if err := parseHunkHeader(line, &h); err != nil {
+			return p.errorf("%v", err)
+		}
+
+		oldLines, newLines := 0, 0
+		for oldLines < h.OldLines || newLines < h.NewLines {
+			line, ok := p.next()
+			if !ok {
+				return p.errorf("hunk is truncated (want %d old and %d new lines, got %d and %d)", h.OldLines, h.NewLines, oldLines, newLines)
+			}
+			if line == "" {
+				// the trailing space of an empty context line is stripped by some editors
+				line = " "
+			}
+			op := line[0]
+			switch op {
+			case OpContext:
+				oldLines++
+				newLines++
+			case OpDelete:
+				oldLines++
+			case OpAdd:
+				newLines++
+			case '\\':
+				p.noNewline(&h)
+				continue
+			default:
+				return p.errorf("malformed line in hunk: %s", line)
+			}
+			if oldLines > h.OldLines || newLines > h.NewLines {
+				return p.errorf("hunk has more lines than its header")
+			}
+			h.Lines = append(h.Lines, Line{Op: op, Text: line[1:] + "\n"})
+		}
+		if line, ok := p.peek(); ok && strings.HasPrefix(line, `\`) {
+			p.pos++
+			p.noNewline(&h)
+		}
+		f.Hunks = append(f.Hunks, h)
+	}
+}
+
+// noNewline removes the newline of the last line of the hunk by `\ No newline at end of file`.
+func (p *parser) noNewline(h *Hunk) {
+	if n := len(h.Lines); n > 0 {
+		h.Lines[n-1].Text = strings.TrimSuffix(h.Lines[n-1].Text, "\n")
+	}
+}
+
+// parseHunkHeader parses `@@ -l,s +l,s @@`.
+func parseHunkHeader(line string, h *Hunk) error {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return fmt.Errorf("malformed hunk header: %s", line)
+	}
+	var err error
+	if h.OldStart, h.OldLines, err = parseRange(fields[1][1:]); err != nil {
+		return fmt.Errorf("malformed hunk header: %s", line)
+	}
+	if h.NewStart, h.NewLines, err = parseRange(fields[2][1:]); err != nil {
+		return fmt.Errorf("malformed hunk header: %s", line)
+	}
+	return nil
+}
+
+func parseRange(s string) (int, int, error) {
+	start, count, found := strings.Cut(s, ",")
+	l, err := strconv.Atoi(start)
+	if err != nil || l < 0 {
+		return 0, 0, fmt.Errorf("malformed range: %s", s)
+	}
+	if !found {
+		return l, 1, nil
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return 0, 0, fmt.Errorf("malformed range: %s", s)
+	}
+	return l, n, nil
+}
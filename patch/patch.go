@@ -0,0 +1,631 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options is the options of patch(1) supported in applying patches.
+type Options struct {
+	// Strip is the number of the leading components stripped from the names like patch -p.
+	// A negative value strips 1 from git-style diffs and the directories from the others like patch without -p.
+	Strip int
+	// Fuzz is the number of the context lines ignored in matching a hunk like patch -F. A negative value is 2 of patch.
+	Fuzz int
+	// Reverse reverts the patch like patch -R
+	Reverse bool
+	// IgnoreWhitespace matches the context ignoring the differences of whitespace like patch -l
+	IgnoreWhitespace bool
+}
+
+// ParseOptions parses the options of patch(1) such as `-p1 -R`.
+// The options without effects on the result such as -s are ignored.
+func ParseOptions(s string) (Options, error) {
+	opts := Options{Strip: -1, Fuzz: -1}
+	args := strings.Fields(s)
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		var (
+			strip string
+			fuzz  string
+			err   error
+		)
+		switch {
+		case arg == "-p" || arg == "--strip":
+			if i+1 == len(args) {
+				return opts, fmt.Errorf("patch option %s requires a number", arg)
+			}
+			i++
+			strip = args[i]
+		case strings.HasPrefix(arg, "--strip="):
+			strip = strings.TrimPrefix(arg, "--strip=")
+		case strings.HasPrefix(arg, "-p"):
+			strip = strings.TrimPrefix(arg, "-p")
+		case arg == "-F" || arg == "--fuzz":
+			if i+1 == len(args) {
+				return opts, fmt.Errorf("patch option %s requires a number", arg)
+			}
+			i++
+			fuzz = args[i]
+		case strings.HasPrefix(arg, "--fuzz="):
+			fuzz = strings.TrimPrefix(arg, "--fuzz=")
+		case strings.HasPrefix(arg, "-F"):
+			fuzz = strings.TrimPrefix(arg, "-F")
+		case arg == "-R" || arg == "--reverse":
+			opts.Reverse = true
+		case arg == "-l" || arg == "--ignore-whitespace":
+			opts.IgnoreWhitespace = true
+		case arg == "-s" || arg == "--silent" || arg == "--quiet" || arg == "-f" || arg == "--force" ||
+			arg == "-t" || arg == "--batch" || arg == "-E" || arg == "--remove-empty-files":
+		default:
+			return opts, fmt.Errorf("patch option %s is not supported", arg)
+		}
+		if strip != "" {
+			if opts.Strip, err = strconv.Atoi(strip); err != nil || opts.Strip < 0 {
+				return opts, fmt.Errorf("patch option %s is invalid", arg)
+			}
+		}
+		if fuzz != "" {
+			if opts.Fuzz, err = strconv.Atoi(fuzz); err != nil || opts.Fuzz < 0 {
+				return opts, fmt.Errorf("patch option %s is invalid", arg)
+			}
+		}
+	}
+	return opts, nil
+}
+
+// Reject is a change which is not applied.
+type Reject struct {
+	Patch string
+	// Pos is the line number of the change in the patch
+	Pos  int
+	File string
+	// Hunk is the number of the hunk in the file from 1. It is 0 for the file itself.
+	Hunk int
+	// Line is the line number of the file the hunk is expected at
+	Line   int
+	Reason string
+}
+
+func (r Reject) String() string {
+	if r.Hunk == 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", r.Patch, r.Pos, r.File, r.Reason)
+	}
+	return fmt.Sprintf("%s:%d: %s: hunk #%d FAILED at %d: %s", r.Patch, r.Pos, r.File, r.Hunk, r.Line, r.Reason)
+}
+
+// RejectError is the error of the changes which are not applied. Nothing is changed with it.
+type RejectError struct {
+	Rejects []Reject
+}
+
+func (e *RejectError) Error() string {
+	lines := make([]string, 0, len(e.Rejects)+1)
+	lines = append(lines, "the changes are rejected:")
+	for _, r := range e.Rejects {
+		lines = append(lines, "  "+r.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Result is the result of applying patches.
+type Result struct {
+	// Files is the paths of the files changed relative to the directory in order of the names
+	Files []string
+	// Notes is the notes of the hunks applied at the other lines than expected
+	Notes []string
+}
+
+// file is a file in the tree being patched in memory.
+type file struct {
+	lines  []string
+	exists bool
+	mode   os.FileMode
+
+	// the original state on the disk
+	orig       []byte
+	origExists bool
+	origMode   os.FileMode
+	changed    bool
+}
+
+// tree is the files of a directory being patched in memory.
+type tree struct {
+	dir   string
+	files map[string]*file
+}
+
+func (t *tree) load(name string) (*file, error) {
+	if f, ok := t.files[name]; ok {
+		return f, nil
+	}
+	f := &file{mode: 0644}
+	info, err := os.Lstat(filepath.Join(t.dir, name))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	case !info.Mode().IsRegular():
+		return nil, fmt.Errorf("%s is not a regular file", name)
+	default:
+		f.orig, err = os.ReadFile(filepath.Join(t.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		f.exists, f.origExists = true, true
+		f.mode, f.origMode = info.Mode().Perm(), info.Mode().Perm()
+		f.lines = splitLines(string(f.orig))
+	}
+	t.files[name] = f
+	return f, nil
+}
+
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// Apply applies the patches to dir (or Dir of each patch under it) in order.
+// All patches are applied in memory before the tree is changed, so nothing is changed when a change is rejected (*RejectError) or dryRun is true.
+// The files changed are restored when writing them fails.
+func Apply(dir string, patches []*Patch, dryRun bool) (Result, error) {
+	var result Result
+	t := &tree{dir: dir, files: make(map[string]*file)}
+	var rejects []Reject
+	for _, p := range patches {
+		for _, f := range p.Files {
+			rs, err := t.applyFile(p, f, &result)
+			if err != nil {
+				return result, err
+			}
+			rejects = append(rejects, rs...)
+		}
+	}
+	if len(rejects) > 0 {
+		return result, &RejectError{Rejects: rejects}
+	}
+
+	for name, f := range t.files {
+		if f.changed {
+			result.Files = append(result.Files, name)
+		}
+	}
+	sort.Strings(result.Files)
+	if dryRun {
+		return result, nil
+	}
+	return result, t.commit(result.Files)
+}
+
+// stripName strips the leading components of name like patch -p. A negative strip leaves the base name like patch without -p.
+func stripName(name string, strip int) (string, bool) {
+	name = filepath.ToSlash(name)
+	if strip < 0 {
+		name = name[strings.LastIndexByte(name, '/')+1:]
+	}
+	for i := 0; i < strip; i++ {
+		j := strings.IndexByte(name, '/')
+		if j < 0 {
+			return "", false
+		}
+		name = name[j+1:]
+	}
+	name = filepath.Clean(filepath.FromSlash(name))
+	return name, filepath.IsLocal(name)
+}
+
+func (t *tree) applyFile(p *Patch, pf *File, result *Result) ([]Reject, error) {
+	strip := p.Strip
+	if strip < 0 && pf.Git {
+		strip = 1
+	}
+	fuzz := p.Fuzz
+	if fuzz < 0 {
+		fuzz = defaultFuzz
+	}
+	base := filepath.Clean(p.Dir)
+	if !filepath.IsLocal(base) {
+		return nil, fmt.Errorf("%s: directory %s is out of %s", p.Name, p.Dir, t.dir)
+	}
+	oldName, newName, hunks := pf.OldName, pf.NewName, pf.Hunks
+	if p.Reverse {
+		oldName, newName, hunks = newName, oldName, reverseHunks(hunks)
+	}
+
+	reject := func(name string, hunk, line int, reason string) []Reject {
+		pos := pf.Pos
+		if hunk > 0 {
+			pos = pf.Hunks[hunk-1].Pos
+		}
+		return []Reject{{Patch: p.Name, Pos: pos, File: name, Hunk: hunk, Line: line, Reason: reason}}
+	}
+
+	var src, dst string
+	for _, n := range []struct {
+		name string
+		path *string
+	}{{oldName, &src}, {newName, &dst}} {
+		if n.name == "" {
+			continue
+		}
+		name, ok := stripName(n.name, strip)
+		if !ok {
+			reason := "name is out of the directory without -p"
+			if strip >= 0 {
+				reason = fmt.Sprintf("name is out of the directory with -p%d", strip)
+			}
+			return reject(n.name, 0, 0, reason), nil
+		}
+		*n.path = filepath.Join(base, name)
+	}
+	label := dst
+	if label == "" {
+		label = src
+	}
+
+	var lines []string
+	mode := pf.Mode
+	if src != "" {
+		f, err := t.load(src)
+		if err != nil {
+			return nil, err
+		}
+		if !f.exists && !creates(hunks) {
+			return reject(src, 0, 0, "file does not exist"), nil
+		}
+		lines = f.lines
+		if mode == 0 {
+			mode = f.mode
+		}
+	}
+	if dst != "" && dst != src {
+		f, err := t.load(dst)
+		if err != nil {
+			return nil, err
+		}
+		if f.exists {
+			return reject(dst, 0, 0, "file already exists (the patch may be applied already)"), nil
+		}
+	}
+
+	lines, rejects, notes := applyHunks(lines, hunks, fuzz, p.IgnoreWhitespace)
+	if len(rejects) > 0 {
+		var rs []Reject
+		for _, r := range rejects {
+			rs = append(rs, reject(label, r.hunk, r.line, r.reason)...)
+		}
+		return rs, nil
+	}
+	for _, n := range notes {
+		result.Notes = append(result.Notes, fmt.Sprintf("%s: %s", label, n))
+	}
+
+	if src != "" && src != dst {
+		f := t.files[src]
+		if dst == "" && len(lines) > 0 {
+			return reject(src, 0, 0, "file deleted is not empty after applying the hunks"), nil
+		}
+		f.lines, f.exists, f.changed = nil, false, true
+	}
+	if dst != "" {
+		f := t.files[dst]
+		if mode == 0 {
+			mode = 0644
+		}
+		f.lines, f.exists, f.mode, f.changed = lines, true, mode, true
+	}
+	return nil, nil
+}
+
+// creates reports whether the hunks create a file in a diff without /dev/null.
+func creates(hunks []Hunk) bool {
+	return len(hunks) == 1 && hunks[0].OldStart == 0 && hunks[0].OldLines == 0
+}
+
+func reverseHunks(hunks []Hunk) []Hunk {
+	reversed := make([]Hunk, len(hunks))
+	for i, h := range hunks {
+		r := Hunk{OldStart: h.NewStart, OldLines: h.NewLines, NewStart: h.OldStart, NewLines: h.OldLines, Pos: h.Pos}
+		r.Lines = make([]Line, len(h.Lines))
+		for j, l := range h.Lines {
+			switch l.Op {
+			case OpDelete:
+				l.Op = OpAdd
+			case OpAdd:
+				l.Op = OpDelete
+			}
+			r.Lines[j] = l
+		}
+		reversed[i] = r
+	}
+	return reversed
+}
+
+// defaultFuzz is the fuzz factor of patch
+const defaultFuzz = 2
+
+// hunkReject is a hunk which is not applied.
+type hunkReject struct {
+	hunk   int
+	line   int
+	reason string
+}
+
+// applyHunks applies the hunks to lines like GNU patch. A hunk is searched around the line expected shifted by the hunks before it,
+// and up to fuzz context lines at its ends are ignored when it does not match. The context lines are kept as they are in lines.
+// The notes of the hunks shifted or fuzzed report the lines in the result and the offsets from the lines in the hunks as GNU patch.
+func applyHunks(lines []string, hunks []Hunk, fuzz int, ignoreWhitespace bool) ([]string, []hunkReject, []string) {
+	var (
+		result  []string
+		rejects []hunkReject
+		notes   []string
+		offset  int
+		// next is the index of lines after the last hunk applied
+		next int
+	)
+	for i, h := range hunks {
+		var old, new []string
+		for _, l := range h.Lines {
+			if l.Op != OpAdd {
+				old = append(old, l.Text)
+			}
+			if l.Op != OpDelete {
+				new = append(new, l.Text)
+			}
+		}
+		start := h.OldStart - 1
+		if h.OldLines == 0 {
+			// the lines are added after OldStart
+			start = h.OldStart
+		}
+		expected := start + offset
+
+		at, fuzzed := -1, 0
+		for f := 0; f <= min(fuzz, h.context()) && at < 0; f++ {
+			at, fuzzed = locate(lines, old, h, f, expected, next, ignoreWhitespace), f
+		}
+		if at < 0 {
+			reason := mismatch(lines, old, expected, ignoreWhitespace)
+			if len(new) > 0 && search(expected, next, len(lines)-len(new), func(at int) bool { return matchAt(lines, new, at, ignoreWhitespace) }) >= 0 {
+				reason = "the hunk is applied already or reversed"
+			}
+			rejects = append(rejects, hunkReject{hunk: i + 1, line: expected + 1, reason: reason})
+			continue
+		}
+		result = append(result, lines[next:at]...)
+		if at != start || fuzzed > 0 {
+			note := fmt.Sprintf("hunk #%d succeeded at %d", i+1, len(result)+1)
+			if fuzzed > 0 {
+				note += fmt.Sprintf(" with fuzz %d", fuzzed)
+			}
+			switch at - start {
+			case 0:
+			case 1, -1:
+				note += fmt.Sprintf(" (offset %d line)", at-start)
+			default:
+				note += fmt.Sprintf(" (offset %d lines)", at-start)
+			}
+			notes = append(notes, note)
+		}
+		n := at
+		for _, l := range h.Lines {
+			switch l.Op {
+			case OpContext:
+				result = append(result, lines[n])
+				n++
+			case OpDelete:
+				n++
+			case OpAdd:
+				result = append(result, l.Text)
+			}
+		}
+		next = n
+		// the hunks are searched in lines before applying them, so that the offset is of the lines shifted only
+		offset += at - expected
+	}
+	result = append(result, lines[next:]...)
+	return result, rejects, notes
+}
+
+// contexts returns the numbers of the context lines before and after the changes of the hunk.
+func (h Hunk) contexts() (int, int) {
+	prefix := 0
+	for prefix < len(h.Lines) && h.Lines[prefix].Op == OpContext {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(h.Lines)-prefix && h.Lines[len(h.Lines)-1-suffix].Op == OpContext {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// context returns the larger number of the context lines before and after the changes of the hunk.
+func (h Hunk) context() int {
+	prefix, suffix := h.contexts()
+	return max(prefix, suffix)
+}
+
+// locate returns the index of lines old of the hunk matches ignoring fuzz context lines at its ends, or -1, like locate_hunk of GNU patch.
+// A hunk having less context lines before the changes than after them is at the start of the file, and the one having less after them is at the end.
+func locate(lines, old []string, h Hunk, fuzz, expected, min int, ignoreWhitespace bool) int {
+	prefix, suffix := h.contexts()
+	prefixFuzz := fuzz + prefix - h.context()
+	suffixFuzz := fuzz + suffix - h.context()
+	last := len(lines) - len(old)
+	match := func(at, prefixFuzz, suffixFuzz int) bool {
+		return matchAt(lines, old[prefixFuzz:len(old)-suffixFuzz], at+prefixFuzz, ignoreWhitespace)
+	}
+
+	switch {
+	case prefixFuzz < 0 && h.OldStart <= 1:
+		if min == 0 && last >= 0 && match(0, 0, suffixFuzz) {
+			return 0
+		}
+		return -1
+	case suffixFuzz < 0:
+		if last >= min && match(last, prefixFuzz, 0) {
+			return last
+		}
+		return -1
+	}
+	prefixFuzz = max(prefixFuzz, 0)
+	return search(expected, min, last, func(at int) bool { return match(at, prefixFuzz, suffixFuzz) })
+}
+
+// search returns the index nearest to expected between min and last where match is true, trying the later one first like GNU patch.
+// It returns -1 without a match.
+func search(expected, min, last int, match func(int) bool) int {
+	if last < min {
+		return -1
+	}
+	if expected < min {
+		expected = min
+	}
+	if expected > last {
+		expected = last
+	}
+	for d := 0; expected-d >= min || expected+d <= last; d++ {
+		for _, at := range []int{expected + d, expected - d} {
+			if at >= min && at <= last && match(at) {
+				return at
+			}
+		}
+	}
+	return -1
+}
+
+func matchAt(lines, want []string, at int, ignoreWhitespace bool) bool {
+	for i, w := range want {
+		if !equalLine(lines[at+i], w, ignoreWhitespace) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalLine(a, b string, ignoreWhitespace bool) bool {
+	if a == b {
+		return true
+	}
+	if !ignoreWhitespace {
+		return false
+	}
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// mismatch explains why want does not match lines with the first line differing
+// at the position where the most lines match nearest to expected.
+func mismatch(lines, want []string, expected int, ignoreWhitespace bool) string {
+	last := len(lines) - len(want)
+	if last < 0 {
+		return fmt.Sprintf("the file has %d lines", len(lines))
+	}
+	best, bestMatches := -1, 0
+	for at := 0; at <= last; at++ {
+		matches := 0
+		for i, w := range want {
+			if equalLine(lines[at+i], w, ignoreWhitespace) {
+				matches++
+			}
+		}
+		if matches > bestMatches || (matches == bestMatches && matches > 0 && abs(at-expected) < abs(best-expected)) {
+			best, bestMatches = at, matches
+		}
+	}
+	if best < 0 {
+		return "no lines of the context are found"
+	}
+	for i, w := range want {
+		if !equalLine(lines[best+i], w, ignoreWhitespace) {
+			return fmt.Sprintf("line %d is %q, want %q", best+i+1, strings.TrimSuffix(lines[best+i], "\n"), strings.TrimSuffix(w, "\n"))
+		}
+	}
+	return "the context does not match"
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// commit writes the files changed into the directory. The files written are restored on a failure.
+func (t *tree) commit(names []string) error {
+	var done []string
+	for _, name := range names {
+		if err := t.write(name); err != nil {
+			for _, d := range done {
+				t.restore(d)
+			}
+			t.restore(name)
+			return fmt.Errorf("failed to write %s (the changes are rolled back): %w", name, err)
+		}
+		done = append(done, name)
+	}
+	return nil
+}
+
+func (t *tree) write(name string) error {
+	f := t.files[name]
+	path := filepath.Join(t.dir, name)
+	if !f.exists {
+		err := os.Remove(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return writeFile(path, []byte(strings.Join(f.lines, "")), f.mode)
+}
+
+// restore restores the original state of the file.
+func (t *tree) restore(name string) {
+	f := t.files[name]
+	path := filepath.Join(t.dir, name)
+	if !f.origExists {
+		os.Remove(path)
+		return
+	}
+	writeFile(path, f.orig, f.origMode)
+}
+
+// writeFile writes the file through a temporary file so that it is never seen half-written.
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpFileName := tmp.Name()
+	defer os.Remove(tmpFileName)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFileName, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, path)
+}
@@ -0,0 +1,665 @@
+package patch
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+const nginxC = `#include <ngx_config.h>
+#include <ngx_core.h>
+
+static ngx_int_t ngx_add_inherited_sockets(ngx_cycle_t *cycle);
+static void ngx_cleanup_environment(void *data);
+
+int
+main(int argc, char *const *argv)
+{
+    ngx_int_t         i;
+    ngx_log_t        *log;
+    ngx_cycle_t      *cycle, init_cycle;
+}
+`
+
+const unifiedDiff = `fix the log
+
+--- nginx-1.28.0/src/core/nginx.c	2026-10-18 12:00:00.000000000 +0900
++++ nginx-1.28.0.new/src/core/nginx.c	2026-10-18 12:30:00.000000000 +0900
+@@ -8,6 +8,7 @@
+ main(int argc, char *const *argv)
+ {
+     ngx_int_t         i;
+-    ngx_log_t        *log;
++    ngx_log_t        *log, *error_log;
++    ngx_uint_t        patched;
+     ngx_cycle_t      *cycle, init_cycle;
+ }
+`
+
+const gitDiff = `From 0123456789abcdef Mon Sep 17 00:00:00 2001
+Subject: [PATCH] add the module and rename the readme
+
+diff --git a/src/core/nginx.c b/src/core/nginx.c
+index 1111111..2222222 100644
+--- a/src/core/nginx.c
++++ b/src/core/nginx.c
+@@ -1,2 +1,3 @@
+ #include <ngx_config.h>
+ #include <ngx_core.h>
++#include <ngx_patched.h>
+diff --git a/src/core/ngx_patched.h b/src/core/ngx_patched.h
+new file mode 100755
+index 0000000..3333333
+--- /dev/null
++++ b/src/core/ngx_patched.h
+@@ -0,0 +1,2 @@
++#define NGX_PATCHED 1
++#define NGX_PATCHED_NAME "patched"
+\ No newline at end of file
+diff --git a/README b/README.md
+similarity index 90%
+rename from README
+rename to README.md
+index 4444444..5555555 100644
+--- a/README
++++ b/README.md
+@@ -1,2 +1,2 @@
+-nginx
++# nginx
+ documentation is available at https://nginx.org
+diff --git a/CHANGES.ru b/CHANGES.ru
+deleted file mode 100644
+index 6666666..0000000
+--- a/CHANGES.ru
++++ /dev/null
+@@ -1 +0,0 @@
+-changes
+`
+
+func TestParse(t *testing.T) {
+	p, err := Parse("git.patch", []byte(gitDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []File{
+		{OldName: "a/src/core/nginx.c", NewName: "b/src/core/nginx.c", Git: true, Pos: 4},
+		{OldName: "", NewName: "b/src/core/ngx_patched.h", Mode: 0755, Git: true, Pos: 12},
+		{OldName: "a/README", NewName: "b/README.md", Git: true, Pos: 21},
+		{OldName: "a/CHANGES.ru", NewName: "", Git: true, Pos: 32},
+	}
+	if len(p.Files) != len(want) {
+		t.Fatalf("got: %d files, want: %d files", len(p.Files), len(want))
+	}
+	for i, f := range p.Files {
+		got := *f
+		got.Hunks = nil
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Fatalf("got: %+v, want: %+v", got, want[i])
+		}
+	}
+
+	hunk := p.Files[1].Hunks[0]
+	if hunk.Pos != 17 || hunk.NewLines != 2 || hunk.Lines[1].Text != `#define NGX_PATCHED_NAME "patched"` {
+		t.Fatalf("got: %+v, want: the last line without the newline", hunk)
+	}
+
+	p, err = Parse("unified.patch", []byte(unifiedDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := p.Files[0]; f.OldName != "nginx-1.28.0/src/core/nginx.c" || f.NewName != "nginx-1.28.0.new/src/core/nginx.c" || f.Git {
+		t.Fatalf("got: %+v, want: the names without the timestamps", f)
+	}
+
+	for _, diff := range []string{
+		"no diff here\n",
+		"--- a/x\n+++ b/x\n@@ -1,2 +1,2 @@\n x\n",
+		"--- a/x\n+++ b/x\n@@ -1 +1 @@\n*x\n",
+		"--- a/x\n+++ b/x\n@@ -a +1 @@\n x\n",
+		"diff --git a/x b/x\nGIT binary patch\n",
+	} {
+		if _, err := Parse("broken.patch", []byte(diff)); err == nil {
+			t.Fatalf("Parse(%q) should fail", diff)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	tests := []struct {
+		option     string
+		want       Options
+		shouldFail bool
+	}{
+		{option: "", want: Options{Strip: -1, Fuzz: -1}},
+		{option: "-p1", want: Options{Strip: 1, Fuzz: -1}},
+		{option: "-p 2 -R", want: Options{Strip: 2, Fuzz: -1, Reverse: true}},
+		{option: "--strip=0 -l -s", want: Options{Strip: 0, Fuzz: -1, IgnoreWhitespace: true}},
+		{option: "-F0 -p1", want: Options{Strip: 1, Fuzz: 0}},
+		{option: "--fuzz 3", want: Options{Strip: -1, Fuzz: 3}},
+		{option: "-Fx", shouldFail: true},
+		{option: "-N", shouldFail: true},
+		{option: "-px", shouldFail: true},
+		{option: "-p", shouldFail: true},
+	}
+	for _, test := range tests {
+		got, err := ParseOptions(test.option)
+		if test.shouldFail {
+			if err == nil {
+				t.Fatalf("ParseOptions(%q) should fail", test.option)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Fatalf("got: %+v, want: %+v", got, test.want)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	original := map[string]string{
+		"src/core/nginx.c": nginxC,
+		"README":           "nginx\ndocumentation is available at https://nginx.org\n",
+		"CHANGES.ru":       "changes\n",
+	}
+	dir := t.TempDir()
+	writeTree(t, dir, original)
+
+	p, err := Parse("git.patch", []byte(gitDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Apply(dir, []*Patch{p}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFiles := []string{"CHANGES.ru", "README", "README.md", "src/core/nginx.c", "src/core/ngx_patched.h"}
+	if !reflect.DeepEqual(result.Files, wantFiles) {
+		t.Fatalf("got: %v, want: %v", result.Files, wantFiles)
+	}
+	got := readTree(t, dir)
+	want := map[string]string{
+		"src/core/nginx.c":       strings.Replace(nginxC, "<ngx_core.h>\n", "<ngx_core.h>\n#include <ngx_patched.h>\n", 1),
+		"src/core/ngx_patched.h": "#define NGX_PATCHED 1\n#define NGX_PATCHED_NAME \"patched\"",
+		"README.md":              "# nginx\ndocumentation is available at https://nginx.org\n",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "src/core/ngx_patched.h")); err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("got: %v, %v, want: 0755", info.Mode(), err)
+	}
+
+	// reverting restores the original tree
+	p.Reverse = true
+	if _, err := Apply(dir, []*Patch{p}, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTree(t, dir); !reflect.DeepEqual(got, original) {
+		t.Fatalf("got: %v, want: %v", got, original)
+	}
+}
+
+func TestApplyOffset(t *testing.T) {
+	dir := t.TempDir()
+	shifted := "/* Copyright (C) Nginx, Inc. */\n\n" + nginxC
+	writeTree(t, dir, map[string]string{"src/core/nginx.c": shifted})
+
+	p, err := Parse("unified.patch", []byte(unifiedDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Strip = 1
+	result, err := Apply(dir, []*Patch{p}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src/core/nginx.c: hunk #1 succeeded at 10 (offset 2 lines)"}; !reflect.DeepEqual(result.Notes, want) {
+		t.Fatalf("got: %v, want: %v", result.Notes, want)
+	}
+	got := readTree(t, dir)["src/core/nginx.c"]
+	want := strings.Replace(shifted, "    ngx_log_t        *log;\n", "    ngx_log_t        *log, *error_log;\n    ngx_uint_t        patched;\n", 1)
+	if got != want {
+		t.Fatalf("got: %v, want: %v", got, want)
+	}
+}
+
+// handlers returns the functions with the same body, which gives the hunks of the same context.
+func handlers(n int, changed map[int]string) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		body := "    rc = NGX_OK;\n\n"
+		if c, ok := changed[i]; ok {
+			body = c
+		}
+		fmt.Fprintf(&b, "static ngx_int_t\nngx_handler_%d(ngx_http_request_t *r)\n{\n    ngx_int_t  rc;\n\n%s    return rc;\n}\n\n", i, body)
+	}
+	return b.String()
+}
+
+func TestApplyHunksWithRepeatedContext(t *testing.T) {
+	for _, cmd := range []string{"diff", "patch"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("%s is not found", cmd)
+		}
+	}
+
+	// the hunks adding and deleting lines change the line count before the next hunks
+	original := handlers(10, nil)
+	modified := handlers(10, map[int]string{
+		1: "    rc = NGX_OK;\n    ngx_log_debug0(NGX_LOG_DEBUG_HTTP, r->connection->log, 0, \"handler\");\n    r->main->count++;\n\n",
+		3: "",
+		4: "    rc = NGX_DECLINED;\n\n",
+		6: "    rc = NGX_OK;\n    r->main->count++;\n    r->main->count++;\n    r->main->count++;\n\n",
+		8: "",
+	})
+
+	tmp := t.TempDir()
+	writeTree(t, tmp, map[string]string{"original.c": original, "modified.c": modified})
+	diff, err := exec.Command("diff", "-U1", "--label", "a/src/http/ngx_handlers.c", "--label", "b/src/http/ngx_handlers.c",
+		filepath.Join(tmp, "original.c"), filepath.Join(tmp, "modified.c")).Output()
+	if err == nil || len(diff) == 0 {
+		t.Fatalf("got: %v, want: the differences", err)
+	}
+	patchPath := filepath.Join(tmp, "handlers.patch")
+	if err := os.WriteFile(patchPath, diff, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the source code applied is shifted from the patch or not
+	for _, header := range []string{"", "/*\n * Copyright (C) Nginx, Inc.\n */\n\n"} {
+		dir := t.TempDir()
+		gnuDir := t.TempDir()
+		for _, d := range []string{dir, gnuDir} {
+			writeTree(t, d, map[string]string{"src/http/ngx_handlers.c": header + original})
+		}
+
+		p, err := Parse("handlers.patch", diff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Strip = 1
+		result, err := Apply(dir, []*Patch{p}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := exec.Command("patch", "-p1", "-d", gnuDir, "-i", patchPath).CombinedOutput()
+		if err != nil {
+			t.Fatalf("got: %v, want: nil (%s)", err, out)
+		}
+
+		got := readTree(t, dir)["src/http/ngx_handlers.c"]
+		if want := header + modified; got != want {
+			t.Fatalf("got: %v, want: %v", got, want)
+		}
+		if want := readTree(t, gnuDir)["src/http/ngx_handlers.c"]; got != want {
+			t.Fatalf("got: %v, want: %v", got, want)
+		}
+
+		// the notes are the ones of GNU patch
+		var gnuNotes []string
+		for _, l := range strings.Split(string(out), "\n") {
+			if strings.HasPrefix(l, "Hunk #") {
+				gnuNotes = append(gnuNotes, "src/http/ngx_handlers.c: h"+strings.TrimSuffix(strings.TrimPrefix(l, "H"), "."))
+			}
+		}
+		// the hunks are shifted by the header only
+		wantNotes := 0
+		if header != "" {
+			wantNotes = len(p.Files[0].Hunks)
+		}
+		if len(gnuNotes) != wantNotes {
+			t.Fatalf("got: %v, want: %d notes", gnuNotes, wantNotes)
+		}
+		if !reflect.DeepEqual(result.Notes, gnuNotes) {
+			t.Fatalf("got: %v, want: %v", result.Notes, gnuNotes)
+		}
+
+		// reverting restores the source code
+		p.Reverse = true
+		if _, err := Apply(dir, []*Patch{p}, false); err != nil {
+			t.Fatal(err)
+		}
+		if got := readTree(t, dir)["src/http/ngx_handlers.c"]; got != header+original {
+			t.Fatalf("got: %v, want: %v", got, header+original)
+		}
+	}
+}
+
+func TestApplyFuzz(t *testing.T) {
+	for _, cmd := range []string{"diff", "patch"} {
+		if _, err := exec.LookPath(cmd); err != nil {
+			t.Skipf("%s is not found", cmd)
+		}
+	}
+
+	original := handlers(1, nil)
+	modified := handlers(1, map[int]string{0: "    rc = NGX_DECLINED;\n\n"})
+	tmp := t.TempDir()
+	writeTree(t, tmp, map[string]string{"original.c": original, "modified.c": modified})
+	diff, err := exec.Command("diff", "-U3", "--label", "a/src/http/ngx_handlers.c", "--label", "b/src/http/ngx_handlers.c",
+		filepath.Join(tmp, "original.c"), filepath.Join(tmp, "modified.c")).Output()
+	if err == nil || len(diff) == 0 {
+		t.Fatalf("got: %v, want: the differences", err)
+	}
+	patchPath := filepath.Join(tmp, "handlers.patch")
+	if err := os.WriteFile(patchPath, diff, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the context lines far from the changes are modified after the patch is made
+	header := "/*\n * Copyright (C) Nginx, Inc.\n */\n\n"
+	tests := []struct {
+		source string
+		option string
+		note   string
+	}{
+		{source: strings.Replace(original, "{\n", "{ /* handler */\n", 1), option: "-p1", note: "src/http/ngx_handlers.c: hunk #1 succeeded at 3 with fuzz 1"},
+		{source: header + strings.Replace(original, "    ngx_int_t  rc;\n", "    ngx_int_t  rc, n;\n", 1), option: "-p1", note: "src/http/ngx_handlers.c: hunk #1 succeeded at 7 with fuzz 2 (offset 4 lines)"},
+		{source: strings.Replace(original, "{\n", "{ /* handler */\n", 1), option: "-p1 -F0"},
+		{source: strings.Replace(original, "    ngx_int_t  rc;\n\n", "    ngx_int_t  rc;\n    /* handler */\n", 1), option: "-p1"},
+	}
+
+	for _, test := range tests {
+		dir := t.TempDir()
+		gnuDir := t.TempDir()
+		for _, d := range []string{dir, gnuDir} {
+			writeTree(t, d, map[string]string{"src/http/ngx_handlers.c": test.source})
+		}
+
+		p, err := Parse("handlers.patch", diff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Options, err = ParseOptions(test.option); err != nil {
+			t.Fatal(err)
+		}
+		result, err := Apply(dir, []*Patch{p}, false)
+		out, gnuErr := exec.Command("patch", append(strings.Fields(test.option), "--dry-run", "-d", gnuDir, "-i", patchPath)...).CombinedOutput()
+
+		// the hunks beyond the fuzz factor are rejected like GNU patch
+		if test.note == "" {
+			var rejectErr *RejectError
+			if !errors.As(err, &rejectErr) {
+				t.Fatalf("got: %v, want: *RejectError", err)
+			}
+			if gnuErr == nil {
+				t.Fatalf("got: nil, want: an error of GNU patch (%s)", out)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gnuErr != nil {
+			t.Fatalf("got: %v, want: nil (%s)", gnuErr, out)
+		}
+		if want := []string{test.note}; !reflect.DeepEqual(result.Notes, want) {
+			t.Fatalf("got: %v, want: %v", result.Notes, want)
+		}
+		if want := "H" + strings.TrimPrefix(test.note, "src/http/ngx_handlers.c: h") + "."; !strings.Contains(string(out), want) {
+			t.Fatalf("got: %s, want: %v", out, want)
+		}
+		// the context lines are kept as they are in the source code
+		got := readTree(t, dir)["src/http/ngx_handlers.c"]
+		if want := strings.Replace(test.source, "    rc = NGX_OK;\n", "    rc = NGX_DECLINED;\n", 1); got != want {
+			t.Fatalf("got: %v, want: %v", got, want)
+		}
+	}
+}
+
+func TestApplyReject(t *testing.T) {
+	dir := t.TempDir()
+	original := map[string]string{
+		"src/core/nginx.c": strings.Replace(nginxC, "ngx_cycle_t      *cycle", "ngx_cycle_t      *c", 1),
+		"README":           "nginx\ndocumentation is available at https://nginx.org\n",
+		"CHANGES.ru":       "changes\n",
+	}
+	writeTree(t, dir, original)
+
+	unified, err := Parse("unified.patch", []byte(unifiedDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unified.Strip = 1
+	git, err := Parse("git.patch", []byte(gitDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Apply(dir, []*Patch{git, unified}, false)
+	var rejectErr *RejectError
+	if !errors.As(err, &rejectErr) {
+		t.Fatalf("got: %v, want: RejectError", err)
+	}
+	want := []Reject{{
+		Patch:  "unified.patch",
+		Pos:    5,
+		File:   "src/core/nginx.c",
+		Hunk:   1,
+		Line:   8,
+		Reason: `line 13 is "    ngx_cycle_t      *c, init_cycle;", want "    ngx_cycle_t      *cycle, init_cycle;"`,
+	}}
+	if !reflect.DeepEqual(rejectErr.Rejects, want) {
+		t.Fatalf("got: %+v, want: %+v", rejectErr.Rejects, want)
+	}
+	// the git-style diff applying is not written either
+	if got := readTree(t, dir); !reflect.DeepEqual(got, original) {
+		t.Fatalf("got: %v, want: %v", got, original)
+	}
+
+	// applying twice is detected
+	if _, err := Apply(dir, []*Patch{git}, false); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Apply(dir, []*Patch{git}, true)
+	if !errors.As(err, &rejectErr) || len(rejectErr.Rejects) != 3 {
+		t.Fatalf("got: %v, want: 3 rejects", err)
+	}
+	if r := rejectErr.Rejects[0]; r.File != filepath.FromSlash("src/core/ngx_patched.h") || r.Reason != "file already exists (the patch may be applied already)" {
+		t.Fatalf("got: %v, want: the file created already", r)
+	}
+
+	writeTree(t, dir, map[string]string{"src/core/nginx.c": nginxC})
+	if _, err := Apply(dir, []*Patch{unified}, false); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Apply(dir, []*Patch{unified}, true)
+	if !errors.As(err, &rejectErr) || rejectErr.Rejects[0].Reason != "the hunk is applied already or reversed" {
+		t.Fatalf("got: %v, want: the hunk applied already", err)
+	}
+}
+
+func TestApplyDryRun(t *testing.T) {
+	dir := t.TempDir()
+	original := map[string]string{"src/core/nginx.c": nginxC}
+	writeTree(t, dir, original)
+
+	p, err := Parse("unified.patch", []byte(unifiedDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Strip = 1
+	result, err := Apply(dir, []*Patch{p}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"src/core/nginx.c"}; !reflect.DeepEqual(result.Files, want) {
+		t.Fatalf("got: %v, want: %v", result.Files, want)
+	}
+	if got := readTree(t, dir); !reflect.DeepEqual(got, original) {
+		t.Fatalf("got: %v, want: %v", got, original)
+	}
+}
+
+func TestApplyRollback(t *testing.T) {
+	dir := t.TempDir()
+	original := map[string]string{"src/core/nginx.c": nginxC, "zz": "a file in the way\n"}
+	writeTree(t, dir, original)
+
+	p, err := Parse("unified.patch", []byte(unifiedDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Strip = 1
+	// zz/new.c can not be written as zz is a file
+	blocked, err := Parse("blocked.patch", []byte("--- /dev/null\n+++ b/zz/new.c\n@@ -0,0 +1 @@\n+new\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked.Strip = 1
+
+	if _, err := Apply(dir, []*Patch{p, blocked}, false); err == nil {
+		t.Fatal("Apply should fail")
+	}
+	if got := readTree(t, dir); !reflect.DeepEqual(got, original) {
+		t.Fatalf("got: %v, want: %v", got, original)
+	}
+}
+
+func TestStripName(t *testing.T) {
+	tests := []struct {
+		name  string
+		strip int
+		want  string
+		ok    bool
+	}{
+		{name: "a/src/core/nginx.c", strip: 1, want: filepath.FromSlash("src/core/nginx.c"), ok: true},
+		{name: "src/core/nginx.c", strip: 0, want: filepath.FromSlash("src/core/nginx.c"), ok: true},
+		{name: "nginx-1.28.0/src/core/nginx.c", strip: -1, want: "nginx.c", ok: true},
+		{name: "a/..", strip: -1, ok: false},
+		{name: "nginx.c", strip: 1, ok: false},
+		{name: "a/../../etc/passwd", strip: 1, ok: false},
+		{name: "/etc/passwd", strip: 0, ok: false},
+	}
+	for _, test := range tests {
+		got, ok := stripName(test.name, test.strip)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Fatalf("got: %v, %v, want: %v, %v", got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestParseSeries(t *testing.T) {
+	tests := []struct {
+		series     string
+		want       []SeriesEntry
+		shouldFail bool
+	}{
+		{
+			series: "# upstream fixes\n\nfix.patch\nfeature.patch -p0 # keep the order\n/tmp/echo.patch -p1 -d ngx_echo\n",
+			want: []SeriesEntry{
+				{Path: "fix.patch"},
+				{Path: "feature.patch", Option: "-p0"},
+				{Path: "/tmp/echo.patch", Option: "-p1", Target: "ngx_echo"},
+			},
+		},
+		{series: "fix#1.patch -R\n", want: []SeriesEntry{{Path: "fix#1.patch", Option: "-R"}}},
+		{series: "fix.patch -d\n", shouldFail: true},
+		{series: "fix.patch -N\n", shouldFail: true},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		path := filepath.Join(dir, SeriesName)
+		if err := os.WriteFile(path, []byte(test.series), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ParseSeries(path)
+		if test.shouldFail {
+			if err == nil {
+				t.Fatalf("ParseSeries(%q) should fail", test.series)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range test.want {
+			if !filepath.IsAbs(test.want[i].Path) {
+				test.want[i].Path = filepath.Join(dir, test.want[i].Path)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("got: %+v, want: %+v", got, test.want)
+		}
+	}
+
+	opts, err := SeriesEntry{Option: "-R"}.Options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Options{Strip: 1, Fuzz: -1, Reverse: true}); opts != want {
+		t.Fatalf("got: %+v, want: %+v", opts, want)
+	}
+}
+
+func TestApplyDir(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"ngx_echo/src/core/nginx.c": nginxC})
+
+	p, err := Parse("unified.patch", []byte(unifiedDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Dir = "ngx_echo"
+	p.Strip = 1
+	if _, err := Apply(dir, []*Patch{p}, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "ngx_echo", "src", "core", "nginx.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "*error_log") {
+		t.Fatalf("got: %q, want nginx.c patched", data)
+	}
+
+	p.Dir = "../ngx_echo"
+	if _, err := Apply(dir, []*Patch{p}, true); err == nil {
+		t.Fatal("Apply out of the directory should fail")
+	}
+}
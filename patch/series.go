@@ -0,0 +1,85 @@
+package patch
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SeriesName is the name of a series file of quilt listing the patches in order of applying.
+const SeriesName = "series"
+
+// SeriesEntry is a patch listed in a series file.
+type SeriesEntry struct {
+	// Path is the path of the patch resolved from the directory of the series file
+	Path string `json:"path"`
+	// Option is the options of the patch such as -p1. The patch is applied with -p1 without -p like quilt.
+	Option string `json:"option,omitempty"`
+	// Target is the component patched given by -d such as the name of a module. It is empty for nginx.
+	Target string `json:"target,omitempty"`
+}
+
+// ParseSeries parses the series file of path. A line of the series file is a patch with its options such as
+//
+//	# comment
+//	fix-upstream.patch -p1
+//	ngx_echo-fix.patch -p1 -d ngx_echo
+//
+// where -d is the component the patch is applied to.
+func ParseSeries(path string) ([]SeriesEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []SeriesEntry
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		entry := SeriesEntry{Path: fields[0]}
+		if !filepath.IsAbs(entry.Path) {
+			entry.Path = filepath.Join(filepath.Dir(path), entry.Path)
+		}
+		var options []string
+		for i := 1; i < len(fields); i++ {
+			if fields[i] == "-d" {
+				if i+1 == len(fields) {
+					return nil, fmt.Errorf("%s:%d: -d requires a component", path, n)
+				}
+				i++
+				entry.Target = fields[i]
+				continue
+			}
+			options = append(options, fields[i])
+		}
+		entry.Option = strings.Join(options, " ")
+		if _, err := entry.Options(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Options returns the options of the entry with -p1 by default.
+func (e SeriesEntry) Options() (Options, error) {
+	opts, err := ParseOptions(e.Option)
+	if opts.Strip < 0 {
+		opts.Strip = 1
+	}
+	return opts, err
+}
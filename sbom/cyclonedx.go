@@ -0,0 +1,121 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxLicense struct {
+	License    *cdxLicenseID `json:"license,omitempty"`
+	Expression string        `json:"expression,omitempty"`
+}
+
+type cdxLicenseID struct {
+	ID string `json:"id"`
+}
+
+type cdxExternalReference struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type cdxComponent struct {
+	Type               string                 `json:"type"`
+	BOMRef             string                 `json:"bom-ref"`
+	Name               string                 `json:"name"`
+	Version            string                 `json:"version,omitempty"`
+	Hashes             []cdxHash              `json:"hashes,omitempty"`
+	Licenses           []cdxLicense           `json:"licenses,omitempty"`
+	PURL               string                 `json:"purl,omitempty"`
+	ExternalReferences []cdxExternalReference `json:"externalReferences,omitempty"`
+}
+
+type cdxDocument struct {
+	BOMFormat    string `json:"bomFormat"`
+	SpecVersion  string `json:"specVersion"`
+	SerialNumber string `json:"serialNumber"`
+	Version      int    `json:"version"`
+	Metadata     struct {
+		Timestamp string `json:"timestamp"`
+		Tools     struct {
+			Components []cdxComponent `json:"components"`
+		} `json:"tools"`
+		Component *cdxComponent `json:"component,omitempty"`
+	} `json:"metadata"`
+	Components []cdxComponent `json:"components"`
+}
+
+func cdxLicenses(license string) []cdxLicense {
+	if license == "" || license == "NOASSERTION" {
+		return nil
+	}
+	if strings.ContainsAny(license, " ") {
+		return []cdxLicense{{Expression: license}}
+	}
+	return []cdxLicense{{License: &cdxLicenseID{ID: license}}}
+}
+
+// CycloneDX returns the document in CycloneDX 1.5 JSON.
+func (d *Document) CycloneDX() ([]byte, error) {
+	var doc cdxDocument
+	doc.BOMFormat = "CycloneDX"
+	doc.SpecVersion = "1.5"
+	doc.SerialNumber = "urn:uuid:" + newUUID()
+	doc.Version = 1
+	doc.Metadata.Timestamp = d.Created.UTC().Format(time.RFC3339)
+	doc.Metadata.Tools.Components = []cdxComponent{{Type: "application", BOMRef: "nginx-build", Name: d.Tool}}
+	doc.Components = []cdxComponent{}
+
+	for i := range d.Components {
+		c := &d.Components[i]
+		cc := cdxComponent{
+			Type:     "library",
+			BOMRef:   fmt.Sprintf("%d-%s", i, c.Name),
+			Name:     c.Name,
+			Version:  c.Version,
+			Licenses: cdxLicenses(c.License),
+			PURL:     c.purl(),
+		}
+		if cc.Version == "" {
+			cc.Version = c.Commit
+		}
+		if c.SHA256 != "" {
+			cc.Hashes = append(cc.Hashes, cdxHash{Alg: "SHA-256", Content: c.SHA256})
+		}
+		if c.SHA1 != "" {
+			cc.Hashes = append(cc.Hashes, cdxHash{Alg: "SHA-1", Content: c.SHA1})
+		}
+		if c.DownloadURL != "" {
+			if c.VCS != "" {
+				ref := cdxExternalReference{Type: "vcs", URL: c.DownloadURL}
+				if c.Commit != "" {
+					ref.Comment = "commit " + c.Commit
+				}
+				cc.ExternalReferences = append(cc.ExternalReferences, ref)
+			} else {
+				cc.ExternalReferences = append(cc.ExternalReferences, cdxExternalReference{Type: "distribution", URL: c.DownloadURL})
+			}
+		}
+
+		switch c.Kind {
+		case KindFlavor:
+			cc.Type = "application"
+			flavor := cc
+			doc.Metadata.Component = &flavor
+			continue
+		case KindPatch:
+			cc.Type = "file"
+		}
+		doc.Components = append(doc.Components, cc)
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,104 @@
+package sbom
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// component kinds
+const (
+	KindFlavor = iota
+	KindLibrary
+	KindModule
+	KindPatch
+)
+
+// Component is a component of a build of nginx.
+type Component struct {
+	Kind        int
+	Name        string
+	Version     string
+	DownloadURL string
+	// SHA256 and SHA1 are the checksums of the archive or the patch file
+	SHA256  string
+	SHA1    string
+	License string
+	// VCS is the form of a 3rd party module such as git and hg
+	VCS    string
+	Commit string
+}
+
+// Document is a software bill of materials of a build.
+type Document struct {
+	Name       string
+	Tool       string
+	Created    time.Time
+	Components []Component
+}
+
+var licenses = map[string]string{
+	"nginx":         "BSD-2-Clause",
+	"openresty":     "BSD-2-Clause",
+	"ngx_openresty": "BSD-2-Clause",
+	"freenginx":     "BSD-2-Clause",
+	"angie":         "BSD-2-Clause",
+	"tengine":       "BSD-2-Clause",
+	"pcre2":         "BSD-3-Clause WITH PCRE2-exception",
+	"libressl":      "ISC AND OpenSSL",
+	"zlib":          "Zlib",
+}
+
+// License returns the SPDX license expression of a known component.
+func License(name, version string) string {
+	if name == "openssl" {
+		// OpenSSL is licensed under Apache License 2.0 since 3.0.0
+		if len(version) > 0 && version[0] >= '3' {
+			return "Apache-2.0"
+		}
+		return "OpenSSL"
+	}
+	if l, ok := licenses[name]; ok {
+		return l
+	}
+	return "NOASSERTION"
+}
+
+// Checksums returns the SHA-256 and SHA-1 checksums of the file.
+func Checksums(path string) (string, string, error) {
+	sha256sum, err := util.FileSHA256(path)
+	if err != nil {
+		return "", "", err
+	}
+	sha1sum, err := util.FileSHA1(path)
+	if err != nil {
+		return "", "", err
+	}
+	return sha256sum, sha1sum, nil
+}
+
+func (c *Component) purl() string {
+	if c.Kind == KindPatch {
+		return ""
+	}
+	version := c.Version
+	if c.Commit != "" {
+		version = c.Commit
+	}
+	if version == "" {
+		return fmt.Sprintf("pkg:generic/%s", c.Name)
+	}
+	return fmt.Sprintf("pkg:generic/%s@%s", c.Name, version)
+}
+
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,121 @@
+package sbom
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupDocument(t *testing.T) Document {
+	return Document{
+		Name:    "nginx-1.28.0",
+		Tool:    "nginx-build-v0.15.0",
+		Created: time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC),
+		Components: []Component{
+			{Kind: KindFlavor, Name: "nginx", Version: "1.28.0", DownloadURL: "https://nginx.org/download/nginx-1.28.0.tar.gz", SHA256: "aaaa", License: License("nginx", "1.28.0")},
+			{Kind: KindLibrary, Name: "openssl", Version: "3.5.0", License: License("openssl", "3.5.0")},
+			{Kind: KindModule, Name: "ngx_http_hello_world", DownloadURL: "https://github.com/cubicdaiya/ngx_http_hello_world", VCS: "git", Commit: "0123abcd", License: License("ngx_http_hello_world", "")},
+			{Kind: KindPatch, Name: "fix.patch", SHA256: "bbbb", SHA1: "cccc"},
+		},
+	}
+}
+
+func TestLicense(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		want    string
+	}{
+		{name: "nginx", version: "1.28.0", want: "BSD-2-Clause"},
+		{name: "openssl", version: "3.5.0", want: "Apache-2.0"},
+		{name: "openssl", version: "1.1.1w", want: "OpenSSL"},
+		{name: "zlib", version: "1.3.1", want: "Zlib"},
+		{name: "ngx_http_hello_world", version: "", want: "NOASSERTION"},
+	}
+
+	for _, test := range tests {
+		got := License(test.name, test.version)
+		if got != test.want {
+			t.Fatalf("got: %v, want: %v", got, test.want)
+		}
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fix.patch")
+	if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sha256sum, sha1sum, err := Checksums(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha256sum != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatalf("unexpected SHA-256: %v", sha256sum)
+	}
+	if sha1sum != "a9993e364706816aba3e25717850c26c9cd0d89d" {
+		t.Fatalf("unexpected SHA-1: %v", sha1sum)
+	}
+}
+
+func TestSPDX(t *testing.T) {
+	doc := setupDocument(t)
+	b, err := doc.SPDX()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got spdxDocument
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.SPDXVersion != "SPDX-2.3" {
+		t.Fatalf("unexpected version: %v", got.SPDXVersion)
+	}
+	if len(got.Packages) != 3 || len(got.Files) != 1 {
+		t.Fatalf("unexpected packages and files: %s", b)
+	}
+	if got.Packages[2].DownloadLocation != "git+https://github.com/cubicdaiya/ngx_http_hello_world@0123abcd" {
+		t.Fatalf("unexpected download location: %v", got.Packages[2].DownloadLocation)
+	}
+
+	wants := map[string]bool{"DESCRIBES": false, "CONTAINS": false, "PATCH_APPLIED": false}
+	for _, r := range got.Relationships {
+		wants[r.RelationshipType] = true
+	}
+	for k, v := range wants {
+		if !v {
+			t.Fatalf("relationship %v is not found", k)
+		}
+	}
+}
+
+func TestCycloneDX(t *testing.T) {
+	doc := setupDocument(t)
+	b, err := doc.CycloneDX()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got cdxDocument
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.BOMFormat != "CycloneDX" {
+		t.Fatalf("unexpected format: %v", got.BOMFormat)
+	}
+	if got.Metadata.Component == nil || got.Metadata.Component.Name != "nginx" {
+		t.Fatalf("flavor is not the component of metadata: %s", b)
+	}
+	if len(got.Components) != 3 {
+		t.Fatalf("unexpected components: %s", b)
+	}
+	if got.Components[1].Version != "0123abcd" || got.Components[1].ExternalReferences[0].Type != "vcs" {
+		t.Fatalf("unexpected module: %+v", got.Components[1])
+	}
+	if got.Components[2].Type != "file" {
+		t.Fatalf("unexpected patch: %+v", got.Components[2])
+	}
+}
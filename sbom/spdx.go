@@ -0,0 +1,145 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var spdxIDRe = regexp.MustCompile(`[^A-Za-z0-9.-]`)
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxFile struct {
+	FileName         string         `json:"fileName"`
+	SPDXID           string         `json:"SPDXID"`
+	Checksums        []spdxChecksum `json:"checksums"`
+	LicenseConcluded string         `json:"licenseConcluded"`
+	CopyrightText    string         `json:"copyrightText"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+type spdxDocument struct {
+	SPDXVersion       string `json:"spdxVersion"`
+	DataLicense       string `json:"dataLicense"`
+	SPDXID            string `json:"SPDXID"`
+	Name              string `json:"name"`
+	DocumentNamespace string `json:"documentNamespace"`
+	CreationInfo      struct {
+		Created  string   `json:"created"`
+		Creators []string `json:"creators"`
+	} `json:"creationInfo"`
+	Packages      []spdxPackage      `json:"packages"`
+	Files         []spdxFile         `json:"files,omitempty"`
+	Relationships []spdxRelationship `json:"relationships"`
+}
+
+func spdxID(prefix string, i int, name string) string {
+	return fmt.Sprintf("SPDXRef-%s-%d-%s", prefix, i, spdxIDRe.ReplaceAllString(name, "-"))
+}
+
+func spdxDownloadLocation(c *Component) string {
+	if c.DownloadURL == "" {
+		return "NOASSERTION"
+	}
+	if c.VCS != "" {
+		location := fmt.Sprintf("%s+%s", c.VCS, c.DownloadURL)
+		if c.Commit != "" {
+			location += "@" + c.Commit
+		}
+		return location
+	}
+	return c.DownloadURL
+}
+
+// SPDX returns the document in SPDX 2.3 JSON.
+func (d *Document) SPDX() ([]byte, error) {
+	var doc spdxDocument
+	doc.SPDXVersion = "SPDX-2.3"
+	doc.DataLicense = "CC0-1.0"
+	doc.SPDXID = "SPDXRef-DOCUMENT"
+	doc.Name = d.Name
+	doc.DocumentNamespace = fmt.Sprintf("https://github.com/cubicdaiya/nginx-build/spdx/%s-%s", spdxIDRe.ReplaceAllString(d.Name, "-"), newUUID())
+	doc.CreationInfo.Created = d.Created.UTC().Format(time.RFC3339)
+	doc.CreationInfo.Creators = []string{"Tool: " + d.Tool}
+
+	var flavorID string
+	for i := range d.Components {
+		c := &d.Components[i]
+		if c.Kind == KindPatch {
+			id := spdxID("File", i, c.Name)
+			doc.Files = append(doc.Files, spdxFile{
+				FileName: c.Name,
+				SPDXID:   id,
+				Checksums: []spdxChecksum{
+					{Algorithm: "SHA1", ChecksumValue: c.SHA1},
+					{Algorithm: "SHA256", ChecksumValue: c.SHA256},
+				},
+				LicenseConcluded: "NOASSERTION",
+				CopyrightText:    "NOASSERTION",
+			})
+			if flavorID != "" {
+				doc.Relationships = append(doc.Relationships, spdxRelationship{id, "PATCH_APPLIED", flavorID})
+			}
+			continue
+		}
+
+		id := spdxID("Package", i, c.Name)
+		p := spdxPackage{
+			Name:             c.Name,
+			SPDXID:           id,
+			VersionInfo:      c.Version,
+			DownloadLocation: spdxDownloadLocation(c),
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  c.License,
+			CopyrightText:    "NOASSERTION",
+			ExternalRefs: []spdxExternalRef{
+				{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: c.purl()},
+			},
+		}
+		if p.VersionInfo == "" {
+			p.VersionInfo = c.Commit
+		}
+		if c.SHA256 != "" {
+			p.Checksums = append(p.Checksums, spdxChecksum{Algorithm: "SHA256", ChecksumValue: c.SHA256})
+		}
+		doc.Packages = append(doc.Packages, p)
+
+		if c.Kind == KindFlavor {
+			flavorID = id
+			doc.Relationships = append(doc.Relationships, spdxRelationship{"SPDXRef-DOCUMENT", "DESCRIBES", id})
+		} else if flavorID != "" {
+			doc.Relationships = append(doc.Relationships, spdxRelationship{flavorID, "CONTAINS", id})
+		}
+	}
+
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,138 @@
+package smoke
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the modules having *_temp_path. Their directives are unknown when the modules are disabled.
+var tempPathModules = []string{"proxy", "fastcgi", "uwsgi", "scgi"}
+
+// config is nginx.conf of a smoke test.
+type config struct {
+	Prefix    string
+	HTTPPort  int
+	HTTPSPort int
+	Modules   []string
+	// Disabled is the modules in tempPathModules disabled with --without-http_*_module
+	Disabled map[string]bool
+}
+
+// prepare makes the directories and the contents served under the prefix.
+func (c *config) prepare() error {
+	for _, dir := range []string{"conf", "logs", "html", "temp"} {
+		if err := os.MkdirAll(filepath.Join(c.Prefix, dir), 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(c.Prefix, "html", "index.html"), []byte(Body), 0644)
+}
+
+// generate generates nginx.conf.
+// All the paths are in the prefix as the paths compiled into nginx may not be writable.
+func (c *config) generate() string {
+	var b strings.Builder
+
+	for _, m := range c.Modules {
+		fmt.Fprintf(&b, "load_module %s;\n", m)
+	}
+	b.WriteString(`
+worker_processes 1;
+pid logs/nginx.pid;
+lock_file logs/nginx.lock;
+error_log logs/error.log;
+
+events {
+    worker_connections 64;
+}
+
+http {
+    access_log off;
+    client_body_temp_path temp/client_body;
+`)
+	for _, m := range tempPathModules {
+		if !c.Disabled[m] {
+			fmt.Fprintf(&b, "    %s_temp_path temp/%s;\n", m, m)
+		}
+	}
+	fmt.Fprintf(&b, `
+    server {
+        listen 127.0.0.1:%d;
+        root html;
+    }
+`, c.HTTPPort)
+	if c.HTTPSPort != 0 {
+		fmt.Fprintf(&b, `
+    server {
+        listen 127.0.0.1:%d ssl;
+        ssl_certificate cert.pem;
+        ssl_certificate_key key.pem;
+        root html;
+    }
+`, c.HTTPSPort)
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+func (c *config) write() error {
+	return os.WriteFile(filepath.Join(c.Prefix, "conf", "nginx.conf"), []byte(c.generate()), 0644)
+}
+
+// writeCertificate generates a self-signed certificate for localhost into dir
+// and returns the pool trusting it.
+func writeCertificate(dir string) (*x509.CertPool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return pool, nil
+}
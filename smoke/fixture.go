@@ -0,0 +1,350 @@
+package smoke
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/util"
+)
+
+// DefaultFixtureTimeout is the timeout of a fixture without timeout.
+const DefaultFixtureTimeout = 30 * time.Second
+
+// Fixture is a directory containing a template of nginx.conf and fixture.json.
+//
+// nginx.conf is a text/template with the fields of FixtureParams.
+// fixture.json has the requests to nginx and the responses expected.
+type Fixture struct {
+	Name     string
+	Dir      string
+	Timeout  time.Duration
+	Requests []Request
+}
+
+// Request is a request to nginx in a fixture and its response expected.
+type Request struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// HTTPS sends the request with TLS to HTTPSPort. nginx.conf should listen on it with ssl and the certificate in FixtureParams.
+	HTTPS  bool   `json:"https"`
+	Expect Expect `json:"expect"`
+}
+
+// Expect is a response expected.
+type Expect struct {
+	// Status is the status code. 200 is expected when it is 0.
+	Status int `json:"status"`
+	// Headers are the regular expressions matching the headers
+	Headers map[string]string `json:"headers"`
+	// Body is the regular expression matching the body
+	Body string `json:"body"`
+}
+
+// FixtureParams is the parameters of the template of nginx.conf in a fixture.
+type FixtureParams struct {
+	// Port and HTTPSPort are the ports on 127.0.0.1 for nginx to listen on
+	Port      int
+	HTTPSPort int
+	// Prefix is the prefix of nginx. The fixture directory is copied into Prefix/conf.
+	Prefix string
+	// Modules is the directory of the dynamic modules built
+	Modules string
+	// Certificate and CertificateKey are a self-signed certificate for localhost
+	Certificate    string
+	CertificateKey string
+}
+
+type fixtureJSON struct {
+	Timeout  string    `json:"timeout"`
+	Requests []Request `json:"requests"`
+}
+
+// LoadFixtures loads the fixtures in the subdirectories of dir.
+func LoadFixtures(dir string) ([]Fixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixtures []Fixture
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		fixture, err := LoadFixture(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	if len(fixtures) == 0 {
+		return nil, fmt.Errorf("no fixtures in %s", dir)
+	}
+	sort.Slice(fixtures, func(i, j int) bool {
+		return fixtures[i].Name < fixtures[j].Name
+	})
+	return fixtures, nil
+}
+
+// LoadFixture loads the fixture in dir.
+func LoadFixture(dir string) (Fixture, error) {
+	fixture := Fixture{
+		Name:    filepath.Base(dir),
+		Dir:     dir,
+		Timeout: DefaultFixtureTimeout,
+	}
+
+	if !util.FileExists(filepath.Join(dir, "nginx.conf")) {
+		return fixture, fmt.Errorf("fixture %s: nginx.conf is not found", fixture.Name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "fixture.json"))
+	if err != nil {
+		return fixture, fmt.Errorf("fixture %s: %w", fixture.Name, err)
+	}
+	var v fixtureJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fixture, fmt.Errorf("fixture %s: %w", fixture.Name, err)
+	}
+	if v.Timeout != "" {
+		fixture.Timeout, err = time.ParseDuration(v.Timeout)
+		if err != nil {
+			return fixture, fmt.Errorf("fixture %s: %w", fixture.Name, err)
+		}
+	}
+	if len(v.Requests) == 0 {
+		return fixture, fmt.Errorf("fixture %s: no requests", fixture.Name)
+	}
+	for i, r := range v.Requests {
+		if r.Method == "" {
+			v.Requests[i].Method = http.MethodGet
+		}
+		if r.Path == "" {
+			v.Requests[i].Path = "/"
+		}
+		if r.Expect.Status == 0 {
+			v.Requests[i].Expect.Status = http.StatusOK
+		}
+		if _, err := r.Expect.compile(); err != nil {
+			return fixture, fmt.Errorf("fixture %s: %w", fixture.Name, err)
+		}
+	}
+	fixture.Requests = v.Requests
+
+	return fixture, nil
+}
+
+type compiledExpect struct {
+	headers map[string]*regexp.Regexp
+	body    *regexp.Regexp
+}
+
+func (e *Expect) compile() (compiledExpect, error) {
+	c := compiledExpect{headers: make(map[string]*regexp.Regexp)}
+	for k, v := range e.Headers {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return c, fmt.Errorf("header %s: %w", k, err)
+		}
+		c.headers[k] = re
+	}
+	if e.Body != "" {
+		re, err := regexp.Compile(e.Body)
+		if err != nil {
+			return c, fmt.Errorf("body: %w", err)
+		}
+		c.body = re
+	}
+	return c, nil
+}
+
+// check checks the response matches the expectation.
+func (e *Expect) check(res *http.Response, body []byte) error {
+	c, err := e.compile()
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != e.Status {
+		return fmt.Errorf("status got: %d, want: %d", res.StatusCode, e.Status)
+	}
+	keys := make([]string, 0, len(c.headers))
+	for k := range c.headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if v := res.Header.Get(k); !c.headers[k].MatchString(v) {
+			return fmt.Errorf("header %s got: %q, want: /%s/", k, v, c.headers[k])
+		}
+	}
+	if c.body != nil && !c.body.Match(body) {
+		return fmt.Errorf("body got: %q, want: /%s/", body, c.body)
+	}
+	return nil
+}
+
+// RunFixtures runs the fixtures against nginx one by one.
+// Each fixture runs in its own prefix on a random port within its timeout.
+// All the fixtures run even if some of them fail and the returned error is the first failure.
+func (t *Test) RunFixtures(ctx context.Context, fixtures []Fixture) (Report, error) {
+	var (
+		report   Report
+		firstErr error
+	)
+	for _, f := range fixtures {
+		if err := t.runFixture(ctx, f, &report); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("fixture %s: %w", f.Name, err)
+		}
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+	}
+	return report, firstErr
+}
+
+func (t *Test) runFixture(ctx context.Context, f Fixture, report *Report) error {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	prefix, err := os.MkdirTemp("", "nginx-build-fixture")
+	if err != nil {
+		return report.add(f.Name, err)
+	}
+	defer os.RemoveAll(prefix)
+	if err := os.Chmod(prefix, 0755); err != nil {
+		return report.add(f.Name, err)
+	}
+
+	params, certPool, err := t.prepareFixture(f, prefix)
+	if err != nil {
+		return report.add(f.Name, err)
+	}
+
+	if err := report.add(f.Name+": nginx -t", t.testConfig(ctx, prefix)); err != nil {
+		return err
+	}
+
+	srv, err := t.start(ctx, prefix)
+	if err != nil {
+		return report.add(f.Name+": start", err)
+	}
+	defer srv.stop()
+	if err := srv.waitListening(ctx, params.Port); err != nil {
+		return report.add(f.Name+": start", err)
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: certPool, ServerName: "localhost"},
+		},
+		// the redirections are checked as the responses
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var firstErr error
+	for _, r := range f.Requests {
+		err := report.add(fmt.Sprintf("%s: %s %s", f.Name, r.Method, r.Path), r.do(ctx, client, params))
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return report.add(f.Name+": stop", srv.stop())
+}
+
+// prepareFixture copies the fixture into prefix and generates nginx.conf from its template.
+func (t *Test) prepareFixture(f Fixture, prefix string) (FixtureParams, *x509.CertPool, error) {
+	var params FixtureParams
+
+	confDir := filepath.Join(prefix, "conf")
+	if err := util.CopyTree(f.Dir, confDir); err != nil {
+		return params, nil, err
+	}
+	for _, dir := range []string{"logs", "html", "temp"} {
+		if err := os.MkdirAll(filepath.Join(prefix, dir), 0755); err != nil {
+			return params, nil, err
+		}
+	}
+	certPool, err := writeCertificate(confDir)
+	if err != nil {
+		return params, nil, err
+	}
+
+	params = FixtureParams{
+		Prefix:         prefix,
+		Modules:        t.ModulesDir,
+		Certificate:    filepath.Join(confDir, "cert.pem"),
+		CertificateKey: filepath.Join(confDir, "key.pem"),
+	}
+	if params.Port, err = freePort(); err != nil {
+		return params, nil, err
+	}
+	if params.HTTPSPort, err = freePort(); err != nil {
+		return params, nil, err
+	}
+
+	tmpl, err := template.ParseFiles(filepath.Join(f.Dir, "nginx.conf"))
+	if err != nil {
+		return params, nil, err
+	}
+	var conf strings.Builder
+	if err := tmpl.Execute(&conf, params); err != nil {
+		return params, nil, err
+	}
+	if err := os.WriteFile(filepath.Join(confDir, "nginx.conf"), []byte(conf.String()), 0644); err != nil {
+		return params, nil, err
+	}
+	return params, certPool, nil
+}
+
+func (r *Request) do(ctx context.Context, client *http.Client, params FixtureParams) error {
+	url := fmt.Sprintf("http://127.0.0.1:%d%s", params.Port, r.Path)
+	if r.HTTPS {
+		url = fmt.Sprintf("https://127.0.0.1:%d%s", params.HTTPSPort, r.Path)
+	}
+
+	var body io.Reader
+	if r.Body != "" {
+		body = strings.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, url, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range r.Headers {
+		if strings.EqualFold(k, "Host") {
+			req.Host = v
+		} else {
+			req.Header.Set(k, v)
+		}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	return r.Expect.check(res, resBody)
+}
@@ -0,0 +1,306 @@
+package smoke
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cubicdaiya/nginx-build/command"
+)
+
+// Body is the contents served by nginx in a smoke test.
+const Body = "nginx-build smoke test\n"
+
+const (
+	startTimeout = 10 * time.Second
+	stopTimeout  = 10 * time.Second
+)
+
+// Test is the parameters of a smoke test.
+type Test struct {
+	// BinaryPath is the path of nginx tested
+	BinaryPath string
+	// Modules is the paths of the dynamic modules loaded with load_module
+	Modules []string
+	// ModulesDir is the directory of the dynamic modules for the fixtures
+	ModulesDir string
+}
+
+// Check is the result of a check in a smoke test.
+type Check struct {
+	Name string
+	Err  error
+}
+
+// Report is the result of a smoke test.
+type Report struct {
+	Checks []Check
+}
+
+// Passed reports whether all the checks passed.
+func (r *Report) Passed() bool {
+	for _, c := range r.Checks {
+		if c.Err != nil {
+			return false
+		}
+	}
+	return len(r.Checks) > 0
+}
+
+func (r *Report) add(name string, err error) error {
+	r.Checks = append(r.Checks, Check{Name: name, Err: err})
+	return err
+}
+
+// Modules returns the dynamic modules built in objs of the source directory of nginx.
+func Modules(objsDir string) ([]string, error) {
+	modules, err := filepath.Glob(filepath.Join(objsDir, "*.so"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(modules)
+	return modules, nil
+}
+
+// builtWith reports whether nginx is configured with the option in the output of `nginx -V`.
+func builtWith(versionInfo, option string) bool {
+	for _, arg := range strings.Fields(versionInfo) {
+		if arg == option {
+			return true
+		}
+	}
+	return false
+}
+
+// freePort returns a TCP port on localhost which is not used now.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// Run runs the smoke test of nginx in a temporary prefix.
+// It does not access the network except localhost.
+// The returned error is the first failure of the checks or an error preparing the test.
+func (t *Test) Run(ctx context.Context) (Report, error) {
+	var report Report
+
+	out, err := exec.CommandContext(ctx, t.BinaryPath, "-V").CombinedOutput()
+	if err != nil {
+		return report, report.add("nginx -V", fmt.Errorf("%w: %s", err, out))
+	}
+	versionInfo := string(out)
+	ssl := builtWith(versionInfo, "--with-http_ssl_module")
+
+	prefix, err := os.MkdirTemp("", "nginx-build-smoke")
+	if err != nil {
+		return report, err
+	}
+	defer os.RemoveAll(prefix)
+	// the workers may run as an unprivileged user
+	if err := os.Chmod(prefix, 0755); err != nil {
+		return report, err
+	}
+
+	conf := config{
+		Prefix:   prefix,
+		Disabled: make(map[string]bool),
+	}
+	for _, m := range tempPathModules {
+		conf.Disabled[m] = builtWith(versionInfo, "--without-http_"+m+"_module")
+	}
+	if conf.HTTPPort, err = freePort(); err != nil {
+		return report, err
+	}
+	var certPool *x509.CertPool
+	if ssl {
+		if conf.HTTPSPort, err = freePort(); err != nil {
+			return report, err
+		}
+		certPool, err = writeCertificate(filepath.Join(prefix, "conf"))
+		if err != nil {
+			return report, err
+		}
+	}
+	if err := conf.prepare(); err != nil {
+		return report, err
+	}
+
+	// tests load_module for each module with the modules preceding it as a module may depend on them
+	for i, m := range t.Modules {
+		conf.Modules = t.Modules[:i+1]
+		if err := conf.write(); err != nil {
+			return report, err
+		}
+		if err := report.add("load_module "+filepath.Base(m), t.testConfig(ctx, prefix)); err != nil {
+			return report, err
+		}
+	}
+
+	conf.Modules = t.Modules
+	if err := conf.write(); err != nil {
+		return report, err
+	}
+	if err := report.add("nginx -t", t.testConfig(ctx, prefix)); err != nil {
+		return report, err
+	}
+
+	srv, err := t.start(ctx, prefix)
+	if err != nil {
+		return report, report.add("start", err)
+	}
+	defer srv.stop()
+
+	client := &http.Client{
+		Timeout: startTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: certPool, ServerName: "localhost"},
+		},
+	}
+	if err := srv.waitListening(ctx, conf.HTTPPort); err != nil {
+		return report, report.add("start", err)
+	}
+	if err := report.add("http", get(ctx, client, fmt.Sprintf("http://127.0.0.1:%d/", conf.HTTPPort))); err != nil {
+		return report, err
+	}
+	if ssl {
+		if err := report.add("https", get(ctx, client, fmt.Sprintf("https://127.0.0.1:%d/", conf.HTTPSPort))); err != nil {
+			return report, err
+		}
+	}
+
+	if err := report.add("stop", srv.stop()); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func (t *Test) args(prefix string) []string {
+	return []string{t.BinaryPath, "-p", prefix + "/", "-c", "conf/nginx.conf", "-e", "logs/error.log"}
+}
+
+func (t *Test) testConfig(ctx context.Context, prefix string) error {
+	cmd, err := command.Make(ctx, prefix, append(t.args(prefix), "-t"))
+	if err != nil {
+		return err
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// server is nginx running in the foreground.
+type server struct {
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	done   chan struct{}
+	err    error
+
+	stopOnce sync.Once
+	stopErr  error
+}
+
+func (t *Test) start(ctx context.Context, prefix string) (*server, error) {
+	cmd, err := command.Make(ctx, prefix, append(t.args(prefix), "-g", "daemon off;"))
+	if err != nil {
+		return nil, err
+	}
+	srv := &server{cmd: cmd, done: make(chan struct{})}
+	cmd.Stderr = &srv.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		srv.err = cmd.Wait()
+		close(srv.done)
+	}()
+	return srv, nil
+}
+
+// waitListening waits until nginx listens on the port.
+func (srv *server) waitListening(ctx context.Context, port int) error {
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		select {
+		case <-srv.done:
+			return fmt.Errorf("nginx exited: %v: %s", srv.err, strings.TrimSpace(srv.stderr.String()))
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("nginx does not listen on 127.0.0.1:%d in %s", port, startTimeout)
+}
+
+// stop stops nginx gracefully with SIGQUIT and kills it when it does not stop in time.
+func (srv *server) stop() error {
+	srv.stopOnce.Do(func() {
+		select {
+		case <-srv.done:
+			srv.stopErr = fmt.Errorf("nginx exited unexpectedly: %v", srv.err)
+			return
+		default:
+		}
+		if err := srv.cmd.Process.Signal(syscall.SIGQUIT); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			srv.stopErr = err
+		}
+		select {
+		case <-srv.done:
+			if srv.stopErr == nil {
+				srv.stopErr = srv.err
+			}
+		case <-time.After(stopTimeout):
+			syscall.Kill(-srv.cmd.Process.Pid, syscall.SIGKILL)
+			<-srv.done
+			srv.stopErr = fmt.Errorf("nginx does not stop in %s", stopTimeout)
+		}
+	})
+	return srv.stopErr
+}
+
+func get(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	if string(body) != Body {
+		return fmt.Errorf("GET %s: unexpected body %q", url, body)
+	}
+	return nil
+}
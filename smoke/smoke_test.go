@@ -0,0 +1,179 @@
+package smoke
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuiltWith(t *testing.T) {
+	versionInfo := `nginx version: nginx/1.28.0
+built by gcc 12.2.0 (Debian 12.2.0-14)
+configure arguments: --with-http_ssl_module --without-http_uwsgi_module --with-http_v2_module
+`
+	tests := []struct {
+		option string
+		want   bool
+	}{
+		{"--with-http_ssl_module", true},
+		{"--without-http_uwsgi_module", true},
+		{"--with-http_v3_module", false},
+		{"--with-http_ssl", false},
+	}
+
+	for _, test := range tests {
+		if got := builtWith(versionInfo, test.option); got != test.want {
+			t.Fatalf("%s got: %v, want: %v", test.option, got, test.want)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	c := config{
+		Prefix:    "/tmp/smoke",
+		HTTPPort:  18080,
+		HTTPSPort: 18443,
+		Modules:   []string{"/work/objs/ndk_http_module.so", "/work/objs/ngx_http_lua_module.so"},
+		Disabled:  map[string]bool{"uwsgi": true},
+	}
+	conf := c.generate()
+
+	wants := []string{
+		"load_module /work/objs/ndk_http_module.so;\nload_module /work/objs/ngx_http_lua_module.so;\n",
+		"pid logs/nginx.pid;",
+		"proxy_temp_path temp/proxy;",
+		"scgi_temp_path temp/scgi;",
+		"listen 127.0.0.1:18080;",
+		"listen 127.0.0.1:18443 ssl;",
+		"ssl_certificate cert.pem;",
+	}
+	for _, want := range wants {
+		if !strings.Contains(conf, want) {
+			t.Fatalf("nginx.conf does not contain %q:\n%s", want, conf)
+		}
+	}
+	if strings.Contains(conf, "uwsgi_temp_path") {
+		t.Fatalf("nginx.conf contains the directive of the module disabled:\n%s", conf)
+	}
+	if strings.Contains(conf, "daemon") {
+		t.Fatalf("daemon is given with -g:\n%s", conf)
+	}
+
+	c.HTTPSPort = 0
+	if conf := c.generate(); strings.Contains(conf, "ssl") {
+		t.Fatalf("nginx.conf without SSL contains ssl:\n%s", conf)
+	}
+}
+
+func TestWriteCertificate(t *testing.T) {
+	dir := t.TempDir()
+	pool, err := writeCertificate(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "cert.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: pool, DNSName: "localhost"}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("got: %v, want: %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+}
+
+func TestReportPassed(t *testing.T) {
+	var report Report
+	if report.Passed() {
+		t.Fatalf("report without checks should not pass")
+	}
+	report.add("nginx -t", nil)
+	report.add("http", nil)
+	if !report.Passed() {
+		t.Fatalf("report should pass: %+v", report)
+	}
+	report.add("https", errors.New("tls: handshake failure"))
+	if report.Passed() {
+		t.Fatalf("report should fail: %+v", report)
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	fixtures, err := LoadFixtures("../config/fixtures.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != 1 || fixtures[0].Name != "hello" {
+		t.Fatalf("got: %+v", fixtures)
+	}
+	f := fixtures[0]
+	if f.Timeout != 10*time.Second {
+		t.Fatalf("got: %v, want: %v", f.Timeout, 10*time.Second)
+	}
+	if len(f.Requests) != 3 {
+		t.Fatalf("got: %v, want: %v", len(f.Requests), 3)
+	}
+	// defaults
+	if r := f.Requests[1]; r.Method != http.MethodGet || r.Expect.Status != http.StatusOK || !r.HTTPS {
+		t.Fatalf("got: %+v", r)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "nginx.conf"), []byte("events {}\n"), 0644)
+	tests := []struct {
+		fixture string
+	}{
+		{`{"requests": []}`},
+		{`{"timeout": "ten seconds", "requests": [{"path": "/"}]}`},
+		{`{"requests": [{"path": "/", "expect": {"body": "("}}]}`},
+	}
+	for _, test := range tests {
+		os.WriteFile(filepath.Join(dir, "fixture.json"), []byte(test.fixture), 0644)
+		if _, err := LoadFixture(dir); err == nil {
+			t.Fatalf("LoadFixture(%s) should fail", test.fixture)
+		}
+	}
+}
+
+func TestExpectCheck(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusMovedPermanently,
+		Header:     http.Header{"Location": []string{"http://example.com/new"}},
+	}
+	tests := []struct {
+		expect Expect
+		body   string
+		ok     bool
+	}{
+		{Expect{Status: 301, Headers: map[string]string{"Location": "/new$"}}, "", true},
+		{Expect{Status: 200}, "", false},
+		{Expect{Status: 301, Headers: map[string]string{"Location": "^/new$"}}, "", false},
+		{Expect{Status: 301, Body: "Moved"}, "<title>301 Moved Permanently</title>", true},
+		{Expect{Status: 301, Body: "^Moved"}, "<title>301 Moved Permanently</title>", false},
+	}
+
+	for _, test := range tests {
+		err := test.expect.check(res, []byte(test.body))
+		if (err == nil) != test.ok {
+			t.Fatalf("%+v got: %v, want ok: %v", test.expect, err, test.ok)
+		}
+	}
+}
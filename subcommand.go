@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// the groups of the options in Options
+var (
+	commonGroup = []string{"d", "verbose", "output", "timeout", "lock-timeout"}
+	flavorGroup = []string{
+		"flavor", "v", "openresty", "freenginx",
+		"openrestyversion", "freenginxversion", "angieversion", "tengineversion",
+	}
+	libraryGroup = []string{
+		"pcre", "openssl", "libressl", "zlib",
+		"pcreversion", "opensslversion", "libresslversion", "zlibversion",
+	}
+	configureGroup = []string{
+		"c", "m", "j", "clear", "clear-scope", "variant", "patch", "patch-opt", "patch-dry-run", "help-all",
+		"openresty-luajit", "openresty-luajit-xcflags", "openresty-with", "openresty-without", "openresty-pcre-jit",
+		"sbom-spdx", "sbom-cyclonedx", "target", "target-prefix", "target-sysroot",
+	}
+	testGroup    = []string{"smoke-test", "fixtures", "nginx-tests", "nginx-tests-glob"}
+	installGroup = []string{"destdir", "install-modules-dir", "package", "package-spec", "export", "export-path", "oci-base"}
+	matrixGroup  = []string{"matrix-flavors", "matrix-versions", "matrix-tls", "matrix-parallel"}
+	bisectGroup  = []string{"good", "bad", "run", "releases"}
+	bundleGroup  = []string{"bundle", "fetch-shprov", "fetch-patch"}
+	// the options of the modes before the subcommands
+	legacyGroup = []string{"version", "versions", "configureonly", "install", "idempotent", "openresty-bundle"}
+)
+
+// subcommand is a subcommand of nginx-build.
+type subcommand struct {
+	name string
+	desc string
+	// options is the names of the options in Options available in the subcommand
+	options []string
+	// configure is true when the configure options of nginx (e.g. --with-http_ssl_module) are available
+	configure bool
+}
+
+func optionGroups(groups ...[]string) []string {
+	var options []string
+	for _, g := range groups {
+		options = append(options, g...)
+	}
+	return options
+}
+
+var subcommands = []subcommand{
+	{
+		name:      "build",
+		desc:      "download, configure and build nginx (default)",
+		options:   optionGroups(commonGroup, flavorGroup, libraryGroup, configureGroup, testGroup, installGroup, matrixGroup, []string{"trace", "from-bundle"}, legacyGroup),
+		configure: true,
+	},
+	{
+		name:      "fetch",
+		desc:      "download and extract nginx, the static libraries and 3rd party modules and write a bundle of them",
+		options:   optionGroups(commonGroup, flavorGroup, libraryGroup, bundleGroup, []string{"c", "m", "clear", "clear-scope", "patch", "patch-opt", "openresty-bundle"}),
+		configure: false,
+	},
+	{
+		name:      "configure",
+		desc:      "configure nginx without building",
+		options:   optionGroups(commonGroup, flavorGroup, libraryGroup, configureGroup, []string{"from-bundle"}),
+		configure: true,
+	},
+	{
+		name:      "install",
+		desc:      "build nginx and install it, build a package or export an OCI image",
+		options:   optionGroups(commonGroup, flavorGroup, libraryGroup, configureGroup, testGroup, installGroup, []string{"trace", "idempotent", "from-bundle"}),
+		configure: true,
+	},
+	{
+		name:      "clean",
+		desc:      "remove the working directory of a version, its parts with -clear-scope or a variant with -variant",
+		options:   optionGroups([]string{"d", "verbose", "lock-timeout", "m", "clear-scope", "variant"}, flavorGroup, libraryGroup),
+		configure: false,
+	},
+	{
+		name:    "gc",
+		desc:    "list the versions in the working directory and remove them by -max-age, -keep and -max-size",
+		options: []string{"d", "output", "max-age", "keep", "max-size", "dry-run"},
+	},
+	{
+		name:      "info",
+		desc:      "show what the options resolve to without building",
+		options:   optionGroups([]string{"d", "output"}, flavorGroup, libraryGroup, configureGroup),
+		configure: true,
+	},
+	{
+		name:    "versions",
+		desc:    "print nginx versions",
+		options: nil,
+	},
+	{
+		name:      "doctor",
+		desc:      "check the commands and the headers required for building nginx",
+		options:   optionGroups([]string{"d", "output", "nginx-tests"}, flavorGroup, libraryGroup, configureGroup),
+		configure: true,
+	},
+	{
+		name:      "bisect",
+		desc:      "search the first version failing a build or a test between -good and -bad",
+		options:   optionGroups(commonGroup, flavorGroup, libraryGroup, configureGroup, testGroup, bisectGroup),
+		configure: true,
+	},
+}
+
+// findSubcommand returns the subcommand of name.
+func findSubcommand(name string) (*subcommand, bool) {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i], true
+		}
+	}
+	return nil, false
+}
+
+// parseSubcommand returns the subcommand and its arguments.
+// The arguments without a subcommand are of build as before the subcommands.
+func parseSubcommand(args []string) (*subcommand, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if args[0] == "help" {
+			if len(args) > 1 {
+				if cmd, ok := findSubcommand(args[1]); ok {
+					return cmd, []string{"-h"}
+				}
+			}
+			printSubcommands()
+			os.Exit(0)
+		}
+		cmd, ok := findSubcommand(args[0])
+		if !ok {
+			log.Fatalf("unknown command %s (see '%s help')", args[0], os.Args[0])
+		}
+		return cmd, args[1:]
+	}
+	cmd, _ := findSubcommand("build")
+	return cmd, args
+}
+
+func (cmd *subcommand) has(option string) bool {
+	for _, o := range cmd.options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+func printSubcommands() {
+	fmt.Fprintf(os.Stdout, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range subcommands {
+		fmt.Fprintf(os.Stdout, "  %-10s %s\n", cmd.name, cmd.desc)
+	}
+	fmt.Fprintf(os.Stdout, "\nThe options without a command are of build. See '%s help <command>' for the options of a command.\n", os.Args[0])
+}
+
+// usage prints the options of the subcommand in Options.
+func (cmd *subcommand) usage(fs *flag.FlagSet) {
+	fmt.Fprintf(os.Stdout, "Usage: %s %s [options]\n\n%s\n\nOptions:\n", os.Args[0], cmd.name, cmd.desc)
+
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if isNginxBuildOption(f.Name) {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		s := fmt.Sprintf("  -%s", name)
+		s += "\n\t"
+		s += fs.Lookup(name).Usage
+		defValue := defaultStringValue(name)
+		if defValue != "" {
+			s += fmt.Sprintf(" ( default: %s )", defValue)
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", s)
+	}
+	if cmd.configure {
+		fmt.Fprintf(os.Stdout, "\nThe configure options of nginx are also available. See '%s %s -help-all'.\n", os.Args[0], cmd.name)
+	}
+}
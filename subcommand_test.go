@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+func TestParseSubcommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{args: nil, name: "build", rest: nil},
+		{args: []string{"-d", "work"}, name: "build", rest: []string{"-d", "work"}},
+		{args: []string{"build", "-d", "work"}, name: "build", rest: []string{"-d", "work"}},
+		{args: []string{"install", "-destdir", "staging"}, name: "install", rest: []string{"-destdir", "staging"}},
+		{args: []string{"fetch"}, name: "fetch", rest: []string{}},
+		{args: []string{"gc", "-keep", "2"}, name: "gc", rest: []string{"-keep", "2"}},
+		{args: []string{"help", "bisect"}, name: "bisect", rest: []string{"-h"}},
+	}
+
+	for _, test := range tests {
+		cmd, rest := parseSubcommand(test.args)
+		if cmd.name != test.name {
+			t.Fatalf("got: %v, want: %v (%v)", cmd.name, test.name, test.args)
+		}
+		if !reflect.DeepEqual(rest, test.rest) {
+			t.Fatalf("got: %v, want: %v (%v)", rest, test.rest, test.args)
+		}
+	}
+}
+
+func TestSubcommandOptions(t *testing.T) {
+	// the options of the subcommands are of nginx-build
+	for _, cmd := range subcommands {
+		for _, option := range cmd.options {
+			if !isNginxBuildOption(option) {
+				t.Fatalf("-%s of %s is not an option of nginx-build", option, cmd.name)
+			}
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		ok   bool
+	}{
+		{name: "build", args: []string{"-d", "work", "-openssl", "-matrix-tls", "system"}, ok: true},
+		{name: "build", args: []string{"--with-http_v2_module"}, ok: false},
+		{name: "build", args: []string{"-good", "1.27.0"}, ok: false},
+		{name: "fetch", args: []string{"-bundle", "bundle.tar.gz", "-fetch-shprov"}, ok: true},
+		{name: "fetch", args: []string{"-install"}, ok: false},
+		{name: "configure", args: []string{"-c", "configure.sh", "-target", "aarch64-linux-gnu"}, ok: true},
+		{name: "configure", args: []string{"-smoke-test"}, ok: false},
+		{name: "install", args: []string{"-destdir", "staging", "-idempotent"}, ok: true},
+		{name: "install", args: []string{"-matrix-flavors", "nginx"}, ok: false},
+		{name: "clean", args: []string{"-d", "work", "-clear-scope", "nginx"}, ok: true},
+		{name: "clean", args: []string{"-c", "configure.sh"}, ok: false},
+		{name: "gc", args: []string{"-d", "work", "-keep", "2", "-max-age", "30d", "-dry-run"}, ok: true},
+		{name: "gc", args: []string{"-openssl"}, ok: false},
+		{name: "info", args: []string{"-output", "json", "-flavor", "angie"}, ok: true},
+		{name: "info", args: []string{"-verbose"}, ok: false},
+		{name: "doctor", args: []string{"-nginx-tests", "nginx-tests"}, ok: true},
+		{name: "bisect", args: []string{"-good", "1.25.0", "-bad", "1.27.0", "-run", "true"}, ok: true},
+		{name: "bisect", args: []string{"-export", "oci"}, ok: false},
+		{name: "build", args: []string{"-d", "work", "extra"}, ok: false},
+	}
+
+	for _, test := range tests {
+		cmd, ok := findSubcommand(test.name)
+		if !ok {
+			t.Fatalf("unknown command %s", test.name)
+		}
+		_, err := parseArgs(cmd, test.args, flag.ContinueOnError)
+		if (err == nil) != test.ok {
+			t.Fatalf("got: %v, want: %v (%s %v)", err, test.ok, test.name, test.args)
+		}
+	}
+
+	// the options not available in a subcommand have their default values
+	cmd, _ := findSubcommand("gc")
+	if _, err := parseArgs(cmd, []string{"-d", "work"}, flag.ContinueOnError); err != nil {
+		t.Fatal(err)
+	}
+	if got := *nginxBuildOptions.Values["output"].Value; got != "text" {
+		t.Fatalf("got: %v, want: %v", got, "text")
+	}
+	if got := *nginxBuildOptions.Bools["openssl"].Enabled; got {
+		t.Fatalf("got: %v, want: %v", got, false)
+	}
+	if got := *nginxBuildOptions.Values["d"].Value; got != "work" {
+		t.Fatalf("got: %v, want: %v", got, "work")
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+
+	"github.com/cubicdaiya/nginx-build/nginxbuild"
+)
+
+// writeTrace writes the timings of the stages into path in the Chrome trace event format.
+func writeTrace(path string, timings []nginxbuild.Timing) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := nginxbuild.WriteTrace(f, timings); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,7 +1,12 @@
 package util
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -68,3 +73,75 @@ func ClearWorkDir(workDir string) error {
 	}
 	return err
 }
+
+// CopyTree copies a file or a directory recursively.
+// The symbolic links in the directory are copied as they are, while src is followed when it is a symbolic link.
+func CopyTree(src, dst string) error {
+	return CopyTreeExcept(src, dst, nil)
+}
+
+// CopyTreeExcept copies a file or a directory recursively like CopyTree except the files and the directories excluded reports true for.
+// excluded is given the paths relative to src.
+func CopyTreeExcept(src, dst string, excluded func(rel string) bool) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if excluded != nil && rel != "." && excluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, b, info.Mode().Perm())
+	})
+}
+
+// FileSHA256 returns the SHA-256 checksum of the file in hex.
+func FileSHA256(path string) (string, error) {
+	return fileChecksum(path, sha256.New())
+}
+
+// FileSHA1 returns the SHA-1 checksum of the file in hex.
+func FileSHA1(path string) (string, error) {
+	return fileChecksum(path, sha1.New())
+}
+
+func fileChecksum(path string, h hash.Hash) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
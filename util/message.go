@@ -4,12 +4,11 @@ import (
 	"bufio"
 	"log"
 	"os"
-
-	"github.com/cubicdaiya/nginx-build/command"
 )
 
-func PrintFatalMsg(err error, path string) {
-	if command.VerboseEnabled {
+// PrintFatalMsg prints the log file of the failed command and exits.
+func PrintFatalMsg(err error, path string, verbose bool) {
+	if verbose {
 		log.Fatal(err)
 	}
 
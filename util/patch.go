@@ -1,61 +1,53 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
-	"sync"
 
-	"github.com/cubicdaiya/nginx-build/command"
+	"github.com/cubicdaiya/nginx-build/patch"
 )
 
-var (
-	mutex   sync.Mutex
-	patched bool
-)
-
-func init() {
-	patched = false
+// PatchFile is a patch applied to a component of a build.
+type PatchFile struct {
+	Path string `json:"path"`
+	// Option is the options of patch(1) such as -p1
+	Option string `json:"option,omitempty"`
+	// Target is the component patched such as the name of a module. It is empty for nginx.
+	Target string `json:"target,omitempty"`
+	// Series is the series file listing the patch. The patch is applied with -p1 without -p in it like quilt.
+	Series string `json:"series,omitempty"`
 }
 
-func patch(path, option string, reverse bool) error {
-
-	if reverse {
-		mutex.Lock()
-		if patched {
-			mutex.Unlock()
-			return nil
-		}
-		patched = true
-		mutex.Unlock()
-	}
-
-	args := []string{"sh", "-c"}
-	body := ""
-	if reverse {
-		body = fmt.Sprintf("patch %s -R < %s", option, path)
-	} else {
-		body = fmt.Sprintf("patch %s < %s", option, path)
+func (f PatchFile) options() (patch.Options, error) {
+	if f.Series != "" {
+		return patch.SeriesEntry{Option: f.Option}.Options()
 	}
-	args = append(args, body)
+	return patch.ParseOptions(f.Option)
+}
 
-	cmd, err := command.Make(args)
+// ExplicitOption returns the option applying the patch out of its series, which has -p1 unless -p is given.
+func (f PatchFile) ExplicitOption() (string, error) {
+	opts, err := patch.ParseOptions(f.Option)
 	if err != nil {
-		return err
+		return "", err
 	}
-
-	// As the output of patch is interactive,
-	// the result is always output.
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
+	if f.Series == "" || opts.Strip >= 0 {
+		return f.Option, nil
+	}
+	return strings.TrimSpace("-p1 " + f.Option), nil
 }
 
-func Patch(path, option, root string, reverse bool) {
+// PatchFiles expands the comma-separated patch paths into the patches applied with option.
+// A series file or a directory having it is replaced with the patches it lists with their options,
+// and the other directories are replaced with all files they contain (recursively).
+func PatchFiles(path, option, root string) ([]PatchFile, error) {
 	if path == "" {
-		return
+		return nil, nil
 	}
 
 	var pathes []string
@@ -65,8 +57,7 @@ func Patch(path, option, root string, reverse bool) {
 		pathes = append(pathes, path)
 	}
 
-	// replace directories with all files they contain (recursively)
-	var expanded_paths []string
+	var files []PatchFile
 	for _, path := range pathes {
 		if !strings.HasPrefix(path, "/") {
 			path = fmt.Sprintf("%s/%s", root, path)
@@ -74,35 +65,114 @@ func Patch(path, option, root string, reverse bool) {
 
 		isDir, err := IsDirectory(path)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
+		}
+		series := ""
+		switch {
+		case isDir && FileExists(filepath.Join(path, patch.SeriesName)):
+			series = filepath.Join(path, patch.SeriesName)
+		case !isDir && filepath.Base(path) == patch.SeriesName:
+			series = path
 		}
-		if isDir {
+
+		switch {
+		case series != "":
+			entries, err := patch.ParseSeries(series)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				files = append(files, PatchFile{Path: e.Path, Option: e.Option, Target: e.Target, Series: series})
+			}
+		case isDir:
 			paths, err := ListDirectory(path)
 			if err != nil {
-				log.Fatal(err)
+				return nil, err
 			}
-			if paths != nil {
-				expanded_paths = append(expanded_paths, paths...)
+			for _, p := range paths {
+				files = append(files, PatchFile{Path: p, Option: option})
 			}
-		} else {
-			expanded_paths = append(expanded_paths, path)
+		default:
+			files = append(files, PatchFile{Path: path, Option: option})
 		}
 	}
 
-	pathes = expanded_paths
+	return files, nil
+}
 
-	for _, path := range pathes {
-		if FileExists(path) {
-			if reverse {
-				log.Printf("Reverting patch: %s", path)
-			} else {
-				log.Printf("Applying patch: %s %s", option, path)
-			}
-			if err := patch(path, option, reverse); err != nil {
-				log.Fatalf("Failed to apply patch: %s %s", option, path)
-			}
-		} else {
-			log.Fatalf("Patch pathname: %s is not found", path)
+// PatchPaths expands the comma-separated patch paths into patch files like PatchFiles.
+func PatchPaths(path, root string) ([]string, error) {
+	files, err := PatchFiles(path, "", root)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	return paths, nil
+}
+
+// Patch applies the patches to the source code of the components under dir.
+// targets maps the targets of the patches to the directories relative to dir, and the patches without a target are applied to targets[""].
+// All patches are checked before dir is changed, so nothing is changed when a patch does not apply
+// and with dryRun. The patches are reverted in the reverse order with reverse.
+// Patch returns the paths of the files changed relative to dir.
+func Patch(ctx context.Context, files []PatchFile, dir string, targets map[string]string, reverse, dryRun bool, logger *log.Logger) ([]string, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	var patches []*patch.Patch
+	for _, f := range files {
+		if !FileExists(f.Path) {
+			return nil, fmt.Errorf("Patch pathname: %s is not found", f.Path)
+		}
+		target, ok := targets[f.Target]
+		if !ok {
+			return nil, fmt.Errorf("Patch target: %s of %s is not a component of the build", f.Target, f.Path)
+		}
+		opts, err := f.options()
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(f.Path)
+		if err != nil {
+			return nil, err
 		}
+		p, err := patch.Parse(f.Path, data)
+		if err != nil {
+			return nil, err
+		}
+		p.Dir = target
+		p.Options = opts
+		p.Reverse = opts.Reverse != reverse
+		switch {
+		case dryRun:
+			logger.Printf("Checking patch: %s %s", f.Option, f.Path)
+		case reverse:
+			logger.Printf("Reverting patch: %s", f.Path)
+		default:
+			logger.Printf("Applying patch: %s %s", f.Option, f.Path)
+		}
+		patches = append(patches, p)
+	}
+	if reverse {
+		slices.Reverse(patches)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result, err := patch.Apply(dir, patches, dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply patch: %w", err)
+	}
+	for _, note := range result.Notes {
+		logger.Println(note)
+	}
+	for _, name := range result.Files {
+		logger.Printf("patching file %s", name)
 	}
+	return result.Files, nil
 }
@@ -25,11 +25,25 @@ func versionsGenFreenginx() []string {
 	}
 }
 
+func versionsGenAngie() []string {
+	return []string{
+		fmt.Sprintf("angie-%s", builder.AngieVersion),
+	}
+}
+
+func versionsGenTengine() []string {
+	return []string{
+		fmt.Sprintf("tengine-%s", builder.TengineVersion),
+	}
+}
+
 func printNginxVersions() {
 	var versions []string
 	versions = append(versions, versionsGenNginx()...)
 	versions = append(versions, versionsGenOpenResty()...)
 	versions = append(versions, versionsGenFreenginx()...)
+	versions = append(versions, versionsGenAngie()...)
+	versions = append(versions, versionsGenTengine()...)
 	for _, v := range versions {
 		fmt.Println(v)
 	}